@@ -0,0 +1,181 @@
+// Minimal, leveled and structured logger interface used by the network packages.
+//   Structured fields are passed as alternating keys and values, for example:
+//       logger.Debug("connected", "room", roomID, "user", userID)
+//   The method set is the same as that of *slog.Logger, so a slog logger can be injected directly.
+//   The default everywhere is Nop(), i.e. library users decide what (and whether anything) is printed.
+package logging
+
+import (
+	"fmt"
+	"gopkg.in/ini.v1"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+	LevelOff
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	default:
+		return "OFF"
+	}
+}
+
+// Parses one of debug, info, warn, error, off (case insensitive)
+func ParseLevel(raw string) (Level, error) {
+	switch strings.ToLower(raw) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	case "off", "":
+		return LevelOff, nil
+	default:
+		return LevelOff, fmt.Errorf("unknown log level: %v", raw)
+	}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
+
+// Logger that discards everything, the default
+func Nop() Logger {
+	return nopLogger{}
+}
+
+// Returns the given logger, or Nop() if it is nil
+func OrNop(l Logger) Logger {
+	if l == nil {
+		return Nop()
+	}
+	return l
+}
+
+// Logger forwarding to another logger, that can be replaced while other goroutines are logging through it
+//   components hand the same Swappable to everything they start, so that a later SetLogger reaches all of it
+type Swappable struct {
+	current atomic.Value // always holds a swappedLogger
+}
+
+type swappedLogger struct {
+	Logger
+}
+
+// Swappable that forwards to the given logger, or to Nop() if it is nil
+func NewSwappable(l Logger) *Swappable {
+	s := &Swappable{}
+	s.Set(l)
+	return s
+}
+
+// Replaces the logger forwarded to, Nop() if it is nil
+func (s *Swappable) Set(l Logger) {
+	s.current.Store(swappedLogger{OrNop(l)})
+}
+
+// The logger currently forwarded to
+func (s *Swappable) Get() Logger {
+	return s.current.Load().(swappedLogger).Logger
+}
+
+func (s *Swappable) Debug(msg string, args ...interface{}) { s.Get().Debug(msg, args...) }
+func (s *Swappable) Info(msg string, args ...interface{})  { s.Get().Info(msg, args...) }
+func (s *Swappable) Warn(msg string, args ...interface{})  { s.Get().Warn(msg, args...) }
+func (s *Swappable) Error(msg string, args ...interface{}) { s.Get().Error(msg, args...) }
+
+type stdLogger struct {
+	out      *log.Logger
+	minLevel Level
+}
+
+// Logger that prints every message at or above minLevel to out, formatted as: LEVEL msg key=value key=value
+func NewStd(out *log.Logger, minLevel Level) Logger {
+	return stdLogger{out: out, minLevel: minLevel}
+}
+
+func (s stdLogger) Debug(msg string, args ...interface{}) { s.print(LevelDebug, msg, args) }
+func (s stdLogger) Info(msg string, args ...interface{})  { s.print(LevelInfo, msg, args) }
+func (s stdLogger) Warn(msg string, args ...interface{})  { s.print(LevelWarn, msg, args) }
+func (s stdLogger) Error(msg string, args ...interface{}) { s.print(LevelError, msg, args) }
+
+func (s stdLogger) print(level Level, msg string, args []interface{}) {
+	if level < s.minLevel {
+		return
+	}
+	s.out.Println(Format(level, msg, args...))
+}
+
+// Formats the message and its fields as: LEVEL msg key=value key=value
+//   a trailing key without value is printed as !BADKEY=<key>, same as slog
+func Format(level Level, msg string, args ...interface{}) string {
+	var b strings.Builder
+	b.WriteString(level.String())
+	b.WriteString(" ")
+	b.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			fmt.Fprintf(&b, " !BADKEY=%v", args[i])
+			break
+		}
+		fmt.Fprintf(&b, " %v=%v", args[i], quoteIfRequired(fmt.Sprint(args[i+1])))
+	}
+	return b.String()
+}
+
+func quoteIfRequired(v string) string {
+	if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+		return fmt.Sprintf("%q", v)
+	}
+	return v
+}
+
+// Reads the optional [logging] section:
+//     [logging]
+//     ; one of debug, info, warn, error, off (the default)
+//     level=info
+//   Returns a std logger writing to stderr, or Nop() if the level is off or invalid
+func NewFromCFG(cfg *ini.File) Logger {
+	level, err := ParseLevel(cfg.Section("logging").Key("level").String())
+	if err != nil || level == LevelOff {
+		return Nop()
+	}
+	return NewStd(log.New(os.Stderr, "", log.LstdFlags), level)
+}
+
+// Implemented by components with an injectable logger
+type Settable interface {
+	SetLogger(logger Logger)
+}
@@ -0,0 +1,56 @@
+package logging_test
+
+import (
+	"bytes"
+	"github.com/jokrey/utility-algorithms-golang/network/logging"
+	"log"
+	"log/slog"
+	"testing"
+)
+
+// *slog.Logger can be injected wherever a logging.Logger is expected
+var _ logging.Logger = (*slog.Logger)(nil)
+
+func TestStdLoggerLevelsAndFields(t *testing.T) {
+	var out bytes.Buffer
+	logger := logging.NewStd(log.New(&out, "", 0), logging.LevelInfo)
+
+	logger.Debug("hidden", "room", "r1")
+	logger.Info("connected", "room", "r1", "user", "u 1", "dangling")
+
+	expected := "INFO connected room=r1 user=\"u 1\" !BADKEY=dangling\n"
+	if out.String() != expected {
+		t.Fatalf("got %q, expected %q", out.String(), expected)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	level, err := logging.ParseLevel("WARN")
+	if err != nil || level != logging.LevelWarn {
+		t.Fatalf("could not parse WARN: %v, %v", level, err)
+	}
+	if _, err := logging.ParseLevel("verbose"); err == nil {
+		t.Fatalf("parsed unknown level")
+	}
+}
+
+func TestSwappableLogger(t *testing.T) {
+	var out bytes.Buffer
+	logger := logging.NewSwappable(nil)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			logger.Debug("concurrent")
+		}
+	}()
+	logger.Set(logging.NewStd(log.New(&out, "", 0), logging.LevelWarn))
+	<-done
+
+	logger.Info("hidden")
+	logger.Warn("shown", "room", "r1")
+	if out.String() != "WARN shown room=r1\n" {
+		t.Fatalf("got %q", out.String())
+	}
+}
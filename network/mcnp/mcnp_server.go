@@ -1,7 +1,7 @@
 package mcnp
 
 import (
-	"fmt"
+	"github.com/jokrey/utility-algorithms-golang/network/logging"
 	"net"
 	"strconv"
 )
@@ -11,39 +11,40 @@ type MCNP_Server struct {
 	port int
 	handleConnection func(conn MCNP_Connection)
 	port_listener net.Listener
+	logger logging.Logger
 }
 
 //Golang Constructor
 func New_MCNP_Server(port int, connectionHandler func(conn MCNP_Connection)) MCNP_Server {
-	newServer := MCNP_Server{true, port, connectionHandler, nil}
+	newServer := MCNP_Server{true, port, connectionHandler, nil, logging.Nop()}
 	return newServer
 }
 
+///Default is logging.Nop(), has to be set before RunListenerLoop
+func (server *MCNP_Server) SetLogger(logger logging.Logger) {
+	server.logger = logging.OrNop(logger)
+}
+
 ///can very well be run in a different go routine.
 ///Then it is possible to Close it from the outside.
 ///Mostly however it makes sense to run it on the main thread. Since most server only want to handleConnections.
 func (server *MCNP_Server) RunListenerLoop() error {
 	tmp, err := net.Listen("tcp", ":"+strconv.Itoa(server.port))
 	server.port_listener=tmp
-	fmt.Println("RunListenerLoop: ", server.port_listener)
 	if err == nil {
-		fmt.Println("\n=======! Server Started !=======\n")
+		server.logger.Info("mcnp server started", "port", server.port)
 		for server.running {
-			fmt.Println("=======! Listening to port", server.port, "for new connection !=======")
+			server.logger.Debug("listening for new connection", "port", server.port)
 			conn, listenerr := server.port_listener.Accept()
 			if listenerr != nil {
-				fmt.Println("Sorry. There was an attempt at a connection, but it failed")
+				server.logger.Warn("there was an attempt at a connection, but it failed", "port", server.port, "err", listenerr)
 			} else {
 				go func () {
 					//last resort error handling.
 					defer func() {
 						if r := recover(); r != nil {
-							fmt.Println("=========ERROR IN CUSTOM CONNECTION HANDLER========")
-							fmt.Println("ERROR ERROR ERROR ERROR ERROR ERROR ERROR ERROR ERROR")
-							fmt.Println("Child Connection in Server Loop has panicked:\n", r)
-							fmt.Println("Panic was recovered by printing this message and functionality will resume ")
-							fmt.Println("ERROR ERROR ERROR ERROR ERROR ERROR ERROR ERROR ERROR")
-							fmt.Println("===================================================")
+							//panic was recovered by logging it and functionality will resume
+							server.logger.Error("custom connection handler panicked", "remote", conn.RemoteAddr(), "panic", r)
 						}
 					}()
 
@@ -59,6 +60,5 @@ func (server *MCNP_Server) RunListenerLoop() error {
 
 func (server MCNP_Server) Close() {
 	server.running = false
-	fmt.Println("Close: ", server.port_listener)
 	server.port_listener.Close()
 }
\ No newline at end of file
@@ -20,16 +20,22 @@ func NewTimedCallback() TimedCallback {
 	}
 }
 
-func (t TimedCallback) IsExpectingCallback() bool {
+// Safe to call while another goroutine (re-)schedules or stops the callback
+func (t *TimedCallback) IsExpectingCallback() bool {
+	t.rwL.RLock()
+	defer t.rwL.RUnlock()
 	return t.callbackRef != nil
 }
-func (t TimedCallback) GetCallbackExpectedAt() time.Time {
+// Safe to call while another goroutine (re-)schedules or stops the callback
+func (t *TimedCallback) GetCallbackExpectedAt() time.Time {
+	t.rwL.RLock()
+	defer t.rwL.RUnlock()
 	return t.callbackExpectedAt
 }
 func (t *TimedCallback) Stop() {
 	t.rwL.Lock()
-	if t.callbackRef != nil && !t.callbackRef.Stop() {
-		<-t.callbackRef.C
+	if t.callbackRef != nil {
+		t.callbackRef.Stop() // AfterFunc timers have no channel to drain, if already fired the callback runs regardless
 	}
 	t.callbackRef = nil
 	t.rwL.Unlock()
@@ -57,7 +63,7 @@ func (t *TimedCallback) CallMeBackAt(at time.Time, f func()) bool {
 }
 func (t *TimedCallback) CallMeBackIfEarlierThanCurrent(at time.Time, f func()) bool {
 	t.rwL.RLock()
-	if !t.IsExpectingCallback() || at.Before(t.GetCallbackExpectedAt()) {
+	if t.callbackRef == nil || at.Before(t.callbackExpectedAt) {
 		t.rwL.RUnlock()
 		t.CallMeBackAt(at, f)
 		return true
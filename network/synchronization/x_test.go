@@ -56,3 +56,23 @@ func TestRecursive(t *testing.T) {
 		t.Fatalf("counter only = %v - apparently not called recursively", counter)
 	}
 }
+
+func TestStopWhileCallbackFires(t *testing.T) {
+	tcb := synchronization.NewTimedCallback()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 3000; i++ {
+			tcb.CallMeBackAfter(time.Duration(i%50)*time.Microsecond+time.Microsecond, func() {})
+			time.Sleep(time.Duration(i%7) * 10 * time.Microsecond)
+			tcb.Stop()
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(15 * time.Second):
+		t.Fatalf("Stop blocked, apparently it waited for a timer that had already fired")
+	}
+}
@@ -1,6 +1,7 @@
 package signaling
 
 import (
+	"github.com/jokrey/utility-algorithms-golang/network/logging"
 	"github.com/jokrey/utility-algorithms-golang/network/wsclientable"
 	"gopkg.in/ini.v1"
 	"log"
@@ -14,14 +15,19 @@ func StartRoomSignalingServerUnencrypted(cfg *ini.File) {
 	bindPort, _ := cfg.Section("signaling").Key("port").Int()
 	httpRoute := cfg.Section("signaling").Key("http_route").String()
 
+	logger := logging.NewFromCFG(cfg)
 	base := wsclientable.NewWSHandlingServer()
-	base.AddRoomForwardingFunctionality(wsclientable.BundleControllers(
+	base.SetLogger(logger)
+	controllers := wsclientable.BundleControllers(
 		wsclientable.NewPermanentRoomControllerFromCFG(cfg),
 		wsclientable.NewHTTPRoomEditorFromCFG(cfg),
 		wsclientable.NewHTTPTemporaryRoomEditorFromCFG(cfg),
-	), "offer", "answer", "candidate")
+	)
+	controllers.SetUserGroupsFromCFG(cfg)
+	base.AddRoomForwardingFunctionalityWithContextAuthenticator(controllers, userAuthenticatorFromCFG(cfg, permitAllUsers()), "offer", "answer", "candidate")
+	base.AddRoomWebhooksFunctionalityFromCFG(controllers, cfg)
 
-	log.Printf("Started RoomSignalingServer on %v:%v", bindAddress, bindPort)
+	logger.Info("started room signaling server", "address", bindAddress, "port", bindPort)
 	err := base.StartUnencrypted(bindAddress, bindPort, httpRoute)
 	if err != nil {
 		log.Fatal("Failed to start https server - with error: ", err)
@@ -36,14 +42,19 @@ func StartRoomSignalingServerWithSSL(cfg *ini.File) {
 	bindPort, _ := cfg.Section("signaling").Key("port").Int()
 	httpRoute := cfg.Section("signaling").Key("http_route").String()
 
+	logger := logging.NewFromCFG(cfg)
 	base := wsclientable.NewWSHandlingServer()
-	base.AddRoomForwardingFunctionality(wsclientable.BundleControllers(
+	base.SetLogger(logger)
+	controllers := wsclientable.BundleControllers(
 		wsclientable.NewPermanentRoomControllerFromCFG(cfg),
 		wsclientable.NewHTTPRoomEditorFromCFG(cfg),
 		wsclientable.NewHTTPTemporaryRoomEditorFromCFG(cfg),
-	), "offer", "answer", "candidate")
+	)
+	controllers.SetUserGroupsFromCFG(cfg)
+	base.AddRoomForwardingFunctionalityWithContextAuthenticator(controllers, userAuthenticatorFromCFG(cfg, permitAllUsers()), "offer", "answer", "candidate")
+	base.AddRoomWebhooksFunctionalityFromCFG(controllers, cfg)
 
-	log.Printf("Started RoomSignalingServer on %v:%v", bindAddress, bindPort)
+	logger.Info("started room signaling server", "address", bindAddress, "port", bindPort)
 	err := base.StartWithTLSMultipleCerts(bindAddress, bindPort, httpRoute, wsclientable.ReadMultipleCertsFromCfg(cfg)...)
 	if err != nil {
 		log.Fatal("Failed to start https server - with error: ", err)
@@ -57,11 +68,16 @@ func StartRoomSignalingServerWithSSLWithCustomControllers(cfg *ini.File, control
 	bindPort, _ := cfg.Section("signaling").Key("port").Int()
 	httpRoute := cfg.Section("signaling").Key("http_route").String()
 
+	logger := logging.NewFromCFG(cfg)
 	base := wsclientable.NewWSHandlingServer()
-	base.AddRoomForwardingFunctionality(wsclientable.BundleControllers(controllers...),
-		"offer", "answer", "candidate")
+	base.SetLogger(logger)
+	bundle := wsclientable.BundleControllers(controllers...)
+	bundle.SetUserGroupsFromCFG(cfg)
+	base.AddRoomForwardingFunctionalityWithContextAuthenticator(bundle,
+		userAuthenticatorFromCFG(cfg, permitAllUsers()), "offer", "answer", "candidate")
+	base.AddRoomWebhooksFunctionalityFromCFG(bundle, cfg)
 
-	log.Printf("Started RoomSignalingServer on %v:%v", bindAddress, bindPort)
+	logger.Info("started room signaling server", "address", bindAddress, "port", bindPort)
 	err := base.StartWithTLSMultipleCerts(bindAddress, bindPort, httpRoute, wsclientable.ReadMultipleCertsFromCfg(cfg)...)
 	if err != nil {
 		log.Fatal("Failed to start https server - with error: ", err)
@@ -75,13 +91,63 @@ func StartRoomSignalingServerUnencryptedWithCustomControllers(cfg *ini.File, con
 	bindPort, _ := cfg.Section("signaling").Key("port").Int()
 	httpRoute := cfg.Section("signaling").Key("http_route").String()
 
+	logger := logging.NewFromCFG(cfg)
 	base := wsclientable.NewWSHandlingServer()
-	base.AddRoomForwardingFunctionality(wsclientable.BundleControllers(controllers...),
-		"offer", "answer", "candidate")
+	base.SetLogger(logger)
+	bundle := wsclientable.BundleControllers(controllers...)
+	bundle.SetUserGroupsFromCFG(cfg)
+	base.AddRoomForwardingFunctionalityWithContextAuthenticator(bundle,
+		userAuthenticatorFromCFG(cfg, permitAllUsers()), "offer", "answer", "candidate")
+	base.AddRoomWebhooksFunctionalityFromCFG(bundle, cfg)
 
-	log.Printf("Started RoomSignalingServer on %v:%v", bindAddress, bindPort)
+	logger.Info("started room signaling server", "address", bindAddress, "port", bindPort)
 	err := base.StartUnencrypted(bindAddress, bindPort, httpRoute)
 	if err != nil {
 		log.Fatal("Failed to start https server - with error: ", err)
 	}
 }
+
+// minimal example config, with all required fields and some comments in example_configs/room_mtls.ini
+//  Starts a room signaling server with all possible controllers (config see ini)
+//  Clients authenticate with a client certificate instead of the user url param, only the room is taken from the url
+//  Within rooms, it will allow direct forwarding to other KNOWN users (on message types offer, answer, candidate)
+func StartRoomSignalingServerWithMutualTLS(cfg *ini.File) {
+	bindAddress := cfg.Section("signaling").Key("address").String()
+	bindPort, _ := cfg.Section("signaling").Key("port").Int()
+	httpRoute := cfg.Section("signaling").Key("http_route").String()
+	clientAuth, err := wsclientable.ReadClientCertificateAuthFromCfg(cfg)
+	if err != nil {
+		log.Fatal("Invalid client_auth config - with error: ", err)
+	}
+
+	logger := logging.NewFromCFG(cfg)
+	base := wsclientable.NewWSHandlingServer()
+	base.SetLogger(logger)
+	controllers := wsclientable.BundleControllers(
+		wsclientable.NewPermanentRoomControllerFromCFG(cfg),
+		wsclientable.NewHTTPRoomEditorFromCFG(cfg),
+		wsclientable.NewHTTPTemporaryRoomEditorFromCFG(cfg),
+	)
+	controllers.SetUserGroupsFromCFG(cfg)
+	base.AddRoomForwardingFunctionalityWithContextAuthenticator(controllers,
+		userAuthenticatorFromCFG(cfg, wsclientable.AuthenticateUserByClientCertificate(clientAuth.IdentityFrom)),
+		"offer", "answer", "candidate")
+	base.AddRoomWebhooksFunctionalityFromCFG(controllers, cfg)
+
+	logger.Info("started room signaling server", "address", bindAddress, "port", bindPort)
+	err = base.StartWithMutualTLS(bindAddress, bindPort, httpRoute, clientAuth, wsclientable.ReadMultipleCertsFromCfg(cfg)...)
+	if err != nil {
+		log.Fatal("Failed to start https server - with error: ", err)
+	}
+}
+
+// Clients with a token url param are authenticated by their invite, if the config has an [invite_tokens] section
+//   all other clients by the given userAuthenticator
+func userAuthenticatorFromCFG(cfg *ini.File, userAuthenticator wsclientable.RequestAuthenticator) wsclientable.ContextAuthenticator {
+	return wsclientable.AuthenticateUserByInviteTokenFromCFG(cfg, wsclientable.ContextAuthenticatorFrom(userAuthenticator))
+}
+
+// The default user authenticator of the room signaling servers: user=<userID> from the url params
+func permitAllUsers() wsclientable.RequestAuthenticator {
+	return wsclientable.RequestAuthenticatorFromURLParams(wsclientable.AuthenticateUserPermitAll())
+}
@@ -1,6 +1,7 @@
 package signaling
 
 import (
+	"github.com/jokrey/utility-algorithms-golang/network/logging"
 	"github.com/jokrey/utility-algorithms-golang/network/wsclientable"
 	"gopkg.in/ini.v1"
 	"log"
@@ -14,11 +15,13 @@ func StartSimplestSignalingServerUnencrypted(cfg *ini.File) {
 	bindPort, _ := cfg.Section("signaling").Key("port").Int()
 	httpRoute := cfg.Section("signaling").Key("http_route").String()
 
+	logger := logging.NewFromCFG(cfg)
 	base := wsclientable.NewWSHandlingServer()
+	base.SetLogger(logger)
 	base.SetAuthenticator(wsclientable.AuthenticateUserPermitAll())
 	base.AddDirectForwardingFunctionality("offer", "answer", "candidate")
 
-	log.Printf("Started SignalingServer on %v:%v", bindAddress, bindPort)
+	logger.Info("started signaling server", "address", bindAddress, "port", bindPort)
 	err := base.StartUnencrypted(bindAddress, bindPort, httpRoute)
 	if err != nil {
 		log.Fatal("Failed to start https server - with error: ", err)
@@ -33,13 +36,40 @@ func StartSimplestSignalingServerWithSSL(cfg *ini.File) {
 	bindPort, _ := cfg.Section("signaling").Key("port").Int()
 	httpRoute := cfg.Section("signaling").Key("http_route").String()
 
+	logger := logging.NewFromCFG(cfg)
 	base := wsclientable.NewWSHandlingServer()
+	base.SetLogger(logger)
 	base.SetAuthenticator(wsclientable.AuthenticateUserPermitAll())
 	base.AddDirectForwardingFunctionality("offer", "answer", "candidate")
 
-	log.Printf("Started SignalingServer on %v:%v", bindAddress, bindPort)
+	logger.Info("started signaling server", "address", bindAddress, "port", bindPort)
 	err := base.StartWithTLSMultipleCerts(bindAddress, bindPort, httpRoute, wsclientable.ReadMultipleCertsFromCfg(cfg)...)
 	if err != nil {
 		log.Fatal("Failed to start https server - with error: ", err)
 	}
 }
+
+// minimal example config, see the [client_auth] section in example_configs/room_mtls.ini
+//  Clients authenticate with a client certificate instead of the user url param
+//  Allows direct forwarding to other KNOWN users (on message types offer, answer, candidate)
+func StartSimplestSignalingServerWithMutualTLS(cfg *ini.File) {
+	bindAddress := cfg.Section("signaling").Key("address").String()
+	bindPort, _ := cfg.Section("signaling").Key("port").Int()
+	httpRoute := cfg.Section("signaling").Key("http_route").String()
+	clientAuth, err := wsclientable.ReadClientCertificateAuthFromCfg(cfg)
+	if err != nil {
+		log.Fatal("Invalid client_auth config - with error: ", err)
+	}
+
+	logger := logging.NewFromCFG(cfg)
+	base := wsclientable.NewWSHandlingServer()
+	base.SetLogger(logger)
+	base.SetRequestAuthenticator(wsclientable.AuthenticateUserByClientCertificate(clientAuth.IdentityFrom))
+	base.AddDirectForwardingFunctionality("offer", "answer", "candidate")
+
+	logger.Info("started signaling server", "address", bindAddress, "port", bindPort)
+	err = base.StartWithMutualTLS(bindAddress, bindPort, httpRoute, clientAuth, wsclientable.ReadMultipleCertsFromCfg(cfg)...)
+	if err != nil {
+		log.Fatal("Failed to start https server - with error: ", err)
+	}
+}
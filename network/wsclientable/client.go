@@ -1,11 +1,16 @@
 package wsclientable
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/websocket"
-	"log"
+	"github.com/jokrey/utility-algorithms-golang/network/logging"
 	"net"
+	"net/http"
+	neturl "net/url"
 	"strings"
 	"sync"
 	"time"
@@ -21,12 +26,30 @@ import (
 //   Typed Messages can be sent using 'SendTyped' and 'SendMapTyped'(for json support)
 //   Typed Messages can be received over the 'ListenLoop', note that ListenLoop blocks and it can be advisable to run it in a goroutine
 type ClientConnection struct {
-	// stringified type but golang does not(yet) support generics, because 'we don't need it'
-	//   (which is why they now add it to the language, just like Java did)
-	//   (making the same mistake twice)
-	ID       string
+	// On the server the user id of the connection, unique among the connections of the same room
+	ID string
+	// On the server the authenticated identity of the connection (zero on the client side)
+	Identity Identity
 	raw      *websocket.Conn
 	writeMut *sync.Mutex
+	logger   logging.Logger
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+// The context of this connection. It is cancelled when the connection is closed (i.e. when ListenLoop returns),
+//   so that slow work in message handlers (or room controllers) can be abandoned once the client has left.
+//   On the server it carries the request-scoped values set by the ContextAuthenticator.
+func (c ClientConnection) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// The subprotocol negotiated in the upgrade handshake, empty if none was
+func (c ClientConnection) Subprotocol() string {
+	return c.raw.Subprotocol()
 }
 
 func (c ClientConnection) SendRaw(text string) error {
@@ -57,25 +80,157 @@ func (c ClientConnection) Close() error {
 	return c.raw.Close()
 }
 
+// Application close codes (4000-4999) sent by the room functionality
+const (
+	// The connection was authenticated, but not accepted into its room (for example because it is full)
+	CloseCodeRoomRejected = 4003
+	// The connection was removed from its room by a moderator
+	CloseCodeKicked = 4004
+	// The room of the connection ended (its time frame is over, or it was removed)
+	CloseCodeRoomClosed = 4005
+	// The personal window of the user in its room ended (see 'room_user_access.go')
+	CloseCodeAccessEnded = 4006
+)
+
+// Sends a close message with the given code and reason (at most 123 bytes, longer reasons are cut) and closes the connection
+//   the ListenLoop of the other side returns the code and reason
+func (c ClientConnection) CloseWithReason(closeCode int, reason string) error {
+	c.writeMut.Lock()
+	defer c.writeMut.Unlock()
+
+	if len(reason) > 123 { // control frames are limited to 125 bytes, 2 are taken by the code
+		reason = reason[:123]
+	}
+	_ = c.raw.WriteControl(
+		websocket.CloseMessage, websocket.FormatCloseMessage(closeCode, reason), time.Now().Add(time.Second),
+	)
+	return c.raw.Close()
+}
+
 // Connect this websocket to the given url (example: http://dns.com:8080/route?user=testUserName)
 // Server at url must be a wsclientable-server for reliable results
 // On handshake problems, check cert (correct domain, still valid, added to local trusted)
-func Connect(url string) (*ClientConnection, error) {
+//   or use ConnectWithOptions to trust a custom CA
+// At most one ConnectOptions can be given (optional, so that existing calls with only the url keep working)
+func Connect(url string, options ...ConnectOptions) (*ClientConnection, error) {
+	switch len(options) {
+	case 0:
+		return ConnectWithOptions(url, ConnectOptions{})
+	case 1:
+		return ConnectWithOptions(url, options[0])
+	}
+	return nil, fmt.Errorf("at most one ConnectOptions can be given, got %v", len(options))
+}
+
+// Options for ConnectWithOptions, the zero value behaves like websocket.DefaultDialer
+type ConnectOptions struct {
+	// Additional http headers sent with the upgrade request (for example Authorization)
+	Header http.Header
+	// Pem encoded CA certificates to trust in addition to the system pool
+	//   for example the <dns>_cert.pem generated with generate_cert.go, as in the example configs
+	CAPem []byte
+	// Used as is, if set - CAPem is then added to a copy of its RootCAs
+	TLSConfig *tls.Config
+	// If 0, websocket.DefaultDialer's handshake timeout is used
+	HandshakeTimeout time.Duration
+	// Offered to the server in order of preference, the negotiated one is ClientConnection.Subprotocol (see Server.SetSubprotocols)
+	Subprotocols []string
+	// If nil, websocket.DefaultDialer's proxy (from environment) is used - use NoProxy to disable
+	Proxy func(*http.Request) (*neturl.URL, error)
+	// Used by the listen loop of the connection, if nil: logging.Nop()
+	Logger logging.Logger
+	// Used for dialing and as parent of the connection context, if nil: context.Background()
+	Context context.Context
+}
+
+// Can be set as ConnectOptions.Proxy to connect directly, ignoring environment proxy settings
+func NoProxy(*http.Request) (*neturl.URL, error) {
+	return nil, nil
+}
+
+// Convenience for a ConnectOptions.Proxy that always uses the proxy at the given url
+func FixedProxy(proxyURL string) (func(*http.Request) (*neturl.URL, error), error) {
+	parsed, err := neturl.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url(%v), error: %w", proxyURL, err)
+	}
+	return http.ProxyURL(parsed), nil
+}
+
+func (o ConnectOptions) dialer() (*websocket.Dialer, error) {
+	dialer := *websocket.DefaultDialer
+	if o.HandshakeTimeout > 0 {
+		dialer.HandshakeTimeout = o.HandshakeTimeout
+	}
+	if o.Proxy != nil {
+		dialer.Proxy = o.Proxy
+	}
+	dialer.Subprotocols = o.Subprotocols
+
+	if o.TLSConfig != nil {
+		dialer.TLSClientConfig = o.TLSConfig.Clone()
+	}
+	if len(o.CAPem) > 0 {
+		if dialer.TLSClientConfig == nil {
+			dialer.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS10}
+		}
+		if dialer.TLSClientConfig.RootCAs == nil {
+			systemPool, err := x509.SystemCertPool()
+			if err != nil {
+				systemPool = x509.NewCertPool()
+			}
+			dialer.TLSClientConfig.RootCAs = systemPool
+		} else {
+			// Clone of the config shares the pool, appending to it would change the pool of the caller
+			dialer.TLSClientConfig.RootCAs = dialer.TLSClientConfig.RootCAs.Clone()
+		}
+		if !dialer.TLSClientConfig.RootCAs.AppendCertsFromPEM(o.CAPem) {
+			return nil, fmt.Errorf("no certificates found in given CAPem")
+		}
+	}
+	return &dialer, nil
+}
+
+// Same as Connect, but with custom request headers, trusted CAs, timeouts, subprotocols and proxy
+func ConnectWithOptions(url string, options ConnectOptions) (*ClientConnection, error) {
 	// also works for https
 	if strings.HasPrefix(url, "http") {
 		url = "ws" + url[4:]
 	}
 
-	raw, _, err := websocket.DefaultDialer.Dial(url, nil)
+	dialer, err := options.dialer()
+	if err != nil {
+		return nil, err
+	}
+	parent := options.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+	raw, _, err := dialer.DialContext(parent, url, options.Header)
 	if err != nil {
 		return nil, fmt.Errorf("could not dial to url(%v), error: %w", url, err)
 	}
 
-	return &ClientConnection{ID: "SERVER AT: " + url, raw: raw, writeMut: new(sync.Mutex)}, nil
+	ctx, cancel := context.WithCancel(parent)
+	return &ClientConnection{
+		ID: "SERVER AT: " + url, raw: raw, writeMut: new(sync.Mutex), logger: logging.OrNop(options.Logger),
+		ctx: ctx, cancel: cancel,
+	}, nil
 }
 
 const PingInterval = 66
 
+// Message handlers registered under this type receive all messages for which no handler of their own type is registered
+//   without such a handler, the listen loop closes the connection on unrecognised types (except informational types)
+const AnyOtherMessageType = "*"
+
+// Messages the server sends only to inform the client (see 'room_lobby.go', 'room_closing.go' and 'room_state.go')
+//   ListenLoop ignores them if no handler is given, instead of closing the connection
+var informationalMessageTypes = map[string]bool{
+	MessageTypeRoomInfo: true, MessageTypeRoomClosing: true, MessageTypeRoomOpensAt: true, MessageTypeRoomOpened: true,
+	MessageTypeStateSnapshot: true, MessageTypeStateChanged: true, MessageTypeHistory: true,
+}
+
 type ClientCloseMessage struct {
 	code int
 	text string
@@ -84,15 +239,23 @@ type ClientCloseMessage struct {
 // enables the listen loop, which will serve the given message handlers.
 // will only return when this connection is closed, so it will typically be run in a goroutine
 // Returns the close code and the closing message (1000 indicates normal closing)
+// The connection context is cancelled as soon as the connection is closed, even while a handler is still running
 func (c ClientConnection) ListenLoop(messageHandlers MessageHandlers) (int, string) {
 	in := make(chan []byte)
 	pingTicker := time.NewTicker(PingInterval * time.Second)
+	defer pingTicker.Stop()
 	stop := make(chan ClientCloseMessage)
+	if c.cancel != nil {
+		defer c.cancel()
+	}
 
 	go func() {
 		for {
 			wsMessageType, message, err := c.raw.ReadMessage()
 			if err != nil {
+				if c.cancel != nil {
+					c.cancel() // before blocking on stop, a handler might currently block the loop
+				}
 				if coc, ok := err.(*websocket.CloseError); ok {
 					stop <- ClientCloseMessage{code: coc.Code, text: coc.Text}
 				} else if coc, ok := err.(*net.OpError); ok {
@@ -104,7 +267,7 @@ func (c ClientConnection) ListenLoop(messageHandlers MessageHandlers) (int, stri
 			}
 
 			if wsMessageType != websocket.TextMessage {
-				log.Println("Received message with unexpected type {}", wsMessageType)
+				c.logger.Warn("received message with unexpected websocket message type", "connection", c.ID, "wsMessageType", wsMessageType)
 			}
 			in <- message
 		}
@@ -114,7 +277,7 @@ func (c ClientConnection) ListenLoop(messageHandlers MessageHandlers) (int, stri
 		select {
 		case <-pingTicker.C:
 			if err := c.raw.WriteMessage(websocket.PingMessage, nil); err != nil {
-				log.Println("Could not send ping - already closed?")
+				c.logger.Debug("could not send ping - already closed?", "connection", c.ID, "err", err)
 				_ = c.raw.Close()
 				break //go back to select, expect message in close channel
 			}
@@ -122,18 +285,22 @@ func (c ClientConnection) ListenLoop(messageHandlers MessageHandlers) (int, stri
 			{
 				var messageJSON map[string]interface{}
 				if err := json.Unmarshal(message, &messageJSON); err != nil {
-					log.Println("Received unparsable json from ", c.ID, ", closing connection")
-					log.Println(string(message))
+					c.logger.Warn("received unparsable json, closing connection", "connection", c.ID, "message", string(message))
 					_ = c.raw.Close()
 
 					break //go back to select, expect message in close channel
 				}
 				mType := messageJSON["type"].(string)
 				handler := messageHandlers[mType]
+				if handler == nil {
+					handler = messageHandlers[AnyOtherMessageType]
+				}
 				if handler != nil {
 					handler(mType, c, messageJSON["data"].(map[string]interface{}))
+				} else if informationalMessageTypes[mType] {
+					c.logger.Debug("ignored informational message without handler", "connection", c.ID, "mType", mType)
 				} else {
-					log.Println("Received unrecognised type ", mType, " from ", c.ID, ", closing connection")
+					c.logger.Warn("received unrecognised type, closing connection", "connection", c.ID, "mType", mType)
 					_ = c.raw.Close()
 				}
 			}
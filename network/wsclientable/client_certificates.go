@@ -0,0 +1,131 @@
+package wsclientable
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"gopkg.in/ini.v1"
+	"io/ioutil"
+	"net/http"
+)
+
+//Idea:
+//  Instead of a user=<userID> url param, clients can authenticate using a client certificate (mutual tls).
+//  The server is started with StartWithMutualTLS, which verifies the presented certificate against a CA bundle.
+//  The user id is then derived from the verified certificate, from the subject or one of the SANs.
+//  Example config section:
+//     [client_auth]
+//     ca_path=configs/certs_go/device_ca.pem
+//     ; if false, certificates are only requested, connections without one are left to the authenticator
+//     require=true
+//     ; one of common_name, san_email, san_dns, san_uri
+//     identity_from=common_name
+
+type ClientCertificateAuth struct {
+	// Path to a pem file with one or more CA certificates, client certificates have to be signed by one of them
+	CABundlePath string
+	// Whether the tls handshake fails without a valid client certificate.
+	//   If false, certificates are requested and verified if given, but connections without one are still accepted
+	Required bool
+	// Which part of the client certificate is used as the user id
+	IdentityFrom CertificateIdentitySource
+}
+
+func (c ClientCertificateAuth) tlsClientAuthType() tls.ClientAuthType {
+	if c.Required {
+		return tls.RequireAndVerifyClientCert
+	}
+	return tls.VerifyClientCertIfGiven
+}
+
+// Reads the [client_auth] section, see above
+func ReadClientCertificateAuthFromCfg(cfg *ini.File) (ClientCertificateAuth, error) {
+	section := cfg.Section("client_auth")
+	identityFrom, err := ParseCertificateIdentitySource(section.Key("identity_from").MustString("common_name"))
+	if err != nil {
+		return ClientCertificateAuth{}, err
+	}
+	return ClientCertificateAuth{
+		CABundlePath: section.Key("ca_path").String(),
+		Required:     section.Key("require").MustBool(true),
+		IdentityFrom: identityFrom,
+	}, nil
+}
+
+// Reads all pem encoded certificates in the file at the given path into a pool
+func LoadCertPool(pemPath string) (*x509.CertPool, error) {
+	pemCont, err := ioutil.ReadFile(pemPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading ca bundle from \"%v\", error: %w", pemPath, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemCont) {
+		return nil, fmt.Errorf("no certificates found in ca bundle \"%v\"", pemPath)
+	}
+	return pool, nil
+}
+
+type CertificateIdentitySource int
+
+const (
+	IdentityFromCommonName CertificateIdentitySource = iota
+	IdentityFromSANEmail
+	IdentityFromSANDNS
+	IdentityFromSANURI
+)
+
+func ParseCertificateIdentitySource(raw string) (CertificateIdentitySource, error) {
+	switch raw {
+	case "common_name":
+		return IdentityFromCommonName, nil
+	case "san_email":
+		return IdentityFromSANEmail, nil
+	case "san_dns":
+		return IdentityFromSANDNS, nil
+	case "san_uri":
+		return IdentityFromSANURI, nil
+	default:
+		return IdentityFromCommonName, fmt.Errorf("unknown certificate identity source: %v", raw)
+	}
+}
+
+// Returns the user id encoded in the verified client certificate of the given request
+//   the first SAN of the requested kind is used
+func UserIDFromClientCertificate(request *http.Request, source CertificateIdentitySource) (string, error) {
+	if request.TLS == nil || len(request.TLS.VerifiedChains) == 0 || len(request.TLS.VerifiedChains[0]) == 0 {
+		return "", AuthenticationError{Reason: "no verified client certificate"}
+	}
+	cert := request.TLS.VerifiedChains[0][0]
+
+	var userID string
+	switch source {
+	case IdentityFromCommonName:
+		userID = cert.Subject.CommonName
+	case IdentityFromSANEmail:
+		if len(cert.EmailAddresses) > 0 {
+			userID = cert.EmailAddresses[0]
+		}
+	case IdentityFromSANDNS:
+		if len(cert.DNSNames) > 0 {
+			userID = cert.DNSNames[0]
+		}
+	case IdentityFromSANURI:
+		if len(cert.URIs) > 0 {
+			userID = cert.URIs[0].String()
+		}
+	}
+
+	if len(userID) == 0 {
+		return "", AuthenticationError{Reason: "client certificate does not contain a user id"}
+	}
+	return userID, nil
+}
+
+// Authenticate Function, which permits all connections with a verified client certificate
+//   Requires the server to be started with StartWithMutualTLS
+//   The user id is derived from the certificate, see UserIDFromClientCertificate
+func AuthenticateUserByClientCertificate(source CertificateIdentitySource) RequestAuthenticator {
+	return func(request *http.Request) (string, error) {
+		return UserIDFromClientCertificate(request, source)
+	}
+}
@@ -8,19 +8,19 @@ import (
 	"strings"
 )
 
-//See UrlWithParamsForRoomConnection, StartChatClientLoop
-func StartChatClientLoopAs(baseurl, userID string) error {
-	return StartChatClientLoop(UrlWithParamsForUserConnection(baseurl, userID), "message")
+//See UrlWithParamsForUserConnection, StartChatClientLoop
+func StartChatClientLoopAs(baseurl, userID string, options ...ConnectOptions) error {
+	return StartChatClientLoop(UrlWithParamsForUserConnection(baseurl, userID), "message", options...)
 }
 
 //See UrlWithParamsForRoomConnection, StartChatClientLoop
-func StartChatClientLoopForRoom(baseurl, roomID, userID string) error {
-	return StartChatClientLoop(UrlWithParamsForRoomConnection(baseurl, roomID, userID), "message")
+func StartChatClientLoopForRoom(baseurl, roomID, userID string, options ...ConnectOptions) error {
+	return StartChatClientLoop(UrlWithParamsForRoomConnection(baseurl, roomID, userID), "message", options...)
 }
 
-//Connect to url. Listen to os.stdin for messages to send to remote
-func StartChatClientLoop(url string, chatMType string) error {
-	client, err := Connect(url)
+//Connect to url (see Connect for the options). Listen to os.stdin for messages to send to remote
+func StartChatClientLoop(url string, chatMType string, options ...ConnectOptions) error {
+	client, err := Connect(url, options...)
 	if err != nil {
 		return err
 	}
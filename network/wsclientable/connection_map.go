@@ -33,6 +33,30 @@ func (m ConnectionMap) AddIfNotConnected(connection ClientConnection) bool {
 	return true
 }
 
+// Same as AddIfNotConnected, but does not add the connection if the map already holds maxConnections (0 = unlimited)
+//   returns whether the connection was added and whether the map was full
+func (m ConnectionMap) AddIfNotConnectedWithin(connection ClientConnection, maxConnections int) (added bool, full bool) {
+	m.rwMut.Lock()
+	defer m.rwMut.Unlock()
+
+	if _, exists := m.rMap[connection.ID]; exists {
+		return false, false
+	}
+	if maxConnections > 0 && len(m.rMap) >= maxConnections {
+		return false, true
+	}
+	m.rMap[connection.ID] = &connection
+	return true, false
+}
+
+// Number of connections in the map
+func (m ConnectionMap) Count() int {
+	m.rwMut.RLock()
+	defer m.rwMut.RUnlock()
+
+	return len(m.rMap)
+}
+
 // Removes the connection with the given id from this map
 // Return nil if no connection was removed, otherwise the removed connection is returned (IT IS NOT CLOSED YET)
 func (m ConnectionMap) Remove(connectionID string) *ClientConnection {
@@ -70,10 +94,25 @@ func (m ConnectionMap) ForAll(f func(connection *ClientConnection)) {
 // Iterates the map and closes all connection, returns the latest error
 // (i.e. if there are multiple errors, the method will continue to iterate and return only the latest error)
 func (m ConnectionMap) CloseAll() (int, error) {
+	return m.closeAll(func(connection *ClientConnection) error {
+		return connection.Close()
+	})
+}
+
+// Same as CloseAll, but sends the given close code and reason (see ClientConnection.CloseWithReason)
+func (m ConnectionMap) CloseAllWithReason(closeCode int, reason string) (int, error) {
+	return m.closeAll(func(connection *ClientConnection) error {
+		return connection.CloseWithReason(closeCode, reason)
+	})
+}
+
+func (m ConnectionMap) closeAll(closeFunc func(connection *ClientConnection) error) (int, error) {
+	m.rwMut.RLock()
 	num := len(m.rMap)
+	m.rwMut.RUnlock()
 	var err error
 	m.ForAll(func(connection *ClientConnection) {
-		e := connection.Close()
+		e := closeFunc(connection)
 		if e != nil {
 			err = e
 		}
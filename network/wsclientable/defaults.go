@@ -1,12 +1,36 @@
 package wsclientable
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// Converts an authenticator that only looks at the url params of the upgrade request into a RequestAuthenticator
+func RequestAuthenticatorFromURLParams(authenticator func(initialParams url.Values) (string, error)) RequestAuthenticator {
+	return func(request *http.Request) (string, error) {
+		initialParams, err := url.ParseQuery(request.URL.RawQuery)
+		if err != nil {
+			return "", AuthenticationError{Reason: "could not parse url params"}
+		}
+		return authenticator(initialParams)
+	}
+}
+
+// Converts the given authenticator into a ContextAuthenticator,
+//   the returned string is the user id and the context is the unchanged request context
+func ContextAuthenticatorFrom(authenticator RequestAuthenticator) ContextAuthenticator {
+	return func(request *http.Request) (context.Context, Identity, error) {
+		userID, err := authenticator(request)
+		return request.Context(), Identity{UserID: userID}, err
+	}
+}
+
 // Default Authenticate Function, which permits all connections that specify a user=<userName> in the params
 // for example: y.x.com/route?user=test.
 func AuthenticateUserPermitAll() func(initialParams url.Values) (string, error) {
@@ -30,9 +54,9 @@ func UrlWithParamsForUserConnection(baseurl, userID string) string {
 	return baseurl + "&user=" + userID
 }
 
-// See Connect and UrlWithParamsForRoomConnection
-func ConnectAs(baseurl, userID string) (*ClientConnection, error) {
-	return Connect(UrlWithParamsForUserConnection(baseurl, userID))
+// See Connect and UrlWithParamsForUserConnection
+func ConnectAs(baseurl, userID string, options ...ConnectOptions) (*ClientConnection, error) {
+	return Connect(UrlWithParamsForUserConnection(baseurl, userID), options...)
 }
 
 // Default Authenticate Function, which permits all connections that specify a user=<userName> and password=<pw>
@@ -60,6 +84,11 @@ func AuthenticateUserPermitPassword(correctCombination func(string, string) bool
 }
 
 //Checks for every connection whether the user is not already connected, the room exists and the user is allowed in that room
+//   both room and user are taken from the url params (room=<roomID>&user=<userID>)
+//   Returns a connection id that encodes room and user (see ConnectionIDStringToRoomIDAndUserID),
+//     so that connections of the same user in different rooms have different ids (both together have to fit MaxIDLength).
+//   Deprecated: use AuthenticateRoomUserFromURLParams with SetContextAuthenticator,
+//     the room and user of its connections are in their Identity (connection.Identity.RoomID and .UserID).
 func AuthenticateRoomUserPermitAllowed(rooms RoomControllerI) func(initialParams url.Values) (string, error) {
 	return func(initialParams url.Values) (string, error) {
 		roomID := initialParams.Get("room")
@@ -70,22 +99,82 @@ func AuthenticateRoomUserPermitAllowed(rooms RoomControllerI) func(initialParams
 		if len(userID) == 0 {
 			return "", MissingURLFieldError{MissingFieldName: "user"}
 		}
-
-		if rooms.IsConnected(roomID, userID) {
-			return "", AuthenticationError{Reason: "User(" + userID + ") already connected in room: " + roomID}
+		identity := Identity{RoomID: roomID, UserID: userID}
+		if err := identity.Validate(); err != nil {
+			return "", err
+		}
+		if err := authenticateRoomUser(rooms, roomID, identity); err != nil {
+			return "", err
 		}
+		return RoomIDAndUserIDToClientConnectionIDString(roomID, userID), nil
+	}
+}
+
+// Same as AuthenticateRoomUserPermitAllowed, but returns the entire Identity (room and user) of the connection
+//   the connection id is the user id
+func AuthenticateRoomUserFromURLParams(rooms RoomControllerI) ContextAuthenticator {
+	return AuthenticateRoomUserWith(rooms, RequestAuthenticatorFromURLParams(AuthenticateUserPermitAll()))
+}
+
+// Same as AuthenticateRoomUserFromURLParams, but only the room is taken from the url params.
+//   The user id is determined by the given userAuthenticator (for example AuthenticateUserByClientCertificate)
+func AuthenticateRoomUserWith(rooms RoomControllerI, userAuthenticator RequestAuthenticator) ContextAuthenticator {
+	return AuthenticateRoomUserWithContext(rooms, ContextAuthenticatorFrom(userAuthenticator))
+}
 
-		room := rooms.GetRoom(roomID)
-		if room == nil {
-			return "", AuthenticationError{Reason: "Could not find room: " + roomID}
+// Same as AuthenticateRoomUserWith, but keeps the context (and its values) and the attributes
+//   of the identity returned by the userAuthenticator. Its RoomID is overridden with the room from the url params.
+func AuthenticateRoomUserWithContext(rooms RoomControllerI, userAuthenticator ContextAuthenticator) ContextAuthenticator {
+	return func(request *http.Request) (context.Context, Identity, error) {
+		initialParams, err := url.ParseQuery(request.URL.RawQuery)
+		if err != nil {
+			return request.Context(), Identity{}, AuthenticationError{Reason: "could not parse url params"}
 		}
-		if !room.IsAllowed(userID) {
-			return "", AuthenticationError{Reason: "User(" + userID + ") not currently allowed in room: " + roomID}
+		roomID := initialParams.Get("room")
+		if len(roomID) == 0 {
+			return request.Context(), Identity{}, MissingURLFieldError{MissingFieldName: "room"}
+		}
+		ctx, identity, err := userAuthenticator(request)
+		if err != nil {
+			return request.Context(), Identity{}, err
+		}
+		identity.RoomID = roomID
+		if err := identity.Validate(); err != nil {
+			return request.Context(), Identity{}, err
 		}
 
-		convertedClientID := RoomIDAndUserIDToClientConnectionIDString(roomID, userID)
-		return convertedClientID, nil
+		err = authenticateRoomUser(rooms, roomID, identity)
+		if rejections, ok := rooms.(roomRejectionEmitter); ok && err != nil {
+			// rejected before the connection is opened, so the controllers never see it
+			rejections.emitRejected(UserRejectedEvent{RoomID: roomID, UserID: identity.UserID, Err: err})
+		}
+		return ctx, identity, err
+	}
+}
+
+func authenticateRoomUser(rooms RoomControllerI, roomID string, identity Identity) error {
+	userID := identity.UserID
+	if rooms.IsConnected(roomID, userID) {
+		return AuthenticationError{Reason: "User(" + userID + ") already connected in room: " + roomID}
 	}
+
+	room := rooms.GetRoom(roomID)
+	if room == nil {
+		return AuthenticationError{Reason: "Could not find room: " + roomID}
+	}
+	if !IsAllowedConnection(room, identity) {
+		if _, waits := lobbyOpeningFor(room, identity, time.Now()); !waits {
+			return AuthenticationError{Reason: "User(" + userID + ") not currently allowed in room: " + roomID}
+		}
+	}
+	if isRoomLocked(rooms, roomID) && !RoleOfConnection(room, identity).CanModerate() {
+		return AuthenticationError{Reason: "Room(" + roomID + ") is locked"}
+	}
+	// checked again (atomically) when the connection is added to the room, this rejects early with a clear reason
+	if maxParticipants := MaxParticipantsOf(room); maxParticipants > 0 && CountConnectionsInRoom(rooms, roomID) >= maxParticipants {
+		return AuthenticationError{Reason: "Room(" + roomID + ") is full (max participants: " + strconv.Itoa(maxParticipants) + ")"}
+	}
+	return nil
 }
 
 // Returns complete url for room connection (baseurl example: http://dns.com:8080/route)
@@ -98,11 +187,12 @@ func UrlWithParamsForRoomConnection(baseurl, roomID, userID string) string {
 }
 
 // See Connect and UrlWithParamsForRoomConnection
-func ConnectToRoom(baseurl, roomID, userID string) (*ClientConnection, error) {
-	return Connect(UrlWithParamsForRoomConnection(baseurl, roomID, userID))
+func ConnectToRoom(baseurl, roomID, userID string, options ...ConnectOptions) (*ClientConnection, error) {
+	return Connect(UrlWithParamsForRoomConnection(baseurl, roomID, userID), options...)
 }
 
-// yes, I know the following is ugly, but golang is seriously missing support for generics and this is kinda mostly ok.
+// Parses connection ids created by RoomIDAndUserIDToClientConnectionIDString (see AuthenticateRoomUserPermitAllowed)
+//   Connections authenticated with an Identity have the user id as connection id, read connection.Identity instead
 func ConnectionIDStringToRoomIDAndUserID(connectionID string) (string, string, error) {
 	var connectionIDJSON map[string]string
 	if err := json.Unmarshal([]byte(connectionID), &connectionIDJSON); err != nil {
@@ -110,6 +200,12 @@ func ConnectionIDStringToRoomIDAndUserID(connectionID string) (string, string, e
 	}
 	return connectionIDJSON["r"], connectionIDJSON["u"], nil
 }
+
+// Connection id that encodes room and user as json, ids are escaped so that they can always be parsed back
 func RoomIDAndUserIDToClientConnectionIDString(roomID, userID string) string {
-	return "{\"r\":\"" + roomID + "\", \"u\":\"" + userID + "\"}"
+	return "{\"r\":" + jsonString(roomID) + ", \"u\":" + jsonString(userID) + "}"
+}
+func jsonString(raw string) string {
+	encoded, _ := json.Marshal(raw) // cannot fail for strings
+	return string(encoded)
 }
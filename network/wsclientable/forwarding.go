@@ -1,9 +1,5 @@
 package wsclientable
 
-import (
-	"log"
-)
-
 // Will add direct relay functionality to the server on the given message types.
 //   for that it will keep a map of currently open connections
 //   In the data field it will require a 'to' field,
@@ -14,7 +10,7 @@ func (s *Server) AddDirectForwardingFunctionality(messageTypes ...string) {
 	knownPeers := NewConnectionMap()
 
 	s.AddConnOpenedHandler(func(connection ClientConnection) {
-		log.Printf("Connected: %v", connection.ID)
+		s.logger.Debug("connected", "connection", connection.ID)
 
 		wasNewConnection := knownPeers.AddIfNotConnected(connection)
 		if !wasNewConnection {
@@ -22,13 +18,13 @@ func (s *Server) AddDirectForwardingFunctionality(messageTypes ...string) {
 		}
 	})
 	s.AddConnClosedHandler(func(connectionID string, code int, text string) {
-		log.Printf("Disconnected: %v (c=%v, r=%v)", connectionID, code, text)
+		s.logger.Debug("disconnected", "connection", connectionID, "code", code, "reason", text)
 		knownPeers.Remove(connectionID)
 	})
 
 	directRelayHandler := func(mType string, connection ClientConnection, data map[string]interface{}) {
 		if to, ok := data["to"]; !ok || to == nil {
-			log.Printf("Missing field for message of type %v", mType)
+			s.logger.Debug("missing field 'to'", "connection", connection.ID, "mType", mType)
 
 			return
 		}
@@ -41,7 +37,7 @@ func (s *Server) AddDirectForwardingFunctionality(messageTypes ...string) {
 			err := connection.SendTyped(
 				"error", "{\"requestType\":\""+mType+"\", \"reason\":\"Peer "+to+" not found\"}")
 			if err != nil {
-				log.Printf("Error sending to %v\n", connection)
+				s.logger.Warn("error sending", "connection", connection.ID, "mType", "error", "err", err)
 			}
 
 			return
@@ -50,7 +46,7 @@ func (s *Server) AddDirectForwardingFunctionality(messageTypes ...string) {
 		// relay to other connection
 		err := peer.SendMapTyped(mType, data)
 		if err != nil {
-			log.Printf("Error sending to %v", connection)
+			s.logger.Warn("error relaying", "connection", connection.ID, "to", to, "mType", mType, "err", err)
 		}
 	}
 
@@ -1,12 +1,17 @@
 package wsclientable
 
 import (
+	"encoding/json"
+	"fmt"
+	"github.com/jokrey/utility-algorithms-golang/network/logging"
 	"gopkg.in/ini.v1"
-	"log"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 //Idea:
@@ -23,17 +28,34 @@ import (
 //     import requests; r = requests.post("http://localhost:8087/rooms/control/add?id=test&allowed_clients=["s", "c", "parent"]"); print(r.reason, r.text)
 //     import requests; r = requests.post("http://localhost:8087/rooms/control/edit?id=test&allowed_clients=["s", "c", "parent", "admin"]"); print(r.reason, r.text)
 //     import requests; r = requests.post("http://localhost:8087/rooms/control/remove?id=test"); print(r.reason, r.text)
+//  all add and edit routes additionally accept an optional max_participants=<n> (0 or missing = unlimited)
+//    and optional roles={"<userID>":"owner", "<userID>":"moderator"} (users without a role are participants)
+//    and optional message_types=["<mType>"] and send_roles={"<mType>":["<role>"]} (see ForwardingPolicy)
+//    and optional user_windows={"<userID>": {"until_in_seconds_from_now": 1800}} (see 'room_user_access.go')
+//    and optional history={"message_types":["chat"], "replay":50} (see 'room_history.go')
+//  optionally (invite_route in the config and an [invite_tokens] section) signed invites to rooms of the editor can be minted:
+//     import requests; r = requests.post("http://localhost:8087/rooms/control/invite?id=test&user=guest&valid_until_in_seconds_from_now=3600"); print(r.reason, r.text)
+//     optional role=<role> and valid_from_in_seconds_from_now=<n>, the response is the token (see 'room_invite_tokens.go')
+//  optionally (export_route and import_route in the config) the rooms of the editor can be exported and imported (see 'room_backup.go')
 //
 //NOTE: If the server is closed all created rooms will disappear and would need to be re-added...
+//  unless the config has a [rooms_db] section, then the rooms of all editors are kept in that database (see RoomStorageFromCFG)
 
 type HTTPRoomEditor struct {
 	RoomControllerI
+	logger          logging.Logger
 	raw             *http.Server
+	addr            string // bound by Init
 	bindAddress     string
 	bindPort        int
 	addRoomRoute    string
 	editRoomRoute   string
 	removeRoomRoute string
+	inviteRoute     string
+	invites         InviteTokens
+	roomType        string // the type of the edited rooms, only those are exported and imported
+	exportRoute     string
+	importRoute     string
 }
 
 func NewHTTPRoomEditor(
@@ -42,12 +64,14 @@ func NewHTTPRoomEditor(
 ) *HTTPRoomEditor {
 	return &HTTPRoomEditor{
 		RoomControllerI: controller,
+		logger:          logging.Nop(),
 		raw:             nil,
 		bindAddress:     bindAddress,
 		bindPort:        bindPort,
 		addRoomRoute:    addRoomRoute,
 		editRoomRoute:   editRoomRoute,
 		removeRoomRoute: removeRoomRoute,
+		roomType:        RoomTypePermanent,
 	}
 }
 
@@ -57,10 +81,14 @@ func NewHTTPRoomEditorFromCFG(cfg *ini.File) *HTTPRoomEditor {
 	addRoomRoute := cfg.Section("http_room_controller").Key("add_room_route").String()
 	editRoomRoute := cfg.Section("http_room_controller").Key("edit_room_route").String()
 	removeRoomRoute := cfg.Section("http_room_controller").Key("remove_room_route").String()
-	return NewHTTPRoomEditorWithStorage(
-		NewMutableRamRoomStorage(),
+	editor := NewHTTPRoomEditorWithStorage(
+		RoomStorageFromCFG(cfg, RoomTypePermanent),
 		bindAddress, bindPort, addRoomRoute, editRoomRoute, removeRoomRoute,
 	)
+	editor.SetInviteRouteFromCFG(cfg, "http_room_controller")
+	editor.SetBackupRoutesFromCFG(cfg, "http_room_controller")
+	editor.SetLogger(logging.NewFromCFG(cfg))
+	return editor
 }
 
 func NewHTTPRoomEditorWithStorage(
@@ -82,6 +110,106 @@ func NewHTTPRoomEditorInRam(
 	)
 }
 
+// Sets the logger of the editor and of the edited controller, if it supports it (default: logging.Nop())
+func (p *HTTPRoomEditor) SetLogger(logger logging.Logger) {
+	p.logger = logging.OrNop(logger)
+	if settable, ok := p.RoomControllerI.(logging.Settable); ok {
+		settable.SetLogger(p.logger)
+	}
+}
+
+// Sets the route on which invite tokens for rooms of this editor are minted (see 'room_invite_tokens.go')
+//   has to be called before Init
+func (p *HTTPRoomEditor) SetInviteRoute(inviteRoute string, invites InviteTokens) {
+	p.inviteRoute = inviteRoute
+	p.invites = invites
+}
+
+// Sets the invite route from the invite_route key of the given section and the secret from [invite_tokens],
+//   nothing is set if the key is missing
+func (p *HTTPRoomEditor) SetInviteRouteFromCFG(cfg *ini.File, section string) {
+	inviteRoute := cfg.Section(section).Key("invite_route").String()
+	if len(inviteRoute) > 0 {
+		p.SetInviteRoute(inviteRoute, NewInviteTokensFromCFG(cfg))
+	}
+}
+
+// Sets the routes on which the rooms of this editor are exported and imported (see 'room_backup.go'), empty routes are not served
+//   has to be called before Init
+func (p *HTTPRoomEditor) SetBackupRoutes(exportRoute, importRoute string) {
+	p.exportRoute = exportRoute
+	p.importRoute = importRoute
+}
+
+// Sets the backup routes from the export_route and import_route keys of the given section
+func (p *HTTPRoomEditor) SetBackupRoutesFromCFG(cfg *ini.File, section string) {
+	p.SetBackupRoutes(cfg.Section(section).Key("export_route").String(), cfg.Section(section).Key("import_route").String())
+}
+
+// Adds the handler to the edited controller, if it reports removed rooms (see RoomRemovalNotifierI)
+func (p *HTTPRoomEditor) AddRoomRemovedHandler(handler func(roomID string)) {
+	if notifier, ok := p.RoomControllerI.(RoomRemovalNotifierI); ok {
+		notifier.AddRoomRemovedHandler(handler)
+	}
+}
+
+// Adds the handler to the edited controller, if it reports expired rooms (see RoomExpiryNotifierI)
+func (p *HTTPRoomEditor) AddRoomExpiredHandler(handler func(roomID string)) {
+	if notifier, ok := p.RoomControllerI.(RoomExpiryNotifierI); ok {
+		notifier.AddRoomExpiredHandler(handler)
+	}
+}
+
+// Subscribes to the events of the edited controller, if it reports them (see RoomEventSourceI)
+func (p *HTTPRoomEditor) SubscribeRoomEvents(handler func(event RoomEvent)) func() {
+	if source, ok := p.RoomControllerI.(RoomEventSourceI); ok {
+		return source.SubscribeRoomEvents(handler)
+	}
+	return func() {}
+}
+
+// Exports the rooms of the edited controller, if it supports it (see RoomExporterI)
+func (p *HTTPRoomEditor) ExportRooms(customCodecs ...RoomCodecI) (RoomExport, error) {
+	if exporter, ok := p.RoomControllerI.(RoomExporterI); ok {
+		return exporter.ExportRooms(customCodecs...)
+	}
+	return RoomExport{}, fmt.Errorf("cannot export rooms, the controller does not support it")
+}
+
+// Reports the reason of a rejection, if the edited controller reports it (see RoomRejectionReporterI)
+func (p *HTTPRoomEditor) NewConnectionForRoomWithReason(roomID string, connection ClientConnection) error {
+	return NewConnectionForRoomWithReason(p.RoomControllerI, roomID, connection)
+}
+
+// Iterates the connections of the edited controller, if it can (see RoomConnectionsIterableI)
+func (p *HTTPRoomEditor) ForAllIn(roomID string, f func(connection *ClientConnection)) {
+	ForAllInRoom(p.RoomControllerI, roomID, f)
+}
+
+// Counts the connections of the edited controller (see CountConnectionsInRoom)
+func (p *HTTPRoomEditor) CountConnectionsInRoom(roomID string) int {
+	return CountConnectionsInRoom(p.RoomControllerI, roomID)
+}
+
+// Only the rooms of the edited type are imported (see RoomTypedControllerI)
+func (p *HTTPRoomEditor) RoomTypes() []string {
+	return []string{p.roomType}
+}
+
+// Sets the user groups of the edited controller, if it supports them (see UserGroupsSettable)
+func (p *HTTPRoomEditor) SetUserGroups(groups UserGroupsI) {
+	if settable, ok := p.RoomControllerI.(UserGroupsSettable); ok {
+		settable.SetUserGroups(groups)
+	}
+}
+
+// Sets the closing warnings of the edited controller, if it supports them
+func (p *HTTPRoomEditor) SetClosingWarnings(offsets ...time.Duration) {
+	if settable, ok := p.RoomControllerI.(ClosingWarningsSettable); ok {
+		settable.SetClosingWarnings(offsets...)
+	}
+}
+
 // implement interface RoomControllerI:
 func (p *HTTPRoomEditor) Close() error {
 	e1 := p.RoomControllerI.Close()
@@ -93,13 +221,9 @@ func (p *HTTPRoomEditor) Close() error {
 }
 
 func (p *HTTPRoomEditor) Init() {
+	listener := p.listen()
 	go func() {
 		handler := http.NewServeMux() // required for concurrent server creation used in tests...
-		server := http.Server{
-			Addr:    p.bindAddress + ":" + strconv.Itoa(p.bindPort),
-			Handler: handler,
-		}
-		p.raw = &server
 
 		addOrEditRouteFunc := func(writer http.ResponseWriter, request *http.Request) {
 			initialParams, err := url.ParseQuery(request.URL.RawQuery)
@@ -123,8 +247,44 @@ func (p *HTTPRoomEditor) Init() {
 				_, _ = writer.Write([]byte("missing field in url params(json array string): allowed_clients"))
 				return
 			}
+			if err := ValidateAllowList(UnmarshalJsonArray(allowedClientIdsRaw)); err != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				_, _ = writer.Write([]byte("field in url params(json array of user ids, groups or patterns): allowed_clients - " + err.Error()))
+				return
+			}
+			maxParticipants, err := parseMaxParticipants(initialParams)
+			if err != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				_, _ = writer.Write([]byte("field in url params(not a positive number): max_participants"))
+				return
+			}
+			roles, err := UnmarshalJsonRoles(initialParams.Get("roles"))
+			if err != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				_, _ = writer.Write([]byte("field in url params(json object of user ids to roles): roles"))
+				return
+			}
+			policy, err := ParseForwardingPolicy(initialParams.Get("message_types"), initialParams.Get("send_roles"))
+			if err != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				_, _ = writer.Write([]byte("fields in url params(json array, json object of arrays): message_types, send_roles"))
+				return
+			}
+			userWindows, err := ParseUserWindows(initialParams.Get("user_windows"), time.Now().Unix())
+			if err != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				_, _ = writer.Write([]byte("field in url params(json object of user ids to windows): user_windows"))
+				return
+			}
+			history, err := ParseHistoryPolicy(initialParams.Get("history"))
+			if err != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				_, _ = writer.Write([]byte("field in url params(json object with message_types and replay): history - " + err.Error()))
+				return
+			}
 
-			newRoom := NewPermanentRoom(roomID, UnmarshalJsonArray(allowedClientIdsRaw))
+			newRoom := NewPermanentRoom(roomID, UnmarshalJsonArray(allowedClientIdsRaw)).
+				WithMaxParticipants(maxParticipants).WithRoles(roles).WithForwardingPolicy(policy).WithUserWindows(userWindows).WithHistoryPolicy(history)
 			err = p.AddRoom(roomID, newRoom, !isAddRoute)
 			if err != nil {
 				writer.WriteHeader(http.StatusInternalServerError)
@@ -139,16 +299,68 @@ func (p *HTTPRoomEditor) Init() {
 				response = "added permanent room(" + roomID + ") for clients" + allowedClientIdsRaw
 			}
 			_, _ = writer.Write([]byte(response))
-			log.Println(response)
+			p.logger.Info(response)
 		}
 		handler.HandleFunc(p.addRoomRoute, addOrEditRouteFunc)
 		handler.HandleFunc(p.editRoomRoute, addOrEditRouteFunc)
 		handler.HandleFunc(p.removeRoomRoute, p.httpRemoveRoomHandleFunc)
-		log.Println("Started Http Room Editor Server on " + p.bindAddress + ":" + strconv.Itoa(p.bindPort))
-		_ = server.ListenAndServe()
+		if len(p.inviteRoute) > 0 {
+			handler.HandleFunc(p.inviteRoute, p.httpMintInviteHandleFunc)
+		}
+		if len(p.exportRoute) > 0 {
+			handler.HandleFunc(p.exportRoute, p.httpExportRoomsHandleFunc)
+		}
+		if len(p.importRoute) > 0 {
+			handler.HandleFunc(p.importRoute, p.httpImportRoomsHandleFunc)
+		}
+		p.logger.Info("started http room editor server", "address", p.Addr())
+		p.serve(listener, handler)
 	}()
 }
 
+// Binds the address of the editor, so requests are accepted once Init returns (and answered once the routes are served)
+//   with bind port 0 a free port is bound, see Addr
+func (p *HTTPRoomEditor) listen() net.Listener {
+	p.raw = &http.Server{Addr: p.bindAddress + ":" + strconv.Itoa(p.bindPort)}
+	listener, err := net.Listen("tcp", p.raw.Addr)
+	if err != nil {
+		p.logger.Error("could not start http room editor server", "address", p.raw.Addr, "err", err)
+		return nil
+	}
+	p.addr = listener.Addr().String()
+	return listener
+}
+
+// Serves the routes on the bound address until the editor is closed
+func (p *HTTPRoomEditor) serve(listener net.Listener, handler http.Handler) {
+	if listener == nil {
+		return
+	}
+	p.raw.Handler = handler
+	_ = p.raw.Serve(listener)
+}
+
+// Returns the address the editor is bound to (host:port), empty before Init or if it could not be bound
+func (p *HTTPRoomEditor) Addr() string {
+	return p.addr
+}
+
+// Parses the optional max_participants url param, 0 if missing
+func parseMaxParticipants(initialParams url.Values) (int, error) {
+	raw := initialParams.Get("max_participants")
+	if len(raw) == 0 {
+		return 0, nil
+	}
+	maxParticipants, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, err
+	}
+	if maxParticipants < 0 {
+		return 0, fmt.Errorf("negative max_participants: %v", maxParticipants)
+	}
+	return maxParticipants, nil
+}
+
 func (p *HTTPRoomEditor) httpRemoveRoomHandleFunc(writer http.ResponseWriter, request *http.Request) {
 	initialParams, err := url.ParseQuery(request.URL.RawQuery)
 	if err != nil {
@@ -179,5 +391,147 @@ func (p *HTTPRoomEditor) httpRemoveRoomHandleFunc(writer http.ResponseWriter, re
 
 	response := "removed room(" + roomID + ")"
 	_, _ = writer.Write([]byte(response))
-	log.Println(response)
+	p.logger.Info(response)
+}
+
+func (p *HTTPRoomEditor) httpMintInviteHandleFunc(writer http.ResponseWriter, request *http.Request) {
+	initialParams, err := url.ParseQuery(request.URL.RawQuery)
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		_, _ = writer.Write([]byte("could not parse url"))
+		return
+	}
+
+	roomID := initialParams.Get("id")
+	userID := initialParams.Get("user")
+	validUntilInSecondsFromNow, errU := strconv.ParseInt(initialParams.Get("valid_until_in_seconds_from_now"), 10, 64)
+	validFromInSecondsFromNow, errF := int64(0), error(nil)
+	if raw := initialParams.Get("valid_from_in_seconds_from_now"); len(raw) > 0 {
+		validFromInSecondsFromNow, errF = strconv.ParseInt(raw, 10, 64)
+	}
+
+	if len(roomID) == 0 || len(userID) == 0 {
+		writer.WriteHeader(http.StatusBadRequest)
+		_, _ = writer.Write([]byte("missing field in url params(string): id or user"))
+		return
+	}
+	if errF != nil || errU != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		_, _ = writer.Write([]byte("field in url params(missing or not a number): valid_from_in_seconds_from_now or valid_until_in_seconds_from_now"))
+		return
+	}
+	var role Role
+	if rawRole := initialParams.Get("role"); len(rawRole) > 0 {
+		if role, err = ParseRole(rawRole); err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			_, _ = writer.Write([]byte("error: " + err.Error()))
+			return
+		}
+	}
+	if p.GetRoom(roomID) == nil {
+		writer.WriteHeader(http.StatusNotFound)
+		_, _ = writer.Write([]byte("cannot invite to room, not found"))
+		return
+	}
+
+	currentUnixTime := time.Now().Unix()
+	token, err := p.invites.Mint(InviteClaims{
+		RoomID: roomID, UserID: userID, Role: role,
+		NotBeforeUnixTime: currentUnixTime + validFromInSecondsFromNow,
+		ExpiresUnixTime:   currentUnixTime + validUntilInSecondsFromNow,
+	})
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		_, _ = writer.Write([]byte("error: " + err.Error()))
+		return
+	}
+
+	_, _ = writer.Write([]byte(token))
+	p.logger.Info("minted invite", "room", roomID, "user", userID, "role", role,
+		"until", time.Unix(currentUnixTime+validUntilInSecondsFromNow, 0).Format("02.01.2006-15:04:05"))
+}
+
+// The maximum size of an import request body
+const maxRoomImportBytes = 32 << 20
+
+func (p *HTTPRoomEditor) httpExportRoomsHandleFunc(writer http.ResponseWriter, request *http.Request) {
+	format := request.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "ini" {
+		writer.WriteHeader(http.StatusBadRequest)
+		_, _ = writer.Write([]byte("field in url params(json or ini): format"))
+		return
+	}
+
+	export, err := p.ExportRooms()
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		_, _ = writer.Write([]byte("error: " + err.Error()))
+		return
+	}
+	export = export.OfTypes(p.roomType)
+	if format == "ini" {
+		cfg, err := export.ToINI()
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			_, _ = writer.Write([]byte("error: " + err.Error()))
+			return
+		}
+		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = cfg.WriteTo(writer)
+	} else {
+		writer.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(writer).Encode(export)
+	}
+	p.logger.Info("exported rooms", "type", p.roomType, "rooms", len(export.Rooms), "format", format)
+}
+
+func (p *HTTPRoomEditor) httpImportRoomsHandleFunc(writer http.ResponseWriter, request *http.Request) {
+	initialParams, err := url.ParseQuery(request.URL.RawQuery)
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		_, _ = writer.Write([]byte("could not parse url"))
+		return
+	}
+	mode, err := ParseRoomImportMode(initialParams.Get("mode"))
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		_, _ = writer.Write([]byte("field in url params(merge or override): mode"))
+		return
+	}
+	dryRun := initialParams.Get("dry_run") == "true"
+	body, err := ioutil.ReadAll(http.MaxBytesReader(writer, request.Body, maxRoomImportBytes))
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		_, _ = writer.Write([]byte("could not read body: " + err.Error()))
+		return
+	}
+
+	var export RoomExport
+	switch initialParams.Get("format") {
+	case "", "json":
+		export, err = ParseRoomExport(body)
+	case "ini":
+		var cfg *ini.File
+		if cfg, err = ini.Load(body); err == nil {
+			export, err = ParseRoomExportINI(cfg)
+		}
+	default:
+		err = fmt.Errorf("field in url params(json or ini): format")
+	}
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		_, _ = writer.Write([]byte("error: " + err.Error()))
+		return
+	}
+
+	result, err := ImportRooms(p, export, mode, dryRun)
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		_, _ = writer.Write([]byte("error: " + err.Error()))
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(writer).Encode(result)
+	p.logger.Info("imported rooms", "type", p.roomType, "mode", mode, "dryRun", dryRun,
+		"added", result.Added, "replaced", result.Replaced, "kept", result.Kept, "ignored", result.Ignored)
 }
@@ -1,8 +1,8 @@
 package wsclientable
 
 import (
+	"github.com/jokrey/utility-algorithms-golang/network/logging"
 	"gopkg.in/ini.v1"
-	"log"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -24,8 +24,10 @@ import (
 //     import requests; r = requests.post("http://localhost:8087/rooms/control/add?id=test&allowed_clients=["s", "c", "parent"]"); print(r.reason, r.text)
 //     import requests; r = requests.post("http://localhost:8087/rooms/control/edit?id=test&allowed_clients=["s", "c", "parent", "admin"]"); print(r.reason, r.text)
 //     import requests; r = requests.post("http://localhost:8087/rooms/control/remove?id=test"); print(r.reason, r.text)
+//  optional bounds (the room is removed once reached): until_unix_in_seconds_from_now=3600&max_occurrences=10
 //
 //NOTE: If the server is closed all created rooms will disappear and would need to be re-added...
+//  unless [rooms_db] is configured (see RoomStorageFromCFG)
 
 type HTTPRepeatingRoomEditor struct {
 	*HTTPRoomEditor
@@ -35,12 +37,14 @@ func NewHTTPRepeatingRoomEditor(
 	controller RoomControllerI,
 	bindAddress string, bindPort int, addRoomRoute, editRoomRoute, removeRoomRoute string,
 ) *HTTPRepeatingRoomEditor {
-	return &HTTPRepeatingRoomEditor{
+	editor := &HTTPRepeatingRoomEditor{
 		HTTPRoomEditor: NewHTTPRoomEditor(
 			controller,
 			bindAddress, bindPort, addRoomRoute, editRoomRoute, removeRoomRoute,
 		),
 	}
+	editor.roomType = RoomTypeRepeating
+	return editor
 }
 
 func NewHTTPRepeatingRoomEditorFromCFG(cfg *ini.File) *HTTPRepeatingRoomEditor {
@@ -49,10 +53,14 @@ func NewHTTPRepeatingRoomEditorFromCFG(cfg *ini.File) *HTTPRepeatingRoomEditor {
 	addRoomRoute := cfg.Section("http_repeating_room_controller").Key("add_room_route").String()
 	editRoomRoute := cfg.Section("http_repeating_room_controller").Key("edit_room_route").String()
 	removeRoomRoute := cfg.Section("http_repeating_room_controller").Key("remove_room_route").String()
-	return NewHTTPRepeatingRoomEditorWithStorage(
-		NewMutableRamRoomStorage(),
+	editor := NewHTTPRepeatingRoomEditorWithStorage(
+		RoomStorageFromCFG(cfg, RoomTypeRepeating),
 		bindAddress, bindPort, addRoomRoute, editRoomRoute, removeRoomRoute,
 	)
+	editor.SetInviteRouteFromCFG(cfg, "http_repeating_room_controller")
+	editor.SetBackupRoutesFromCFG(cfg, "http_repeating_room_controller")
+	editor.SetLogger(logging.NewFromCFG(cfg))
+	return editor
 }
 
 func NewHTTPRepeatingRoomEditorWithStorage(
@@ -73,11 +81,15 @@ func NewHTTPRepeatingRoomPersistingEditorFromCFG(cfg *ini.File) *HTTPRepeatingRo
 	editRoomRoute := cfg.Section("http_repeating_room_controller_persisted").Key("edit_room_route").String()
 	removeRoomRoute := cfg.Section("http_repeating_room_controller_persisted").Key("remove_room_route").String()
 	dbPath := cfg.Section("http_repeating_room_controller_persisted").Key("db_path").String()
-	return NewHTTPRepeatingRoomEditorWithStorage(
+	editor := NewHTTPRepeatingRoomEditorWithStorage(
 		NewRepeatingRoomBoltStorage(dbPath),
 		bindAddress, bindPort,
 		addRoomRoute, editRoomRoute, removeRoomRoute,
 	)
+	editor.SetInviteRouteFromCFG(cfg, "http_repeating_room_controller_persisted")
+	editor.SetBackupRoutesFromCFG(cfg, "http_repeating_room_controller_persisted")
+	editor.SetLogger(logging.NewFromCFG(cfg))
+	return editor
 }
 
 func NewHTTPRepeatingPersistedRoomEditor(
@@ -93,6 +105,7 @@ func NewHTTPRepeatingPersistedRoomEditor(
 }
 
 func (p HTTPRepeatingRoomEditor) Init() {
+	listener := p.listen()
 	go func() {
 		handler := http.NewServeMux() // required for concurrent server creation used in tests...
 
@@ -122,6 +135,41 @@ func (p HTTPRepeatingRoomEditor) Init() {
 				_, _ = writer.Write([]byte("missing field in url params(json array string): allowed_clients"))
 				return
 			}
+			if err := ValidateAllowList(UnmarshalJsonArray(allowedClientIdsRaw)); err != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				_, _ = writer.Write([]byte("field in url params(json array of user ids, groups or patterns): allowed_clients - " + err.Error()))
+				return
+			}
+			maxParticipants, err := parseMaxParticipants(initialParams)
+			if err != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				_, _ = writer.Write([]byte("field in url params(not a positive number): max_participants"))
+				return
+			}
+			roles, err := UnmarshalJsonRoles(initialParams.Get("roles"))
+			if err != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				_, _ = writer.Write([]byte("field in url params(json object of user ids to roles): roles"))
+				return
+			}
+			policy, err := ParseForwardingPolicy(initialParams.Get("message_types"), initialParams.Get("send_roles"))
+			if err != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				_, _ = writer.Write([]byte("fields in url params(json array, json object of arrays): message_types, send_roles"))
+				return
+			}
+			userWindows, err := ParseUserWindows(initialParams.Get("user_windows"), time.Now().Unix())
+			if err != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				_, _ = writer.Write([]byte("field in url params(json object of user ids to windows): user_windows"))
+				return
+			}
+			history, err := ParseHistoryPolicy(initialParams.Get("history"))
+			if err != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				_, _ = writer.Write([]byte("field in url params(json object with message_types and replay): history - " + err.Error()))
+				return
+			}
 			if errF != nil {
 				writer.WriteHeader(http.StatusBadRequest)
 				_, _ = writer.Write([]byte("field in url params(missing or not a number): first_time_unix_in_seconds_from_now"))
@@ -138,12 +186,36 @@ func (p HTTPRepeatingRoomEditor) Init() {
 				return
 			}
 
+			var untilUnixInSecondsFromNow, maxOccurrences int64
+			if raw := initialParams.Get("until_unix_in_seconds_from_now"); len(raw) > 0 {
+				untilUnixInSecondsFromNow, err = strconv.ParseInt(raw, 10, 64)
+				if err != nil || untilUnixInSecondsFromNow <= 0 {
+					writer.WriteHeader(http.StatusBadRequest)
+					_, _ = writer.Write([]byte("field in url params(not a positive number): until_unix_in_seconds_from_now"))
+					return
+				}
+			}
+			if raw := initialParams.Get("max_occurrences"); len(raw) > 0 {
+				maxOccurrences, err = strconv.ParseInt(raw, 10, 64)
+				if err != nil || maxOccurrences <= 0 {
+					writer.WriteHeader(http.StatusBadRequest)
+					_, _ = writer.Write([]byte("field in url params(not a positive number): max_occurrences"))
+					return
+				}
+			}
+
 			currentUnixTime := time.Now().Unix()
+			var untilUnixTimestamp int64
+			if untilUnixInSecondsFromNow > 0 {
+				untilUnixTimestamp = currentUnixTime + untilUnixInSecondsFromNow
+			}
 
 			newRoom := NewRepeatingRoom(
 				roomID, UnmarshalJsonArray(allowedClientIdsRaw),
 				currentUnixTime+firstTimeUnixInSecondsFromNow, repeatEverySeconds, durationInSeconds,
-			)
+			).WithUntil(untilUnixTimestamp).WithMaxOccurrences(maxOccurrences).
+				WithMaxParticipants(maxParticipants).WithRoles(roles).WithForwardingPolicy(policy).WithUserWindows(userWindows).WithHistoryPolicy(history).
+				WithLobby(initialParams.Get("lobby") == "true")
 			err = p.AddRoom(roomID, newRoom, !isAddRoute)
 			if err != nil {
 				writer.WriteHeader(http.StatusInternalServerError)
@@ -160,19 +232,29 @@ func (p HTTPRepeatingRoomEditor) Init() {
 					", duration=" + (time.Duration(newRoom.DurationInSeconds) * time.Second).String() +
 					", repeating=" + (time.Duration(newRoom.RepeatEverySeconds) * time.Second).String()
 			}
+			if newRoom.UntilUnixTimestamp > 0 {
+				response += ", until=" + time.Unix(newRoom.UntilUnixTimestamp, 0).String()
+			}
+			if newRoom.MaxOccurrences > 0 {
+				response += ", max-occurrences=" + strconv.FormatInt(newRoom.MaxOccurrences, 10)
+			}
 			_, _ = writer.Write([]byte(response))
-			log.Println(response)
+			p.logger.Info(response)
 		}
 		handler.HandleFunc(p.addRoomRoute, addOrEditRouteFunc)
 		handler.HandleFunc(p.editRoomRoute, addOrEditRouteFunc)
 		handler.HandleFunc(p.removeRoomRoute, p.httpRemoveRoomHandleFunc)
-
-		log.Println("Started Repeating Room Editor Server on " + p.bindAddress + ":" + strconv.Itoa(p.bindPort))
-		server := http.Server{
-			Addr:    p.bindAddress + ":" + strconv.Itoa(p.bindPort),
-			Handler: handler,
+		if len(p.inviteRoute) > 0 {
+			handler.HandleFunc(p.inviteRoute, p.httpMintInviteHandleFunc)
+		}
+		if len(p.exportRoute) > 0 {
+			handler.HandleFunc(p.exportRoute, p.httpExportRoomsHandleFunc)
 		}
-		p.raw = &server
-		_ = server.ListenAndServe()
+		if len(p.importRoute) > 0 {
+			handler.HandleFunc(p.importRoute, p.httpImportRoomsHandleFunc)
+		}
+
+		p.logger.Info("started repeating room editor server", "address", p.Addr())
+		p.serve(listener, handler)
 	}()
 }
@@ -0,0 +1,205 @@
+package wsclientable
+
+import (
+	"github.com/jokrey/utility-algorithms-golang/network/logging"
+	"gopkg.in/ini.v1"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//Idea:
+//  Scheduled rooms can be edited over a local http connection, like repeating rooms (see 'http_editor_repeating.go')
+//    It is highly advisable NOT to open the port pointing to this server to the web, since there is not auth.
+//  Instead of the period fields, requests carry the schedule (see 'room_schedule.go'):
+//    'timezone' (IANA name, e.g. Europe/Berlin - UTC if missing), 'rules' (json array) and 'excluded_dates' (optional json array)
+//  example editing requests (python3) - note: the concrete required call depends on the config file:
+//     import requests; r = requests.post("http://localhost:8091/rooms/scheduled/add", params={"id": "test", "allowed_clients": '["s", "c", "parent"]', "timezone": "Europe/Berlin", "rules": '["Mon,Wed 17:00-18:30"]'}); print(r.reason, r.text)
+//     import requests; r = requests.post("http://localhost:8091/rooms/scheduled/edit", params={"id": "test", "allowed_clients": '["s", "c", "parent"]', "timezone": "Europe/Berlin", "rules": '["Mon,Wed 17:00-18:30"]', "excluded_dates": '["2026-12-23"]'}); print(r.reason, r.text)
+//     import requests; r = requests.post("http://localhost:8091/rooms/scheduled/remove?id=test"); print(r.reason, r.text)
+//
+//NOTE: If the server is closed all created rooms will disappear and would need to be re-added, unless the persisted editor is used
+//  or [rooms_db] is configured (see RoomStorageFromCFG)
+
+type HTTPScheduledRoomEditor struct {
+	*HTTPRoomEditor
+}
+
+func NewHTTPScheduledRoomEditor(
+	controller RoomControllerI,
+	bindAddress string, bindPort int, addRoomRoute, editRoomRoute, removeRoomRoute string,
+) *HTTPScheduledRoomEditor {
+	editor := &HTTPScheduledRoomEditor{
+		HTTPRoomEditor: NewHTTPRoomEditor(
+			controller,
+			bindAddress, bindPort, addRoomRoute, editRoomRoute, removeRoomRoute,
+		),
+	}
+	editor.roomType = RoomTypeScheduled
+	return editor
+}
+
+func NewHTTPScheduledRoomEditorFromCFG(cfg *ini.File) *HTTPScheduledRoomEditor {
+	bindAddress := cfg.Section("http_scheduled_room_controller").Key("address").String()
+	bindPort, _ := cfg.Section("http_scheduled_room_controller").Key("port").Int()
+	addRoomRoute := cfg.Section("http_scheduled_room_controller").Key("add_room_route").String()
+	editRoomRoute := cfg.Section("http_scheduled_room_controller").Key("edit_room_route").String()
+	removeRoomRoute := cfg.Section("http_scheduled_room_controller").Key("remove_room_route").String()
+	editor := NewHTTPScheduledRoomEditorWithStorage(
+		RoomStorageFromCFG(cfg, RoomTypeScheduled),
+		bindAddress, bindPort, addRoomRoute, editRoomRoute, removeRoomRoute,
+	)
+	editor.SetInviteRouteFromCFG(cfg, "http_scheduled_room_controller")
+	editor.SetBackupRoutesFromCFG(cfg, "http_scheduled_room_controller")
+	editor.SetLogger(logging.NewFromCFG(cfg))
+	return editor
+}
+
+func NewHTTPScheduledRoomEditorWithStorage(
+	roomStorage RoomStorageI,
+	bindAddress string, bindPort int, addRoomRoute, editRoomRoute, removeRoomRoute string,
+) *HTTPScheduledRoomEditor {
+	controller := NewScheduledRoomController(roomStorage)
+	return NewHTTPScheduledRoomEditor(
+		controller,
+		bindAddress, bindPort, addRoomRoute, editRoomRoute, removeRoomRoute,
+	)
+}
+
+func NewHTTPScheduledRoomPersistingEditorFromCFG(cfg *ini.File) *HTTPScheduledRoomEditor {
+	bindAddress := cfg.Section("http_scheduled_room_controller_persisted").Key("address").String()
+	bindPort, _ := cfg.Section("http_scheduled_room_controller_persisted").Key("port").Int()
+	addRoomRoute := cfg.Section("http_scheduled_room_controller_persisted").Key("add_room_route").String()
+	editRoomRoute := cfg.Section("http_scheduled_room_controller_persisted").Key("edit_room_route").String()
+	removeRoomRoute := cfg.Section("http_scheduled_room_controller_persisted").Key("remove_room_route").String()
+	dbPath := cfg.Section("http_scheduled_room_controller_persisted").Key("db_path").String()
+	editor := NewHTTPScheduledRoomEditorWithStorage(
+		NewScheduledRoomBoltStorage(dbPath),
+		bindAddress, bindPort,
+		addRoomRoute, editRoomRoute, removeRoomRoute,
+	)
+	editor.SetInviteRouteFromCFG(cfg, "http_scheduled_room_controller_persisted")
+	editor.SetBackupRoutesFromCFG(cfg, "http_scheduled_room_controller_persisted")
+	editor.SetLogger(logging.NewFromCFG(cfg))
+	return editor
+}
+
+func NewHTTPScheduledPersistedRoomEditor(
+	bindAddress string, bindPort int,
+	addRoomRoute, editRoomRoute, removeRoomRoute string,
+	dbPath string,
+) *HTTPScheduledRoomEditor {
+	return NewHTTPScheduledRoomEditorWithStorage(
+		NewScheduledRoomBoltStorage(dbPath),
+		bindAddress, bindPort,
+		addRoomRoute, editRoomRoute, removeRoomRoute,
+	)
+}
+
+func (p HTTPScheduledRoomEditor) Init() {
+	listener := p.listen()
+	go func() {
+		handler := http.NewServeMux() // required for concurrent server creation used in tests...
+
+		addOrEditRouteFunc := func(writer http.ResponseWriter, request *http.Request) {
+			initialParams, err := url.ParseQuery(request.URL.RawQuery)
+			if err != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				_, _ = writer.Write([]byte("could not parse url"))
+				return
+			}
+			isAddRoute := strings.HasPrefix(request.RequestURI, p.addRoomRoute) || strings.HasPrefix(request.RequestURI, "/"+p.addRoomRoute)
+
+			roomID := initialParams.Get("id")
+			allowedClientIdsRaw := initialParams.Get("allowed_clients")
+
+			if len(roomID) == 0 {
+				writer.WriteHeader(http.StatusBadRequest)
+				_, _ = writer.Write([]byte("missing field in url params(string): id"))
+				return
+			}
+			if len(allowedClientIdsRaw) == 0 {
+				writer.WriteHeader(http.StatusBadRequest)
+				_, _ = writer.Write([]byte("missing field in url params(json array string): allowed_clients"))
+				return
+			}
+			if err := ValidateAllowList(UnmarshalJsonArray(allowedClientIdsRaw)); err != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				_, _ = writer.Write([]byte("field in url params(json array of user ids, groups or patterns): allowed_clients - " + err.Error()))
+				return
+			}
+			maxParticipants, err := parseMaxParticipants(initialParams)
+			if err != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				_, _ = writer.Write([]byte("field in url params(not a positive number): max_participants"))
+				return
+			}
+			roles, err := UnmarshalJsonRoles(initialParams.Get("roles"))
+			if err != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				_, _ = writer.Write([]byte("field in url params(json object of user ids to roles): roles"))
+				return
+			}
+			policy, err := ParseForwardingPolicy(initialParams.Get("message_types"), initialParams.Get("send_roles"))
+			if err != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				_, _ = writer.Write([]byte("fields in url params(json array, json object of arrays): message_types, send_roles"))
+				return
+			}
+			userWindows, err := ParseUserWindows(initialParams.Get("user_windows"), time.Now().Unix())
+			if err != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				_, _ = writer.Write([]byte("field in url params(json object of user ids to windows): user_windows"))
+				return
+			}
+			history, err := ParseHistoryPolicy(initialParams.Get("history"))
+			if err != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				_, _ = writer.Write([]byte("field in url params(json object with message_types and replay): history - " + err.Error()))
+				return
+			}
+			schedule, err := ParseSchedule(initialParams.Get("timezone"), initialParams.Get("rules"), initialParams.Get("excluded_dates"))
+			if err != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				_, _ = writer.Write([]byte("fields in url params(iana timezone, json array of rules, json array of dates): timezone, rules, excluded_dates - " + err.Error()))
+				return
+			}
+
+			newRoom := NewScheduledRoom(roomID, UnmarshalJsonArray(allowedClientIdsRaw), schedule).
+				WithMaxParticipants(maxParticipants).WithRoles(roles).WithForwardingPolicy(policy).WithUserWindows(userWindows).WithHistoryPolicy(history).
+				WithLobby(initialParams.Get("lobby") == "true")
+			err = p.AddRoom(roomID, newRoom, !isAddRoute)
+			if err != nil {
+				writer.WriteHeader(http.StatusInternalServerError)
+				_, _ = writer.Write([]byte("error: " + err.Error()))
+				return
+			}
+
+			var response string
+			if len(newRoom.allowedClients) == 0 {
+				response = "added scheduled room(" + roomID + ") for all clients"
+			} else {
+				response = "added scheduled room(" + roomID + ") for clients " + allowedClientIdsRaw
+			}
+			response += " with rules=" + initialParams.Get("rules") + " in " + schedule.Location().String()
+			_, _ = writer.Write([]byte(response))
+			p.logger.Info(response)
+		}
+		handler.HandleFunc(p.addRoomRoute, addOrEditRouteFunc)
+		handler.HandleFunc(p.editRoomRoute, addOrEditRouteFunc)
+		handler.HandleFunc(p.removeRoomRoute, p.httpRemoveRoomHandleFunc)
+		if len(p.inviteRoute) > 0 {
+			handler.HandleFunc(p.inviteRoute, p.httpMintInviteHandleFunc)
+		}
+		if len(p.exportRoute) > 0 {
+			handler.HandleFunc(p.exportRoute, p.httpExportRoomsHandleFunc)
+		}
+		if len(p.importRoute) > 0 {
+			handler.HandleFunc(p.importRoute, p.httpImportRoomsHandleFunc)
+		}
+
+		p.logger.Info("started scheduled room editor server", "address", p.Addr())
+		p.serve(listener, handler)
+	}()
+}
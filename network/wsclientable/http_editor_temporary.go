@@ -1,8 +1,8 @@
 package wsclientable
 
 import (
+	"github.com/jokrey/utility-algorithms-golang/network/logging"
 	"gopkg.in/ini.v1"
-	"log"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -24,11 +24,17 @@ import (
 //     import requests; r = requests.post("http://localhost:8087/rooms/control/add?id=test&allowed_clients=["s", "c", "parent"]"); print(r.reason, r.text)
 //     import requests; r = requests.post("http://localhost:8087/rooms/control/edit?id=test&allowed_clients=["s", "c", "parent", "admin"]"); print(r.reason, r.text)
 //     import requests; r = requests.post("http://localhost:8087/rooms/control/remove?id=test"); print(r.reason, r.text)
+//  optionally (extend_room_route and shorten_room_route in the config) the end of a room can be moved without reconnects:
+//     import requests; r = requests.post("http://localhost:8087/rooms/control/extend?id=test&seconds=600"); print(r.reason, r.text)
+//     import requests; r = requests.post("http://localhost:8087/rooms/control/shorten?id=test&seconds=300"); print(r.reason, r.text)
 //
 //NOTE: If the server is closed all created rooms will disappear and would need to be re-added...
+//  unless [rooms_db] is configured, the temporary rooms are then kept next to the rooms of the other editors
 
 type HTTPTemporaryRoomEditor struct {
 	*HTTPRoomEditor
+	extendRoomRoute  string
+	shortenRoomRoute string
 }
 
 func NewHTTPTemporaryRoomEditorFromCFG(cfg *ini.File) *HTTPTemporaryRoomEditor {
@@ -37,10 +43,18 @@ func NewHTTPTemporaryRoomEditorFromCFG(cfg *ini.File) *HTTPTemporaryRoomEditor {
 	addRoomRoute := cfg.Section("http_room_controller").Key("add_room_route").String()
 	editRoomRoute := cfg.Section("http_room_controller").Key("edit_room_route").String()
 	removeRoomRoute := cfg.Section("http_room_controller").Key("remove_room_route").String()
-	return NewHTTPTemporaryRoomEditorWithStorage(
-		NewMutableRamRoomStorage(),
+	editor := NewHTTPTemporaryRoomEditorWithStorage(
+		RoomStorageFromCFG(cfg, RoomTypeTemporary),
 		bindAddress, bindPort, addRoomRoute, editRoomRoute, removeRoomRoute,
 	)
+	editor.SetExtendAndShortenRoutes(
+		cfg.Section("http_room_controller").Key("extend_room_route").String(),
+		cfg.Section("http_room_controller").Key("shorten_room_route").String(),
+	)
+	editor.SetInviteRouteFromCFG(cfg, "http_room_controller")
+	editor.SetBackupRoutesFromCFG(cfg, "http_room_controller")
+	editor.SetLogger(logging.NewFromCFG(cfg))
+	return editor
 }
 
 func NewHTTPTemporaryRoomEditorWithStorage(
@@ -57,12 +71,14 @@ func NewHTTPTemporaryRoomEditor(
 	controller RoomControllerI,
 	bindAddress string, bindPort int, addRoomRoute, editRoomRoute, removeRoomRoute string,
 ) *HTTPTemporaryRoomEditor {
-	return &HTTPTemporaryRoomEditor{
+	editor := &HTTPTemporaryRoomEditor{
 		HTTPRoomEditor: NewHTTPRoomEditor(
 			controller,
 			bindAddress, bindPort, addRoomRoute, editRoomRoute, removeRoomRoute,
 		),
 	}
+	editor.roomType = RoomTypeTemporary
+	return editor
 }
 func NewHTTPTemporaryRoomEditorInRam(
 	bindAddress string, bindPort int, addRoomRoute, editRoomRoute, removeRoomRoute string,
@@ -80,10 +96,18 @@ func NewHTTPTemporaryPersistedRoomEditorFromCfg(cfg *ini.File) *HTTPTemporaryRoo
 	editRoomRoute := cfg.Section("http_temporary_room_controller_persisted").Key("edit_room_route").String()
 	removeRoomRoute := cfg.Section("http_temporary_room_controller_persisted").Key("remove_room_route").String()
 	dbPath := cfg.Section("http_temporary_room_controller_persisted").Key("db_path").String()
-	return NewHTTPTemporaryRoomEditorWithStorage(
+	editor := NewHTTPTemporaryRoomEditorWithStorage(
 		NewTemporaryRoomBoltStorage(dbPath),
 		bindAddress, bindPort, addRoomRoute, editRoomRoute, removeRoomRoute,
 	)
+	editor.SetExtendAndShortenRoutes(
+		cfg.Section("http_temporary_room_controller_persisted").Key("extend_room_route").String(),
+		cfg.Section("http_temporary_room_controller_persisted").Key("shorten_room_route").String(),
+	)
+	editor.SetInviteRouteFromCFG(cfg, "http_temporary_room_controller_persisted")
+	editor.SetBackupRoutesFromCFG(cfg, "http_temporary_room_controller_persisted")
+	editor.SetLogger(logging.NewFromCFG(cfg))
+	return editor
 }
 
 func NewHTTPTemporaryPersistedRoomEditor(
@@ -98,7 +122,15 @@ func NewHTTPTemporaryPersistedRoomEditor(
 	)
 }
 
+// Sets the routes that move only the end of a room (see RoomEndMovableI), empty routes are not served (the default)
+//   must be called before Init
+func (p *HTTPTemporaryRoomEditor) SetExtendAndShortenRoutes(extendRoomRoute, shortenRoomRoute string) {
+	p.extendRoomRoute = extendRoomRoute
+	p.shortenRoomRoute = shortenRoomRoute
+}
+
 func (p *HTTPTemporaryRoomEditor) Init() {
+	listener := p.listen()
 	go func() {
 		handler := http.NewServeMux() // required for concurrent server creation used in tests...
 
@@ -129,6 +161,41 @@ func (p *HTTPTemporaryRoomEditor) Init() {
 				_, _ = writer.Write([]byte("missing field in url params(json array string): allowed_clients"))
 				return
 			}
+			if err := ValidateAllowList(UnmarshalJsonArray(allowedClientIdsRaw)); err != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				_, _ = writer.Write([]byte("field in url params(json array of user ids, groups or patterns): allowed_clients - " + err.Error()))
+				return
+			}
+			maxParticipants, err := parseMaxParticipants(initialParams)
+			if err != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				_, _ = writer.Write([]byte("field in url params(not a positive number): max_participants"))
+				return
+			}
+			roles, err := UnmarshalJsonRoles(initialParams.Get("roles"))
+			if err != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				_, _ = writer.Write([]byte("field in url params(json object of user ids to roles): roles"))
+				return
+			}
+			policy, err := ParseForwardingPolicy(initialParams.Get("message_types"), initialParams.Get("send_roles"))
+			if err != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				_, _ = writer.Write([]byte("fields in url params(json array, json object of arrays): message_types, send_roles"))
+				return
+			}
+			userWindows, err := ParseUserWindows(initialParams.Get("user_windows"), time.Now().Unix())
+			if err != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				_, _ = writer.Write([]byte("field in url params(json object of user ids to windows): user_windows"))
+				return
+			}
+			history, err := ParseHistoryPolicy(initialParams.Get("history"))
+			if err != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				_, _ = writer.Write([]byte("field in url params(json object with message_types and replay): history - " + err.Error()))
+				return
+			}
 			if errF != nil {
 				writer.WriteHeader(http.StatusBadRequest)
 				_, _ = writer.Write([]byte("field in url params(missing or not a number): valid_from_in_seconds_from_now"))
@@ -145,7 +212,8 @@ func (p *HTTPTemporaryRoomEditor) Init() {
 			newRoom := NewTemporaryRoom(
 				roomID, UnmarshalJsonArray(allowedClientIdsRaw),
 				currentUnixTime+validFromInSecondsFromNow, currentUnixTime+validUntilInSecondsFromNow,
-			)
+			).WithMaxParticipants(maxParticipants).WithRoles(roles).WithForwardingPolicy(policy).WithUserWindows(userWindows).WithHistoryPolicy(history).
+				WithLobby(initialParams.Get("lobby") == "true")
 			err = p.AddRoom(roomID, newRoom, !isAddRoute)
 			if err != nil {
 				writer.WriteHeader(http.StatusInternalServerError)
@@ -163,18 +231,83 @@ func (p *HTTPTemporaryRoomEditor) Init() {
 				response = "added temporary room(" + roomID + ") for clients" + allowedClientIdsRaw + activeBetweenString
 			}
 			_, _ = writer.Write([]byte(response))
-			log.Println(response)
+			p.logger.Info(response)
 		}
 		handler.HandleFunc(p.addRoomRoute, addOrEditRouteFunc)
 		handler.HandleFunc(p.editRoomRoute, addOrEditRouteFunc)
 		handler.HandleFunc(p.removeRoomRoute, p.httpRemoveRoomHandleFunc)
-
-		log.Println("Started Temporary Room Editor Server on " + p.bindAddress + ":" + strconv.Itoa(p.bindPort))
-		server := http.Server{
-			Addr:    p.bindAddress + ":" + strconv.Itoa(p.bindPort),
-			Handler: handler,
+		if len(p.inviteRoute) > 0 {
+			handler.HandleFunc(p.inviteRoute, p.httpMintInviteHandleFunc)
+		}
+		if len(p.exportRoute) > 0 {
+			handler.HandleFunc(p.exportRoute, p.httpExportRoomsHandleFunc)
 		}
-		p.raw = &server
-		_ = server.ListenAndServe()
+		if len(p.importRoute) > 0 {
+			handler.HandleFunc(p.importRoute, p.httpImportRoomsHandleFunc)
+		}
+		if len(p.extendRoomRoute) > 0 {
+			handler.HandleFunc(p.extendRoomRoute, p.httpMoveRoomEndHandleFunc(true))
+		}
+		if len(p.shortenRoomRoute) > 0 {
+			handler.HandleFunc(p.shortenRoomRoute, p.httpMoveRoomEndHandleFunc(false))
+		}
+
+		p.logger.Info("started temporary room editor server", "address", p.Addr())
+		p.serve(listener, handler)
 	}()
 }
+
+func (p *HTTPTemporaryRoomEditor) httpMoveRoomEndHandleFunc(extend bool) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		initialParams, err := url.ParseQuery(request.URL.RawQuery)
+		if err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			_, _ = writer.Write([]byte("could not parse url"))
+			return
+		}
+
+		roomID := initialParams.Get("id")
+		seconds, errS := strconv.ParseInt(initialParams.Get("seconds"), 10, 64)
+
+		if len(roomID) == 0 {
+			writer.WriteHeader(http.StatusBadRequest)
+			_, _ = writer.Write([]byte("missing field in url params(string): id"))
+			return
+		}
+		if errS != nil || seconds < 0 {
+			writer.WriteHeader(http.StatusBadRequest)
+			_, _ = writer.Write([]byte("field in url params(missing or not a positive number): seconds"))
+			return
+		}
+		movable, ok := p.RoomControllerI.(RoomEndMovableI)
+		if !ok {
+			writer.WriteHeader(http.StatusNotImplemented)
+			_, _ = writer.Write([]byte("controller cannot move the end of rooms"))
+			return
+		}
+
+		var changedRoom TemporaryRoom
+		verb := "extend"
+		if extend {
+			changedRoom, err = movable.ExtendRoom(roomID, seconds)
+		} else {
+			changedRoom, err = movable.ShortenRoom(roomID, seconds)
+			verb = "shorten"
+		}
+		if _, notFound := err.(RoomNotFoundError); notFound {
+			writer.WriteHeader(http.StatusNotFound)
+			_, _ = writer.Write([]byte("cannot " + verb + " room, not found"))
+			return
+		}
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			_, _ = writer.Write([]byte("error: " + err.Error()))
+			return
+		}
+
+		response := verb + "ed temporary room(" + roomID + ") - active until " +
+			time.Unix(changedRoom.ValidUntilUnixTime, 0).Format("02.01.2006-15:04:05")
+		_, _ = writer.Write([]byte(response))
+		p.logger.Info(response)
+	}
+}
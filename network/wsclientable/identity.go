@@ -0,0 +1,77 @@
+package wsclientable
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+//Idea:
+//  Every connection on the server carries the Identity it was authenticated with.
+//  Handlers and room controllers read the room and user directly from it, instead of parsing a connection id.
+//  Authenticators can attach arbitrary attributes (for example a display name or roles),
+//     which are then available for the lifetime of the connection.
+//  IDs are validated once, when the connection is authenticated (see ValidateID).
+
+// Maximum length (in bytes) of room and user ids
+const MaxIDLength = 256
+
+type Identity struct {
+	// Empty for connections that are not in a room
+	RoomID string
+	UserID string
+	// Set by the authenticator, never modified afterwards (must not be written by handlers, it is shared)
+	Attributes map[string]interface{}
+}
+
+// Returns the attribute under the given key, or nil
+func (i Identity) Attribute(key string) interface{} {
+	return i.Attributes[key]
+}
+
+// Returns the string attribute under the given key, or "" if it is not set or not a string
+func (i Identity) StringAttribute(key string) string {
+	v, _ := i.Attributes[key].(string)
+	return v
+}
+
+// Returns a copy of the identity, with the given attribute set
+func (i Identity) WithAttribute(key string, value interface{}) Identity {
+	attributes := make(map[string]interface{}, len(i.Attributes)+1)
+	for k, v := range i.Attributes {
+		attributes[k] = v
+	}
+	attributes[key] = value
+	i.Attributes = attributes
+	return i
+}
+
+// Checks that the id (of the given kind, i.e. "room" or "user") is non empty, valid utf8,
+//   at most MaxIDLength bytes long and does not contain control characters
+func ValidateID(kind, id string) error {
+	if len(id) == 0 {
+		return AuthenticationError{Reason: kind + " id is empty"}
+	}
+	if len(id) > MaxIDLength {
+		return AuthenticationError{Reason: kind + " id is too long"}
+	}
+	if !utf8.ValidString(id) {
+		return AuthenticationError{Reason: kind + " id is not valid utf8"}
+	}
+	for _, r := range id {
+		if unicode.IsControl(r) {
+			return AuthenticationError{Reason: kind + " id contains control characters"}
+		}
+	}
+	return nil
+}
+
+// Validates the user id and (if set) the room id of the identity
+func (i Identity) Validate() error {
+	if err := ValidateID("user", i.UserID); err != nil {
+		return err
+	}
+	if len(i.RoomID) > 0 {
+		return ValidateID("room", i.RoomID)
+	}
+	return nil
+}
@@ -8,6 +8,8 @@
 //       For that we store the incoming websocket connections.
 //           (it is also out of the box possible to create virtual servers, called rooms)
 //       2.1. Authentication based on initial http request params is easily customizable
+//            Connections carry their authenticated Identity (room and user). Their ID is the user id,
+//            except for the deprecated AuthenticateRoomUserPermitAllowed, which still encodes room and user in the ID.
 package wsclientable
 
 import (
@@ -33,6 +35,37 @@ func (m AuthenticationError) Error() string {
 	return "Authentication failed, because " + m.Reason
 }
 
+// Error if a connection is not accepted into a room (for example because the room is full)
+type RoomRejectionError struct {
+	RoomID string
+	Reason string
+}
+
+func (m RoomRejectionError) Error() string {
+	return "Rejected from room(" + m.RoomID + "), because " + m.Reason
+}
+
+// Error if an operation requires a room that does not exist
+type RoomNotFoundError struct {
+	RoomID string
+}
+
+func (m RoomNotFoundError) Error() string {
+	return "room(" + m.RoomID + ") not found"
+}
+
+// Error if a versioned change of the shared state of a room expected another version of the key (see 'room_state.go')
+type RoomStateVersionConflictError struct {
+	RoomID          string
+	Key             string
+	ExpectedVersion uint64
+	CurrentVersion  uint64
+}
+
+func (m RoomStateVersionConflictError) Error() string {
+	return fmt.Sprintf("state key %q of room(%v) has version %v, expected %v", m.Key, m.RoomID, m.CurrentVersion, m.ExpectedVersion)
+}
+
 // Given cfg requires ssl.<child> sections.
 // Each section must have a 'cert_path' and 'key_path' field.
 // The resulting cert-paths can be used when starting a wsclientable-server
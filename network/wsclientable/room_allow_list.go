@@ -0,0 +1,321 @@
+package wsclientable
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"github.com/jokrey/utility-algorithms-golang/network/logging"
+	"gopkg.in/ini.v1"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+//Idea:
+//  Listing every user of every room does not scale (e.g. 30 students per classroom, edited whenever a student joins).
+//  Entries of the allowed clients of a room can therefore be more than exact user ids:
+//     "alice"                 exactly the user alice
+//     "group:students"        all members of the named user group
+//     "glob:teacher-*"        all users matching the glob (* any sequence, ? any single character)
+//     "regex:^t[0-9]{3}$"     all users matching the regular expression (must match the whole id)
+//     "!<entry>"              deny entry, users matching it are rejected even if another entry allows them
+//  If a room has only deny entries, all other users are allowed (like a room without allowed clients).
+//  NOTE: Before these entries existed every entry was an exact user id. Existing entries that start with "!", "group:",
+//     "glob:" or "regex:" now have the meaning above, rename such users or their entries when upgrading.
+//  Groups are resolved by the controller that returns the room (SetUserGroups on a controller or on RoomControllers,
+//     default: no groups, group entries match nobody). Changes to a group apply to all rooms of the controller immediately.
+//  Groups can be defined in the config (RoomControllers.SetUserGroupsFromCFG):
+//     [user_groups]
+//     students=["s1", "s2", "s3"]
+//  or kept in a bolt database ([user_groups_persisted] db_path=groups.db, see NewUserGroupsBoltStorage)
+//  Compiled patterns are cached (the maxCompiledAllowPatterns most recently used), since rooms are decoded again on every lookup
+
+const (
+	AllowEntryGroupPrefix = "group:"
+	AllowEntryGlobPrefix  = "glob:"
+	AllowEntryRegexPrefix = "regex:"
+	AllowEntryDenyPrefix  = "!"
+)
+
+// Validates the entries of an allow list, i.e. that patterns compile and group names are not empty
+func ValidateAllowList(entries []string) error {
+	for _, entry := range entries {
+		pattern := strings.TrimPrefix(entry, AllowEntryDenyPrefix)
+		if len(pattern) == 0 {
+			return fmt.Errorf("empty allow list entry %q", entry)
+		}
+		switch {
+		case strings.HasPrefix(pattern, AllowEntryGroupPrefix):
+			if len(pattern) == len(AllowEntryGroupPrefix) {
+				return fmt.Errorf("allow list entry %q without group name", entry)
+			}
+		case strings.HasPrefix(pattern, AllowEntryGlobPrefix), strings.HasPrefix(pattern, AllowEntryRegexPrefix):
+			if _, err := compileAllowPattern(pattern); err != nil {
+				return fmt.Errorf("invalid allow list entry %q: %w", entry, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Returns whether the user is allowed by the entries (true if there are no allowing entries) and whether it is denied
+//   group entries are resolved with the given groups, they match nobody if groups is nil
+func matchAllowList(entries map[string]bool, groups UserGroupsI, userID string) (allowed bool, denied bool) {
+	if len(entries) == 0 {
+		return true, false
+	}
+	hasAllowingEntries := false
+	for entry := range entries {
+		if strings.HasPrefix(entry, AllowEntryDenyPrefix) {
+			if matchesAllowEntry(entry[len(AllowEntryDenyPrefix):], groups, userID) {
+				return false, true
+			}
+			continue
+		}
+		hasAllowingEntries = true
+		if !allowed && matchesAllowEntry(entry, groups, userID) {
+			allowed = true
+		}
+	}
+	return allowed || !hasAllowingEntries, false
+}
+
+func matchesAllowEntry(entry string, groups UserGroupsI, userID string) bool {
+	switch {
+	case strings.HasPrefix(entry, AllowEntryGroupPrefix):
+		return groups != nil && groups.IsMember(entry[len(AllowEntryGroupPrefix):], userID)
+	case strings.HasPrefix(entry, AllowEntryGlobPrefix), strings.HasPrefix(entry, AllowEntryRegexPrefix):
+		compiled, err := compileAllowPattern(entry)
+		return err == nil && compiled.MatchString(userID)
+	default:
+		return entry == userID
+	}
+}
+
+// Upper bound of cached compiled patterns, the least recently used pattern is evicted when it is exceeded
+const maxCompiledAllowPatterns = 1024
+
+// rooms are decoded on every lookup, so that patterns in use are only compiled once
+var compiledAllowPatterns = newAllowPatternCache(maxCompiledAllowPatterns)
+
+// Least recently used cache of compiled patterns
+type allowPatternCache struct {
+	mut     sync.Mutex
+	max     int
+	order   *list.List               // most recently used at the front, of *allowPatternCacheEntry
+	entries map[string]*list.Element // pattern -> element in order
+}
+
+type allowPatternCacheEntry struct {
+	pattern  string
+	compiled *regexp.Regexp
+}
+
+func newAllowPatternCache(max int) *allowPatternCache {
+	return &allowPatternCache{max: max, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (c *allowPatternCache) get(pattern string) (*regexp.Regexp, bool) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	element, ok := c.entries[pattern]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*allowPatternCacheEntry).compiled, true
+}
+
+func (c *allowPatternCache) add(pattern string, compiled *regexp.Regexp) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if element, ok := c.entries[pattern]; ok { // compiled concurrently
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[pattern] = c.order.PushFront(&allowPatternCacheEntry{pattern: pattern, compiled: compiled})
+	if c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*allowPatternCacheEntry).pattern)
+	}
+}
+
+func compileAllowPattern(pattern string) (*regexp.Regexp, error) {
+	if compiled, ok := compiledAllowPatterns.get(pattern); ok {
+		return compiled, nil
+	}
+	var expression string
+	if strings.HasPrefix(pattern, AllowEntryGlobPrefix) {
+		quoted := regexp.QuoteMeta(pattern[len(AllowEntryGlobPrefix):])
+		expression = strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(quoted)
+	} else {
+		expression = pattern[len(AllowEntryRegexPrefix):]
+	}
+	compiled, err := regexp.Compile("^(?:" + expression + ")$")
+	if err != nil {
+		return nil, err
+	}
+	compiledAllowPatterns.add(pattern, compiled)
+	return compiled, nil
+}
+
+// Named groups of users, referenced by allow list entries ("group:<name>")
+type UserGroupsI interface {
+	// Whether the user is a member of the group, false if the group does not exist
+	IsMember(group, userID string) bool
+	// The members of the group, empty if the group does not exist
+	Members(group string) []string
+	// Replaces the members of the group, no members remove the group
+	SetGroup(group string, members []string) error
+	AddMember(group, userID string) error
+	RemoveMember(group, userID string) error
+
+	// Closes underlying resources
+	Close() error
+}
+
+// Implemented by the controllers (and editors) that resolve the group entries of their rooms
+type UserGroupsSettable interface {
+	// Sets the groups that group entries of the rooms of the controller are resolved with (nil: no groups)
+	//   the caller keeps ownership, the controller does not close them
+	SetUserGroups(groups UserGroupsI)
+}
+
+// The groups of a controller, shared with the callbacks it starts (for example the end of personal access windows)
+//   SetUserGroups may replace them while other goroutines check connections against them
+type sharedUserGroups struct {
+	current atomic.Value // holds a userGroupsHolder once set
+}
+
+type userGroupsHolder struct {
+	UserGroupsI
+}
+
+// nil until set
+func (s *sharedUserGroups) get() UserGroupsI {
+	holder, _ := s.current.Load().(userGroupsHolder)
+	return holder.UserGroupsI
+}
+func (s *sharedUserGroups) set(groups UserGroupsI) {
+	s.current.Store(userGroupsHolder{groups})
+}
+
+// Returns the room with its group entries resolved by the given groups
+//   rooms that do not embed Room (custom rooms) are returned unchanged
+func withUserGroups(room RoomI, groups UserGroupsI) RoomI {
+	if attachable, ok := room.(userGroupsAttachable); ok {
+		return attachable.withUserGroups(groups)
+	}
+	return room
+}
+
+type userGroupsAttachable interface {
+	withUserGroups(groups UserGroupsI) RoomI
+}
+
+func (r PermanentRoom) withUserGroups(groups UserGroupsI) RoomI {
+	r.groups = groups
+	return r
+}
+func (r TemporaryRoom) withUserGroups(groups UserGroupsI) RoomI {
+	r.groups = groups
+	return r
+}
+func (r RepeatingRoom) withUserGroups(groups UserGroupsI) RoomI {
+	r.groups = groups
+	return r
+}
+func (r ScheduledRoom) withUserGroups(groups UserGroupsI) RoomI {
+	r.groups = groups
+	return r
+}
+
+// Creates the user groups defined in the config, nil if it defines none (see Idea above)
+//   [user_groups_persisted] takes precedence over [user_groups]
+func LoadUserGroupsFromCFG(cfg *ini.File) UserGroupsI {
+	logger := logging.NewFromCFG(cfg)
+	if _, err := cfg.GetSection("user_groups_persisted"); err == nil {
+		storage, err := NewUserGroupsBoltStorage(cfg.Section("user_groups_persisted").Key("db_path").String())
+		if err != nil {
+			panic("Could not load [user_groups_persisted]: " + err.Error())
+		}
+		storage.SetLogger(logger)
+		logger.Info("user groups loaded from database")
+		return storage
+	} else if _, err := cfg.GetSection("user_groups"); err == nil {
+		logger.Info("user groups loaded from config", "groups", cfg.Section("user_groups").KeyStrings())
+		return NewUserGroupsFromCFG(cfg)
+	}
+	return nil
+}
+
+// Creates in memory user groups from the [user_groups] section, each key is a group with a json array of members
+func NewUserGroupsFromCFG(cfg *ini.File) RamUserGroups {
+	groups := NewRamUserGroups()
+	for _, key := range cfg.Section("user_groups").Keys() {
+		var members []string
+		if err := json.Unmarshal([]byte(key.String()), &members); err != nil {
+			panic("Could not load user group (" + key.Name() + "), members not a json list: " + key.String())
+		}
+		_ = groups.SetGroup(key.Name(), members)
+	}
+	return groups
+}
+
+// UserGroupsI held in memory, lost when the program exits
+type RamUserGroups struct {
+	mut    *sync.RWMutex
+	groups map[string]map[string]bool
+}
+
+func NewRamUserGroups() RamUserGroups {
+	return RamUserGroups{mut: &sync.RWMutex{}, groups: make(map[string]map[string]bool)}
+}
+
+func (g RamUserGroups) IsMember(group, userID string) bool {
+	g.mut.RLock()
+	defer g.mut.RUnlock()
+	return g.groups[group][userID]
+}
+func (g RamUserGroups) Members(group string) []string {
+	g.mut.RLock()
+	defer g.mut.RUnlock()
+	members := make([]string, 0, len(g.groups[group]))
+	for userID := range g.groups[group] {
+		members = append(members, userID)
+	}
+	return members
+}
+func (g RamUserGroups) SetGroup(group string, members []string) error {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	if len(members) == 0 {
+		delete(g.groups, group)
+	} else {
+		g.groups[group] = CreateAllowedIdsMapFromSlice(members)
+	}
+	return nil
+}
+func (g RamUserGroups) AddMember(group, userID string) error {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	if _, ok := g.groups[group]; !ok {
+		g.groups[group] = make(map[string]bool)
+	}
+	g.groups[group][userID] = true
+	return nil
+}
+func (g RamUserGroups) RemoveMember(group, userID string) error {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	delete(g.groups[group], userID)
+	if len(g.groups[group]) == 0 {
+		delete(g.groups, group)
+	}
+	return nil
+}
+func (g RamUserGroups) Close() error {
+	return nil
+}
@@ -0,0 +1,376 @@
+package wsclientable
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/ini.v1"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Idea:
+//  Rooms can be exported from any storage that can list its rooms (IterableRoomStorageI) and imported into a controller,
+//     to move them between environments or to back them up.
+//  The export is json, each room is the document of its type (see 'room_json.go') tagged with the type:
+//     {"version": 1, "exported_unix_time": 1700000000,
+//      "rooms": [{"type": "temporary", "room": {"id": "t", "allowed_clients": ["a"], "valid_from": 1700000000, "valid_until": 1700003600}}]}
+//  or ini, one section per room named <type>_rooms.<n>, with the fields of the document as keys:
+//     [temporary_rooms.room1]
+//     id          = t
+//     allowed_clients = ["a"]
+//     valid_from  = 1700000000
+//     valid_until = 1700003600
+//     arrays and objects are written as json, like in the config (exported permanent rooms can be loaded from [permanent_rooms.*])
+//     strings are written as they are, so a string field (other than the id) that is valid json itself is read back as json
+//  Times are unix times, so an imported room is open exactly when the exported room was.
+//
+//  Imports go through a controller (RoomControllerI.AddRoom), which disconnects the connected clients an imported room does not allow.
+//     A controller that reports its room types (RoomTypedControllerI) only gets rooms of those types, the others are reported as ignored.
+//     Imported into RoomControllers, each room goes to the first bundled controller that takes its type.
+//     All rooms are decoded and validated before the first is added, an export with a broken room changes nothing.
+//     RoomImportMerge adds the rooms that do not exist yet and keeps the existing ones,
+//     RoomImportOverride also replaces the existing rooms.
+//     A dry run makes the same checks, but only reports which rooms would be added, replaced, kept or ignored.
+//  The http editors offer both on optional routes (export_route and import_route in their config section):
+//     import requests; r = requests.get("http://localhost:8087/rooms/control/export?format=ini"); print(r.reason, r.text)
+//     import requests; r = requests.post("http://localhost:8087/rooms/control/import?mode=merge&dry_run=true", data=open("rooms.json").read()); print(r.reason, r.text)
+//     an editor exports and imports only rooms of its type, rooms of other types in an import are reported as ignored
+
+// The version of the export format, newer exports are rejected
+const RoomExportVersion = 1
+
+type RoomExport struct {
+	Version          int            `json:"version"`
+	ExportedUnixTime int64          `json:"exported_unix_time"`
+	Rooms            []ExportedRoom `json:"rooms"`
+}
+
+// A room as the json document of its type
+type ExportedRoom struct {
+	Type string          `json:"type"`
+	Room json.RawMessage `json:"room"`
+}
+
+// Returns the id of the exported room, empty if the document has none
+//   parses the document, so callers that need the id more than once keep it
+func (r ExportedRoom) ID() string {
+	var document struct {
+		ID string `json:"id"`
+	}
+	_ = json.Unmarshal(r.Room, &document)
+	return document.ID
+}
+
+// Returns a copy of the export with only the rooms of the given types
+func (e RoomExport) OfTypes(roomTypes ...string) RoomExport {
+	filtered := RoomExport{Version: e.Version, ExportedUnixTime: e.ExportedUnixTime, Rooms: []ExportedRoom{}}
+	for _, room := range e.Rooms {
+		for _, roomType := range roomTypes {
+			if room.Type == roomType {
+				filtered.Rooms = append(filtered.Rooms, room)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// Implemented by EditableRoomController (and the controllers embedding it) and RoomControllers
+type RoomExporterI interface {
+	// Exports the rooms of the controller, rooms of custom types are encoded with the given codecs
+	ExportRooms(customCodecs ...RoomCodecI) (RoomExport, error)
+}
+
+// Implemented by the controllers of this package that only take rooms of certain types (see ImportRooms)
+type RoomTypedControllerI interface {
+	// Returns the type tags of the rooms the controller takes
+	RoomTypes() []string
+}
+
+// Exports all rooms of the given storage, ordered by id
+//   rooms of custom types are encoded with the given codecs, a room no codec handles fails the export
+func ExportRooms(storage RoomStorageI, customCodecs ...RoomCodecI) (RoomExport, error) {
+	iterable, ok := storage.(IterableRoomStorageI)
+	if !ok {
+		return RoomExport{}, fmt.Errorf("cannot export rooms, the storage cannot list its rooms")
+	}
+	codecs := withBuiltinRoomCodecs(customCodecs)
+	export := RoomExport{Version: RoomExportVersion, ExportedUnixTime: time.Now().Unix(), Rooms: []ExportedRoom{}}
+	var encodeErr error
+	err := iterable.ForAll(func(room RoomI) {
+		if encodeErr != nil {
+			return
+		}
+		exported, err := exportRoom(codecs, room)
+		if err != nil {
+			encodeErr = err
+			return
+		}
+		export.Rooms = append(export.Rooms, exported)
+	})
+	if err != nil {
+		return RoomExport{}, err
+	}
+	if encodeErr != nil {
+		return RoomExport{}, encodeErr
+	}
+	sortExportedRooms(export.Rooms)
+	return export, nil
+}
+
+// Sorts the rooms by id, each id is parsed once
+func sortExportedRooms(rooms []ExportedRoom) {
+	ids := make([]string, len(rooms))
+	for i, room := range rooms {
+		ids[i] = room.ID()
+	}
+	sort.Stable(exportedRoomsByID{rooms: rooms, ids: ids})
+}
+
+type exportedRoomsByID struct {
+	rooms []ExportedRoom
+	ids   []string
+}
+
+func (s exportedRoomsByID) Len() int           { return len(s.rooms) }
+func (s exportedRoomsByID) Less(i, j int) bool { return s.ids[i] < s.ids[j] }
+func (s exportedRoomsByID) Swap(i, j int) {
+	s.rooms[i], s.rooms[j] = s.rooms[j], s.rooms[i]
+	s.ids[i], s.ids[j] = s.ids[j], s.ids[i]
+}
+
+func exportRoom(codecs []RoomCodecI, room RoomI) (ExportedRoom, error) {
+	codec := roomCodecOf(codecs, room)
+	if codec == nil {
+		return ExportedRoom{}, fmt.Errorf("cannot export room(%v), no codec handles %T", room.GetID(), room)
+	}
+	data, err := codec.Encode(room)
+	if err != nil {
+		return ExportedRoom{}, fmt.Errorf("cannot export room(%v): %w", room.GetID(), err)
+	}
+	return ExportedRoom{Type: codec.RoomType(), Room: data}, nil
+}
+
+// Parses a json export, as written by encoding/json from a RoomExport
+func ParseRoomExport(data []byte) (RoomExport, error) {
+	var export RoomExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return RoomExport{}, fmt.Errorf("could not parse room export: %w", err)
+	}
+	if export.Version > RoomExportVersion {
+		return RoomExport{}, fmt.Errorf("room export version %v is newer than the supported version %v", export.Version, RoomExportVersion)
+	}
+	return export, nil
+}
+
+// Writes the export as ini, one section per room (see Idea)
+func (e RoomExport) ToINI() (*ini.File, error) {
+	cfg := ini.Empty()
+	counts := map[string]int{}
+	for _, room := range e.Rooms {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(room.Room, &fields); err != nil {
+			return nil, fmt.Errorf("cannot write room(%v) as ini: %w", room.ID(), err)
+		}
+		counts[room.Type]++
+		section := cfg.Section(room.Type + "_rooms.room" + strconv.Itoa(counts[room.Type]))
+
+		keys := make([]string, 0, len(fields))
+		for key := range fields {
+			if key != "id" {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range append([]string{"id"}, keys...) {
+			value := string(fields[key])
+			var str string
+			if err := json.Unmarshal(fields[key], &str); err == nil {
+				value = str
+			}
+			section.Key(key).SetValue(value)
+		}
+	}
+	return cfg, nil
+}
+
+// Parses the rooms of all sections named <type>_rooms.<name> (see Idea), other sections are ignored
+//   so a config file with [permanent_rooms.*] sections can be imported as well
+func ParseRoomExportINI(cfg *ini.File) (RoomExport, error) {
+	export := RoomExport{Version: RoomExportVersion, Rooms: []ExportedRoom{}}
+	for _, section := range cfg.Sections() {
+		separator := strings.Index(section.Name(), "_rooms.")
+		if separator <= 0 {
+			continue
+		}
+		fields := map[string]json.RawMessage{}
+		for _, key := range section.Keys() {
+			value := key.String()
+			if key.Name() != "id" && json.Valid([]byte(value)) {
+				fields[key.Name()] = json.RawMessage(value)
+			} else {
+				quoted, _ := json.Marshal(value)
+				fields[key.Name()] = quoted
+			}
+		}
+		if _, ok := fields["id"]; !ok {
+			return RoomExport{}, fmt.Errorf("missing id in section [%v]", section.Name())
+		}
+		data, err := json.Marshal(fields)
+		if err != nil {
+			return RoomExport{}, err
+		}
+		export.Rooms = append(export.Rooms, ExportedRoom{Type: section.Name()[:separator], Room: data})
+	}
+	return export, nil
+}
+
+type RoomImportMode string
+
+const (
+	// Adds the rooms that do not exist yet, keeps the existing ones
+	RoomImportMerge RoomImportMode = "merge"
+	// Adds the rooms that do not exist yet, replaces the existing ones
+	RoomImportOverride RoomImportMode = "override"
+)
+
+// Parses merge or override, an empty mode is merge
+func ParseRoomImportMode(raw string) (RoomImportMode, error) {
+	switch RoomImportMode(raw) {
+	case "", RoomImportMerge:
+		return RoomImportMerge, nil
+	case RoomImportOverride:
+		return RoomImportOverride, nil
+	}
+	return "", fmt.Errorf("unknown import mode %q, expected %v or %v", raw, RoomImportMerge, RoomImportOverride)
+}
+
+// The ids of the imported rooms, by what happened (or would happen in a dry run) to them
+type RoomImportResult struct {
+	DryRun   bool     `json:"dry_run"`
+	Added    []string `json:"added"`
+	Replaced []string `json:"replaced"`
+	Kept     []string `json:"kept"`
+	// rooms of types no importing controller takes
+	Ignored []string `json:"ignored,omitempty"`
+}
+
+// Imports the rooms of the export into the controller (see Idea)
+//   rooms of custom types are decoded with the given codecs
+//   if adding a room fails, the rooms added before stay and the result reports them
+func ImportRooms(controller RoomControllerI, export RoomExport, mode RoomImportMode, dryRun bool, customCodecs ...RoomCodecI) (RoomImportResult, error) {
+	result := RoomImportResult{DryRun: dryRun, Added: []string{}, Replaced: []string{}, Kept: []string{}}
+	imports, err := planImport(controller, export, mode, withBuiltinRoomCodecs(customCodecs))
+	if err != nil {
+		return result, err
+	}
+	for _, imported := range imports {
+		roomID := imported.roomID
+		switch {
+		case imported.target == nil:
+			result.Ignored = append(result.Ignored, roomID)
+			continue
+		case imported.exists && mode != RoomImportOverride:
+			result.Kept = append(result.Kept, roomID)
+			continue
+		}
+		if !dryRun {
+			if err := imported.target.AddRoom(roomID, imported.room, mode == RoomImportOverride); err != nil {
+				return result, fmt.Errorf("could not import room(%v): %w", roomID, err)
+			}
+		}
+		if imported.exists {
+			result.Replaced = append(result.Replaced, roomID)
+		} else {
+			result.Added = append(result.Added, roomID)
+		}
+	}
+	return result, nil
+}
+
+// A decoded room of an import and the controller it goes to, nil if it is ignored
+type plannedImport struct {
+	roomID string
+	room   RoomI
+	target RoomControllerI
+	exists bool
+}
+
+// Decodes, validates and routes all rooms of the export, fails on the first room that cannot be imported
+//   ignored rooms are not decoded, their type might be unknown to the codecs
+func planImport(controller RoomControllerI, export RoomExport, mode RoomImportMode, codecs []RoomCodecI) ([]plannedImport, error) {
+	if export.Version > RoomExportVersion {
+		return nil, fmt.Errorf("room export version %v is newer than the supported version %v", export.Version, RoomExportVersion)
+	}
+	seen := make(map[string]bool, len(export.Rooms))
+	imports := make([]plannedImport, 0, len(export.Rooms))
+	for _, exported := range export.Rooms {
+		roomID := exported.ID()
+		if err := ValidateID("room", roomID); err != nil {
+			return nil, fmt.Errorf("invalid room in export: %w", err)
+		}
+		if seen[roomID] {
+			return nil, fmt.Errorf("room(%v) is exported twice", roomID)
+		}
+		seen[roomID] = true
+
+		target := importTargetOf(controller, exported.Type)
+		if target == nil {
+			imports = append(imports, plannedImport{roomID: roomID})
+			continue
+		}
+		room, err := decodeExportedRoom(codecs, roomID, exported)
+		if err != nil {
+			return nil, err
+		}
+		exists := controller.GetRoom(roomID) != nil
+		if exists && target.GetRoom(roomID) == nil && mode == RoomImportOverride {
+			return nil, fmt.Errorf("cannot import room(%v), a room of another type has the id", roomID)
+		}
+		imports = append(imports, plannedImport{roomID: roomID, room: room, target: target, exists: exists})
+	}
+	return imports, nil
+}
+
+func decodeExportedRoom(codecs []RoomCodecI, roomID string, exported ExportedRoom) (RoomI, error) {
+	var allowList struct {
+		AllowedClients []string `json:"allowed_clients"`
+	}
+	if err := json.Unmarshal(exported.Room, &allowList); err == nil {
+		if err := ValidateAllowList(allowList.AllowedClients); err != nil {
+			return nil, fmt.Errorf("invalid allowed clients of room(%v): %w", roomID, err)
+		}
+	}
+	codec := roomCodecOfType(codecs, exported.Type)
+	if codec == nil {
+		return nil, fmt.Errorf("cannot import room(%v), unknown type %v", roomID, exported.Type)
+	}
+	return codec.Decode(roomID, exported.Room)
+}
+
+// Returns the controller that takes rooms of the given type, nil if none does (see Idea)
+//   a controller that does not report its types takes rooms of any type
+func importTargetOf(controller RoomControllerI, roomType string) RoomControllerI {
+	if bundle, ok := controller.(*RoomControllers); ok {
+		return bundle.importTargetOf(roomType)
+	}
+	if takesRoomType(controller, roomType) {
+		return controller
+	}
+	return nil
+}
+
+func takesRoomType(controller RoomControllerI, roomType string) bool {
+	typed, ok := controller.(RoomTypedControllerI)
+	if !ok {
+		return true
+	}
+	for _, taken := range typed.RoomTypes() {
+		if taken == roomType {
+			return true
+		}
+	}
+	return false
+}
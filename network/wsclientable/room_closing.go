@@ -0,0 +1,190 @@
+package wsclientable
+
+import (
+	"github.com/jokrey/utility-algorithms-golang/network/logging"
+	"github.com/jokrey/utility-algorithms-golang/network/synchronization"
+	"sort"
+	"sync"
+	"time"
+)
+
+//Idea:
+//  Clients should know the constraints of their room, instead of being disconnected without notice.
+//  When a connection joins a room, it receives
+//     {"type":"room_info", "data":{"room":"<roomID>", "serverTime":<unix seconds>, "opensAt":<unix seconds>, "closesAt":<unix seconds>}}
+//     opensAt and closesAt describe the current window of the room, they are missing if the room is always open.
+//     users with a personal window that ends additionally receive "accessUntil" (see 'room_user_access.go')
+//     serverTime allows the client to correct for differences between its clock and the server clock.
+//  Controllers warn the connections of a room at the offsets set with SetClosingWarnings before the window closes
+//     {"type":"room_closing", "data":{"room":"<roomID>", "closesAt":<unix seconds>, "secondsLeft":<seconds>, "serverTime":<unix seconds>}}
+//  When the window is over or the room is removed, connections are closed with CloseCodeRoomClosed and a reason.
+
+const (
+	MessageTypeRoomInfo    = "room_info"
+	MessageTypeRoomClosing = "room_closing"
+)
+
+// Optionally implemented by rooms that are only open in windows (TemporaryRoom, RepeatingRoom, ScheduledRoom)
+type WindowedRoomI interface {
+	RoomI
+	// The window that is open at now, false if the room is not open. The End is zero if the window never closes
+	CurrentWindow(now time.Time) (ScheduleWindow, bool)
+}
+
+// Returns the window of the room that is open at now, false if the room is always open or currently closed
+func CurrentWindowOf(room RoomI, now time.Time) (ScheduleWindow, bool) {
+	if windowed, ok := room.(WindowedRoomI); ok {
+		return windowed.CurrentWindow(now)
+	}
+	return ScheduleWindow{}, false
+}
+
+// Sends the room_info message described above
+func sendRoomInfo(connection ClientConnection, roomID string, room RoomI) error {
+	now := time.Now()
+	info := map[string]interface{}{"room": roomID, "serverTime": now.Unix()}
+	if window, ok := CurrentWindowOf(room, now); ok {
+		info["opensAt"] = window.Start.Unix()
+		if !window.End.IsZero() {
+			info["closesAt"] = window.End.Unix()
+		}
+	}
+	if window, ok := UserWindowOf(room, connection.Identity.UserID); ok && window.UntilUnixTime != 0 {
+		info["accessUntil"] = window.UntilUnixTime
+	}
+	return connection.SendMapTyped(MessageTypeRoomInfo, info)
+}
+
+// Implemented by the controllers (and editors) that send room_closing warnings
+type ClosingWarningsSettable interface {
+	// Sets the offsets before the end of a room window at which its connections receive a room_closing warning
+	SetClosingWarnings(offsets ...time.Duration)
+}
+
+// Sends the room_closing warnings of one controller, using a single TimedCallback for the next warning of all rooms
+//   only rooms with connections are tracked, controllers track a room when a connection joins or the room is edited
+type closingWarnings struct {
+	mut      *sync.Mutex
+	offsets  []time.Duration // descending
+	rooms    map[string]*closingRoom
+	callback synchronization.TimedCallback
+	forAllIn func(roomID string, f func(connection *ClientConnection))
+	logger   logging.Logger
+}
+
+type closingRoom struct {
+	closesAt   time.Time
+	nextOffset int // index of the next warning to send in offsets
+}
+
+func newClosingWarnings(forAllIn func(roomID string, f func(connection *ClientConnection))) *closingWarnings {
+	return &closingWarnings{
+		mut:      &sync.Mutex{},
+		rooms:    make(map[string]*closingRoom),
+		callback: synchronization.NewTimedCallback(),
+		forAllIn: forAllIn,
+		logger:   logging.Nop(),
+	}
+}
+
+// Sets the offsets before the end of a window at which the connections are warned, none by default
+func (w *closingWarnings) setOffsets(offsets []time.Duration) {
+	sorted := append([]time.Duration(nil), offsets...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] > sorted[j] })
+
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	w.offsets = sorted
+	for _, room := range w.rooms {
+		room.nextOffset = w.firstPendingOffset(room.closesAt, time.Now())
+	}
+	w.scheduleLocked()
+}
+
+// Tracks the current window of the room, untracks it if the room is not in a window that closes
+func (w *closingWarnings) track(roomID string, room RoomI) {
+	now := time.Now()
+	window, ok := CurrentWindowOf(room, now)
+	if !ok || window.End.IsZero() {
+		w.untrack(roomID)
+		return
+	}
+
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	if tracked, ok := w.rooms[roomID]; ok && tracked.closesAt.Equal(window.End) {
+		return
+	}
+	w.rooms[roomID] = &closingRoom{closesAt: window.End, nextOffset: w.firstPendingOffset(window.End, now)}
+	w.scheduleLocked()
+}
+
+func (w *closingWarnings) untrack(roomID string) {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	delete(w.rooms, roomID)
+}
+
+func (w *closingWarnings) stop() {
+	w.callback.Stop()
+}
+
+// requires the lock - warnings whose time has passed when the window is tracked are not sent
+func (w *closingWarnings) firstPendingOffset(closesAt, now time.Time) int {
+	i := 0
+	for i < len(w.offsets) && !closesAt.Add(-w.offsets[i]).After(now) {
+		i++
+	}
+	return i
+}
+
+// requires the lock
+func (w *closingWarnings) scheduleLocked() {
+	var next time.Time
+	for _, room := range w.rooms {
+		if room.nextOffset < len(w.offsets) {
+			at := room.closesAt.Add(-w.offsets[room.nextOffset])
+			if next.IsZero() || at.Before(next) {
+				next = at
+			}
+		}
+	}
+	if !next.IsZero() {
+		w.callback.CallMeBackIfEarlierThanCurrent(next, w.warnDue)
+	}
+}
+
+func (w *closingWarnings) warnDue() {
+	now := time.Now()
+	due := make(map[string]time.Time)
+	w.mut.Lock()
+	for roomID, room := range w.rooms {
+		if !room.closesAt.After(now) {
+			delete(w.rooms, roomID)
+			continue
+		}
+		warn := false
+		for room.nextOffset < len(w.offsets) && !room.closesAt.Add(-w.offsets[room.nextOffset]).After(now) {
+			room.nextOffset++ // if multiple warnings are due, only one is sent
+			warn = true
+		}
+		if warn {
+			due[roomID] = room.closesAt
+		}
+	}
+	w.scheduleLocked()
+	w.mut.Unlock()
+
+	for roomID, closesAt := range due {
+		data := map[string]interface{}{
+			"room": roomID, "closesAt": closesAt.Unix(),
+			"secondsLeft": int64(closesAt.Sub(now).Seconds() + 0.5), "serverTime": now.Unix(),
+		}
+		w.logger.Debug("warning room closing", "room", roomID, "closesAt", closesAt)
+		w.forAllIn(roomID, func(connection *ClientConnection) {
+			if err := connection.SendMapTyped(MessageTypeRoomClosing, data); err != nil {
+				w.logger.Debug("error sending", "room", roomID, "user", connection.ID, "mType", MessageTypeRoomClosing, "err", err)
+			}
+		})
+	}
+}
@@ -1,21 +1,32 @@
 package wsclientable
 
 import (
+	"strconv"
 	"sync"
 )
 
 // This class provides an in-memory, thread safe map from roomID to ConnectionMap.
 // This allows to query connections in rooms (identified by roomID and userID)
+// Within a room, connections are keyed by the user id of their Identity
 // This is used, for example, to forward message between clients that only know each other by id (but within rooms)
+// Additionally it holds the moderation state of rooms (locked, muted users),
+//   which is kept while the room is empty and only reset when all connections in the room are closed by CloseAllInRoom
 type RoomConnectionsMap struct {
-	rwMut   *sync.RWMutex
-	actives map[string]*ConnectionMap
+	rwMut      *sync.RWMutex
+	actives    map[string]*ConnectionMap
+	moderation map[string]*roomModerationState
+}
+
+type roomModerationState struct {
+	locked bool
+	muted  map[string]bool
 }
 
 func NewRoomConnectionsMap() RoomConnectionsMap {
 	return RoomConnectionsMap{
-		rwMut:   &sync.RWMutex{},
-		actives: make(map[string]*ConnectionMap),
+		rwMut:      &sync.RWMutex{},
+		actives:    make(map[string]*ConnectionMap),
+		moderation: make(map[string]*roomModerationState),
 	}
 }
 
@@ -24,6 +35,9 @@ func (p RoomConnectionsMap) CloseAllConnections() (int, error) {
 	p.rwMut.Lock()
 	defer p.rwMut.Unlock()
 
+	for k := range p.moderation {
+		delete(p.moderation, k)
+	}
 	counter := 0
 	var err error
 	for k, room := range p.actives {
@@ -39,13 +53,25 @@ func (p RoomConnectionsMap) CloseAllConnections() (int, error) {
 
 // Closes all connections in given room
 func (p RoomConnectionsMap) CloseAllInRoom(roomID string) (int, error) {
+	return p.closeAllInRoom(roomID, ConnectionMap.CloseAll)
+}
+
+// Same as CloseAllInRoom, but sends the given close code and reason (for example CloseCodeRoomClosed when the room ended)
+func (p RoomConnectionsMap) CloseAllInRoomWithReason(roomID string, closeCode int, reason string) (int, error) {
+	return p.closeAllInRoom(roomID, func(room ConnectionMap) (int, error) {
+		return room.CloseAllWithReason(closeCode, reason)
+	})
+}
+
+func (p RoomConnectionsMap) closeAllInRoom(roomID string, closeAll func(room ConnectionMap) (int, error)) (int, error) {
 	p.rwMut.Lock()
 	defer p.rwMut.Unlock()
 
+	delete(p.moderation, roomID)
 	room, ok := p.actives[roomID]
 	if ok {
 		delete(p.actives, roomID)
-		return room.CloseAll()
+		return closeAll(*room)
 	} else {
 		return 0, nil
 	}
@@ -80,19 +106,96 @@ func (p *RoomConnectionsMap) ForAllRooms(f func(roomID string)) {
 
 // Adds the given connection to the given room
 func (p RoomConnectionsMap) AddConnectionInRoomIfNotConnected(roomID string, connection ClientConnection) bool {
-	p.rwMut.RLock()
-	room, exists := p.actives[roomID]
-	p.rwMut.RUnlock()
+	return p.AddConnectionInRoomWithinCapacity(roomID, connection, 0) == nil
+}
 
+// Adds the given connection to the given room, unless the user is already connected
+//   or the room already holds maxParticipants connections (0 = unlimited).
+//   Check and add are atomic, concurrent connections cannot exceed the capacity.
+//   Returns a RoomRejectionError if the connection was not added
+func (p RoomConnectionsMap) AddConnectionInRoomWithinCapacity(roomID string, connection ClientConnection, maxParticipants int) error {
+	// exclusive, so that the room cannot be removed as empty between lookup and add
+	p.rwMut.Lock()
+	defer p.rwMut.Unlock()
+
+	room, exists := p.actives[roomID]
 	if !exists {
-		p.rwMut.Lock()
 		newRoom := NewConnectionMap()
-		p.actives[roomID] = &newRoom
 		room = &newRoom
-		p.rwMut.Unlock()
 	}
 
-	return room.AddIfNotConnected(connection)
+	added, full := room.AddIfNotConnectedWithin(connection, maxParticipants)
+	if full {
+		return RoomRejectionError{RoomID: roomID, Reason: "room is full (max participants: " + strconv.Itoa(maxParticipants) + ")"}
+	}
+	if !added {
+		return RoomRejectionError{RoomID: roomID, Reason: "user(" + connection.ID + ") already connected"}
+	}
+	if !exists {
+		p.actives[roomID] = room
+	}
+	return nil
+}
+
+// Locks or unlocks the given room, see IsLocked
+func (p RoomConnectionsMap) SetLocked(roomID string, locked bool) {
+	p.rwMut.Lock()
+	defer p.rwMut.Unlock()
+
+	p.moderationStateOf(roomID).locked = locked
+}
+
+// Whether the room was locked by a moderator, connections of participants should then not be accepted
+func (p RoomConnectionsMap) IsLocked(roomID string) bool {
+	p.rwMut.RLock()
+	defer p.rwMut.RUnlock()
+
+	state, ok := p.moderation[roomID]
+	return ok && state.locked
+}
+
+// Mutes or unmutes the given user in the given room, see IsMuted
+func (p RoomConnectionsMap) SetMuted(roomID, userID string, muted bool) {
+	p.rwMut.Lock()
+	defer p.rwMut.Unlock()
+
+	state := p.moderationStateOf(roomID)
+	if muted {
+		state.muted[userID] = true
+	} else {
+		delete(state.muted, userID)
+	}
+}
+
+// Whether the user was muted by a moderator, messages of muted users should not be forwarded
+func (p RoomConnectionsMap) IsMuted(roomID, userID string) bool {
+	p.rwMut.RLock()
+	defer p.rwMut.RUnlock()
+
+	state, ok := p.moderation[roomID]
+	return ok && state.muted[userID]
+}
+
+// requires the write lock
+func (p RoomConnectionsMap) moderationStateOf(roomID string) *roomModerationState {
+	state, ok := p.moderation[roomID]
+	if !ok {
+		state = &roomModerationState{muted: make(map[string]bool)}
+		p.moderation[roomID] = state
+	}
+	return state
+}
+
+// Number of connections in the given room
+func (p RoomConnectionsMap) CountConnectionsInRoom(roomID string) int {
+	p.rwMut.RLock()
+	room, ok := p.actives[roomID]
+	p.rwMut.RUnlock()
+
+	if ok {
+		return room.Count()
+	}
+	return 0
 }
 
 // Removes the given connection from the given room, removing the mapping entirely
@@ -103,7 +206,7 @@ func (p RoomConnectionsMap) RemoveConnectionInRoom(roomID string, userID string)
 
 	room, ok := p.actives[roomID]
 	if ok {
-		con := room.Remove(RoomIDAndUserIDToClientConnectionIDString(roomID, userID))
+		con := room.Remove(userID)
 		if room.IsEmpty() {
 			delete(p.actives, roomID)
 		}
@@ -120,7 +223,7 @@ func (p RoomConnectionsMap) GetConnectionInRoom(roomID, userID string) *ClientCo
 	p.rwMut.RUnlock()
 
 	if ok {
-		return room.GetByID(RoomIDAndUserIDToClientConnectionIDString(roomID, userID))
+		return room.GetByID(userID)
 	} else {
 		return nil
 	}
@@ -133,7 +236,7 @@ func (p RoomConnectionsMap) IsConnected(roomID, userID string) bool {
 	p.rwMut.RUnlock()
 
 	if ok {
-		return room.IsConnected(RoomIDAndUserIDToClientConnectionIDString(roomID, userID))
+		return room.IsConnected(userID)
 	} else {
 		return false
 	}
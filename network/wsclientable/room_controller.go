@@ -1,5 +1,11 @@
 package wsclientable
 
+import (
+	"github.com/jokrey/utility-algorithms-golang/network/logging"
+	"gopkg.in/ini.v1"
+	"time"
+)
+
 // Room controllers are the combination of RoomStorageI and RoomConnectionsMap.
 //   RoomStorage gives us the binary decision of whether a client is currently allowed in a room
 //   RoomConnectionsMap gives us the addressable connection for a given id
@@ -23,6 +29,7 @@ type RoomControllerI interface {
 	// see RoomConnectionsMap.IsConnected
 	IsConnected(roomID string, userID string) bool
 	// see RoomConnectionsMap.AddConnectionInRoom, except the controller might acquire additional resources
+	//   slow work on behalf of the connection should observe connection.Context(), which is cancelled on disconnect
 	NewConnectionForRoom(roomID string, connection ClientConnection) bool
 	// see RoomConnectionsMap.RemoveConnectionInRoom, except the controller might cancel additional resources
 	ConnectionInRoomClosed(roomID string, userID string) *ClientConnection
@@ -30,16 +37,181 @@ type RoomControllerI interface {
 	GetConnectionInRoom(roomID, userID string) *ClientConnection
 }
 
+// Implemented by all controllers in this package and RoomControllers
+//   the connections of other controllers are rejected without a reason (see NewConnectionForRoomWithReason)
+type RoomRejectionReporterI interface {
+	// Same as NewConnectionForRoom, but returns a RoomRejectionError with the reason,
+	//   if the connection is not accepted (for example if the room is full)
+	NewConnectionForRoomWithReason(roomID string, connection ClientConnection) error
+}
+
+// Adds the connection to the given room of the controller, returns the reason if it is not accepted
+func NewConnectionForRoomWithReason(rooms RoomControllerI, roomID string, connection ClientConnection) error {
+	if reporter, ok := rooms.(RoomRejectionReporterI); ok {
+		return reporter.NewConnectionForRoomWithReason(roomID, connection)
+	}
+	if !rooms.NewConnectionForRoom(roomID, connection) {
+		return RoomRejectionError{RoomID: roomID, Reason: "rejected by room"}
+	}
+	return nil
+}
+
+// Implemented by all controllers in this package and RoomControllers
+//   the connections of other controllers are not reached by messages to everyone in the room (see ForAllInRoom)
+type RoomConnectionsIterableI interface {
+	// see RoomConnectionsMap.ForAllIn
+	ForAllIn(roomID string, f func(connection *ClientConnection))
+}
+
+// Calls f for each connection in the given room of the controller, if it can iterate its connections
+func ForAllInRoom(rooms RoomControllerI, roomID string, f func(connection *ClientConnection)) {
+	if iterable, ok := rooms.(RoomConnectionsIterableI); ok {
+		iterable.ForAllIn(roomID, f)
+	}
+}
+
+// Implemented by all controllers in this package and RoomControllers
+//   the connections of other controllers are counted with ForAllIn (see CountConnectionsInRoom)
+type RoomConnectionCounterI interface {
+	// see RoomConnectionsMap.CountConnectionsInRoom
+	CountConnectionsInRoom(roomID string) int
+}
+
+// Number of connections in the given room of the controller
+func CountConnectionsInRoom(rooms RoomControllerI, roomID string) int {
+	if counter, ok := rooms.(RoomConnectionCounterI); ok {
+		return counter.CountConnectionsInRoom(roomID)
+	}
+	count := 0
+	ForAllInRoom(rooms, roomID, func(_ *ClientConnection) {
+		count++
+	})
+	return count
+}
+
 // Bundle of multiple controllers
 // In case of duplicate roomID definitions, the order here determines which room takes precedence
+//   Connections to rooms with a lobby that are not open yet wait in the lobby of the bundle (see 'room_lobby.go')
 type RoomControllers struct {
 	controllers []RoomControllerI
+	lobby       *roomLobby
+	joined      *roomJoinedHandlers
+	relayed     *roomRelayedHandlers
+	events      *roomEventHandlers // events of the bundle itself (see 'room_events.go')
+	ownedGroups *sharedUserGroups  // user groups loaded from a config, closed with the bundle
 }
 
 // Bundle of multiple controllers
 // In case of duplicate roomID definitions, the order here determines which room takes precedence
 func BundleControllers(controllers ...RoomControllerI) RoomControllers {
-	return RoomControllers{controllers}
+	joined := newRoomJoinedHandlers()
+	lobby := newRoomLobby()
+	lobby.joined = joined
+	events := newRoomEventHandlers()
+	lobby.events = events
+	return RoomControllers{
+		controllers: controllers, lobby: lobby, joined: joined, relayed: newRoomRelayedHandlers(), events: events,
+		ownedGroups: &sharedUserGroups{},
+	}
+}
+
+// Sets the logger of all bundled controllers that support it
+func (r *RoomControllers) SetLogger(logger logging.Logger) {
+	if r.lobby != nil {
+		r.lobby.logger = logging.OrNop(logger)
+	}
+	for _, v := range r.controllers {
+		if settable, ok := v.(logging.Settable); ok {
+			settable.SetLogger(logger)
+		}
+	}
+}
+
+// Sets the closing warnings of all bundled controllers that support it (see 'room_closing.go')
+//   for example SetClosingWarnings(5*time.Minute, time.Minute)
+func (r *RoomControllers) SetClosingWarnings(offsets ...time.Duration) {
+	for _, v := range r.controllers {
+		if settable, ok := v.(ClosingWarningsSettable); ok {
+			settable.SetClosingWarnings(offsets...)
+		}
+	}
+}
+
+// Sets the user groups of all bundled controllers that support it (see UserGroupsSettable)
+func (r *RoomControllers) SetUserGroups(groups UserGroupsI) {
+	for _, v := range r.controllers {
+		if settable, ok := v.(UserGroupsSettable); ok {
+			settable.SetUserGroups(groups)
+		}
+	}
+}
+
+// Sets the user groups defined in the config (see LoadUserGroupsFromCFG), if it defines any
+//   unlike with SetUserGroups the bundle owns them, they are closed when the bundle is closed
+func (r *RoomControllers) SetUserGroupsFromCFG(cfg *ini.File) {
+	groups := LoadUserGroupsFromCFG(cfg)
+	if groups == nil {
+		return
+	}
+	r.SetUserGroups(groups)
+	if r.ownedGroups != nil {
+		r.ownedGroups.set(groups)
+	}
+}
+
+// The handler is called whenever a connection joined a room of the bundle, also when admitted from the lobby (see 'room_hooks.go')
+func (r *RoomControllers) AddRoomJoinedHandler(handler func(roomID string, connection ClientConnection)) {
+	if r.joined != nil {
+		r.joined.add(handler)
+	}
+}
+
+// The handler is called whenever a message was relayed within a room of the bundle (see 'room_hooks.go')
+func (r *RoomControllers) AddRoomMessageRelayedHandler(handler func(message RelayedMessage)) {
+	if r.relayed != nil {
+		r.relayed.add(handler)
+	}
+}
+
+// The handler is called whenever a bundled controller that supports it removed a room (see RoomRemovalNotifierI)
+func (r *RoomControllers) AddRoomRemovedHandler(handler func(roomID string)) {
+	for _, v := range r.controllers {
+		if notifier, ok := v.(RoomRemovalNotifierI); ok {
+			notifier.AddRoomRemovedHandler(handler)
+		}
+	}
+}
+
+// The handler is called whenever a bundled controller that supports it removed an expired room (see RoomExpiryNotifierI)
+func (r *RoomControllers) AddRoomExpiredHandler(handler func(roomID string)) {
+	for _, v := range r.controllers {
+		if notifier, ok := v.(RoomExpiryNotifierI); ok {
+			notifier.AddRoomExpiredHandler(handler)
+		}
+	}
+}
+
+// The handler is called with the events of all bundled controllers that support them (see RoomEventSourceI)
+//   rejections are reported by the bundle itself, only if the connection does not wait in the lobby instead
+func (r *RoomControllers) SubscribeRoomEvents(handler func(event RoomEvent)) func() {
+	var unsubscribes []func()
+	if r.events != nil {
+		unsubscribes = append(unsubscribes, r.events.subscribe(handler))
+	}
+	for _, v := range r.controllers {
+		if source, ok := v.(RoomEventSourceI); ok {
+			unsubscribes = append(unsubscribes, source.SubscribeRoomEvents(func(event RoomEvent) {
+				if _, rejected := event.(UserRejectedEvent); !rejected {
+					handler(event)
+				}
+			}))
+		}
+	}
+	return func() {
+		for _, unsubscribe := range unsubscribes {
+			unsubscribe()
+		}
+	}
 }
 
 func (r *RoomControllers) Init() {
@@ -48,6 +220,9 @@ func (r *RoomControllers) Init() {
 	}
 }
 func (r *RoomControllers) Close() error {
+	if r.lobby != nil {
+		r.lobby.close()
+	}
 	var err error
 	for _, v := range r.controllers {
 		e := v.Close()
@@ -55,6 +230,13 @@ func (r *RoomControllers) Close() error {
 			err = e
 		}
 	}
+	if r.ownedGroups != nil {
+		if groups := r.ownedGroups.get(); groups != nil {
+			if e := groups.Close(); e != nil {
+				err = e
+			}
+		}
+	}
 	return err
 }
 
@@ -68,15 +250,101 @@ func (r *RoomControllers) GetRoom(roomID string) RoomI {
 	return nil
 }
 
+// Exports the rooms of all bundled controllers that support it (see RoomExporterI), ordered by id
+//   of rooms with the same id only the one that takes precedence (see GetRoom) is exported
+func (r *RoomControllers) ExportRooms(customCodecs ...RoomCodecI) (RoomExport, error) {
+	export := RoomExport{Version: RoomExportVersion, ExportedUnixTime: time.Now().Unix(), Rooms: []ExportedRoom{}}
+	seen := map[string]bool{}
+	for _, v := range r.controllers {
+		exporter, ok := v.(RoomExporterI)
+		if !ok {
+			continue
+		}
+		controllerExport, err := exporter.ExportRooms(customCodecs...)
+		if err != nil {
+			return RoomExport{}, err
+		}
+		for _, room := range controllerExport.Rooms {
+			if roomID := room.ID(); !seen[roomID] {
+				seen[roomID] = true
+				export.Rooms = append(export.Rooms, room)
+			}
+		}
+	}
+	sortExportedRooms(export.Rooms)
+	return export, nil
+}
+
+// Returns the first bundled controller that takes rooms of the given type, nil if none does (see ImportRooms)
+//   controllers that report their types are preferred over those that take rooms of any type
+func (r *RoomControllers) importTargetOf(roomType string) RoomControllerI {
+	var untyped RoomControllerI
+	for _, v := range r.controllers {
+		if _, typed := v.(RoomTypedControllerI); !typed {
+			if untyped == nil {
+				untyped = v
+			}
+		} else if takesRoomType(v, roomType) {
+			return v
+		}
+	}
+	return untyped
+}
+
+// Delegates to the first controller that has the room (same precedence as GetRoom)
+//   if the room has a lobby and is not open yet, the connection waits in the lobby instead
+//   connections that join the room receive a room_info message (see 'room_closing.go')
 func (r *RoomControllers) NewConnectionForRoom(roomID string, connection ClientConnection) bool {
+	return r.NewConnectionForRoomWithReason(roomID, connection) == nil
+}
+
+// see RoomRejectionReporterI
+func (r *RoomControllers) NewConnectionForRoomWithReason(roomID string, connection ClientConnection) error {
+	err := r.connectOrWait(roomID, connection)
+	if err != nil {
+		r.events.emit(UserRejectedEvent{RoomID: roomID, UserID: connection.Identity.UserID, Err: err})
+	}
+	return err
+}
+func (r *RoomControllers) connectOrWait(roomID string, connection ClientConnection) error {
 	for _, v := range r.controllers {
-		if v.NewConnectionForRoom(roomID, connection) {
-			return true
+		room := v.GetRoom(roomID)
+		if room != nil {
+			err := NewConnectionForRoomWithReason(v, roomID, connection)
+			if err != nil && r.lobby != nil {
+				if opensAt, ok := lobbyOpeningFor(room, connection.Identity, time.Now()); ok {
+					return r.lobby.wait(v, roomID, connection, opensAt)
+				}
+			}
+			if err == nil {
+				if e := sendRoomInfo(connection, roomID, room); e != nil && r.lobby != nil {
+					r.lobby.logger.Debug("error sending", "room", roomID, "user", connection.ID, "mType", MessageTypeRoomInfo, "err", e)
+				}
+				r.joined.notify(roomID, connection)
+			}
+			return err
 		}
 	}
-	return false
+	return RoomRejectionError{RoomID: roomID, Reason: "room not found"}
+}
+
+// Whether the user waits in the lobby of the room, waiting users are not connected to the room yet
+func (r *RoomControllers) IsWaitingInLobby(roomID, userID string) bool {
+	return r.lobby != nil && r.lobby.isWaiting(roomID, userID)
+}
+func (r *RoomControllers) CountConnectionsInRoom(roomID string) int {
+	count := 0
+	for _, v := range r.controllers {
+		count += CountConnectionsInRoom(v, roomID)
+	}
+	return count
 }
 func (r *RoomControllers) ConnectionInRoomClosed(roomID string, userID string) *ClientConnection {
+	if r.lobby != nil {
+		if waiting := r.lobby.remove(roomID, userID); waiting != nil {
+			return waiting
+		}
+	}
 	for _, v := range r.controllers {
 		con := v.ConnectionInRoomClosed(roomID, userID)
 		if con != nil {
@@ -94,7 +362,11 @@ func (r *RoomControllers) GetConnectionInRoom(roomID, userID string) *ClientConn
 	}
 	return nil
 }
+// Users waiting in the lobby of the room count as connected, so that they cannot connect twice
 func (r *RoomControllers) IsConnected(roomID string, userID string) bool {
+	if r.IsWaitingInLobby(roomID, userID) {
+		return true
+	}
 	for _, v := range r.controllers {
 		if v.IsConnected(roomID, userID) {
 			return true
@@ -103,6 +375,55 @@ func (r *RoomControllers) IsConnected(roomID string, userID string) bool {
 	return false
 }
 
+// Controllers that cannot iterate their connections are skipped (see RoomConnectionsIterableI)
+func (r *RoomControllers) ForAllIn(roomID string, f func(connection *ClientConnection)) {
+	for _, v := range r.controllers {
+		ForAllInRoom(v, roomID, f)
+	}
+}
+
+// The moderation state of the first controller that has the room (same precedence as GetRoom)
+//   nil if there is no such room or its controller does not implement RoomModerationStateI
+func (r *RoomControllers) moderationStateOf(roomID string) RoomModerationStateI {
+	for _, v := range r.controllers {
+		if v.GetRoom(roomID) != nil {
+			state, _ := v.(RoomModerationStateI)
+			return state
+		}
+	}
+	return nil
+}
+
+// Sets the state in the first controller that has the room (same precedence as GetRoom), if it supports it
+func (r *RoomControllers) SetLocked(roomID string, locked bool) {
+	if state := r.moderationStateOf(roomID); state != nil {
+		state.SetLocked(roomID, locked)
+	}
+}
+func (r *RoomControllers) IsLocked(roomID string) bool {
+	for _, v := range r.controllers {
+		if state, ok := v.(RoomModerationStateI); ok && state.IsLocked(roomID) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sets the state in the first controller that has the room (same precedence as GetRoom), if it supports it
+func (r *RoomControllers) SetMuted(roomID, userID string, muted bool) {
+	if state := r.moderationStateOf(roomID); state != nil {
+		state.SetMuted(roomID, userID, muted)
+	}
+}
+func (r *RoomControllers) IsMuted(roomID, userID string) bool {
+	for _, v := range r.controllers {
+		if state, ok := v.(RoomModerationStateI); ok && state.IsMuted(roomID, userID) {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *RoomControllers) CloseAndRemoveRoom(roomID string) (bool, error) {
 	for _, v := range r.controllers {
 		existed, e := v.CloseAndRemoveRoom(roomID)
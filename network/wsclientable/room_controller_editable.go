@@ -1,26 +1,98 @@
 package wsclientable
 
+import (
+	"github.com/jokrey/utility-algorithms-golang/network/logging"
+	"time"
+)
+
 // Minimal RoomControllerI implementation
 type EditableRoomController struct {
 	RoomConnectionsMap
-	store RoomStorageI
+	store   RoomStorageI
+	groups  *sharedUserGroups
+	logger  *logging.Swappable // shared with closing and access, which log from their own goroutines
+	closing *closingWarnings
+	access  *userWindowExpirations
+	removed *roomRemovedHandlers
+	expired *roomRemovedHandlers
+	events  *roomEventHandlers
 }
 
 func NewEditableRoomControllerInRam() EditableRoomController {
 	return NewEditableRoomController(NewMutableRamRoomStorage())
 }
 func NewEditableRoomController(roomStorage RoomStorageI) EditableRoomController {
+	connections := NewRoomConnectionsMap()
+	groups := &sharedUserGroups{}
+	logger := logging.NewSwappable(nil)
+	access := newUserWindowExpirations(nil)
+	access.logger = logger
+	access.expire = func(roomID, userID string) {
+		connection := connections.GetConnectionInRoom(roomID, userID)
+		if connection == nil {
+			return
+		}
+		if room := roomStorage.Get(roomID); room == nil || !IsAllowedConnection(withUserGroups(room, groups.get()), connection.Identity) {
+			if err := connection.CloseWithReason(CloseCodeAccessEnded, "access window ended"); err != nil {
+				access.logger.Debug("ignored error on connection close", "room", roomID, "connection", connection.ID, "err", err)
+			}
+		}
+	}
+	closing := newClosingWarnings(connections.ForAllIn)
+	closing.logger = logger
 	return EditableRoomController{
-		RoomConnectionsMap: NewRoomConnectionsMap(),
+		RoomConnectionsMap: connections,
 		store:              roomStorage,
+		groups:             groups,
+		logger:             logger,
+		closing:            closing,
+		access:             access,
+		removed:            newRoomRemovedHandlers(),
+		expired:            newRoomRemovedHandlers(),
+		events:             newRoomEventHandlers(),
+	}
+}
+
+// Sets the logger of this controller and of its storage, if the storage supports it (default: logging.Nop())
+func (p *EditableRoomController) SetLogger(logger logging.Logger) {
+	p.logger.Set(logger)
+	if settable, ok := p.store.(logging.Settable); ok {
+		settable.SetLogger(logging.OrNop(logger))
+	}
+}
+
+// Sets the groups that group entries of the rooms of this controller are resolved with (see 'room_allow_list.go')
+func (p *EditableRoomController) SetUserGroups(groups UserGroupsI) {
+	p.groups.set(groups)
+}
+
+// Returns the room from the storage, with its group entries resolved by the groups of this controller
+func (p *EditableRoomController) getRoom(roomID string) RoomI {
+	room := p.store.Get(roomID)
+	if room == nil {
+		return nil
 	}
+	return withUserGroups(room, p.groups.get())
 }
 
 // implement interface RoomControllerI:
 func (p *EditableRoomController) GetRoom(roomID string) RoomI {
-	return p.store.Get(roomID)
+	return p.getRoom(roomID)
+}
+
+// Exports the rooms of the storage of this controller (see 'room_backup.go'), fails if the storage cannot list its rooms
+func (p *EditableRoomController) ExportRooms(customCodecs ...RoomCodecI) (RoomExport, error) {
+	return ExportRooms(p.store, customCodecs...)
+}
+
+// Sets the offsets before the end of a room window at which its connections receive a room_closing warning (see 'room_closing.go')
+func (p *EditableRoomController) SetClosingWarnings(offsets ...time.Duration) {
+	p.closing.setOffsets(offsets)
 }
+
 func (p *EditableRoomController) Close() error {
+	p.closing.stop()
+	p.access.stop()
 	_, e1 := p.CloseAllConnections()
 	e2 := p.store.Close()
 	if e1 != nil {
@@ -29,21 +101,138 @@ func (p *EditableRoomController) Close() error {
 	return e2
 }
 
+// see RoomEventSourceI
+func (p *EditableRoomController) SubscribeRoomEvents(handler func(event RoomEvent)) func() {
+	return p.events.subscribe(handler)
+}
+
 func (p *EditableRoomController) NewConnectionForRoom(roomID string, connection ClientConnection) bool {
+	return p.NewConnectionForRoomWithReason(roomID, connection) == nil
+}
+
+// see RoomRejectionReporterI
+func (p *EditableRoomController) NewConnectionForRoomWithReason(roomID string, connection ClientConnection) error {
 	room := p.GetRoom(roomID)
-	_, userID, e := ConnectionIDStringToRoomIDAndUserID(connection.ID)
-	if e == nil && room != nil && room.IsAllowed(userID) {
-		return p.AddConnectionInRoomIfNotConnected(roomID, connection)
+	if err := p.checkRoomAllows(roomID, room, connection); err != nil {
+		return err
 	}
-	return false
+	return p.addConnectionAndTrackEnds(roomID, room, connection, MaxParticipantsOf(room))
+}
+
+func (p *EditableRoomController) addConnectionAndTrackEnds(roomID string, room RoomI, connection ClientConnection, maxParticipants int) error {
+	if err := p.AddConnectionInRoomWithinCapacity(roomID, connection, maxParticipants); err != nil {
+		p.events.emit(UserRejectedEvent{RoomID: roomID, UserID: connection.Identity.UserID, Err: err})
+		return err
+	}
+	p.closing.track(roomID, room)
+	p.access.track(roomID, connection.Identity.UserID, room)
+	p.events.emit(UserJoinedEvent{RoomID: roomID, UserID: connection.Identity.UserID, Connection: connection})
+	return nil
+}
+
+// Reports the room as added, or as edited if there was a previous room
+func (p *EditableRoomController) emitAddedOrEdited(roomID string, previous, room RoomI) {
+	if previous == nil {
+		p.events.emit(RoomAddedEvent{RoomID: roomID, Room: room})
+	} else {
+		p.events.emit(RoomEditedEvent{RoomID: roomID, Previous: previous, Room: room})
+	}
+}
+
+// After an edit, the window of the room and the personal windows of its users might have changed
+func (p *EditableRoomController) trackEndsAfterEdit(roomID string, room RoomI) {
+	if p.CountConnectionsInRoom(roomID) > 0 {
+		p.closing.track(roomID, room)
+	} else {
+		p.closing.untrack(roomID)
+	}
+	p.ForAllIn(roomID, func(connection *ClientConnection) {
+		p.access.track(roomID, connection.Identity.UserID, room)
+	})
+}
+
+// see RoomRemovalNotifierI
+func (p *EditableRoomController) AddRoomRemovedHandler(handler func(roomID string)) {
+	p.removed.add(handler)
+}
+
+// see RoomExpiryNotifierI
+func (p *EditableRoomController) AddRoomExpiredHandler(handler func(roomID string)) {
+	p.expired.add(handler)
+}
+
+// Removes the room from the storage and notifies the room removed handlers, if it existed
+func (p *EditableRoomController) removeFromStore(roomID string) (bool, error) {
+	existed, err := p.store.Remove(roomID)
+	if existed {
+		p.notifyRemoved(roomID)
+	}
+	return existed, err
+}
+
+// Notifies the room removed handlers, after the room was removed from the storage
+func (p *EditableRoomController) notifyRemoved(roomID string) {
+	p.removed.notify(roomID)
+	p.events.emit(RoomRemovedEvent{RoomID: roomID})
+}
+
+// Notifies the room expired handlers, after the room was removed
+func (p *EditableRoomController) notifyExpired(roomID string) {
+	p.expired.notify(roomID)
+	p.events.emit(RoomExpiredEvent{RoomID: roomID})
+}
+
+// Closes the connections of a room that ended and stops its warnings
+func (p *EditableRoomController) closeEndedRoom(roomID, reason string) (int, error) {
+	p.closing.untrack(roomID)
+	// collected while the room is removed from the map, so connections closing concurrently don't leave twice
+	var left []string
+	count, err := p.closeAllInRoom(roomID, func(room ConnectionMap) (int, error) {
+		room.ForAll(func(connection *ClientConnection) {
+			left = append(left, connection.Identity.UserID)
+		})
+		return room.CloseAllWithReason(CloseCodeRoomClosed, reason)
+	})
+	if len(left) > 0 {
+		p.events.emit(RoomClosedEvent{RoomID: roomID, Reason: reason})
+	}
+	for _, userID := range left {
+		p.events.emit(UserLeftEvent{RoomID: roomID, UserID: userID})
+	}
+	return count, err
+}
+
+func (p *EditableRoomController) checkRoomAllows(roomID string, room RoomI, connection ClientConnection) error {
+	err := p.rejectionOf(roomID, room, connection)
+	if err != nil {
+		p.events.emit(UserRejectedEvent{RoomID: roomID, UserID: connection.Identity.UserID, Err: err})
+	}
+	return err
+}
+func (p *EditableRoomController) rejectionOf(roomID string, room RoomI, connection ClientConnection) error {
+	if room == nil {
+		return RoomRejectionError{RoomID: roomID, Reason: "room not found"}
+	}
+	userID := connection.Identity.UserID
+	if !IsAllowedConnection(room, connection.Identity) {
+		return RoomRejectionError{RoomID: roomID, Reason: "user(" + userID + ") not currently allowed"}
+	}
+	if p.IsLocked(roomID) && !RoleOfConnection(room, connection.Identity).CanModerate() {
+		return RoomRejectionError{RoomID: roomID, Reason: "room is locked"}
+	}
+	return nil
 }
 func (p *EditableRoomController) ConnectionInRoomClosed(roomID string, userID string) *ClientConnection {
-	return p.RemoveConnectionInRoom(roomID, userID)
+	connection := p.RemoveConnectionInRoom(roomID, userID)
+	if connection != nil {
+		p.events.emit(UserLeftEvent{RoomID: roomID, UserID: userID})
+	}
+	return connection
 }
 
 func (p *EditableRoomController) CloseAndRemoveRoom(roomID string) (bool, error) {
-	_, e1 := p.CloseAllInRoom(roomID)
-	existed, e2 := p.store.Remove(roomID)
+	_, e1 := p.closeEndedRoom(roomID, "room removed")
+	existed, e2 := p.removeFromStore(roomID)
 	if e1 != nil {
 		return existed, e1
 	}
@@ -51,16 +240,19 @@ func (p *EditableRoomController) CloseAndRemoveRoom(roomID string) (bool, error)
 }
 
 func (p *EditableRoomController) AddRoom(roomID string, newRoom RoomI, allowOverride bool) error {
+	previous := p.getRoom(roomID)
 	err := p.store.Put(newRoom, allowOverride)
 	if err != nil {
 		return err
 	}
+	newRoom = withUserGroups(newRoom, p.groups.get())
+	p.emitAddedOrEdited(roomID, previous, newRoom)
 	p.ForAllIn(roomID, func(connection *ClientConnection) {
-		_, userID, e := ConnectionIDStringToRoomIDAndUserID(connection.ID)
-		if e != nil || !newRoom.IsAllowed(userID) {
+		if !IsAllowedConnection(newRoom, connection.Identity) {
 			_ = connection.Close() //automatically removes connection in room also
 		}
 	})
+	p.trackEndsAfterEdit(roomID, newRoom)
 	return nil
 }
 
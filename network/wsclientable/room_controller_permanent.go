@@ -1,9 +1,8 @@
 package wsclientable
 
 import (
+	"github.com/jokrey/utility-algorithms-golang/network/logging"
 	"gopkg.in/ini.v1"
-	"log"
-	"strings"
 )
 
 //Idea:
@@ -12,24 +11,41 @@ import (
 //        it takes precedence and might override the properties of rooms defined by this controller.
 
 func NewPermanentRoomControllerFromCFG(cfg *ini.File) *EditableRoomController {
+	logger := logging.NewFromCFG(cfg)
 	var rooms []PermanentRoom
 	for _, permanentRoom := range cfg.Section("permanent_rooms").ChildSections() {
 		roomID := permanentRoom.Key("id").String()
 		allowedClientIds := UnmarshalJsonArray(permanentRoom.Key("allowed_clients").String())
+		if err := ValidateAllowList(allowedClientIds); err != nil {
+			panic("Could not load permanent room allowed clients (" + roomID + "): " + err.Error())
+		}
+		maxParticipants := permanentRoom.Key("max_participants").MustInt(0)
+		roles, err := UnmarshalJsonRoles(permanentRoom.Key("roles").String())
+		if err != nil {
+			panic("Could not load permanent room roles (" + roomID + "): " + err.Error())
+		}
 
-		rooms = append(rooms, NewPermanentRoom(roomID, allowedClientIds))
+		policy, err := ParseForwardingPolicy(permanentRoom.Key("message_types").String(), permanentRoom.Key("send_roles").String())
+		if err != nil {
+			panic("Could not load permanent room forwarding policy (" + roomID + "): " + err.Error())
+		}
 
-		if len(allowedClientIds) == 0 {
-			log.Println("Permanent room(" + roomID + ") available for all clients")
-		} else {
-			log.Println("Permanent room(" + roomID + ") available for clients" + strings.Join(allowedClientIds, ", "))
+		history, err := ParseHistoryPolicy(permanentRoom.Key("history").String())
+		if err != nil {
+			panic("Could not load permanent room history policy (" + roomID + "): " + err.Error())
 		}
+
+		rooms = append(rooms, NewPermanentRoom(roomID, allowedClientIds).
+			WithMaxParticipants(maxParticipants).WithRoles(roles).WithForwardingPolicy(policy).WithHistoryPolicy(history))
+
+		logger.Info("permanent room available", "room", roomID, "allowedClients", allowedClientIds,
+			"maxParticipants", maxParticipants, "roles", roles, "messageTypes", policy.MessageTypes, "sendRoles", policy.SendRoles)
 	}
-	return NewPermanentRoomController(rooms...)
+	controller := NewPermanentRoomController(rooms...)
+	controller.SetLogger(logger)
+	return controller
 }
 func NewPermanentRoomController(rooms ...PermanentRoom) *EditableRoomController {
-	return &EditableRoomController{
-		RoomConnectionsMap: NewRoomConnectionsMap(),
-		store:              NewRamRoomStorageFromSlice(rooms),
-	}
+	controller := NewEditableRoomController(NewRamRoomStorageFromSlice(rooms))
+	return &controller
 }
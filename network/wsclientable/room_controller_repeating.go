@@ -1,8 +1,8 @@
 package wsclientable
 
 import (
+	"fmt"
 	"github.com/jokrey/utility-algorithms-golang/network/synchronization"
-	"log"
 	"math"
 	"time"
 )
@@ -16,6 +16,8 @@ import (
 //      A repeating room will only allow clients in the given time frame
 //          e.g. clients can only connect within the given time and will be disconnected at the end of that timeframe
 //          the calculation is the following: (c - f)/r <= l
+//      Optionally 'until_unix_in_seconds_from_now' and 'max_occurrences' bound the room,
+//          once the last occurrence ended, the room is removed and its clients disconnected
 // Example editing requests (python3):
 //     import requests; r = requests.post("http://localhost:8089/rooms/repeat/add?id=test&allowed_clients=["c", "s", "parent"]&first_time_unix_in_seconds_from_now=10&repeat_every_seconds=10&duration_in_seconds=5"); print(r.reason, r.text)
 //     import requests; r = requests.post("http://localhost:8089/rooms/repeat/edit?id=test&allowed_clients=["c", "s", "parent", "admin"]&first_time_unix_in_seconds_from_now=0&&repeat_every_seconds=10&duration_in_seconds=20"); print(r.reason, r.text)
@@ -43,48 +45,84 @@ func (p *RepeatingRoomController) Close() error {
 	return p.EditableRoomController.Close()
 }
 
+// Bounded rooms whose end date or number of occurrences has been reached are removed instead of returned
+//   rooms with connections are removed by the expiration callback, rooms without on the next lookup
+func (p *RepeatingRoomController) GetRoom(roomID string) RoomI {
+	room := p.getRoom(roomID)
+	if room != nil && !room.IsValid() {
+		p.logger.Debug("removing repeating room after its last occurrence", "room", roomID)
+		p.removeExpired(roomID)
+		return nil
+	}
+	return room
+}
+
 func (p *RepeatingRoomController) NewConnectionForRoom(roomID string, connection ClientConnection) bool {
+	return p.NewConnectionForRoomWithReason(roomID, connection) == nil
+}
+
+// see RoomRejectionReporterI
+func (p *RepeatingRoomController) NewConnectionForRoomWithReason(roomID string, connection ClientConnection) error {
 	room := p.GetRoom(roomID)
-	_, userID, e := ConnectionIDStringToRoomIDAndUserID(connection.ID)
-	if e == nil && room != nil && room.IsAllowed(userID) {
-		rRoom := room.(RepeatingRoom)
-		p.cleanAtAppropriateTimeForRepeatingRoom(&rRoom)
-		return p.AddConnectionInRoomIfNotConnected(roomID, connection)
+	if err := p.checkRoomAllows(roomID, room, connection); err != nil {
+		return err
 	}
-	return false
+	rRoom := room.(RepeatingRoom)
+	p.cleanAtAppropriateTimeForRepeatingRoom(&rRoom)
+	return p.addConnectionAndTrackEnds(roomID, rRoom, connection, rRoom.MaxParticipants)
 }
 func (p *RepeatingRoomController) CloseAndRemoveRoom(roomID string) (bool, error) {
-	roomI := p.store.Get(roomID)
+	roomI := p.getRoom(roomID)
 	if roomI == nil {
 		return false, nil
 	}
 	oldRoom := roomI.(RepeatingRoom)
-	if ExpirationCallbackDateForRepeatingRoom(&oldRoom) == p.nextExpiration.GetCallbackExpectedAt() {
-		//damn... the closed room is the one that will be the next to expire, need to recompute
+	wasNextToExpire := ExpirationCallbackDateForRepeatingRoom(&oldRoom) == p.nextExpiration.GetCallbackExpectedAt()
+
+	_, e1 := p.closeEndedRoom(roomID, "room removed")
+	existed, e2 := p.removeFromStore(roomID)
+	if wasNextToExpire {
+		//damn... the closed room is the one that will be the next to expire, need to recompute (after removal, it might have been invalid)
 		p.reInitCallback()
 	}
-
-	_, e1 := p.CloseAllInRoom(roomID)
-	existed, e2 := p.store.Remove(roomID)
 	if e1 != nil {
 		return existed, e1
 	}
 	return existed, e2
 }
+
+// Removes a room after its last occurrence and notifies the room expired handlers
+func (p *RepeatingRoomController) removeExpired(roomID string) {
+	if existed, _ := p.CloseAndRemoveRoom(roomID); existed {
+		p.notifyExpired(roomID)
+	}
+}
+// Only takes rooms of type RepeatingRoom (see RoomTypedControllerI)
+func (p *RepeatingRoomController) RoomTypes() []string {
+	return []string{RoomTypeRepeating}
+}
+
 func (p *RepeatingRoomController) AddRoom(roomID string, newRoomI RoomI, allowOverride bool) error {
-	newRoom := newRoomI.(RepeatingRoom)
+	newRoom, ok := newRoomI.(RepeatingRoom)
+	if !ok {
+		return fmt.Errorf("cannot add room(%v), %T is not a RepeatingRoom", roomID, newRoomI)
+	}
 
+	previous := p.getRoom(roomID)
 	err := p.store.Put(newRoom, allowOverride)
 	if err != nil {
 		return err
 	}
+	newRoom.groups = p.groups.get()
+	p.emitAddedOrEdited(roomID, previous, newRoom)
 	p.ForAllIn(roomID, func(connection *ClientConnection) {
-		_, userID, e := ConnectionIDStringToRoomIDAndUserID(connection.ID)
-		if e != nil || !newRoom.IsAllowed(userID) {
+		if !IsAllowedConnection(newRoom, connection.Identity) {
 			_ = connection.Close() //automatically removes connection in room also
 		}
 	})
 
+	p.trackEndsAfterEdit(roomID, newRoom)
+
 	p.cleanAtAppropriateTimeForRepeatingRoom(&newRoom)
 	return nil
 }
@@ -97,15 +135,15 @@ func (p *RepeatingRoomController) cleanAtAppropriateTimeForRepeatingRoom(room *R
 	wasEarlier := p.nextExpiration.CallMeBackIfEarlierThanCurrent(callbackAt, func() {
 		p.reInitCallback()
 	})
-	log.Println("Callback for room(", room.ID, ") will be at the latest at", callbackAt, "(was earlier than current: ", wasEarlier, ")")
+	p.logger.Debug("scheduled expiration callback", "room", room.ID, "at", callbackAt, "wasEarlier", wasEarlier)
 }
 func (p *RepeatingRoomController) reInitCallback() {
-	log.Println("Cleaning Expired Repeating Rooms")
+	p.logger.Debug("cleaning expired repeating rooms")
 	next := p.validateAllConnections()
 	if next != nil {
-		log.Printf("Next Room To Expire: %v, at %v", next.ID, ExpirationCallbackDateForRepeatingRoom(next))
+		p.logger.Debug("next room to expire", "room", next.ID, "at", ExpirationCallbackDateForRepeatingRoom(next))
 	} else {
-		log.Println("No Next Repeating Room To Expire (no repeating rooms with currently connected clients should exist)")
+		p.logger.Debug("no next repeating room to expire (no repeating rooms with currently connected clients should exist)")
 	}
 	p.cleanAtAppropriateTimeForRepeatingRoom(next)
 }
@@ -114,6 +152,13 @@ func ExpirationCallbackDateForRepeatingRoom(r *RepeatingRoom) time.Time {
 	return time.Unix(ExpirationCallbackDateForRepeatingRoomWithUnix(r, time.Now().Unix()), 0).Add(time.Second) //a little after, so the callback is definitely after the expiration so the checks are successful
 }
 func ExpirationCallbackDateForRepeatingRoomWithUnix(r *RepeatingRoom, nowUnix int64) int64 {
+	next := expirationDateForUnboundedRepeatingRoomWithUnix(r, nowUnix)
+	if last := r.LastValidUnixTime(); last < next {
+		return last //bounded room becomes invalid before its next occurrence ends
+	}
+	return next
+}
+func expirationDateForUnboundedRepeatingRoomWithUnix(r *RepeatingRoom, nowUnix int64) int64 {
 	if r.DurationInSeconds >= r.RepeatEverySeconds {
 		return math.MaxInt64 //then call back at the end of the universe
 	}
@@ -138,6 +183,9 @@ func (p *RepeatingRoomController) validateAllConnections() *RepeatingRoom {
 	var currentExpirationDate time.Time
 	p.ForAllRooms(func(roomID string) {
 		r := p.validateConnectionsInRoom(roomID)
+		if r == nil {
+			return
+		}
 		expirationOfR := ExpirationCallbackDateForRepeatingRoom(r)
 		if r.IsValid() && (nextToBecomeInvalid == nil || expirationOfR.Before(currentExpirationDate)) {
 			currentExpirationDate = expirationOfR
@@ -147,16 +195,22 @@ func (p *RepeatingRoomController) validateAllConnections() *RepeatingRoom {
 	return nextToBecomeInvalid
 }
 func (p *RepeatingRoomController) validateConnectionsInRoom(roomID string) *RepeatingRoom {
-	room := p.store.Get(roomID).(RepeatingRoom)
+	roomI := p.getRoom(roomID)
+	if roomI == nil {
+		return nil
+	}
+	room := roomI.(RepeatingRoom)
 	if !room.IsValid() {
-		_, _ = p.CloseAndRemoveRoom(roomID)
+		p.removeExpired(roomID)
 	} else {
+		if !room.IsOpen() && p.CountConnectionsInRoom(roomID) > 0 {
+			p.events.emit(RoomClosedEvent{RoomID: roomID, Reason: "room window ended"})
+		}
 		p.ForAllIn(roomID, func(connection *ClientConnection) {
-			_, userID, e := ConnectionIDStringToRoomIDAndUserID(connection.ID)
-			if e != nil || !room.IsAllowed(userID) {
-				e := connection.Close()
+			if !IsAllowedConnection(room, connection.Identity) {
+				e := connection.CloseWithReason(CloseCodeRoomClosed, "room window ended")
 				if e != nil {
-					log.Printf("IGNORED error on connection("+connection.ID+") close: %v", e)
+					p.logger.Debug("ignored error on connection close", "room", roomID, "connection", connection.ID, "err", e)
 				}
 			}
 		})
@@ -0,0 +1,184 @@
+package wsclientable
+
+import (
+	"fmt"
+	"github.com/jokrey/utility-algorithms-golang/network/synchronization"
+	"time"
+)
+
+//Idea: See 'room_controller_repeating.go'
+//      Instead of a fixed period, the windows of a scheduled room come from its Schedule (see 'room_schedule.go')
+//          e.g. clients can only connect while a window is open and will be disconnected at the end of that window
+//      The controller schedules a single callback, at the end of the open period of the room that closes next
+// Example editing requests (python3):
+//     import requests; r = requests.post("http://localhost:8091/rooms/scheduled/add", params={"id": "test", "allowed_clients": '["c", "s", "parent"]', "timezone": "Europe/Berlin", "rules": '["Mon,Wed 17:00-18:30"]', "excluded_dates": '["2026-12-23"]'}); print(r.reason, r.text)
+//     import requests; r = requests.post("http://localhost:8091/rooms/scheduled/edit", params={"id": "test", "allowed_clients": '["c", "s"]', "timezone": "Europe/Berlin", "rules": '["0 9 * * 1-5 8h"]'}); print(r.reason, r.text)
+//     import requests; r = requests.post("http://localhost:8091/rooms/scheduled/remove?id=test"); print(r.reason, r.text)
+
+type ScheduledRoomController struct {
+	EditableRoomController
+	nextExpiration synchronization.TimedCallback
+}
+
+func NewScheduledRoomController(roomStorage RoomStorageI) *ScheduledRoomController {
+	rc := &ScheduledRoomController{
+		EditableRoomController: NewEditableRoomController(roomStorage),
+		nextExpiration:         synchronization.NewTimedCallback(),
+	}
+	rc.reInitCallback()
+	return rc
+}
+
+func (p *ScheduledRoomController) Close() error {
+	p.nextExpiration.Stop()
+	return p.EditableRoomController.Close()
+}
+
+func (p *ScheduledRoomController) NewConnectionForRoom(roomID string, connection ClientConnection) bool {
+	return p.NewConnectionForRoomWithReason(roomID, connection) == nil
+}
+
+// see RoomRejectionReporterI
+func (p *ScheduledRoomController) NewConnectionForRoomWithReason(roomID string, connection ClientConnection) error {
+	room := p.GetRoom(roomID)
+	if err := p.checkRoomAllows(roomID, room, connection); err != nil {
+		return err
+	}
+	sRoom, ok := room.(ScheduledRoom)
+	if !ok {
+		err := RoomRejectionError{RoomID: roomID, Reason: fmt.Sprintf("%T is not a scheduled room", room)}
+		p.events.emit(UserRejectedEvent{RoomID: roomID, UserID: connection.Identity.UserID, Err: err})
+		return err
+	}
+	p.cleanAtAppropriateTimeForScheduledRoom(&sRoom)
+	return p.addConnectionAndTrackEnds(roomID, sRoom, connection, sRoom.MaxParticipants)
+}
+func (p *ScheduledRoomController) CloseAndRemoveRoom(roomID string) (bool, error) {
+	roomI := p.getRoom(roomID)
+	if roomI == nil {
+		return false, nil
+	}
+	if oldRoom, ok := roomI.(ScheduledRoom); ok {
+		if at, ok := ExpirationCallbackDateForScheduledRoom(&oldRoom); ok && at == p.nextExpiration.GetCallbackExpectedAt() {
+			//the closed room is the one that will be the next to expire, need to recompute
+			p.reInitCallback()
+		}
+	}
+
+	_, e1 := p.closeEndedRoom(roomID, "room removed")
+	existed, e2 := p.removeFromStore(roomID)
+	if e1 != nil {
+		return existed, e1
+	}
+	return existed, e2
+}
+// Only takes rooms of type ScheduledRoom (see RoomTypedControllerI)
+func (p *ScheduledRoomController) RoomTypes() []string {
+	return []string{RoomTypeScheduled}
+}
+
+func (p *ScheduledRoomController) AddRoom(roomID string, newRoomI RoomI, allowOverride bool) error {
+	newRoom, ok := newRoomI.(ScheduledRoom)
+	if !ok {
+		return fmt.Errorf("cannot add room(%v), %T is not a ScheduledRoom", roomID, newRoomI)
+	}
+
+	previous := p.getRoom(roomID)
+	err := p.store.Put(newRoom, allowOverride)
+	if err != nil {
+		return err
+	}
+	newRoom.groups = p.groups.get()
+	p.emitAddedOrEdited(roomID, previous, newRoom)
+	p.ForAllIn(roomID, func(connection *ClientConnection) {
+		if !IsAllowedConnection(newRoom, connection.Identity) {
+			_ = connection.Close() //automatically removes connection in room also
+		}
+	})
+
+	p.trackEndsAfterEdit(roomID, newRoom)
+
+	p.cleanAtAppropriateTimeForScheduledRoom(&newRoom)
+	return nil
+}
+
+func (p *ScheduledRoomController) cleanAtAppropriateTimeForScheduledRoom(room *ScheduledRoom) {
+	if room == nil {
+		return
+	}
+	callbackAt, ok := ExpirationCallbackDateForScheduledRoom(room)
+	if !ok {
+		return
+	}
+	wasEarlier := p.nextExpiration.CallMeBackIfEarlierThanCurrent(callbackAt, func() {
+		p.reInitCallback()
+	})
+	p.logger.Debug("scheduled expiration callback", "room", room.ID, "at", callbackAt, "wasEarlier", wasEarlier)
+}
+func (p *ScheduledRoomController) reInitCallback() {
+	p.logger.Debug("cleaning expired scheduled rooms")
+	next := p.validateAllConnections()
+	if next != nil {
+		at, _ := ExpirationCallbackDateForScheduledRoom(next)
+		p.logger.Debug("next room to expire", "room", next.ID, "at", at)
+	} else {
+		p.logger.Debug("no next scheduled room to expire (no scheduled rooms with currently connected clients should exist)")
+	}
+	p.cleanAtAppropriateTimeForScheduledRoom(next)
+}
+
+// The time at which connections to the room have to be re-validated: shortly after the current open period ends
+//   if the room is currently closed, shortly after the next window ends. False if the room never closes again
+func ExpirationCallbackDateForScheduledRoom(r *ScheduledRoom) (time.Time, bool) {
+	return ExpirationCallbackDateForScheduledRoomAt(r, time.Now())
+}
+func ExpirationCallbackDateForScheduledRoomAt(r *ScheduledRoom, now time.Time) (time.Time, bool) {
+	end, open := r.Schedule.OpenUntil(now)
+	if !open {
+		next, ok := r.Schedule.NextWindowAfter(now)
+		if !ok {
+			return time.Time{}, false
+		}
+		if end, open = r.Schedule.OpenUntil(next.Start); !open {
+			return time.Time{}, false
+		}
+	}
+	return end.Add(time.Second), true //a little after, so the callback is definitely after the expiration so the checks are successful
+}
+
+// Returns the next room to close
+func (p *ScheduledRoomController) validateAllConnections() *ScheduledRoom {
+	var nextToClose *ScheduledRoom
+	var currentExpirationDate time.Time
+	p.ForAllRooms(func(roomID string) {
+		r := p.validateConnectionsInRoom(roomID)
+		if r == nil {
+			return
+		}
+		expirationOfR, ok := ExpirationCallbackDateForScheduledRoom(r)
+		if ok && (nextToClose == nil || expirationOfR.Before(currentExpirationDate)) {
+			currentExpirationDate = expirationOfR
+			nextToClose = r
+		}
+	})
+	return nextToClose
+}
+func (p *ScheduledRoomController) validateConnectionsInRoom(roomID string) *ScheduledRoom {
+	roomI := p.getRoom(roomID)
+	if roomI == nil {
+		return nil
+	}
+	room := roomI.(ScheduledRoom)
+	if !room.IsOpen() && p.CountConnectionsInRoom(roomID) > 0 {
+		p.events.emit(RoomClosedEvent{RoomID: roomID, Reason: "room window ended"})
+	}
+	p.ForAllIn(roomID, func(connection *ClientConnection) {
+		if !IsAllowedConnection(room, connection.Identity) {
+			e := connection.CloseWithReason(CloseCodeRoomClosed, "room window ended")
+			if e != nil {
+				p.logger.Debug("ignored error on connection close", "room", roomID, "connection", connection.ID, "err", e)
+			}
+		}
+	})
+	return &room
+}
@@ -1,8 +1,8 @@
 package wsclientable
 
 import (
+	"fmt"
 	"github.com/jokrey/utility-algorithms-golang/network/synchronization"
-	"log"
 	"time"
 )
 
@@ -22,6 +22,16 @@ import (
 //                so it makes sense to have valid_from_in_seconds_from_now=0,
 //                  otherwise some might be temporarily in an invalid room (leads to automatic disconnect)
 //     import requests; r = requests.post("http://localhost:8089/rooms/temp/control/remove?id=test"); print(r.reason, r.text)
+//  To move only the end of a running room without disconnecting anyone, use ExtendRoom and ShortenRoom instead of editing
+//     import requests; r = requests.post("http://localhost:8089/rooms/temp/control/extend?id=test&seconds=600"); print(r.reason, r.text)
+//     import requests; r = requests.post("http://localhost:8089/rooms/temp/control/shorten?id=test&seconds=300"); print(r.reason, r.text)
+//         connected clients stay connected and receive an updated room_info message (see 'room_closing.go')
+
+// Implemented by controllers that can move the end of a room without disconnecting its clients
+type RoomEndMovableI interface {
+	ExtendRoom(roomID string, seconds int64) (TemporaryRoom, error)
+	ShortenRoom(roomID string, seconds int64) (TemporaryRoom, error)
+}
 
 type TemporaryRoomController struct {
 	EditableRoomController
@@ -38,34 +48,43 @@ func NewTemporaryRoomController(roomStorage RoomStorageI) *TemporaryRoomControll
 }
 
 func (p *TemporaryRoomController) CloseAndRemoveRoom(roomID string) (bool, error) {
-	roomI := p.store.Get(roomID)
+	roomI := p.getRoom(roomID)
 	if roomI == nil {
 		return false, nil
 	}
-	oldRoom := roomI.(TemporaryRoom)
-	if expirationCallbackDateForTemporaryRoom(&oldRoom) == p.nextExpiration.GetCallbackExpectedAt() {
+	if oldRoom, ok := roomI.(TemporaryRoom); ok && expirationCallbackDateForTemporaryRoom(&oldRoom) == p.nextExpiration.GetCallbackExpectedAt() {
 		//damn... the closed room is the one that will be the next to expire, need to recompute
 		p.reInitCallback()
 	}
 
-	_, e1 := p.CloseAllInRoom(roomID)
-	existed, e2 := p.store.Remove(roomID)
+	_, e1 := p.closeEndedRoom(roomID, "room removed")
+	existed, e2 := p.removeFromStore(roomID)
 	if e1 != nil {
 		return existed, e1
 	}
 	return existed, e2
 }
 
+// Only takes rooms of type TemporaryRoom (see RoomTypedControllerI)
+func (p *TemporaryRoomController) RoomTypes() []string {
+	return []string{RoomTypeTemporary}
+}
+
 func (p *TemporaryRoomController) AddRoom(roomID string, newRoomI RoomI, allowOverride bool) error {
-	newRoom := newRoomI.(TemporaryRoom)
+	newRoom, ok := newRoomI.(TemporaryRoom)
+	if !ok {
+		return fmt.Errorf("cannot add room(%v), %T is not a TemporaryRoom", roomID, newRoomI)
+	}
 
+	previous := p.getRoom(roomID)
 	err := p.store.Put(newRoom, allowOverride)
 	if err != nil {
 		return err
 	}
+	newRoom.groups = p.groups.get()
+	p.emitAddedOrEdited(roomID, previous, newRoom)
 	p.ForAllIn(roomID, func(connection *ClientConnection) {
-		_, userID, e := ConnectionIDStringToRoomIDAndUserID(connection.ID)
-		if e != nil || !newRoom.IsAllowed(userID) {
+		if !IsAllowedConnection(newRoom, connection.Identity) {
 			_ = connection.Close() //automatically removes connection in room also
 		}
 	})
@@ -73,31 +92,134 @@ func (p *TemporaryRoomController) AddRoom(roomID string, newRoomI RoomI, allowOv
 		_, _ = p.CloseAllInRoom(roomID) //automatically removes connection in room also
 		// do not delete - room will become active later
 	}
+	p.trackEndsAfterEdit(roomID, newRoom)
 
 	p.cleanAtAppropriateTimeForTemporaryRoom(&newRoom)
 	return nil
 }
 
+// Moves the end of the room the given number of seconds later, keeping all connections
+func (p *TemporaryRoomController) ExtendRoom(roomID string, seconds int64) (TemporaryRoom, error) {
+	if seconds < 0 {
+		return TemporaryRoom{}, fmt.Errorf("negative number of seconds: %v", seconds)
+	}
+	return p.moveRoomEnd(roomID, seconds)
+}
+
+// Moves the end of the room the given number of seconds earlier, keeping all connections until the new end
+//   if the new end has already passed, the room expires immediately
+func (p *TemporaryRoomController) ShortenRoom(roomID string, seconds int64) (TemporaryRoom, error) {
+	if seconds < 0 {
+		return TemporaryRoom{}, fmt.Errorf("negative number of seconds: %v", seconds)
+	}
+	return p.moveRoomEnd(roomID, -seconds)
+}
+
+func (p *TemporaryRoomController) moveRoomEnd(roomID string, seconds int64) (TemporaryRoom, error) {
+	room, err := p.getTemporaryRoom(roomID)
+	if err != nil {
+		return TemporaryRoom{}, err
+	}
+	return p.SetRoomValidUntil(roomID, room.ValidUntilUnixTime+seconds)
+}
+
+// Returns the room, fails if there is no such room or it is not a TemporaryRoom
+func (p *TemporaryRoomController) getTemporaryRoom(roomID string) (TemporaryRoom, error) {
+	roomI := p.getRoom(roomID)
+	if roomI == nil {
+		return TemporaryRoom{}, RoomNotFoundError{RoomID: roomID}
+	}
+	room, ok := roomI.(TemporaryRoom)
+	if !ok {
+		return TemporaryRoom{}, fmt.Errorf("room(%v) is not a temporary room, but %T", roomID, roomI)
+	}
+	return room, nil
+}
+
+// Returns the storage, fails if it cannot store temporary rooms (the controller accepts any RoomStorageI)
+func (p *TemporaryRoomController) getTemporaryStore() (TemporaryRoomStorageI, error) {
+	store, ok := p.store.(TemporaryRoomStorageI)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a TemporaryRoomStorageI", p.store)
+	}
+	return store, nil
+}
+
+// Changes only the end of the room, unlike AddRoom it keeps all connections and the start of the room
+//   the expiration is rescheduled and connected clients receive an updated room_info message
+func (p *TemporaryRoomController) SetRoomValidUntil(roomID string, validUntilUnixTime int64) (TemporaryRoom, error) {
+	oldRoom, err := p.getTemporaryRoom(roomID)
+	if err != nil {
+		return TemporaryRoom{}, err
+	}
+	if validUntilUnixTime < oldRoom.ValidFromUnixTime {
+		return oldRoom, fmt.Errorf("room(%v) cannot end before it starts", roomID)
+	}
+	store, err := p.getTemporaryStore()
+	if err != nil {
+		return oldRoom, err
+	}
+	changedRoom, err := store.SetValidUntil(roomID, validUntilUnixTime)
+	if err != nil {
+		return oldRoom, err
+	}
+	p.events.emit(RoomEditedEvent{RoomID: roomID, Previous: oldRoom, Room: changedRoom})
+
+	if expirationCallbackDateForTemporaryRoom(&oldRoom) == p.nextExpiration.GetCallbackExpectedAt() {
+		//the changed room was the next to expire, the callback might now be too early or too late
+		p.nextExpiration.Stop()
+		p.reInitCallback()
+	} else if !expirationCallbackDateForTemporaryRoom(&changedRoom).After(time.Now()) {
+		p.reInitCallback() //already expired, a callback in the past would never be called
+	} else {
+		p.cleanAtAppropriateTimeForTemporaryRoom(&changedRoom)
+	}
+
+	if changedRoom.IsValid() {
+		p.trackEndsAfterEdit(roomID, changedRoom)
+		p.ForAllIn(roomID, func(connection *ClientConnection) {
+			if err := sendRoomInfo(*connection, roomID, changedRoom); err != nil {
+				p.logger.Debug("error sending", "room", roomID, "user", connection.ID, "mType", MessageTypeRoomInfo, "err", err)
+			}
+		})
+	}
+	p.logger.Debug("moved end of temporary room", "room", roomID, "validUntil", time.Unix(validUntilUnixTime, 0))
+	return changedRoom, nil
+}
+
 func (p *TemporaryRoomController) cleanAtAppropriateTimeForTemporaryRoom(room *TemporaryRoom) {
 	if room == nil {
 		return
 	}
 	p.nextExpiration.CallMeBackIfEarlierThanCurrent(expirationCallbackDateForTemporaryRoom(room), func() {
-		next, _ := p.store.(TemporaryRoomStorageI).CleanExpired(func(removed *TemporaryRoom) {
-			_, _ = p.CloseAllInRoom(removed.GetID())
-			log.Println("Room " + removed.GetID() + " expired and was removed")
-		})
-		p.cleanAtAppropriateTimeForTemporaryRoom(next)
+		p.cleanAtAppropriateTimeForTemporaryRoom(p.cleanExpired())
+	})
+}
+
+// Removes all expired rooms and returns the next to expire
+func (p *TemporaryRoomController) cleanExpired() *TemporaryRoom {
+	store, err := p.getTemporaryStore()
+	if err != nil {
+		p.logger.Error("cannot clean expired rooms", "err", err)
+		return nil
+	}
+	var removedIDs []string
+	next, _ := store.CleanExpired(func(removed *TemporaryRoom) {
+		removedIDs = append(removedIDs, removed.GetID())
 	})
+	// outside of the callback, which might run within a database transaction
+	for _, roomID := range removedIDs {
+		_, _ = p.closeEndedRoom(roomID, "room expired")
+		p.logger.Debug("room expired and was removed", "room", roomID)
+		p.notifyRemoved(roomID)
+		p.notifyExpired(roomID)
+	}
+	return next
 }
 func expirationCallbackDateForTemporaryRoom(room *TemporaryRoom) time.Time {
 	return time.Unix(room.ValidUntilUnixTime, 0).Add(time.Second) //a little after, so the callback is definitely after the expiration so the checks are successful
 }
 
 func (p *TemporaryRoomController) reInitCallback() {
-	next, _ := p.store.(TemporaryRoomStorageI).CleanExpired(func(removed *TemporaryRoom) {
-		_, _ = p.CloseAllInRoom(removed.GetID())
-		log.Println("Room " + removed.GetID() + " expired and was removed")
-	})
-	p.cleanAtAppropriateTimeForTemporaryRoom(next)
+	p.cleanAtAppropriateTimeForTemporaryRoom(p.cleanExpired())
 }
@@ -0,0 +1,164 @@
+package wsclientable
+
+import "sync"
+
+//Idea:
+//  Go code embedding the server wants to observe what happens in rooms, not only raw connections (AddConnOpenedHandler).
+//  Controllers (all in this package) and the RoomControllers bundle implement RoomEventSourceI:
+//     added:    a room was added to a controller (AddRoom of a new room)
+//     edited:   an existing room was replaced (AddRoom with allowOverride, e.g. the edit routes)
+//     removed:  a room was removed (CloseAndRemoveRoom, end_room, expiry)
+//     expired:  a room was removed because it ended for good (see RoomExpiryNotifierI), after removed
+//     opened:   a room opened while clients waited in its lobby (rooms without waiting clients are not watched) - bundle only
+//     closed:   the connections of a room were closed because it ended (removed, expired or its window ended)
+//     joined:   a connection was added to a room (directly or when admitted from the lobby)
+//     left:     a connection in a room closed
+//     rejected: a connection could not be added to a room (also when rejected before the websocket upgrade)
+//  The bundle reports the events of all bundled controllers, except their rejections:
+//     it reports a rejection only if the connection is not let into the lobby either (also for unknown rooms).
+//  Events are typed structs, handlers switch on the type:
+//     switch e := event.(type) { case UserJoinedEvent: ... }
+//  Handlers are called synchronously, in the goroutine that caused the event, and should return quickly.
+//  SubscribeRoomEventsChannel delivers into a buffered channel instead, events are dropped while it is full.
+
+// Implemented by all room events
+type RoomEvent interface {
+	GetRoomID() string
+}
+
+type RoomAddedEvent struct {
+	RoomID string
+	Room   RoomI
+}
+type RoomEditedEvent struct {
+	RoomID   string
+	Previous RoomI
+	Room     RoomI
+}
+type RoomRemovedEvent struct {
+	RoomID string
+}
+type RoomExpiredEvent struct {
+	RoomID string
+}
+type RoomOpenedEvent struct {
+	RoomID string
+}
+type RoomClosedEvent struct {
+	RoomID string
+	// Sent to the clients as close reason, for example "room expired"
+	Reason string
+}
+type UserJoinedEvent struct {
+	RoomID     string
+	UserID     string
+	Connection ClientConnection
+}
+type UserLeftEvent struct {
+	RoomID string
+	UserID string
+}
+type UserRejectedEvent struct {
+	RoomID string
+	UserID string
+	Err    error
+}
+
+func (e RoomAddedEvent) GetRoomID() string    { return e.RoomID }
+func (e RoomEditedEvent) GetRoomID() string   { return e.RoomID }
+func (e RoomRemovedEvent) GetRoomID() string  { return e.RoomID }
+func (e RoomExpiredEvent) GetRoomID() string  { return e.RoomID }
+func (e RoomOpenedEvent) GetRoomID() string   { return e.RoomID }
+func (e RoomClosedEvent) GetRoomID() string   { return e.RoomID }
+func (e UserJoinedEvent) GetRoomID() string   { return e.RoomID }
+func (e UserLeftEvent) GetRoomID() string     { return e.RoomID }
+func (e UserRejectedEvent) GetRoomID() string { return e.RoomID }
+
+// Implemented by all controllers in this package and RoomControllers
+type RoomEventSourceI interface {
+	// The handler is called with every event until unsubscribe is called
+	SubscribeRoomEvents(handler func(event RoomEvent)) (unsubscribe func())
+}
+
+// Delivers the events of the source into a channel with the given buffer, events are dropped while it is full
+//
+//	unsubscribe closes the channel
+func SubscribeRoomEventsChannel(source RoomEventSourceI, buffer int) (<-chan RoomEvent, func()) {
+	events := make(chan RoomEvent, buffer)
+	mut := &sync.Mutex{}
+	closed := false
+	unsubscribe := source.SubscribeRoomEvents(func(event RoomEvent) {
+		mut.Lock()
+		defer mut.Unlock()
+		if closed {
+			return
+		}
+		select {
+		case events <- event:
+		default:
+		}
+	})
+	return events, func() {
+		unsubscribe()
+		mut.Lock()
+		defer mut.Unlock()
+		if !closed {
+			closed = true
+			close(events)
+		}
+	}
+}
+
+// Implemented by the event sources that can reject connections before they are opened (see authenticateRoomUser)
+type roomRejectionEmitter interface {
+	emitRejected(event UserRejectedEvent)
+}
+
+func (r *RoomControllers) emitRejected(event UserRejectedEvent)        { r.events.emit(event) }
+func (p *EditableRoomController) emitRejected(event UserRejectedEvent) { p.events.emit(event) }
+
+type roomEventHandler struct {
+	id      int
+	handler func(event RoomEvent)
+}
+
+type roomEventHandlers struct {
+	mut      *sync.RWMutex
+	nextID   int
+	handlers []roomEventHandler // in order of subscription
+}
+
+func newRoomEventHandlers() *roomEventHandlers {
+	return &roomEventHandlers{mut: &sync.RWMutex{}}
+}
+
+func (h *roomEventHandlers) subscribe(handler func(event RoomEvent)) func() {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	id := h.nextID
+	h.nextID++
+	h.handlers = append(h.handlers, roomEventHandler{id: id, handler: handler})
+	return func() {
+		h.mut.Lock()
+		defer h.mut.Unlock()
+		for i, subscribed := range h.handlers {
+			if subscribed.id == id {
+				// copied, emit might still iterate the old slice
+				h.handlers = append(append([]roomEventHandler{}, h.handlers[:i]...), h.handlers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func (h *roomEventHandlers) emit(event RoomEvent) {
+	if h == nil {
+		return
+	}
+	h.mut.RLock()
+	handlers := h.handlers
+	h.mut.RUnlock()
+	for _, subscribed := range handlers {
+		subscribed.handler(event)
+	}
+}
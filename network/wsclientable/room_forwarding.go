@@ -2,11 +2,11 @@ package wsclientable
 
 import (
 	"encoding/json"
-	"log"
+	"fmt"
 )
 
 //Idea: Rooms are additional fields in the ws upgrade request header.
-//      The server validates that the room is registered and will inform clients of room constraints.
+//      The server validates that the room is registered and will inform clients of room constraints (see 'room_closing.go').
 //         The server will disconnect from clients in rooms that become invalid (timeout or removed by controller)
 //         The server cannot force clients to disconnect in a p2p connection, but this is meant to ask them to.
 //      Rooms can have allowed clients
@@ -17,67 +17,131 @@ import (
 //         Temporary rooms have a date-timeframe
 //         Temporary rooms can be deleted
 
+// Value of the field 'to' of relayed messages, that are sent to everyone else in the room
+const RelayToEveryone = "*"
+
 // Will add direct relay functionality within rooms (described above)
+//   Clients identify with the url params room=<roomID>&user=<userID>
+//   Messages are relayed to the user in their field 'to', or to everyone else in the room if it is RelayToEveryone
+//   The given message types are relayed, unless the ForwardingPolicy of a room restricts them
+//     (AnyOtherMessageType relays all types that have no other handler, the rooms then decide)
 func (s *Server) AddRoomForwardingFunctionality(roomControllers RoomControllers, messageTypes ...string) {
+	s.AddRoomForwardingFunctionalityWithUserAuthenticator(
+		roomControllers, RequestAuthenticatorFromURLParams(AuthenticateUserPermitAll()), messageTypes...,
+	)
+}
+
+// Same as AddRoomForwardingFunctionality, but the user id is determined by the given userAuthenticator
+//   only the room is still taken from the url params (room=<roomID>)
+//   for example AuthenticateUserByClientCertificate allows mutual tls authenticated rooms
+func (s *Server) AddRoomForwardingFunctionalityWithUserAuthenticator(
+	roomControllers RoomControllers, userAuthenticator RequestAuthenticator, messageTypes ...string,
+) {
+	s.AddRoomForwardingFunctionalityWithContextAuthenticator(
+		roomControllers, ContextAuthenticatorFrom(userAuthenticator), messageTypes...,
+	)
+}
+
+// Same as AddRoomForwardingFunctionalityWithUserAuthenticator,
+//   but request-scoped values in the context returned by the userAuthenticator are kept in the connection context
+func (s *Server) AddRoomForwardingFunctionalityWithContextAuthenticator(
+	roomControllers RoomControllers, userAuthenticator ContextAuthenticator, messageTypes ...string,
+) {
 	rooms := roomControllers
+	rooms.SetLogger(s.logger)
 	rooms.Init()
 
-	s.SetAuthenticator(AuthenticateRoomUserPermitAllowed(&rooms))
+	s.SetContextAuthenticator(AuthenticateRoomUserWithContext(&rooms, userAuthenticator))
 
 	s.AddServerClosedHandler(func() {
 		_ = rooms.Close()
 	})
 
 	s.AddConnOpenedHandler(func(connection ClientConnection) {
-		roomID, userID, e := ConnectionIDStringToRoomIDAndUserID(connection.ID)
-		if e != nil {
-			log.Printf("New Connection, but invalid connection ID(%v), closing", connection.ID)
-			_ = connection.Close() //when we could not add the connection to any room, we close it
-			return
-		}
-		log.Print("Connect: " + userID + ", in " + roomID)
-		if !rooms.NewConnectionForRoom(roomID, connection) {
-			_ = connection.Close() //when we could not add the connection to any room, we close it
+		roomID, userID := connection.Identity.RoomID, connection.Identity.UserID
+		s.logger.Debug("connected", "room", roomID, "user", userID)
+		if err := rooms.NewConnectionForRoomWithReason(roomID, connection); err != nil {
+			s.logger.Debug("connection rejected by room", "room", roomID, "user", userID, "err", err)
+			//when we could not add the connection to any room, we close it
+			_ = connection.CloseWithReason(CloseCodeRoomRejected, err.Error())
 		}
 	})
-	s.AddConnClosedHandler(func(connectionID string, code int, reason string) {
-		roomID, userID, e := ConnectionIDStringToRoomIDAndUserID(connectionID)
-		if e != nil {
-			log.Println("Disconnect:", "Invalid ConnectionID(", connectionID, ")", " :::: RAW(can look strange, might be normal): c=", code, ", r=", reason, ")")
-		} else {
-			log.Println("Disconnect:", userID, ", in", roomID, " :::: RAW(can look strange, might be normal): c=", code, ", r=", reason, ")")
-		}
+	s.AddConnClosedHandlerWithConnection(func(connection ClientConnection, code int, reason string) {
+		roomID, userID := connection.Identity.RoomID, connection.Identity.UserID
+		// raw code and reason can look strange, might be normal
+		s.logger.Debug("disconnected", "room", roomID, "user", userID, "code", code, "reason", reason)
 		rooms.ConnectionInRoomClosed(roomID, userID)
 	})
 
 	directRelayWithinRoom := func(mType string, client ClientConnection, data map[string]interface{}) {
 		if to, ok := data["to"]; !ok || to == nil {
-			log.Printf("Missing field for message of type %v", mType)
+			s.logger.Debug("missing field 'to'", "connection", client.ID, "mType", mType)
 			return
 		}
 
-		roomID, userID, _ := ConnectionIDStringToRoomIDAndUserID(client.ID)
+		roomID, userID := client.Identity.RoomID, client.Identity.UserID
 
 		room := rooms.GetRoom(roomID)
 		if room == nil {
-			log.Print("Client's(" + userID + ") room(" + roomID + ") no longer exists - closing client connection")
-			log.Print("This should never happen." +
-				"If a room is closed, all clients should be disconnected and no new clients accepted." +
-				"Getting a request here is either an unlikely race condition or a bug. Or both. Anyway, closing now.")
+			// If a room is closed, all clients should be disconnected and no new clients accepted.
+			// Getting a request here is either an unlikely race condition or a bug. Or both. Anyway, closing now.
+			s.logger.Warn("client's room no longer exists - closing client connection", "room", roomID, "user", userID)
 			_ = client.Close()
 			return
 		}
 
+		if rooms.IsWaitingInLobby(roomID, userID) {
+			err := client.SendMapTyped("error", map[string]interface{}{
+				"requestType": mType, "reason": "waiting in lobby of room " + roomID,
+			})
+			if err != nil {
+				s.logger.Warn("error sending", "room", roomID, "user", userID, "mType", "error", "err", err)
+			}
+			return
+		}
+
 		to := data["to"].(string)
 		data["from"] = userID
 
+		if err := ForwardingPolicyOf(room).Check(mType, RoleOfConnection(room, client.Identity)); err != nil {
+			err := client.SendMapTyped("error", map[string]interface{}{
+				"requestType": mType, "code": ErrorCodeMessageTypeDenied, "reason": err.Error(),
+			})
+			if err != nil {
+				s.logger.Warn("error sending", "room", roomID, "user", userID, "mType", "error", "err", err)
+			}
+			return
+		}
+		if rooms.IsMuted(roomID, userID) {
+			err := client.SendMapTyped("error", map[string]interface{}{
+				"requestType": mType, "reason": "muted in room " + roomID,
+			})
+			if err != nil {
+				s.logger.Warn("error sending", "room", roomID, "user", userID, "mType", "error", "err", err)
+			}
+			return
+		}
+
+		if to == RelayToEveryone {
+			rooms.ForAllIn(roomID, func(peer *ClientConnection) {
+				if peer.Identity.UserID == userID {
+					return
+				}
+				if err := peer.SendMapTyped(mType, data); err != nil {
+					s.logger.Warn("error relaying", "room", roomID, "user", userID, "to", peer.Identity.UserID, "mType", mType, "err", err)
+				}
+			})
+			rooms.relayed.notify(RelayedMessage{RoomID: roomID, Type: mType, From: userID, To: to, Data: data})
+			return
+		}
+
 		peer := rooms.GetConnectionInRoom(roomID, to)
 		//log.Printf("Attempt send from(%v), to(%v), peer(%v), in room(%v)", userID, to, peer, roomID)
 		if peer == nil {
 			err := client.SendTyped("error",
 				"{\"requestType\":\""+mType+"\", \"reason\":\"Peer "+to+" not found in room "+roomID+"\"}")
 			if err != nil {
-				log.Printf("Error sending to %v from %v in room %v", to, userID, roomID)
+				s.logger.Warn("error sending", "room", roomID, "user", userID, "mType", "error", "err", err)
 			}
 			return
 		}
@@ -85,8 +149,9 @@ func (s *Server) AddRoomForwardingFunctionality(roomControllers RoomControllers,
 		// relay to other client
 		err := peer.SendMapTyped(mType, data)
 		if err != nil {
-			log.Printf("Error sending to %v from %v in room %v", to, userID, roomID)
+			s.logger.Warn("error relaying", "room", roomID, "user", userID, "to", to, "mType", mType, "err", err)
 		}
+		rooms.relayed.notify(RelayedMessage{RoomID: roomID, Type: mType, From: userID, To: to, Data: data})
 	}
 
 	for _, mType := range messageTypes {
@@ -98,11 +163,32 @@ func UnmarshalJsonArray(jsonArray string) []string {
 	var strs []string
 	err := json.Unmarshal([]byte(jsonArray), &strs)
 	if err != nil {
-		log.Panic("Could not load permanent room allowed Ids (not a list: " + jsonArray + ")")
+		panic("Could not load permanent room allowed Ids (not a list: " + jsonArray + ")")
 	}
 	return strs
 }
 
+// Parses a json object of user ids to roles, for example {"alice":"owner", "bob":"moderator"}
+//   an empty string is parsed as no roles
+func UnmarshalJsonRoles(jsonObject string) (map[string]Role, error) {
+	roles := make(map[string]Role)
+	if len(jsonObject) == 0 {
+		return roles, nil
+	}
+	var raw map[string]string
+	if err := json.Unmarshal([]byte(jsonObject), &raw); err != nil {
+		return nil, fmt.Errorf("roles not a json object of strings: %w", err)
+	}
+	for userID, rawRole := range raw {
+		role, err := ParseRole(rawRole)
+		if err != nil {
+			return nil, err
+		}
+		roles[userID] = role
+	}
+	return roles, nil
+}
+
 func CreateAllowedIdsMapFromSlice(allowedClientIds []string) map[string]bool {
 	allowedClientIdsMap := make(map[string]bool)
 	for i := 0; i < len(allowedClientIds); i++ {
@@ -0,0 +1,102 @@
+package wsclientable
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+//Idea:
+//  A server can relay many message types (those given to AddRoomForwardingFunctionality),
+//     each room decides which of them it relays and who may send them.
+//     That way signaling rooms (offer, answer, candidate) and chat rooms (chat) can run on the same server.
+//  Example: only the host may start a call, everyone may answer
+//     message_types=["offer", "answer", "candidate"]
+//     send_roles={"offer":["host"]}
+//  Denied messages are answered with:
+//     {"type":"error", "data":{"requestType":"<mType>", "code":"message_type_denied", "reason":"..."}}
+
+// Error code of the error message sent when a room does not relay a message type for the sender
+const ErrorCodeMessageTypeDenied = "message_type_denied"
+
+type ForwardingPolicy struct {
+	// Message types relayed within the room, if empty all types the server relays are relayed
+	MessageTypes []string
+	// Message type -> roles that may send it, types without an entry may be sent by everyone
+	SendRoles map[string][]Role
+}
+
+// Optionally implemented by rooms that restrict forwarding
+//   All rooms embedding Room implement it
+type ForwardingPolicyRoomI interface {
+	RoomI
+	GetForwardingPolicy() ForwardingPolicy
+}
+
+// Returns the forwarding policy of the given room, the zero policy (relay everything) if the room does not have one
+func ForwardingPolicyOf(room RoomI) ForwardingPolicy {
+	if restricted, ok := room.(ForwardingPolicyRoomI); ok {
+		return restricted.GetForwardingPolicy()
+	}
+	return ForwardingPolicy{}
+}
+
+// Returns nil if a user with the given role may send the given message type, otherwise the reason why not
+func (f ForwardingPolicy) Check(mType string, role Role) error {
+	if len(f.MessageTypes) > 0 && !containsString(f.MessageTypes, mType) {
+		return fmt.Errorf("message type %v is not relayed in this room", mType)
+	}
+	if roles, restricted := f.SendRoles[mType]; restricted {
+		for _, allowed := range roles {
+			if allowed == role {
+				return nil
+			}
+		}
+		return fmt.Errorf("role %v may not send %v in this room", role, mType)
+	}
+	return nil
+}
+
+// Whether the policy restricts anything
+func (f ForwardingPolicy) IsZero() bool {
+	return len(f.MessageTypes) == 0 && len(f.SendRoles) == 0
+}
+
+func (f ForwardingPolicy) clone() ForwardingPolicy {
+	var clone ForwardingPolicy
+	if len(f.MessageTypes) > 0 {
+		clone.MessageTypes = append([]string{}, f.MessageTypes...)
+	}
+	if len(f.SendRoles) > 0 {
+		clone.SendRoles = make(map[string][]Role, len(f.SendRoles))
+		for mType, roles := range f.SendRoles {
+			clone.SendRoles[mType] = append([]Role{}, roles...)
+		}
+	}
+	return clone
+}
+
+// Parses the policy from a json array of message types and a json object of message types to arrays of roles
+//   for example: ["offer", "answer"] and {"offer":["host"]} - empty strings are parsed as no restriction
+func ParseForwardingPolicy(messageTypesJSON, sendRolesJSON string) (ForwardingPolicy, error) {
+	var policy ForwardingPolicy
+	if len(messageTypesJSON) > 0 {
+		if err := json.Unmarshal([]byte(messageTypesJSON), &policy.MessageTypes); err != nil {
+			return ForwardingPolicy{}, fmt.Errorf("message types not a json array of strings: %w", err)
+		}
+	}
+	if len(sendRolesJSON) > 0 {
+		if err := json.Unmarshal([]byte(sendRolesJSON), &policy.SendRoles); err != nil {
+			return ForwardingPolicy{}, fmt.Errorf("send roles not a json object of string arrays: %w", err)
+		}
+	}
+	return policy, nil
+}
+
+func containsString(slice []string, s string) bool {
+	for _, v := range slice {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,319 @@
+package wsclientable
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+//Idea:
+//  For chat-style message types, late joiners should see what was said before they joined.
+//  Rooms opt in with a HistoryPolicy, naming the relayed message types that are kept:
+//     history={"message_types":["chat"], "replay":50}
+//  Relayed messages of those types (to a single user or to everyone, see 'room_forwarding.go') are appended to a log per room.
+//     the storage decides the retention, by count and by age (see HistoryRetention)
+//  On join (also when admitted from the lobby), the connection receives the last <replay> messages it may see:
+//     {"type":"history", "data":{"room":"<roomID>", "messages":[{"seq":7, "type":"chat", "from":"a", "to":"*", "data":{...}, "at":<unix>}], "more":true}}
+//     messages are ordered oldest first, a user sees messages sent to everyone, to them and by them
+//     a message relayed while the replay is sent can arrive twice, clients can drop it by its seq
+//  Older messages are requested page by page, with the seq of the oldest message known to the client:
+//     {"type":"history", "data":{"before":7, "limit":50}}   - the answer has the same format as the replay
+//  Failed requests are answered with {"type":"error", "data":{"requestType":"history", "reason":"..."}}
+//  The history of a room is deleted when its controller removes the room (see 'room_hooks.go').
+//  It is kept in memory (NewRamRoomHistoryStorage) or persisted in bolt (see 'room_storage_history_db.go').
+
+const MessageTypeHistory = "history"
+
+// Maximum number of messages in a single history message (replay or requested page)
+const MaxHistoryPageSize = 200
+
+type HistoryPolicy struct {
+	// Relayed message types that are kept in the history, nothing is kept if empty
+	MessageTypes []string `json:"message_types"`
+	// Number of messages replayed on join (0 = none, the history can still be requested)
+	Replay int `json:"replay"`
+}
+
+// Optionally implemented by rooms that keep a history
+//   All rooms embedding Room implement it
+type HistoryRoomI interface {
+	RoomI
+	GetHistoryPolicy() HistoryPolicy
+}
+
+// Returns the history policy of the given room, the zero policy (no history) if the room does not have one
+func HistoryPolicyOf(room RoomI) HistoryPolicy {
+	if withHistory, ok := room.(HistoryRoomI); ok {
+		return withHistory.GetHistoryPolicy()
+	}
+	return HistoryPolicy{}
+}
+
+// Whether relayed messages of the given type are kept
+func (h HistoryPolicy) Records(mType string) bool {
+	return containsString(h.MessageTypes, mType)
+}
+
+// Whether the policy keeps anything
+func (h HistoryPolicy) IsZero() bool {
+	return len(h.MessageTypes) == 0 && h.Replay == 0
+}
+
+func (h HistoryPolicy) clone() HistoryPolicy {
+	if len(h.MessageTypes) > 0 {
+		h.MessageTypes = append([]string{}, h.MessageTypes...)
+	}
+	return h
+}
+
+// Parses the policy from a json object, for example: {"message_types":["chat"], "replay":50} - empty strings are parsed as no history
+func ParseHistoryPolicy(policyJSON string) (HistoryPolicy, error) {
+	var policy HistoryPolicy
+	if len(policyJSON) == 0 {
+		return policy, nil
+	}
+	if err := json.Unmarshal([]byte(policyJSON), &policy); err != nil {
+		return HistoryPolicy{}, fmt.Errorf("history policy not a json object with message_types and replay: %w", err)
+	}
+	if policy.Replay < 0 || policy.Replay > MaxHistoryPageSize {
+		return HistoryPolicy{}, fmt.Errorf("replay must be between 0 and %v", MaxHistoryPageSize)
+	}
+	return policy, nil
+}
+
+// A relayed message kept in the history of a room
+type HistoryMessage struct {
+	// Position in the log of the room, increasing from 1
+	Seq  uint64 `json:"seq"`
+	Type string `json:"type"`
+	From string `json:"from"`
+	// The receiving user, RelayToEveryone if the message was sent to everyone in the room
+	To string `json:"to"`
+	// The relayed data, as it was received by the peers
+	Data       json.RawMessage `json:"data"`
+	AtUnixTime int64           `json:"at"`
+}
+
+// Whether the given user may see the message
+func (m HistoryMessage) VisibleTo(userID string) bool {
+	return m.To == RelayToEveryone || m.To == userID || m.From == userID
+}
+
+// Limits how long the storages keep messages, zero fields do not limit
+type HistoryRetention struct {
+	// Maximum number of messages kept per room, the oldest are dropped first
+	MaxMessages int
+	// Messages older than this are dropped
+	MaxAge time.Duration
+}
+
+// Whether a message sent at the given time is too old at now
+func (r HistoryRetention) expired(atUnixTime int64, now time.Time) bool {
+	return r.MaxAge > 0 && atUnixTime < now.Add(-r.MaxAge).Unix()
+}
+
+// Stores the history of rooms
+type RoomHistoryStorageI interface {
+	// Appends the message to the log of the room and returns its sequence number (the Seq of the given message is ignored)
+	Append(roomID string, message HistoryMessage) (uint64, error)
+	// Returns up to limit messages with a sequence number below beforeSeq (0 = starting with the newest) for which visible is true
+	//   ordered oldest first, and whether older messages for which visible is true exist
+	Before(roomID string, beforeSeq uint64, limit int, visible func(HistoryMessage) bool) ([]HistoryMessage, bool, error)
+	// Deletes the complete history of the room
+	RemoveRoom(roomID string) error
+
+	// Closes underlying resources
+	Close() error
+}
+
+// Adds the room history described above.
+//   roomControllers have to be the same controllers given to AddRoomForwardingFunctionality (which initializes and closes them)
+//   the storage is closed with the server
+func (s *Server) AddRoomHistoryFunctionality(roomControllers RoomControllers, storage RoomHistoryStorageI) {
+	rooms := &roomControllers
+
+	reject := func(client ClientConnection, reason string) {
+		err := client.SendMapTyped("error", map[string]interface{}{"requestType": MessageTypeHistory, "reason": reason})
+		if err != nil {
+			s.logger.Warn("error sending", "connection", client.ID, "mType", "error", "err", err)
+		}
+	}
+	sendPage := func(connection ClientConnection, roomID string, beforeSeq uint64, limit int) error {
+		userID := connection.Identity.UserID
+		messages, more, err := storage.Before(roomID, beforeSeq, limit, func(m HistoryMessage) bool {
+			return m.VisibleTo(userID)
+		})
+		if err != nil {
+			s.logger.Error("could not read room history", "room", roomID, "err", err)
+			return err
+		}
+		if messages == nil {
+			messages = []HistoryMessage{}
+		}
+		err = connection.SendMapTyped(MessageTypeHistory, map[string]interface{}{
+			"room": roomID, "messages": messages, "more": more,
+		})
+		if err != nil {
+			s.logger.Debug("error sending", "room", roomID, "user", userID, "mType", MessageTypeHistory, "err", err)
+		}
+		return nil
+	}
+
+	rooms.AddRoomMessageRelayedHandler(func(message RelayedMessage) {
+		room := rooms.GetRoom(message.RoomID)
+		if room == nil || !HistoryPolicyOf(room).Records(message.Type) {
+			return
+		}
+		data, err := json.Marshal(message.Data)
+		if err != nil {
+			s.logger.Warn("could not encode relayed message for history", "room", message.RoomID, "mType", message.Type, "err", err)
+			return
+		}
+		_, err = storage.Append(message.RoomID, HistoryMessage{
+			Type: message.Type, From: message.From, To: message.To, Data: data, AtUnixTime: time.Now().Unix(),
+		})
+		if err != nil {
+			s.logger.Error("could not append to room history", "room", message.RoomID, "mType", message.Type, "err", err)
+		}
+	})
+	rooms.AddRoomJoinedHandler(func(roomID string, connection ClientConnection) {
+		room := rooms.GetRoom(roomID)
+		if room == nil {
+			return
+		}
+		if replay := HistoryPolicyOf(room).Replay; replay > 0 {
+			_ = sendPage(connection, roomID, 0, replay)
+		}
+	})
+	rooms.AddRoomRemovedHandler(func(roomID string) {
+		if err := storage.RemoveRoom(roomID); err != nil {
+			s.logger.Error("could not remove room history", "room", roomID, "err", err)
+		}
+	})
+	s.AddServerClosedHandler(func() {
+		_ = storage.Close()
+	})
+
+	s.AddMessageHandler(MessageTypeHistory, func(mType string, client ClientConnection, data map[string]interface{}) {
+		roomID, userID := client.Identity.RoomID, client.Identity.UserID
+		room := rooms.GetRoom(roomID)
+		if room == nil || !rooms.IsConnected(roomID, userID) {
+			reject(client, "not in room "+roomID)
+			return
+		}
+		if rooms.IsWaitingInLobby(roomID, userID) {
+			reject(client, "waiting in lobby of room "+roomID)
+			return
+		}
+		if HistoryPolicyOf(room).IsZero() {
+			reject(client, "room "+roomID+" keeps no history")
+			return
+		}
+
+		var beforeSeq uint64
+		if raw, ok := data["before"]; ok {
+			number, isNumber := raw.(float64)
+			if !isNumber || number < 1 || number != float64(uint64(number)) {
+				reject(client, "field 'before' is not a sequence number")
+				return
+			}
+			beforeSeq = uint64(number)
+		}
+		limit := MaxHistoryPageSize
+		if raw, ok := data["limit"]; ok {
+			number, isNumber := raw.(float64)
+			if !isNumber || number < 1 || number > MaxHistoryPageSize || number != float64(int(number)) {
+				reject(client, fmt.Sprintf("field 'limit' is not a number between 1 and %v", MaxHistoryPageSize))
+				return
+			}
+			limit = int(number)
+		}
+		if err := sendPage(client, roomID, beforeSeq, limit); err != nil {
+			reject(client, "could not read history")
+		}
+	})
+}
+
+// RoomHistoryStorageI held in memory, lost when the program exits
+type RamRoomHistoryStorage struct {
+	mut       *sync.Mutex
+	retention HistoryRetention
+	logs      map[string]*ramHistoryLog
+}
+
+type ramHistoryLog struct {
+	lastSeq  uint64
+	messages []HistoryMessage
+}
+
+func NewRamRoomHistoryStorage(retention HistoryRetention) RamRoomHistoryStorage {
+	return RamRoomHistoryStorage{mut: &sync.Mutex{}, retention: retention, logs: make(map[string]*ramHistoryLog)}
+}
+
+func (r RamRoomHistoryStorage) Append(roomID string, message HistoryMessage) (uint64, error) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	log, ok := r.logs[roomID]
+	if !ok {
+		log = &ramHistoryLog{}
+		r.logs[roomID] = log
+	}
+	log.lastSeq++
+	message.Seq = log.lastSeq
+	message.Data = append(json.RawMessage{}, message.Data...)
+	log.messages = append(log.messages, message)
+
+	now := time.Now()
+	drop := 0
+	for drop < len(log.messages) &&
+		((r.retention.MaxMessages > 0 && len(log.messages)-drop > r.retention.MaxMessages) ||
+			r.retention.expired(log.messages[drop].AtUnixTime, now)) {
+		drop++
+	}
+	log.messages = append([]HistoryMessage{}, log.messages[drop:]...)
+	return message.Seq, nil
+}
+
+func (r RamRoomHistoryStorage) Before(roomID string, beforeSeq uint64, limit int, visible func(HistoryMessage) bool) ([]HistoryMessage, bool, error) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	log, ok := r.logs[roomID]
+	if !ok {
+		return nil, false, nil
+	}
+	now := time.Now()
+	var page []HistoryMessage
+	for i := len(log.messages) - 1; i >= 0; i-- {
+		message := log.messages[i]
+		if (beforeSeq != 0 && message.Seq >= beforeSeq) || !visible(message) {
+			continue
+		}
+		if r.retention.expired(message.AtUnixTime, now) {
+			break
+		}
+		if len(page) == limit {
+			return reverseHistoryMessages(page), true, nil
+		}
+		page = append(page, message)
+	}
+	return reverseHistoryMessages(page), false, nil
+}
+
+func (r RamRoomHistoryStorage) RemoveRoom(roomID string) error {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	delete(r.logs, roomID)
+	return nil
+}
+
+func (r RamRoomHistoryStorage) Close() error {
+	return nil
+}
+
+func reverseHistoryMessages(messages []HistoryMessage) []HistoryMessage {
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages
+}
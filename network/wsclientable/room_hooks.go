@@ -0,0 +1,115 @@
+package wsclientable
+
+import "sync"
+
+//Idea:
+//  Functionality built on top of rooms (for example the shared state in 'room_state.go') keeps data per room and per member.
+//  Instead of wrapping every controller, it registers hooks on the bundle of controllers (RoomControllers):
+//     joined:  a connection was added to a room - directly or when it was admitted from the lobby (after room_info was sent)
+//     removed: a controller removed a room - by CloseAndRemoveRoom (also end_room) or because the room expired
+//     expired: a controller removed a room because it ended for good (temporary rooms, repeating rooms after their last occurrence)
+//     relayed: a message was relayed within a room, to a single user or to everyone (see 'room_forwarding.go')
+//  Handlers are called synchronously, in the goroutine that caused the event, and should return quickly.
+//  Removal and expiry are reported by controllers implementing RoomRemovalNotifierI and RoomExpiryNotifierI (all controllers in this package).
+
+// Optionally implemented by controllers that report when they remove a room
+type RoomRemovalNotifierI interface {
+	// The handler is called after the controller removed a room, by CloseAndRemoveRoom or because it expired
+	AddRoomRemovedHandler(handler func(roomID string))
+}
+
+// Optionally implemented by controllers that report when a room expired
+type RoomExpiryNotifierI interface {
+	// The handler is called after the controller removed a room because it ended, after the room removed handlers
+	AddRoomExpiredHandler(handler func(roomID string))
+}
+
+// Handlers of removed and of expired rooms
+type roomRemovedHandlers struct {
+	mut      *sync.RWMutex
+	handlers []func(roomID string)
+}
+
+func newRoomRemovedHandlers() *roomRemovedHandlers {
+	return &roomRemovedHandlers{mut: &sync.RWMutex{}}
+}
+
+func (h *roomRemovedHandlers) add(handler func(roomID string)) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	h.handlers = append(h.handlers, handler)
+}
+
+func (h *roomRemovedHandlers) notify(roomID string) {
+	h.mut.RLock()
+	handlers := h.handlers
+	h.mut.RUnlock()
+	for _, handler := range handlers {
+		handler(roomID)
+	}
+}
+
+type roomJoinedHandlers struct {
+	mut      *sync.RWMutex
+	handlers []func(roomID string, connection ClientConnection)
+}
+
+func newRoomJoinedHandlers() *roomJoinedHandlers {
+	return &roomJoinedHandlers{mut: &sync.RWMutex{}}
+}
+
+func (h *roomJoinedHandlers) add(handler func(roomID string, connection ClientConnection)) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	h.handlers = append(h.handlers, handler)
+}
+
+func (h *roomJoinedHandlers) notify(roomID string, connection ClientConnection) {
+	if h == nil {
+		return
+	}
+	h.mut.RLock()
+	handlers := h.handlers
+	h.mut.RUnlock()
+	for _, handler := range handlers {
+		handler(roomID, connection)
+	}
+}
+
+// A message that was relayed within a room (see AddRoomForwardingFunctionality)
+type RelayedMessage struct {
+	RoomID string
+	Type   string
+	From   string
+	// The receiving user, RelayToEveryone if the message was sent to everyone in the room
+	To string
+	// The relayed data, must not be modified by handlers (it is shared)
+	Data map[string]interface{}
+}
+
+type roomRelayedHandlers struct {
+	mut      *sync.RWMutex
+	handlers []func(message RelayedMessage)
+}
+
+func newRoomRelayedHandlers() *roomRelayedHandlers {
+	return &roomRelayedHandlers{mut: &sync.RWMutex{}}
+}
+
+func (h *roomRelayedHandlers) add(handler func(message RelayedMessage)) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	h.handlers = append(h.handlers, handler)
+}
+
+func (h *roomRelayedHandlers) notify(message RelayedMessage) {
+	if h == nil {
+		return
+	}
+	h.mut.RLock()
+	handlers := h.handlers
+	h.mut.RUnlock()
+	for _, handler := range handlers {
+		handler(message)
+	}
+}
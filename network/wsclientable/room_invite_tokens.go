@@ -0,0 +1,232 @@
+package wsclientable
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/ini.v1"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//Idea:
+//  Allowed clients only say "your user id is in the list", there is no proof that the client is that user.
+//  Invite tokens are minted by the admin side (see HTTPRoomEditor.SetInviteRoute) and signed with a configured secret (HMAC-SHA256).
+//  A token encodes the room, the user, an optional role and the time frame in which it can be used to join.
+//  Clients join with route?room=<roomID>&token=<token>, the user id is taken from the token.
+//     This makes shareable join links possible, without adding the users to the room through the http editors first.
+//  The invite is only checked on upgrade and then kept in the identity of the connection (IdentityAttributeInvite):
+//     the invited user is allowed in the room while it is open, even if not in its allowed clients (deny entries still win)
+//     the role of the token (if any) replaces the role the room assigns to the user
+//     once connected, the connection stays allowed after the token expired (until the room window ends)
+//  Token format: base64url(json claims) + "." + base64url(hmac of the encoded claims)
+//  Example config section:
+//     [invite_tokens]
+//     ; at least MinInviteSecretLength bytes, keep it secret - everyone with it can mint invites
+//     secret=change-me-to-a-long-random-string
+
+// Minimum length of the invite secret in bytes
+const MinInviteSecretLength = 32
+
+// Identity attribute under which the verified InviteClaims of a connection are kept
+const IdentityAttributeInvite = "invite"
+
+type InviteClaims struct {
+	RoomID string `json:"room"`
+	UserID string `json:"user"`
+	// Empty means the role assigned by the room
+	Role Role `json:"role,omitempty"`
+	// The token can be used from this unix time on, 0 means immediately
+	NotBeforeUnixTime int64 `json:"nbf,omitempty"`
+	// The token can be used until this unix time (inclusive)
+	ExpiresUnixTime int64 `json:"exp"`
+}
+
+// Whether the token can be used to join at the given unix time
+func (c InviteClaims) ValidAt(unixTime int64) bool {
+	return unixTime >= c.NotBeforeUnixTime && unixTime <= c.ExpiresUnixTime
+}
+
+func (c InviteClaims) validate() error {
+	if err := ValidateID("room", c.RoomID); err != nil {
+		return err
+	}
+	if err := ValidateID("user", c.UserID); err != nil {
+		return err
+	}
+	if len(c.Role) > 0 {
+		if _, err := ParseRole(string(c.Role)); err != nil {
+			return err
+		}
+	}
+	if c.ExpiresUnixTime < c.NotBeforeUnixTime {
+		return fmt.Errorf("invite expires (%v) before it becomes valid (%v)", c.ExpiresUnixTime, c.NotBeforeUnixTime)
+	}
+	return nil
+}
+
+// Mints and verifies invite tokens with a shared secret
+type InviteTokens struct {
+	secret []byte
+}
+
+func NewInviteTokens(secret []byte) (InviteTokens, error) {
+	if len(secret) < MinInviteSecretLength {
+		return InviteTokens{}, fmt.Errorf("invite secret too short, need at least %v bytes", MinInviteSecretLength)
+	}
+	return InviteTokens{secret: append([]byte{}, secret...)}, nil
+}
+
+// Reads the secret from the [invite_tokens] section (see above), panics if it is missing or too short
+func NewInviteTokensFromCFG(cfg *ini.File) InviteTokens {
+	invites, err := NewInviteTokens([]byte(cfg.Section("invite_tokens").Key("secret").String()))
+	if err != nil {
+		panic("Could not load invite tokens: " + err.Error())
+	}
+	return invites
+}
+
+// Returns a signed token for the given claims
+func (t InviteTokens) Mint(claims InviteClaims) (string, error) {
+	if err := claims.validate(); err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(t.sign(encoded)), nil
+}
+
+// Returns the claims of the token, if it was signed with this secret and can be used at the given time
+func (t InviteTokens) Verify(token string, now time.Time) (InviteClaims, error) {
+	dot := strings.IndexByte(token, '.')
+	if dot < 0 {
+		return InviteClaims{}, AuthenticationError{Reason: "malformed invite token"}
+	}
+	encoded := token[:dot]
+	signature, err := base64.RawURLEncoding.DecodeString(token[dot+1:])
+	if err != nil || !hmac.Equal(signature, t.sign(encoded)) {
+		return InviteClaims{}, AuthenticationError{Reason: "invalid invite token signature"}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return InviteClaims{}, AuthenticationError{Reason: "malformed invite token"}
+	}
+	var claims InviteClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return InviteClaims{}, AuthenticationError{Reason: "malformed invite token"}
+	}
+	if err := claims.validate(); err != nil {
+		return InviteClaims{}, AuthenticationError{Reason: "invalid invite token: " + err.Error()}
+	}
+	if !claims.ValidAt(now.Unix()) {
+		return InviteClaims{}, AuthenticationError{Reason: "invite token not valid at this time"}
+	}
+	return claims, nil
+}
+
+func (t InviteTokens) sign(encodedClaims string) []byte {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(encodedClaims))
+	return mac.Sum(nil)
+}
+
+// Authenticates connections with a token=<token> url param, the user id and the invite are taken from the token
+//   the token has to be minted for the room in the url params (room=<roomID>)
+//   connections without a token param are left to the fallback, rejected if the fallback is nil
+//   use with AddRoomForwardingFunctionalityWithContextAuthenticator
+func AuthenticateUserByInviteToken(invites InviteTokens, fallback ContextAuthenticator) ContextAuthenticator {
+	return func(request *http.Request) (context.Context, Identity, error) {
+		initialParams, err := url.ParseQuery(request.URL.RawQuery)
+		if err != nil {
+			return request.Context(), Identity{}, AuthenticationError{Reason: "could not parse url params"}
+		}
+		token := initialParams.Get("token")
+		if len(token) == 0 {
+			if fallback == nil {
+				return request.Context(), Identity{}, MissingURLFieldError{MissingFieldName: "token"}
+			}
+			return fallback(request)
+		}
+		claims, err := invites.Verify(token, time.Now())
+		if err != nil {
+			return request.Context(), Identity{}, err
+		}
+		if claims.RoomID != initialParams.Get("room") {
+			return request.Context(), Identity{}, AuthenticationError{Reason: "invite token is for another room"}
+		}
+		return request.Context(), Identity{UserID: claims.UserID}.WithAttribute(IdentityAttributeInvite, claims), nil
+	}
+}
+
+// Same as AuthenticateUserByInviteToken with the secret from the config,
+//   returns the fallback unchanged if the config has no [invite_tokens] section
+func AuthenticateUserByInviteTokenFromCFG(cfg *ini.File, fallback ContextAuthenticator) ContextAuthenticator {
+	if _, err := cfg.GetSection("invite_tokens"); err != nil {
+		return fallback
+	}
+	return AuthenticateUserByInviteToken(NewInviteTokensFromCFG(cfg), fallback)
+}
+
+// Returns complete url for an invited room connection (baseurl example: http://dns.com:8080/route)
+// Same as http://dns.com:8080/route?room=<roomID>&token=<token>
+func UrlWithParamsForInvite(baseurl, roomID, token string) string {
+	if !strings.HasSuffix(baseurl, "?") {
+		baseurl += "?"
+	}
+	return baseurl + "room=" + url.QueryEscape(roomID) + "&token=" + url.QueryEscape(token)
+}
+
+// Returns the verified invite the connection was authenticated with, false if it joined without one
+func InviteOf(identity Identity) (InviteClaims, bool) {
+	claims, ok := identity.Attribute(IdentityAttributeInvite).(InviteClaims)
+	return claims, ok
+}
+
+// Optionally implemented by rooms that admit invited users, all rooms embedding Room implement it
+type InvitableRoomI interface {
+	RoomI
+	// Whether the window of the room itself is currently open, regardless of the allowed clients
+	IsOpen() bool
+	// Whether the user is rejected by a deny entry of the allowed clients
+	Denies(userID string) bool
+}
+
+// Returns the room, if the identity carries an invite for it, that it admits
+func invitingRoom(room RoomI, identity Identity) (InvitableRoomI, InviteClaims, bool) {
+	claims, ok := InviteOf(identity)
+	if !ok || claims.RoomID != room.GetID() || claims.UserID != identity.UserID {
+		return nil, claims, false
+	}
+	invitable, ok := room.(InvitableRoomI)
+	if !ok || invitable.Denies(identity.UserID) {
+		return nil, claims, false
+	}
+	return invitable, claims, true
+}
+
+// Whether the connection with the given identity is currently allowed in the room,
+//   either through the room itself (see RoomI.IsAllowed) or through the invite it was authenticated with
+func IsAllowedConnection(room RoomI, identity Identity) bool {
+	if room.IsAllowed(identity.UserID) {
+		return true
+	}
+	invitable, _, ok := invitingRoom(room, identity)
+	return ok && invitable.IsValid() && invitable.IsOpen()
+}
+
+// Returns the role of the connection with the given identity in the room,
+//   the role of its invite if it has one, otherwise the role the room assigns (see RoleOf)
+func RoleOfConnection(room RoomI, identity Identity) Role {
+	if _, claims, ok := invitingRoom(room, identity); ok && len(claims.Role) > 0 {
+		return claims.Role
+	}
+	return RoleOf(room, identity.UserID)
+}
@@ -0,0 +1,219 @@
+package wsclientable
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+//Idea:
+//  Rooms as json documents, for storages that keep a room in a single value (see 'room_storage_sql_db.go')
+//  The fields are named like the url params of the editors:
+//     {"id": "r", "allowed_clients": ["a", "group:staff"], "max_participants": 5, "roles": {"a": "owner"},
+//      "message_types": ["offer"], "send_roles": {"offer": ["owner"]}, "lobby": true,
+//      "user_windows": {"b": {"from": 1700000000, "until": 1700003600}}, "history": {"message_types": ["chat"], "replay": 50}}
+//  plus the fields of the type (times are unix times):
+//     temporary: "valid_from", "valid_until"
+//     repeating: "first_time", "repeat_every_seconds", "duration_in_seconds", "until", "max_occurrences"
+//     scheduled: "schedule": {"timezone": "Europe/Berlin", "rules": ["MON-FRI 09:00-17:00"], "excluded_dates": []}
+//  Custom room types are encoded by their own RoomCodecI, with the same type tags as in 'room_storage_rooms_db.go'
+
+// Encodes the rooms of one type as json
+type RoomCodecI interface {
+	// The type tag stored with the rooms, unique within a storage
+	RoomType() string
+	// Whether the given room is of the type of this codec
+	Handles(room RoomI) bool
+	Encode(room RoomI) (json.RawMessage, error)
+	// The id is given separately, it is the key of the document
+	Decode(roomID string, data json.RawMessage) (RoomI, error)
+}
+
+// The properties shared by all rooms
+type roomDocument struct {
+	ID              string                `json:"id"`
+	AllowedClients  []string              `json:"allowed_clients"`
+	MaxParticipants int                   `json:"max_participants,omitempty"`
+	Roles           map[string]Role       `json:"roles,omitempty"`
+	MessageTypes    []string              `json:"message_types,omitempty"`
+	SendRoles       map[string][]Role     `json:"send_roles,omitempty"`
+	Lobby           bool                  `json:"lobby,omitempty"`
+	UserWindows     map[string]UserWindow `json:"user_windows,omitempty"`
+	History         *HistoryPolicy        `json:"history,omitempty"`
+}
+
+func roomDocumentOf(room Room) roomDocument {
+	allowedClients := make([]string, 0, len(room.allowedClients))
+	for allowedClient := range room.allowedClients {
+		allowedClients = append(allowedClients, allowedClient)
+	}
+	sort.Strings(allowedClients)
+	document := roomDocument{
+		ID:              room.ID,
+		AllowedClients:  allowedClients,
+		MaxParticipants: room.MaxParticipants,
+		Lobby:           room.Lobby,
+		MessageTypes:    room.forwarding.MessageTypes,
+		SendRoles:       room.forwarding.SendRoles,
+	}
+	if len(room.roles) > 0 {
+		document.Roles = room.GetRoles()
+	}
+	if len(room.userWindows) > 0 {
+		document.UserWindows = room.GetUserWindows()
+	}
+	if !room.history.IsZero() {
+		history := room.history.clone()
+		document.History = &history
+	}
+	return document
+}
+
+func (d roomDocument) room(roomID string) Room {
+	room := Room{
+		ID:              roomID,
+		allowedClients:  CreateAllowedIdsMapFromSlice(d.AllowedClients),
+		MaxParticipants: d.MaxParticipants,
+		roles:           createRolesMap(d.Roles),
+		forwarding:      ForwardingPolicy{MessageTypes: d.MessageTypes, SendRoles: d.SendRoles}.clone(),
+		Lobby:           d.Lobby,
+		userWindows:     createUserWindowsMap(d.UserWindows),
+	}
+	if d.History != nil {
+		room.history = d.History.clone()
+	}
+	return room
+}
+
+type temporaryRoomDocument struct {
+	roomDocument
+	ValidFromUnixTime  int64 `json:"valid_from"`
+	ValidUntilUnixTime int64 `json:"valid_until"`
+}
+type repeatingRoomDocument struct {
+	roomDocument
+	FirstTimeUnixTimestamp int64 `json:"first_time"`
+	RepeatEverySeconds     int64 `json:"repeat_every_seconds"`
+	DurationInSeconds      int64 `json:"duration_in_seconds"`
+	UntilUnixTimestamp     int64 `json:"until,omitempty"`
+	MaxOccurrences         int64 `json:"max_occurrences,omitempty"`
+}
+type scheduledRoomDocument struct {
+	roomDocument
+	Schedule Schedule `json:"schedule"`
+}
+
+type builtinRoomCodec struct {
+	roomType string
+	handles  func(room RoomI) bool
+	encode   func(room RoomI) interface{}
+	decode   func(roomID string, data json.RawMessage) (RoomI, error)
+}
+
+func (c builtinRoomCodec) RoomType() string        { return c.roomType }
+func (c builtinRoomCodec) Handles(room RoomI) bool { return c.handles(room) }
+func (c builtinRoomCodec) Encode(room RoomI) (json.RawMessage, error) {
+	return json.Marshal(c.encode(room))
+}
+func (c builtinRoomCodec) Decode(roomID string, data json.RawMessage) (RoomI, error) {
+	room, err := c.decode(roomID, data)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode %v room(%v): %w", c.roomType, roomID, err)
+	}
+	return room, nil
+}
+
+var builtinRoomCodecs = []RoomCodecI{
+	builtinRoomCodec{
+		roomType: RoomTypePermanent,
+		handles:  func(room RoomI) bool { _, ok := room.(PermanentRoom); return ok },
+		encode:   func(room RoomI) interface{} { return roomDocumentOf(room.(PermanentRoom).Room) },
+		decode: func(roomID string, data json.RawMessage) (RoomI, error) {
+			var document roomDocument
+			err := json.Unmarshal(data, &document)
+			return PermanentRoom{Room: document.room(roomID)}, err
+		},
+	},
+	builtinRoomCodec{
+		roomType: RoomTypeTemporary,
+		handles:  func(room RoomI) bool { _, ok := room.(TemporaryRoom); return ok },
+		encode: func(room RoomI) interface{} {
+			r := room.(TemporaryRoom)
+			return temporaryRoomDocument{roomDocumentOf(r.Room), r.ValidFromUnixTime, r.ValidUntilUnixTime}
+		},
+		decode: func(roomID string, data json.RawMessage) (RoomI, error) {
+			var document temporaryRoomDocument
+			err := json.Unmarshal(data, &document)
+			return TemporaryRoom{
+				Room:               document.room(roomID),
+				ValidFromUnixTime:  document.ValidFromUnixTime,
+				ValidUntilUnixTime: document.ValidUntilUnixTime,
+			}, err
+		},
+	},
+	builtinRoomCodec{
+		roomType: RoomTypeRepeating,
+		handles:  func(room RoomI) bool { _, ok := room.(RepeatingRoom); return ok },
+		encode: func(room RoomI) interface{} {
+			r := room.(RepeatingRoom)
+			return repeatingRoomDocument{
+				roomDocumentOf(r.Room),
+				r.FirstTimeUnixTimestamp, r.RepeatEverySeconds, r.DurationInSeconds, r.UntilUnixTimestamp, r.MaxOccurrences,
+			}
+		},
+		decode: func(roomID string, data json.RawMessage) (RoomI, error) {
+			var document repeatingRoomDocument
+			err := json.Unmarshal(data, &document)
+			return RepeatingRoom{
+				Room:                   document.room(roomID),
+				FirstTimeUnixTimestamp: document.FirstTimeUnixTimestamp,
+				RepeatEverySeconds:     document.RepeatEverySeconds,
+				DurationInSeconds:      document.DurationInSeconds,
+				UntilUnixTimestamp:     document.UntilUnixTimestamp,
+				MaxOccurrences:         document.MaxOccurrences,
+			}, err
+		},
+	},
+	builtinRoomCodec{
+		roomType: RoomTypeScheduled,
+		handles:  func(room RoomI) bool { _, ok := room.(ScheduledRoom); return ok },
+		encode: func(room RoomI) interface{} {
+			r := room.(ScheduledRoom)
+			return scheduledRoomDocument{roomDocumentOf(r.Room), r.Schedule}
+		},
+		decode: func(roomID string, data json.RawMessage) (RoomI, error) {
+			var document scheduledRoomDocument
+			err := json.Unmarshal(data, &document) // also parses and validates the schedule
+			return ScheduledRoom{Room: document.room(roomID), Schedule: document.Schedule}, err
+		},
+	},
+}
+
+// Returns the given codecs followed by the built in codecs whose type tag they do not replace
+func withBuiltinRoomCodecs(customCodecs []RoomCodecI) []RoomCodecI {
+	codecs := append([]RoomCodecI{}, customCodecs...)
+	for _, builtin := range builtinRoomCodecs {
+		if roomCodecOfType(customCodecs, builtin.RoomType()) == nil {
+			codecs = append(codecs, builtin)
+		}
+	}
+	return codecs
+}
+
+func roomCodecOfType(codecs []RoomCodecI, roomType string) RoomCodecI {
+	for _, codec := range codecs {
+		if codec.RoomType() == roomType {
+			return codec
+		}
+	}
+	return nil
+}
+
+func roomCodecOf(codecs []RoomCodecI, room RoomI) RoomCodecI {
+	for _, codec := range codecs {
+		if codec.Handles(room) {
+			return codec
+		}
+	}
+	return nil
+}
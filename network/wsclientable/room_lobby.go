@@ -0,0 +1,241 @@
+package wsclientable
+
+import (
+	"github.com/jokrey/utility-algorithms-golang/network/logging"
+	"github.com/jokrey/utility-algorithms-golang/network/synchronization"
+	"sync"
+	"time"
+)
+
+//Idea:
+//  Clients that connect to a room shortly before it opens would otherwise be rejected and have to poll.
+//  Rooms with a lobby (WithLobby) accept those clients into a waiting state instead:
+//     the upgrade succeeds, the client receives
+//       {"type":"room_opens_at", "data":{"room":"<roomID>", "opensAt":<unix seconds>, "serverTime":<unix seconds>}}
+//     and once the room opens, it is admitted into the room automatically and receives
+//       {"type":"room_opened", "data":{"room":"<roomID>"}}
+//     if it cannot be admitted (for example the room is full or was removed), the connection is closed (CloseCodeRoomRejected)
+//  Only clients that are allowed in the room apart from the time are let into the lobby.
+//  Waiting clients are not part of the room: they cannot send or receive room messages and are not counted as participants.
+//  The lobby of a RoomControllers bundle uses a single TimedCallback for the earliest opening of all waiting clients.
+//  The temporary, repeating and scheduled http editors enable the lobby with the url param lobby=true
+
+const (
+	MessageTypeRoomOpensAt = "room_opens_at"
+	MessageTypeRoomOpened  = "room_opened"
+)
+
+// Optionally implemented by rooms that can hold clients in a lobby until they open
+//   All rooms embedding Room implement it
+type LobbyRoomI interface {
+	RoomI
+	// Whether clients arriving before the room opens wait in a lobby instead of being rejected
+	HasLobby() bool
+	// Whether the user is allowed in the room, regardless of time
+	AllowsClient(userID string) bool
+}
+
+// Optionally implemented by rooms that are not always open (TemporaryRoom, RepeatingRoom, ScheduledRoom)
+type OpeningRoomI interface {
+	RoomI
+	// The next time after now at which the room opens, false if it does not open again
+	NextOpeningAfter(now time.Time) (time.Time, bool)
+}
+
+// Returns the next time after now at which the given room opens, false if it does not open again or is always open
+func NextOpeningOf(room RoomI, now time.Time) (time.Time, bool) {
+	if opening, ok := room.(OpeningRoomI); ok {
+		return opening.NextOpeningAfter(now)
+	}
+	return time.Time{}, false
+}
+
+// Returns when the room opens, if the connection is currently not allowed in the room, but may wait for it in its lobby
+//   invited connections (see 'room_invite_tokens.go') may wait as well
+func lobbyOpeningFor(room RoomI, identity Identity, now time.Time) (time.Time, bool) {
+	lobby, ok := room.(LobbyRoomI)
+	if !ok || !lobby.HasLobby() || !room.IsValid() || IsAllowedConnection(room, identity) {
+		return time.Time{}, false
+	}
+	if _, _, invited := invitingRoom(room, identity); !invited && !lobby.AllowsClient(identity.UserID) {
+		return time.Time{}, false
+	}
+	return NextOpeningOf(room, now)
+}
+
+type lobbyEntry struct {
+	connection ClientConnection
+	controller RoomControllerI
+	opensAt    time.Time
+}
+
+type roomLobby struct {
+	mut       *sync.Mutex
+	waiting   map[string]map[string]lobbyEntry // roomID -> userID -> entry
+	admission synchronization.TimedCallback
+	logger    logging.Logger
+	joined    *roomJoinedHandlers
+	events    *roomEventHandlers
+	opened    map[string]time.Time // roomID -> last opening reported as event
+}
+
+func newRoomLobby() *roomLobby {
+	return &roomLobby{
+		mut:       &sync.Mutex{},
+		waiting:   make(map[string]map[string]lobbyEntry),
+		admission: synchronization.NewTimedCallback(),
+		logger:    logging.Nop(),
+		opened:    make(map[string]time.Time),
+	}
+}
+
+// Lets the connection wait until opensAt, then it is added to the room using the given controller
+func (l *roomLobby) wait(controller RoomControllerI, roomID string, connection ClientConnection, opensAt time.Time) error {
+	userID := connection.Identity.UserID
+	l.mut.Lock()
+	inRoom, ok := l.waiting[roomID]
+	if !ok {
+		inRoom = make(map[string]lobbyEntry)
+		l.waiting[roomID] = inRoom
+	}
+	if _, alreadyWaiting := inRoom[userID]; alreadyWaiting {
+		l.mut.Unlock()
+		return RoomRejectionError{RoomID: roomID, Reason: "user(" + userID + ") already waiting in lobby"}
+	}
+	inRoom[userID] = lobbyEntry{connection: connection, controller: controller, opensAt: opensAt}
+	if opensAt.After(time.Now()) {
+		l.admission.CallMeBackIfEarlierThanCurrent(opensAt, l.admitDue)
+	} else {
+		go l.admitDue() // opened in the meantime, a callback in the past would never be called
+	}
+	l.mut.Unlock()
+
+	l.logger.Debug("waiting in lobby", "room", roomID, "user", userID, "opensAt", opensAt)
+	return connection.SendMapTyped(MessageTypeRoomOpensAt, map[string]interface{}{
+		"room": roomID, "opensAt": opensAt.Unix(), "serverTime": time.Now().Unix(),
+	})
+}
+
+// Whether the user currently waits in the lobby of the room
+func (l *roomLobby) isWaiting(roomID, userID string) bool {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	_, ok := l.waiting[roomID][userID]
+	return ok
+}
+
+// Removes the waiting connection, nil if the user did not wait in the lobby of the room
+func (l *roomLobby) remove(roomID, userID string) *ClientConnection {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	entry, ok := l.waiting[roomID][userID]
+	if !ok {
+		return nil
+	}
+	l.removeLocked(roomID, userID)
+	return &entry.connection
+}
+
+// requires the lock
+func (l *roomLobby) removeLocked(roomID, userID string) {
+	delete(l.waiting[roomID], userID)
+	if len(l.waiting[roomID]) == 0 {
+		delete(l.waiting, roomID)
+	}
+}
+
+// Closes all waiting connections
+func (l *roomLobby) close() {
+	l.admission.Stop()
+	l.mut.Lock()
+	var waiting []lobbyEntry
+	for roomID, inRoom := range l.waiting {
+		for _, entry := range inRoom {
+			waiting = append(waiting, entry)
+		}
+		delete(l.waiting, roomID)
+	}
+	l.mut.Unlock()
+
+	for _, entry := range waiting {
+		_ = entry.connection.Close()
+	}
+}
+
+// Admits all connections whose room opened and schedules the callback for the next opening
+func (l *roomLobby) admitDue() {
+	now := time.Now()
+	var due []lobbyEntry
+	l.mut.Lock()
+	var next time.Time
+	for roomID, inRoom := range l.waiting {
+		for userID, entry := range inRoom {
+			if !entry.opensAt.After(now) {
+				due = append(due, entry)
+				l.removeLocked(roomID, userID)
+			} else if next.IsZero() || entry.opensAt.Before(next) {
+				next = entry.opensAt
+			}
+		}
+	}
+	if !next.IsZero() {
+		l.admission.CallMeBackIfEarlierThanCurrent(next, l.admitDue)
+	}
+	var opened []string
+	for _, entry := range due {
+		roomID := entry.connection.Identity.RoomID
+		if l.opened[roomID] != entry.opensAt {
+			l.opened[roomID] = entry.opensAt
+			opened = append(opened, roomID)
+		}
+	}
+	l.mut.Unlock()
+
+	for _, roomID := range opened {
+		l.events.emit(RoomOpenedEvent{RoomID: roomID})
+	}
+	for _, entry := range due {
+		l.admit(entry)
+	}
+}
+
+func (l *roomLobby) admit(entry lobbyEntry) {
+	connection := entry.connection
+	roomID, userID := connection.Identity.RoomID, connection.Identity.UserID
+	if connection.Context().Err() != nil {
+		return // disconnected while being taken from the lobby
+	}
+
+	err := NewConnectionForRoomWithReason(entry.controller, roomID, connection)
+	if err == nil {
+		if connection.Context().Err() != nil { // disconnected while being admitted, the closed handler found nothing to remove
+			entry.controller.ConnectionInRoomClosed(roomID, userID)
+			return
+		}
+		l.logger.Debug("admitted from lobby", "room", roomID, "user", userID)
+		if err := connection.SendMapTyped(MessageTypeRoomOpened, map[string]interface{}{"room": roomID}); err != nil {
+			l.logger.Debug("error sending", "room", roomID, "user", userID, "mType", MessageTypeRoomOpened, "err", err)
+		}
+		if room := entry.controller.GetRoom(roomID); room != nil {
+			if err := sendRoomInfo(connection, roomID, room); err != nil {
+				l.logger.Debug("error sending", "room", roomID, "user", userID, "mType", MessageTypeRoomInfo, "err", err)
+			}
+		}
+		l.joined.notify(roomID, connection)
+		return
+	}
+
+	// the room might have been edited to open later
+	if room := entry.controller.GetRoom(roomID); room != nil {
+		if opensAt, ok := lobbyOpeningFor(room, connection.Identity, time.Now()); ok {
+			if l.wait(entry.controller, roomID, connection, opensAt) == nil {
+				return
+			}
+		}
+	}
+	l.logger.Debug("could not admit from lobby", "room", roomID, "user", userID, "err", err)
+	l.events.emit(UserRejectedEvent{RoomID: roomID, UserID: userID, Err: err})
+	_ = connection.CloseWithReason(CloseCodeRoomRejected, err.Error())
+}
@@ -0,0 +1,136 @@
+package wsclientable
+
+//Idea:
+//  Owners and moderators of a room (see Role) can moderate the room over their websocket connection.
+//  Moderation commands are typed messages:
+//     {"type":"kick", "data":{"user":"<userID>"}}    closes the connection of the user (close code CloseCodeKicked)
+//     {"type":"mute", "data":{"user":"<userID>"}}    messages of the user are no longer forwarded within the room
+//     {"type":"unmute", "data":{"user":"<userID>"}}
+//     {"type":"lock", "data":{}}                      no new participants are accepted (owners and moderators still are)
+//     {"type":"unlock", "data":{}}
+//     {"type":"end_room", "data":{}}                  closes all connections and removes the room
+//  Successful commands are announced to everyone in the room:
+//     {"type":"moderation", "data":{"action":"<command type>", "by":"<moderatorID>", "user":"<userID, if any>"}}
+//  Failed commands are answered with an error message to the sender:
+//     {"type":"error", "data":{"requestType":"<command type>", "reason":"..."}}
+//  Moderators cannot kick or mute owners. Mute and lock are reset when the room is ended or removed.
+
+const (
+	MessageTypeKick       = "kick"
+	MessageTypeMute       = "mute"
+	MessageTypeUnmute     = "unmute"
+	MessageTypeLock       = "lock"
+	MessageTypeUnlock     = "unlock"
+	MessageTypeEndRoom    = "end_room"
+	MessageTypeModeration = "moderation"
+)
+
+// Implemented by all controllers in this package and RoomControllers
+//   rooms of other controllers cannot be locked and their users cannot be muted (those commands are rejected)
+type RoomModerationStateI interface {
+	// see RoomConnectionsMap.SetLocked
+	SetLocked(roomID string, locked bool)
+	// see RoomConnectionsMap.IsLocked
+	IsLocked(roomID string) bool
+	// see RoomConnectionsMap.SetMuted
+	SetMuted(roomID, userID string, muted bool)
+	// see RoomConnectionsMap.IsMuted
+	IsMuted(roomID, userID string) bool
+}
+
+// Whether the room is locked, false if the controller does not implement RoomModerationStateI
+func isRoomLocked(rooms RoomControllerI, roomID string) bool {
+	state, ok := rooms.(RoomModerationStateI)
+	return ok && state.IsLocked(roomID)
+}
+
+// Adds the moderation commands described above.
+//   roomControllers have to be the same controllers given to AddRoomForwardingFunctionality (which initializes and closes them)
+func (s *Server) AddRoomModerationFunctionality(roomControllers RoomControllers) {
+	rooms := &roomControllers
+
+	reject := func(client ClientConnection, mType, reason string) {
+		err := client.SendMapTyped("error", map[string]interface{}{"requestType": mType, "reason": reason})
+		if err != nil {
+			s.logger.Warn("error sending", "connection", client.ID, "mType", "error", "err", err)
+		}
+	}
+	announce := func(roomID string, data map[string]interface{}) {
+		rooms.ForAllIn(roomID, func(connection *ClientConnection) {
+			if err := connection.SendMapTyped(MessageTypeModeration, data); err != nil {
+				s.logger.Debug("error announcing moderation", "room", roomID, "user", connection.ID, "err", err)
+			}
+		})
+	}
+
+	handler := func(mType string, client ClientConnection, data map[string]interface{}) {
+		roomID, moderatorID := client.Identity.RoomID, client.Identity.UserID
+		room := rooms.GetRoom(roomID)
+		if room == nil {
+			reject(client, mType, "room "+roomID+" not found")
+			return
+		}
+		if rooms.IsWaitingInLobby(roomID, moderatorID) {
+			reject(client, mType, "waiting in lobby of room "+roomID)
+			return
+		}
+		moderatorRole := RoleOfConnection(room, client.Identity)
+		if !moderatorRole.CanModerate() {
+			reject(client, mType, "not a moderator of room "+roomID)
+			return
+		}
+
+		state := rooms.moderationStateOf(roomID)
+		if state == nil && mType != MessageTypeKick && mType != MessageTypeEndRoom {
+			reject(client, mType, "room "+roomID+" does not support "+mType)
+			return
+		}
+
+		announcement := map[string]interface{}{"action": mType, "by": moderatorID}
+		switch mType {
+		case MessageTypeKick, MessageTypeMute, MessageTypeUnmute:
+			target, _ := data["user"].(string)
+			if len(target) == 0 {
+				reject(client, mType, "missing field 'user'")
+				return
+			}
+			targetRole := RoleOf(room, target)
+			if peer := rooms.GetConnectionInRoom(roomID, target); peer != nil {
+				targetRole = RoleOfConnection(room, peer.Identity)
+			}
+			if targetRole == RoleOwner && moderatorRole != RoleOwner {
+				reject(client, mType, "cannot moderate owner "+target)
+				return
+			}
+			announcement["user"] = target
+
+			if mType == MessageTypeKick {
+				peer := rooms.GetConnectionInRoom(roomID, target)
+				if peer == nil {
+					reject(client, mType, "Peer "+target+" not found in room "+roomID)
+					return
+				}
+				announce(roomID, announcement)
+				_ = peer.CloseWithReason(CloseCodeKicked, "kicked by "+moderatorID)
+			} else {
+				state.SetMuted(roomID, target, mType == MessageTypeMute)
+				announce(roomID, announcement)
+			}
+		case MessageTypeLock, MessageTypeUnlock:
+			state.SetLocked(roomID, mType == MessageTypeLock)
+			announce(roomID, announcement)
+		case MessageTypeEndRoom:
+			announce(roomID, announcement)
+			if _, err := rooms.CloseAndRemoveRoom(roomID); err != nil {
+				s.logger.Warn("error ending room", "room", roomID, "by", moderatorID, "err", err)
+			}
+		}
+		s.logger.Info("moderation", "room", roomID, "action", mType, "by", moderatorID, "user", announcement["user"])
+	}
+
+	for _, mType := range []string{
+		MessageTypeKick, MessageTypeMute, MessageTypeUnmute, MessageTypeLock, MessageTypeUnlock, MessageTypeEndRoom,
+	} {
+		s.AddMessageHandler(mType, handler)
+	}
+}
@@ -0,0 +1,421 @@
+package wsclientable
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Idea:
+//  A Schedule describes when a ScheduledRoom is open, as wall clock times in an IANA timezone.
+//    Windows are computed in that timezone, so "17:00" stays 17:00 local time across daylight saving changes.
+//  A schedule consists of rules, a window is open if any rule has an open window.
+//    Weekly rules:  "<days> <HH:MM>-<HH:MM>", for example "Mon,Wed 17:00-18:30" or "Mon-Fri 09:00-17:00"
+//                   if the end is not after the start, the window ends on the next day ("Fri 22:00-02:00")
+//    Cron rules:    "<minute> <hour> <day of month> <month> <day of week> <duration>", for example "30 17 * * 1,3 90m"
+//                   the cron fields determine when windows start (supports *, lists, ranges and steps like */15),
+//                   the duration (go syntax, e.g. 90m or 1h30m) how long they stay open.
+//                   As in cron, if both day of month and day of week are restricted, either has to match.
+//  Excluded dates ("2006-01-02", in the schedule's timezone) suppress all windows starting on that date.
+//
+//NOTE: time.LoadLocation requires the timezone database on the host, binaries for hosts without one can import time/tzdata
+
+const scheduleDateLayout = "2006-01-02"
+
+// How far NextWindowAfter searches, rules like "0 0 29 2 *" only match every 4 years
+const scheduleSearchDays = 366*8 + 2
+
+// Time span in which a room is open, Start inclusive and End exclusive (see ScheduledRoom and WindowedRoomI)
+type ScheduleWindow struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Weekly or cron-like rules in a timezone, see NewSchedule. The zero value is never open
+type Schedule struct {
+	Timezone      string   `json:"timezone"`
+	Rules         []string `json:"rules"`
+	ExcludedDates []string `json:"excluded_dates,omitempty"`
+
+	location *time.Location
+	rules    []scheduleRule
+	excluded map[string]bool
+}
+
+type scheduleRule struct {
+	minutes, hours, daysOfMonth, months, weekdays uint64 // bitsets
+	daysOfMonthRestricted, weekdaysRestricted     bool
+
+	duration time.Duration // cron rules, absolute
+	// weekly rules end at a wall clock time, so that the window length follows daylight saving changes
+	wallClockEnd       bool
+	endHour, endMinute int
+	endsOnFollowingDay bool
+}
+
+// Parses the rules and excluded dates in the given IANA timezone ("" is UTC)
+func NewSchedule(timezone string, rules []string, excludedDates []string) (Schedule, error) {
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return Schedule{}, fmt.Errorf("invalid timezone %q: %w", timezone, err)
+	}
+	if len(rules) == 0 {
+		return Schedule{}, fmt.Errorf("schedule requires at least one rule")
+	}
+	s := Schedule{
+		Timezone:      timezone,
+		Rules:         append([]string(nil), rules...),
+		ExcludedDates: append([]string(nil), excludedDates...),
+		location:      location,
+		excluded:      make(map[string]bool, len(excludedDates)),
+	}
+	for _, raw := range rules {
+		rule, err := parseScheduleRule(raw)
+		if err != nil {
+			return Schedule{}, fmt.Errorf("invalid schedule rule %q: %w", raw, err)
+		}
+		s.rules = append(s.rules, rule)
+	}
+	for _, raw := range excludedDates {
+		date, err := time.Parse(scheduleDateLayout, raw)
+		if err != nil {
+			return Schedule{}, fmt.Errorf("invalid excluded date %q (expected %v)", raw, scheduleDateLayout)
+		}
+		s.excluded[date.Format(scheduleDateLayout)] = true
+	}
+	return s, nil
+}
+
+// Parses the rules from a json array and the excluded dates from an optional json array, see NewSchedule
+func ParseSchedule(timezone, rulesJSON, excludedDatesJSON string) (Schedule, error) {
+	var rules, excludedDates []string
+	if err := json.Unmarshal([]byte(rulesJSON), &rules); err != nil {
+		return Schedule{}, fmt.Errorf("rules are not a json array of strings: %w", err)
+	}
+	if len(excludedDatesJSON) > 0 {
+		if err := json.Unmarshal([]byte(excludedDatesJSON), &excludedDates); err != nil {
+			return Schedule{}, fmt.Errorf("excluded dates are not a json array of strings: %w", err)
+		}
+	}
+	return NewSchedule(timezone, rules, excludedDates)
+}
+
+// Re-parses the schedule, so that a decoded schedule is usable
+func (s *Schedule) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Timezone      string   `json:"timezone"`
+		Rules         []string `json:"rules"`
+		ExcludedDates []string `json:"excluded_dates"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	parsed, err := NewSchedule(raw.Timezone, raw.Rules, raw.ExcludedDates)
+	if err != nil {
+		return err
+	}
+	*s = parsed
+	return nil
+}
+
+// The timezone of the schedule, UTC for the zero value
+func (s Schedule) Location() *time.Location {
+	if s.location == nil {
+		return time.UTC
+	}
+	return s.location
+}
+
+// Whether a window is open at the given time
+func (s Schedule) IsOpenAt(t time.Time) bool {
+	_, open := s.windowAt(t)
+	return open
+}
+
+// The end of the open period that contains t, overlapping and adjacent windows are joined
+//   returns false if no window is open at t
+func (s Schedule) OpenUntil(t time.Time) (time.Time, bool) {
+	window, open := s.windowAt(t)
+	if !open {
+		return time.Time{}, false
+	}
+	end := window.End
+	for i := 0; i < 366; i++ { // bounded, always open schedules would otherwise never end
+		next, open := s.windowAt(end)
+		if !open || !next.End.After(end) {
+			break
+		}
+		end = next.End
+	}
+	return end, true
+}
+
+// The next window starting after t, false if there is none within the next years
+func (s Schedule) NextWindowAfter(t time.Time) (ScheduleWindow, bool) {
+	local := t.In(s.Location())
+	for day := 0; day < scheduleSearchDays; day++ {
+		date := time.Date(local.Year(), local.Month(), local.Day()+day, 0, 0, 0, 0, local.Location())
+		if !s.matchesMonth(date.Month()) { // skip the rest of the month
+			day += time.Date(date.Year(), date.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day() - date.Day()
+			continue
+		}
+		var next ScheduleWindow
+		found := false
+		s.forWindowsStartingOn(date.Year(), date.Month(), date.Day(), func(w ScheduleWindow) {
+			if w.Start.After(t) && (!found || w.Start.Before(next.Start)) {
+				next, found = w, true
+			}
+		})
+		if found {
+			return next, true
+		}
+	}
+	return ScheduleWindow{}, false
+}
+
+// Whether any rule has windows starting in the given month
+func (s Schedule) matchesMonth(month time.Month) bool {
+	for _, rule := range s.rules {
+		if rule.months&(1<<uint(month)) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// The window open at t that ends last
+func (s Schedule) windowAt(t time.Time) (ScheduleWindow, bool) {
+	local := t.In(s.Location())
+	lookBackDays := 1
+	for _, rule := range s.rules {
+		if days := int(rule.duration/(24*time.Hour)) + 1; days > lookBackDays {
+			lookBackDays = days
+		}
+	}
+
+	var open ScheduleWindow
+	found := false
+	for day := -lookBackDays; day <= 0; day++ {
+		s.forWindowsStartingOn(local.Year(), local.Month(), local.Day()+day, func(w ScheduleWindow) {
+			if !w.Start.After(t) && t.Before(w.End) && (!found || w.End.After(open.End)) {
+				open, found = w, true
+			}
+		})
+	}
+	return open, found
+}
+
+// day may be out of range, it is normalized like time.Date does
+func (s Schedule) forWindowsStartingOn(year int, month time.Month, day int, f func(ScheduleWindow)) {
+	location := s.Location()
+	date := time.Date(year, month, day, 0, 0, 0, 0, location)
+	if s.excluded[date.Format(scheduleDateLayout)] {
+		return
+	}
+	for _, rule := range s.rules {
+		if !rule.matchesDate(date) {
+			continue
+		}
+		forEachBit(rule.hours, func(hour int) {
+			forEachBit(rule.minutes, func(minute int) {
+				start := time.Date(date.Year(), date.Month(), date.Day(), hour, minute, 0, 0, location)
+				f(ScheduleWindow{Start: start, End: rule.endOf(start)})
+			})
+		})
+	}
+}
+
+func (r scheduleRule) matchesDate(date time.Time) bool {
+	if r.months&(1<<uint(date.Month())) == 0 {
+		return false
+	}
+	dayOfMonthOk := r.daysOfMonth&(1<<uint(date.Day())) != 0
+	weekdayOk := r.weekdays&(1<<uint(date.Weekday())) != 0
+	if r.daysOfMonthRestricted && r.weekdaysRestricted {
+		return dayOfMonthOk || weekdayOk
+	}
+	return dayOfMonthOk && weekdayOk
+}
+
+// Days per month in a leap year, so that the 29th of February is possible
+var scheduleDaysInMonth = [13]int{0, 31, 29, 31, 30, 31, 30, 31, 31, 30, 31, 30, 31}
+
+// Whether any of the days of month exists in any of the months, "31 2" would never match
+func (r scheduleRule) hasDayOfMonthInMonths() bool {
+	for month := 1; month <= 12; month++ {
+		if r.months&(1<<uint(month)) != 0 && r.daysOfMonth&allBits(1, scheduleDaysInMonth[month]) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (r scheduleRule) endOf(start time.Time) time.Time {
+	if !r.wallClockEnd {
+		return start.Add(r.duration)
+	}
+	day := start.Day()
+	if r.endsOnFollowingDay {
+		day++
+	}
+	return time.Date(start.Year(), start.Month(), day, r.endHour, r.endMinute, 0, 0, start.Location())
+}
+
+func parseScheduleRule(raw string) (scheduleRule, error) {
+	fields := strings.Fields(raw)
+	switch len(fields) {
+	case 2:
+		return parseWeeklyRule(fields[0], fields[1])
+	case 6:
+		return parseCronRule(fields)
+	default:
+		return scheduleRule{}, fmt.Errorf("expected a weekly rule (\"Mon,Wed 17:00-18:30\") or 5 cron fields and a duration")
+	}
+}
+
+var scheduleWeekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func parseWeeklyRule(days, span string) (scheduleRule, error) {
+	rule := scheduleRule{
+		daysOfMonth: allBits(1, 31), months: allBits(1, 12),
+		weekdaysRestricted: true, wallClockEnd: true,
+	}
+	for _, part := range strings.Split(days, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		from, ok := scheduleWeekdayNames[strings.ToLower(bounds[0])]
+		if !ok {
+			return scheduleRule{}, fmt.Errorf("unknown weekday %q", bounds[0])
+		}
+		to := from
+		if len(bounds) == 2 {
+			if to, ok = scheduleWeekdayNames[strings.ToLower(bounds[1])]; !ok {
+				return scheduleRule{}, fmt.Errorf("unknown weekday %q", bounds[1])
+			}
+		}
+		for d := from; ; d = (d + 1) % 7 { // Fri-Mon wraps around the week
+			rule.weekdays |= 1 << uint(d)
+			if d == to {
+				break
+			}
+		}
+	}
+
+	times := strings.SplitN(span, "-", 2)
+	if len(times) != 2 {
+		return scheduleRule{}, fmt.Errorf("expected <HH:MM>-<HH:MM>, got %q", span)
+	}
+	startHour, startMinute, err := parseClock(times[0])
+	if err != nil {
+		return scheduleRule{}, err
+	}
+	rule.endHour, rule.endMinute, err = parseClock(times[1])
+	if err != nil {
+		return scheduleRule{}, err
+	}
+	rule.hours = 1 << uint(startHour)
+	rule.minutes = 1 << uint(startMinute)
+	rule.endsOnFollowingDay = rule.endHour*60+rule.endMinute <= startHour*60+startMinute
+	rule.duration = 24 * time.Hour // upper bound, used to look back for open windows
+	return rule, nil
+}
+
+func parseClock(raw string) (int, int, error) {
+	clock, err := time.Parse("15:04", raw)
+	if err != nil {
+		return 0, 0, fmt.Errorf("expected time as HH:MM, got %q", raw)
+	}
+	return clock.Hour(), clock.Minute(), nil
+}
+
+func parseCronRule(fields []string) (scheduleRule, error) {
+	var rule scheduleRule
+	var err error
+	if rule.minutes, _, err = parseCronField(fields[0], 0, 59); err != nil {
+		return scheduleRule{}, fmt.Errorf("minute: %w", err)
+	}
+	if rule.hours, _, err = parseCronField(fields[1], 0, 23); err != nil {
+		return scheduleRule{}, fmt.Errorf("hour: %w", err)
+	}
+	if rule.daysOfMonth, rule.daysOfMonthRestricted, err = parseCronField(fields[2], 1, 31); err != nil {
+		return scheduleRule{}, fmt.Errorf("day of month: %w", err)
+	}
+	if rule.months, _, err = parseCronField(fields[3], 1, 12); err != nil {
+		return scheduleRule{}, fmt.Errorf("month: %w", err)
+	}
+	if rule.weekdays, rule.weekdaysRestricted, err = parseCronField(fields[4], 0, 7); err != nil {
+		return scheduleRule{}, fmt.Errorf("day of week: %w", err)
+	}
+	if rule.weekdays&(1<<7) != 0 { // 7 is sunday as well
+		rule.weekdays = rule.weekdays&^(1<<7) | 1
+	}
+	if rule.daysOfMonthRestricted && !rule.weekdaysRestricted && !rule.hasDayOfMonthInMonths() {
+		return scheduleRule{}, fmt.Errorf("day of month: %q never occurs in month %q", fields[2], fields[3])
+	}
+	if rule.duration, err = time.ParseDuration(fields[5]); err != nil || rule.duration <= 0 {
+		return scheduleRule{}, fmt.Errorf("expected a positive duration, got %q", fields[5])
+	}
+	return rule, nil
+}
+
+// Parses *, n, a-b, lists and steps (*/n, a-b/n) into a bitset, restricted is false for *
+func parseCronField(raw string, min, max int) (uint64, bool, error) {
+	var bits uint64
+	restricted := raw != "*"
+	for _, part := range strings.Split(raw, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s <= 0 {
+				return 0, false, fmt.Errorf("invalid step in %q", part)
+			}
+			step = s
+			part = part[:i]
+		}
+		from, to := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if from, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, false, fmt.Errorf("invalid value %q", bounds[0])
+			}
+			to = from
+			if len(bounds) == 2 {
+				if to, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, false, fmt.Errorf("invalid value %q", bounds[1])
+				}
+			} else if step > 1 {
+				to = max // like cron: "5/15" means 5-max/15
+			}
+		}
+		if from < min || to > max || from > to {
+			return 0, false, fmt.Errorf("%q not within %v-%v", part, min, max)
+		}
+		for v := from; v <= to; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, restricted, nil
+}
+
+// Calls f with each set bit in ascending order, skipping the unset ones
+func forEachBit(set uint64, f func(bit int)) {
+	for set != 0 {
+		bit := bits.TrailingZeros64(set)
+		f(bit)
+		set &^= 1 << uint(bit)
+	}
+}
+
+func allBits(from, to int) uint64 {
+	var bits uint64
+	for v := from; v <= to; v++ {
+		bits |= 1 << uint(v)
+	}
+	return bits
+}
@@ -0,0 +1,252 @@
+package wsclientable
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+//Idea:
+//  Apps in rooms often need a small shared key-value state (current slide, whiteboard mode, who is presenting).
+//  Instead of every client re-broadcasting it peer-to-peer, the server keeps the state of each room.
+//  Clients change it with typed messages:
+//     {"type":"state_set", "data":{"key":"slide", "value":<any json>}}
+//     {"type":"state_delete", "data":{"key":"slide"}}
+//  On join (also when admitted from the lobby), the connection receives the full state:
+//     {"type":"state_snapshot", "data":{"room":"<roomID>", "version":8, "entries":{"slide":{"value":3, "version":8}}}}
+//  Every change is broadcast to everyone in the room, including the sender:
+//     {"type":"state_changed", "data":{"room":"<roomID>", "key":"slide", "value":3, "version":9, "by":"<userID>"}}
+//     deletions carry "deleted":true and no value
+//  Versions:
+//     every room has a counter that is incremented with each change, the version of a key is the counter at its last change
+//     set and delete may carry "version":<n>, then they are only applied if the key currently has that version
+//       (0 means the key must not exist yet), otherwise they are rejected with an error that carries the "currentVersion"
+//     changes are broadcast in version order, so clients can drop diffs older than their snapshot
+//  Failed requests are answered with {"type":"error", "data":{"requestType":"state_set", "reason":"..."}}
+//  The state of a room is deleted when its controller removes the room (see 'room_hooks.go').
+//  It is kept in memory (NewRamRoomStateStorage) or persisted in bolt (see 'room_storage_state_db.go').
+
+const (
+	MessageTypeStateSet      = "state_set"
+	MessageTypeStateDelete   = "state_delete"
+	MessageTypeStateSnapshot = "state_snapshot"
+	MessageTypeStateChanged  = "state_changed"
+)
+
+// Maximum size of the json encoded value of a single state key
+const MaxRoomStateValueSize = 64 * 1024
+
+type RoomStateEntry struct {
+	Value   json.RawMessage `json:"value"`
+	Version uint64          `json:"version"`
+}
+
+type RoomStateSnapshot struct {
+	// The counter of the room, 0 if the state of the room was never changed
+	Version uint64                    `json:"version"`
+	Entries map[string]RoomStateEntry `json:"entries"`
+}
+
+// Stores the shared state of rooms
+type RoomStateStorageI interface {
+	// Returns the full state of the room (empty if it has none)
+	Snapshot(roomID string) (RoomStateSnapshot, error)
+	// Sets the key and returns its new version
+	//   if expectedVersion is not nil, the key must currently have it (0 = must not exist), RoomStateVersionConflictError otherwise
+	Set(roomID, key string, value json.RawMessage, expectedVersion *uint64) (uint64, error)
+	// Deletes the key and returns the new version of the room, false if the key did not exist (nothing changed then)
+	//   expectedVersion as in Set
+	Delete(roomID, key string, expectedVersion *uint64) (uint64, bool, error)
+	// Deletes the complete state of the room
+	RemoveRoom(roomID string) error
+
+	// Closes underlying resources
+	Close() error
+}
+
+// Adds the shared room state described above.
+//   roomControllers have to be the same controllers given to AddRoomForwardingFunctionality (which initializes and closes them)
+//   the storage is closed with the server
+func (s *Server) AddRoomStateFunctionality(roomControllers RoomControllers, storage RoomStateStorageI) {
+	rooms := &roomControllers
+	// changes and snapshots are serialized, so that every member sees the changes in version order
+	mut := &sync.Mutex{}
+
+	reject := func(client ClientConnection, mType, reason string, currentVersion *uint64) {
+		data := map[string]interface{}{"requestType": mType, "reason": reason}
+		if currentVersion != nil {
+			data["currentVersion"] = *currentVersion
+		}
+		if err := client.SendMapTyped("error", data); err != nil {
+			s.logger.Warn("error sending", "connection", client.ID, "mType", "error", "err", err)
+		}
+	}
+
+	rooms.AddRoomJoinedHandler(func(roomID string, connection ClientConnection) {
+		mut.Lock()
+		defer mut.Unlock()
+		snapshot, err := storage.Snapshot(roomID)
+		if err != nil {
+			s.logger.Error("could not read room state", "room", roomID, "err", err)
+			return
+		}
+		err = connection.SendMapTyped(MessageTypeStateSnapshot, map[string]interface{}{
+			"room": roomID, "version": snapshot.Version, "entries": snapshot.Entries,
+		})
+		if err != nil {
+			s.logger.Debug("error sending", "room", roomID, "user", connection.Identity.UserID, "mType", MessageTypeStateSnapshot, "err", err)
+		}
+	})
+	rooms.AddRoomRemovedHandler(func(roomID string) {
+		mut.Lock()
+		defer mut.Unlock()
+		if err := storage.RemoveRoom(roomID); err != nil {
+			s.logger.Error("could not remove room state", "room", roomID, "err", err)
+		}
+	})
+	s.AddServerClosedHandler(func() {
+		_ = storage.Close()
+	})
+
+	handler := func(mType string, client ClientConnection, data map[string]interface{}) {
+		roomID, userID := client.Identity.RoomID, client.Identity.UserID
+		if rooms.GetRoom(roomID) == nil || !rooms.IsConnected(roomID, userID) {
+			reject(client, mType, "not in room "+roomID, nil)
+			return
+		}
+		if rooms.IsWaitingInLobby(roomID, userID) {
+			reject(client, mType, "waiting in lobby of room "+roomID, nil)
+			return
+		}
+		key, _ := data["key"].(string)
+		if err := ValidateID("state key", key); err != nil {
+			reject(client, mType, err.Error(), nil)
+			return
+		}
+		var expectedVersion *uint64
+		if raw, ok := data["version"]; ok {
+			number, isNumber := raw.(float64)
+			if !isNumber || number < 0 || number != float64(uint64(number)) {
+				reject(client, mType, "field 'version' is not a version number", nil)
+				return
+			}
+			version := uint64(number)
+			expectedVersion = &version
+		}
+
+		changed := map[string]interface{}{"room": roomID, "key": key, "by": userID}
+		mut.Lock()
+		defer mut.Unlock()
+		var err error
+		var version uint64
+		if mType == MessageTypeStateSet {
+			value, e := json.Marshal(data["value"])
+			if e != nil || len(value) > MaxRoomStateValueSize {
+				reject(client, mType, fmt.Sprintf("field 'value' missing or larger than %v bytes", MaxRoomStateValueSize), nil)
+				return
+			}
+			version, err = storage.Set(roomID, key, value, expectedVersion)
+			changed["value"] = json.RawMessage(value)
+		} else {
+			var existed bool
+			version, existed, err = storage.Delete(roomID, key, expectedVersion)
+			if err == nil && !existed {
+				reject(client, mType, "state key "+key+" not found", nil)
+				return
+			}
+			changed["deleted"] = true
+		}
+		if conflict, ok := err.(RoomStateVersionConflictError); ok {
+			reject(client, mType, conflict.Error(), &conflict.CurrentVersion)
+			return
+		}
+		if err != nil {
+			s.logger.Error("could not change room state", "room", roomID, "key", key, "mType", mType, "err", err)
+			reject(client, mType, "could not change state", nil)
+			return
+		}
+		changed["version"] = version
+
+		rooms.ForAllIn(roomID, func(connection *ClientConnection) {
+			if err := connection.SendMapTyped(MessageTypeStateChanged, changed); err != nil {
+				s.logger.Debug("error sending", "room", roomID, "user", connection.Identity.UserID, "mType", MessageTypeStateChanged, "err", err)
+			}
+		})
+	}
+	s.AddMessageHandler(MessageTypeStateSet, handler)
+	s.AddMessageHandler(MessageTypeStateDelete, handler)
+}
+
+// RoomStateStorageI held in memory, lost when the program exits
+type RamRoomStateStorage struct {
+	mut    *sync.Mutex
+	states map[string]*RoomStateSnapshot
+}
+
+func NewRamRoomStateStorage() RamRoomStateStorage {
+	return RamRoomStateStorage{mut: &sync.Mutex{}, states: make(map[string]*RoomStateSnapshot)}
+}
+
+func (r RamRoomStateStorage) Snapshot(roomID string) (RoomStateSnapshot, error) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	snapshot := RoomStateSnapshot{Entries: make(map[string]RoomStateEntry)}
+	if state, ok := r.states[roomID]; ok {
+		snapshot.Version = state.Version
+		for key, entry := range state.Entries {
+			snapshot.Entries[key] = entry
+		}
+	}
+	return snapshot, nil
+}
+
+func (r RamRoomStateStorage) Set(roomID, key string, value json.RawMessage, expectedVersion *uint64) (uint64, error) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	state, ok := r.states[roomID]
+	if !ok {
+		state = &RoomStateSnapshot{Entries: make(map[string]RoomStateEntry)}
+	}
+	if err := checkRoomStateVersion(roomID, key, state.Entries[key].Version, expectedVersion); err != nil {
+		return 0, err
+	}
+	state.Version++
+	state.Entries[key] = RoomStateEntry{Value: append(json.RawMessage{}, value...), Version: state.Version}
+	r.states[roomID] = state
+	return state.Version, nil
+}
+
+func (r RamRoomStateStorage) Delete(roomID, key string, expectedVersion *uint64) (uint64, bool, error) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	state, ok := r.states[roomID]
+	if !ok {
+		return 0, false, checkRoomStateVersion(roomID, key, 0, expectedVersion)
+	}
+	entry, exists := state.Entries[key]
+	if err := checkRoomStateVersion(roomID, key, entry.Version, expectedVersion); err != nil || !exists {
+		return state.Version, false, err
+	}
+	state.Version++
+	delete(state.Entries, key)
+	return state.Version, true, nil
+}
+
+func (r RamRoomStateStorage) RemoveRoom(roomID string) error {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	delete(r.states, roomID)
+	return nil
+}
+
+func (r RamRoomStateStorage) Close() error {
+	return nil
+}
+
+// currentVersion is 0 if the key does not exist
+func checkRoomStateVersion(roomID, key string, currentVersion uint64, expectedVersion *uint64) error {
+	if expectedVersion != nil && *expectedVersion != currentVersion {
+		return RoomStateVersionConflictError{RoomID: roomID, Key: key, ExpectedVersion: *expectedVersion, CurrentVersion: currentVersion}
+	}
+	return nil
+}
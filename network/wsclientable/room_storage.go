@@ -1,7 +1,10 @@
 package wsclientable
 
 import (
-	"log"
+	"fmt"
+	"github.com/jokrey/utility-algorithms-golang/network/logging"
+	"math"
+	"sync"
 	"time"
 )
 
@@ -18,12 +21,157 @@ type RoomI interface {
 	IsValid() bool
 }
 
+// Optionally implemented by rooms that limit the number of simultaneously connected clients
+//   All rooms embedding Room implement it
+type CapacityLimitedRoomI interface {
+	RoomI
+	// Maximum number of simultaneous connections in the room, 0 means unlimited
+	GetMaxParticipants() int
+}
+
+// Returns the maximum number of participants of the given room, 0 (unlimited) if the room does not limit it
+func MaxParticipantsOf(room RoomI) int {
+	if limited, ok := room.(CapacityLimitedRoomI); ok {
+		return limited.GetMaxParticipants()
+	}
+	return 0
+}
+
+// Role of a user within a room, determines which moderation commands the user may send
+//   Apart from the predefined roles, rooms can use custom roles (for example "host") in their ForwardingPolicy
+type Role string
+
+const (
+	RoleOwner       Role = "owner"
+	RoleModerator   Role = "moderator"
+	RoleParticipant Role = "participant"
+)
+
+// Whether the role may moderate (kick, mute, lock, end) - owners and moderators
+func (r Role) CanModerate() bool {
+	return r == RoleOwner || r == RoleModerator
+}
+
+// Parses owner, moderator, participant or a custom role (non empty, at most MaxIDLength bytes, no control characters)
+func ParseRole(raw string) (Role, error) {
+	if err := ValidateID("role", raw); err != nil {
+		return RoleParticipant, fmt.Errorf("invalid role %q: %w", raw, err)
+	}
+	return Role(raw), nil
+}
+
+// Optionally implemented by rooms that assign roles to users
+//   All rooms embedding Room implement it
+type RoleAwareRoomI interface {
+	RoomI
+	// The role of the given user, RoleParticipant if none was assigned
+	GetRole(userID string) Role
+}
+
+// Returns the role of the user in the given room, RoleParticipant if the room does not assign roles
+func RoleOf(room RoomI, userID string) Role {
+	if aware, ok := room.(RoleAwareRoomI); ok {
+		return aware.GetRole(userID)
+	}
+	return RoleParticipant
+}
+
 // Room base struct.
 // Stores its own roomID(ID)
 // Stores all allowedClients, if the list is empty ALL connectionIDs are allowed
+//   entries can also be groups, patterns or deny entries (see 'room_allow_list.go')
+// Stores the maximum number of participants, if 0 the number is unlimited
+// Stores the roles of users, users without a role are participants.
+//   Roles do not grant access, if allowedClients is not empty the role holders have to be in it
+// Stores whether clients arriving before the room opens wait in a lobby (see 'room_lobby.go')
+// Stores the personal windows of users, which also allow them in the room (see 'room_user_access.go')
+// Stores which relayed message types are kept in the history of the room (see 'room_history.go')
 type Room struct {
-	ID             string
-	allowedClients map[string]bool // always true, just used because somehow go does not support search in slice - IMMUTABLE
+	ID              string
+	allowedClients  map[string]bool // always true, just used because somehow go does not support search in slice - IMMUTABLE
+	MaxParticipants int
+	roles           map[string]Role // IMMUTABLE, participants are not stored
+	forwarding      ForwardingPolicy
+	Lobby           bool
+	userWindows     map[string]UserWindow // IMMUTABLE
+	history         HistoryPolicy
+	groups          UserGroupsI // resolves group entries of allowedClients, set by the controller returning the room
+}
+
+func (r Room) HasLobby() bool {
+	return r.Lobby
+}
+func (r Room) AllowsClient(userID string) bool {
+	allowed, denied := matchAllowList(r.allowedClients, r.groups, userID)
+	if denied {
+		return false
+	}
+	if !allowed {
+		_, allowed = r.userWindows[userID]
+	}
+	return allowed
+}
+
+// Whether the user is rejected by a deny entry of the allowed clients (see 'room_allow_list.go')
+func (r Room) Denies(userID string) bool {
+	_, denied := matchAllowList(r.allowedClients, r.groups, userID)
+	return denied
+}
+
+// Whether the user is allowed at the given unix time, apart from the window of the room itself
+func (r Room) allowsClientAt(userID string, unixTime int64) bool {
+	if !r.AllowsClient(userID) {
+		return false
+	}
+	if window, ok := r.userWindows[userID]; ok {
+		return window.Contains(unixTime)
+	}
+	return true
+}
+
+func (r Room) GetUserWindow(userID string) (UserWindow, bool) {
+	window, ok := r.userWindows[userID]
+	return window, ok
+}
+
+// Returns a copy of the personal windows (userID -> window)
+func (r Room) GetUserWindows() map[string]UserWindow {
+	return createUserWindowsMap(r.userWindows)
+}
+
+func (r Room) GetMaxParticipants() int {
+	return r.MaxParticipants
+}
+func (r Room) GetForwardingPolicy() ForwardingPolicy {
+	return r.forwarding
+}
+func (r Room) GetHistoryPolicy() HistoryPolicy {
+	return r.history
+}
+func (r Room) GetRole(userID string) Role {
+	if role, ok := r.roles[userID]; ok {
+		return role
+	}
+	return RoleParticipant
+}
+
+// Returns a copy of the assigned roles (userID -> role)
+func (r Room) GetRoles() map[string]Role {
+	roles := make(map[string]Role, len(r.roles))
+	for userID, role := range r.roles {
+		roles[userID] = role
+	}
+	return roles
+}
+
+func createRolesMap(roles map[string]Role) map[string]Role {
+	copied := make(map[string]Role, len(roles))
+	for userID, role := range roles {
+		if role != RoleParticipant {
+			copied[userID] = role
+		}
+	}
+	return copied
 }
 
 // A PermanentRoom is a Room, that will always return true for RoomI.IsValid
@@ -48,17 +196,45 @@ func (r PermanentRoom) GetID() string {
 	return r.ID
 }
 func (r PermanentRoom) IsAllowed(userID string) bool {
-	if len(r.allowedClients) == 0 {
-		return true
-	}
-
-	_, ok := r.allowedClients[userID]
-	return ok
+	return r.allowsClientAt(userID, time.Now().Unix())
+}
+func (r PermanentRoom) IsOpen() bool {
+	return true
 }
 func (r PermanentRoom) IsValid() bool {
 	return true
 }
 
+// Returns a copy of the room that allows at most maxParticipants simultaneous connections (0 = unlimited)
+func (r PermanentRoom) WithMaxParticipants(maxParticipants int) PermanentRoom {
+	r.MaxParticipants = maxParticipants
+	return r
+}
+
+// Returns a copy of the room with the given roles (userID -> role)
+func (r PermanentRoom) WithRoles(roles map[string]Role) PermanentRoom {
+	r.roles = createRolesMap(roles)
+	return r
+}
+
+// Returns a copy of the room that relays messages according to the given policy
+func (r PermanentRoom) WithForwardingPolicy(policy ForwardingPolicy) PermanentRoom {
+	r.forwarding = policy.clone()
+	return r
+}
+
+// Returns a copy of the room with the given personal windows (userID -> window)
+func (r PermanentRoom) WithUserWindows(windows map[string]UserWindow) PermanentRoom {
+	r.userWindows = createUserWindowsMap(windows)
+	return r
+}
+
+// Returns a copy of the room that keeps relayed messages in its history according to the given policy
+func (r PermanentRoom) WithHistoryPolicy(policy HistoryPolicy) PermanentRoom {
+	r.history = policy.clone()
+	return r
+}
+
 // A temporary room is a room, but only allows clients in the specified time frame
 //   There is NO functionality to close a room when the time is up
 //   For that the RoomStorageI.CleanExpired method has to be called - and connections closed via the callback
@@ -74,14 +250,11 @@ func (r TemporaryRoom) GetID() string {
 	return r.ID
 }
 func (r TemporaryRoom) IsAllowed(userID string) bool {
+	return r.IsOpen() && r.allowsClientAt(userID, time.Now().Unix())
+}
+func (r TemporaryRoom) IsOpen() bool {
 	currentUnixTime := time.Now().Unix()
-	timeSlotOk := currentUnixTime >= r.ValidFromUnixTime && currentUnixTime <= r.ValidUntilUnixTime
-	if len(r.allowedClients) == 0 {
-		return timeSlotOk
-	}
-
-	_, ok := r.allowedClients[userID]
-	return ok && timeSlotOk
+	return currentUnixTime >= r.ValidFromUnixTime && currentUnixTime <= r.ValidUntilUnixTime
 }
 func (r TemporaryRoom) IsValid() bool {
 	currentUnixTime := time.Now().Unix()
@@ -89,50 +262,161 @@ func (r TemporaryRoom) IsValid() bool {
 }
 func NewTemporaryRoom(ID string, allowedClientIds []string, validFromUnixTime, validUntilUnixTime int64) TemporaryRoom {
 	return TemporaryRoom{
-		Room{ID, CreateAllowedIdsMapFromSlice(allowedClientIds)},
+		Room{ID: ID, allowedClients: CreateAllowedIdsMapFromSlice(allowedClientIds)},
 		validFromUnixTime,
 		validUntilUnixTime,
 	}
 }
 
+// The start of the time frame, if it has not started yet
+func (r TemporaryRoom) NextOpeningAfter(now time.Time) (time.Time, bool) {
+	if now.Unix() >= r.ValidFromUnixTime {
+		return time.Time{}, false
+	}
+	return time.Unix(r.ValidFromUnixTime, 0), true
+}
+
+// The time frame of the room, if it is open at now
+func (r TemporaryRoom) CurrentWindow(now time.Time) (ScheduleWindow, bool) {
+	nowUnix := now.Unix()
+	if nowUnix < r.ValidFromUnixTime || nowUnix > r.ValidUntilUnixTime {
+		return ScheduleWindow{}, false
+	}
+	return ScheduleWindow{Start: time.Unix(r.ValidFromUnixTime, 0), End: time.Unix(r.ValidUntilUnixTime, 0)}, true
+}
+
+// Returns a copy of the room in which clients arriving before it opens wait in a lobby
+func (r TemporaryRoom) WithLobby(lobby bool) TemporaryRoom {
+	r.Lobby = lobby
+	return r
+}
+
+// Returns a copy of the room that allows at most maxParticipants simultaneous connections (0 = unlimited)
+func (r TemporaryRoom) WithMaxParticipants(maxParticipants int) TemporaryRoom {
+	r.MaxParticipants = maxParticipants
+	return r
+}
+
+// Returns a copy of the room with the given roles (userID -> role)
+func (r TemporaryRoom) WithRoles(roles map[string]Role) TemporaryRoom {
+	r.roles = createRolesMap(roles)
+	return r
+}
+
+// Returns a copy of the room that relays messages according to the given policy
+func (r TemporaryRoom) WithForwardingPolicy(policy ForwardingPolicy) TemporaryRoom {
+	r.forwarding = policy.clone()
+	return r
+}
+
+// Returns a copy of the room with the given personal windows (userID -> window)
+func (r TemporaryRoom) WithUserWindows(windows map[string]UserWindow) TemporaryRoom {
+	r.userWindows = createUserWindowsMap(windows)
+	return r
+}
+
+// Returns a copy of the room that keeps relayed messages in its history according to the given policy
+func (r TemporaryRoom) WithHistoryPolicy(policy HistoryPolicy) TemporaryRoom {
+	r.history = policy.clone()
+	return r
+}
+
 // A repeating room is a room, but only allows clients in the specified time frame
 //   There is NO functionality to close a room when the time is up
 //   For that the RoomStorageI.CleanExpired method has to be called - and connections closed via the callback
 //   That is left to the controller, because naturally that is the only class with enough information to do so
 //!  However it is very desirable that the RoomStorageI.CleanExpired method has an efficient implementation that scales
+//  A repeating room can be bounded by an end date (UntilUnixTimestamp) and/or a number of occurrences (MaxOccurrences)
+//    once the bound is reached, the room becomes invalid (0 means unbounded for both)
 type RepeatingRoom struct {
 	Room
 	FirstTimeUnixTimestamp int64
 	RepeatEverySeconds     int64
 	DurationInSeconds      int64
+	UntilUnixTimestamp     int64
+	MaxOccurrences         int64
 }
 
 func (r RepeatingRoom) GetID() string {
 	return r.ID
 }
 func (r RepeatingRoom) IsAllowed(userID string) bool {
+	return r.IsOpen() && r.allowsClientAt(userID, time.Now().Unix())
+}
+func (r RepeatingRoom) IsOpen() bool {
 	now := time.Now().Unix()
-	if now < r.FirstTimeUnixTimestamp {
+	if now < r.FirstTimeUnixTimestamp || now > r.LastValidUnixTime() {
 		return false
 	}
+	return Pmod(now-r.FirstTimeUnixTimestamp, r.RepeatEverySeconds) <= r.DurationInSeconds //meth
+}
+func Pmod(a, b int64) int64 {
+	return (a%b + b) % b
+}
 
-	timeSlotOk := Pmod(now-r.FirstTimeUnixTimestamp, r.RepeatEverySeconds) <= r.DurationInSeconds //meth
-	if len(r.allowedClients) == 0 {
-		return timeSlotOk
+// The start of the next occurrence, false if the room is bounded and no occurrence starts anymore
+func (r RepeatingRoom) NextOpeningAfter(now time.Time) (time.Time, bool) {
+	nowUnix := now.Unix()
+	next := r.FirstTimeUnixTimestamp
+	if nowUnix >= next {
+		if r.RepeatEverySeconds <= 0 {
+			return time.Time{}, false
+		}
+		next += ((nowUnix-r.FirstTimeUnixTimestamp)/r.RepeatEverySeconds + 1) * r.RepeatEverySeconds
 	}
+	if next > r.LastValidUnixTime() {
+		return time.Time{}, false
+	}
+	return time.Unix(next, 0), true
+}
 
-	log.Printf("Repeating Room Is Allowed - timeslot ok: %v", timeSlotOk)
-
-	_, ok := r.allowedClients[userID]
-
-	log.Printf("Repeating Room in allowed rooms: %v, userID=%v, r.allowedClients=%v", ok, userID, r.allowedClients)
-	return ok && timeSlotOk
+// The occurrence that is open at now, the End is zero if the room never closes
+func (r RepeatingRoom) CurrentWindow(now time.Time) (ScheduleWindow, bool) {
+	nowUnix := now.Unix()
+	last := r.LastValidUnixTime()
+	if nowUnix < r.FirstTimeUnixTimestamp || nowUnix > last {
+		return ScheduleWindow{}, false
+	}
+	var start, end int64
+	if r.DurationInSeconds >= r.RepeatEverySeconds {
+		start, end = r.FirstTimeUnixTimestamp, last // occurrences overlap, always open
+	} else {
+		offset := Pmod(nowUnix-r.FirstTimeUnixTimestamp, r.RepeatEverySeconds)
+		if offset > r.DurationInSeconds {
+			return ScheduleWindow{}, false
+		}
+		start, end = nowUnix-offset, nowUnix-offset+r.DurationInSeconds
+		if last < end {
+			end = last
+		}
+	}
+	window := ScheduleWindow{Start: time.Unix(start, 0)}
+	if end != math.MaxInt64 {
+		window.End = time.Unix(end, 0)
+	}
+	return window, true
 }
-func Pmod(a, b int64) int64 {
-	return (a%b + b) % b
+
+// Returns a copy of the room in which clients arriving before an occurrence wait in a lobby
+func (r RepeatingRoom) WithLobby(lobby bool) RepeatingRoom {
+	r.Lobby = lobby
+	return r
 }
 func (r RepeatingRoom) IsValid() bool {
-	return true
+	return time.Now().Unix() <= r.LastValidUnixTime()
+}
+
+// The last unix time at which the room is valid: the end of the last occurrence or UntilUnixTimestamp, whichever is earlier
+//   math.MaxInt64 if the room repeats forever
+func (r RepeatingRoom) LastValidUnixTime() int64 {
+	last := int64(math.MaxInt64)
+	if r.MaxOccurrences > 0 {
+		last = r.FirstTimeUnixTimestamp + (r.MaxOccurrences-1)*r.RepeatEverySeconds + r.DurationInSeconds
+	}
+	if r.UntilUnixTimestamp > 0 && r.UntilUnixTimestamp < last {
+		last = r.UntilUnixTimestamp
+	}
+	return last
 }
 
 // Create PermanentRoom that allows only clients with one of the given IDs
@@ -148,6 +432,133 @@ func NewRepeatingRoom(ID string, allowedClientIds []string, firstTimeUnixTimesta
 	}
 }
 
+// Returns a copy of the room that becomes invalid at the given unix time (0 = never)
+func (r RepeatingRoom) WithUntil(untilUnixTimestamp int64) RepeatingRoom {
+	r.UntilUnixTimestamp = untilUnixTimestamp
+	return r
+}
+
+// Returns a copy of the room that becomes invalid after the given number of occurrences ended (0 = unlimited)
+func (r RepeatingRoom) WithMaxOccurrences(maxOccurrences int64) RepeatingRoom {
+	r.MaxOccurrences = maxOccurrences
+	return r
+}
+
+// Returns a copy of the room that allows at most maxParticipants simultaneous connections (0 = unlimited)
+func (r RepeatingRoom) WithMaxParticipants(maxParticipants int) RepeatingRoom {
+	r.MaxParticipants = maxParticipants
+	return r
+}
+
+// Returns a copy of the room with the given roles (userID -> role)
+func (r RepeatingRoom) WithRoles(roles map[string]Role) RepeatingRoom {
+	r.roles = createRolesMap(roles)
+	return r
+}
+
+// Returns a copy of the room that relays messages according to the given policy
+func (r RepeatingRoom) WithForwardingPolicy(policy ForwardingPolicy) RepeatingRoom {
+	r.forwarding = policy.clone()
+	return r
+}
+
+// Returns a copy of the room with the given personal windows (userID -> window)
+func (r RepeatingRoom) WithUserWindows(windows map[string]UserWindow) RepeatingRoom {
+	r.userWindows = createUserWindowsMap(windows)
+	return r
+}
+
+// Returns a copy of the room that keeps relayed messages in its history according to the given policy
+func (r RepeatingRoom) WithHistoryPolicy(policy HistoryPolicy) RepeatingRoom {
+	r.history = policy.clone()
+	return r
+}
+
+// A scheduled room is a room, but only allows clients while its Schedule is open
+//   Unlike RepeatingRoom the windows are wall clock times in a timezone (weekly or cron-like rules, see Schedule)
+//   Like RepeatingRoom, there is NO functionality to close connections when a window ends, that is left to the controller
+type ScheduledRoom struct {
+	Room
+	Schedule Schedule
+}
+
+func (r ScheduledRoom) GetID() string {
+	return r.ID
+}
+func (r ScheduledRoom) IsAllowed(userID string) bool {
+	now := time.Now()
+	return r.Schedule.IsOpenAt(now) && r.allowsClientAt(userID, now.Unix())
+}
+func (r ScheduledRoom) IsOpen() bool {
+	return r.Schedule.IsOpenAt(time.Now())
+}
+func (r ScheduledRoom) IsValid() bool {
+	return true
+}
+
+// The start of the next window of the schedule
+func (r ScheduledRoom) NextOpeningAfter(now time.Time) (time.Time, bool) {
+	window, ok := r.Schedule.NextWindowAfter(now)
+	return window.Start, ok
+}
+
+// The window of the schedule that is open at now, overlapping and adjacent windows are joined
+func (r ScheduledRoom) CurrentWindow(now time.Time) (ScheduleWindow, bool) {
+	window, open := r.Schedule.windowAt(now)
+	if !open {
+		return ScheduleWindow{}, false
+	}
+	window.End, _ = r.Schedule.OpenUntil(now)
+	return window, true
+}
+
+// Returns a copy of the room in which clients arriving before a window opens wait in a lobby
+func (r ScheduledRoom) WithLobby(lobby bool) ScheduledRoom {
+	r.Lobby = lobby
+	return r
+}
+
+// Create ScheduledRoom that allows only clients with one of the given IDs (all if empty) while the schedule is open
+func NewScheduledRoom(ID string, allowedClientIds []string, schedule Schedule) ScheduledRoom {
+	return ScheduledRoom{
+		Room: Room{
+			ID:             ID,
+			allowedClients: CreateAllowedIdsMapFromSlice(allowedClientIds),
+		},
+		Schedule: schedule,
+	}
+}
+
+// Returns a copy of the room that allows at most maxParticipants simultaneous connections (0 = unlimited)
+func (r ScheduledRoom) WithMaxParticipants(maxParticipants int) ScheduledRoom {
+	r.MaxParticipants = maxParticipants
+	return r
+}
+
+// Returns a copy of the room with the given roles (userID -> role)
+func (r ScheduledRoom) WithRoles(roles map[string]Role) ScheduledRoom {
+	r.roles = createRolesMap(roles)
+	return r
+}
+
+// Returns a copy of the room that relays messages according to the given policy
+func (r ScheduledRoom) WithForwardingPolicy(policy ForwardingPolicy) ScheduledRoom {
+	r.forwarding = policy.clone()
+	return r
+}
+
+// Returns a copy of the room with the given personal windows (userID -> window)
+func (r ScheduledRoom) WithUserWindows(windows map[string]UserWindow) ScheduledRoom {
+	r.userWindows = createUserWindowsMap(windows)
+	return r
+}
+
+// Returns a copy of the room that keeps relayed messages in its history according to the given policy
+func (r ScheduledRoom) WithHistoryPolicy(policy HistoryPolicy) ScheduledRoom {
+	r.history = policy.clone()
+	return r
+}
+
 // Room Storage is the transparent interface to access rooms based on their roomID.
 // Mutation has to be supported by any implementation
 // Used to switch out the underlying storage location in RoomControllers
@@ -164,3 +575,51 @@ type RoomStorageI interface {
 	// Closes underlying resources, should only be called once. Has to be called (can be deferred).
 	Close() error
 }
+
+// Optionally implemented by storages whose rooms can be listed, all storages of this package implement it
+type IterableRoomStorageI interface {
+	RoomStorageI
+	// Calls the given function with all rooms of the storage, rooms that cannot be decoded are skipped
+	ForAll(f func(room RoomI)) error
+}
+
+// Storages are passed around by value, through the pointer SetLogger applies to all copies
+type sharedLogger struct {
+	logging.Logger
+}
+
+// A database shared by a storage and its views (see BoltRoomStorage.OfTypes), closed once all of them are closed
+type sharedCloser struct {
+	mut   sync.Mutex
+	refs  int
+	close func() error
+}
+
+func newSharedCloser(close func() error) *sharedCloser {
+	return &sharedCloser{refs: 1, close: close}
+}
+
+// Adds a reference, false if the database was closed already
+func (c *sharedCloser) acquire() bool {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if c.refs <= 0 {
+		return false
+	}
+	c.refs++
+	return true
+}
+
+// Removes a reference, the last one closes the database
+func (c *sharedCloser) release() error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if c.refs <= 0 {
+		return nil
+	}
+	c.refs--
+	if c.refs > 0 {
+		return nil
+	}
+	return c.close()
+}
@@ -0,0 +1,173 @@
+package wsclientable
+
+import (
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+//IDEA:
+//  The layouts of the bolt room databases change as rooms gain properties, files written by older versions must keep working.
+//     The same applies to the databases next to them (room states, histories, the webhook outbox and user groups).
+//  Each database holds a metadata bucket with the name of its layout and the version of its schema.
+//     When a storage opens a file, the migrations from the version in the file to the latest version are applied,
+//     all within one transaction, so a failed migration leaves the file as it was.
+//     Files without metadata are version 0: new files, or files written before versions were introduced.
+//     A file with a newer version than the code knows is not opened (BoltSchemaTooNewError), instead of being misread.
+//
+//  Database Model ('-' denotes bolt buckets, '->' denotes key-value pair), next to the buckets of the layout:
+//     - "\x00meta":
+//        "layout" -> <name of the layout, for example "temporary_rooms">
+//        "version" -> <version of the schema>
+//  The name starts with a control character, which room ids cannot contain (see ValidateID),
+//     so it cannot collide with the room buckets older repeating and scheduled layouts kept at the top level.
+//
+//  Adding a field that older code ignores and newer code defaults (like MaxParticipants) needs no migration.
+//  Changing how existing data is stored does: append a migration to the schema of the layout, never change applied ones.
+
+var boltMetaBucket = []byte("\x00meta")
+
+// A layout of a bolt database and the migrations that produce it, the migration at index i upgrades version i to i+1
+type boltSchema struct {
+	layout     string
+	migrations []func(tx *bolt.Tx) error
+}
+
+func (s boltSchema) latestVersion() int {
+	return len(s.migrations)
+}
+
+// Returned by the storage constructors when the schema of the database is newer than the code opening it
+type BoltSchemaTooNewError struct {
+	Path             string
+	Layout           string
+	Version          int
+	SupportedVersion int
+}
+
+func (e BoltSchemaTooNewError) Error() string {
+	return fmt.Sprintf("database %v (%v) has schema version %v, newer than the supported version %v, update the server",
+		e.Path, e.Layout, e.Version, e.SupportedVersion)
+}
+
+// Returns the layout and schema version of the database, version 0 if it has no metadata
+//bucket must be in at least a View context
+func boltSchemaVersionOf(tx *bolt.Tx) (string, int) {
+	metaB := tx.Bucket(boltMetaBucket)
+	if metaB == nil {
+		return "", 0
+	}
+	rawVersion := metaB.Get([]byte("version"))
+	if rawVersion == nil {
+		return string(metaB.Get([]byte("layout"))), 0
+	}
+	return string(metaB.Get([]byte("layout"))), int(int64FromBytes(rawVersion))
+}
+
+// Opens the database at the given path and migrates it to the latest version of the schema
+func openBoltWithSchema(dbPath string, schema boltSchema) (*bolt.DB, error) {
+	db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		layout, version := boltSchemaVersionOf(tx)
+		if len(layout) > 0 && layout != schema.layout {
+			return fmt.Errorf("database %v holds %v, not %v", dbPath, layout, schema.layout)
+		}
+		if version > schema.latestVersion() {
+			return BoltSchemaTooNewError{Path: dbPath, Layout: schema.layout, Version: version, SupportedVersion: schema.latestVersion()}
+		}
+		if version == schema.latestVersion() && len(layout) > 0 {
+			return nil
+		}
+		for ; version < schema.latestVersion(); version++ {
+			if err := schema.migrations[version](tx); err != nil {
+				return fmt.Errorf("could not migrate database %v (%v) to version %v: %w", dbPath, schema.layout, version+1, err)
+			} //auto rollback
+		}
+
+		metaB, err := tx.CreateBucketIfNotExists(boltMetaBucket)
+		if err != nil {
+			return err
+		} //auto rollback
+		err = metaB.Put([]byte("layout"), []byte(schema.layout))
+		if err != nil {
+			return err
+		} //auto rollback
+		return metaB.Put([]byte("version"), int64ToBytes(int64(version)))
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Migration creating the given top level buckets, if they do not exist yet
+func createBoltBuckets(names ...string) func(tx *bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		for _, name := range names {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			} //auto rollback
+		}
+		return nil
+	}
+}
+
+// Migration moving all top level buckets (except the metadata) into a new top level bucket with the given name
+//   older layouts kept one bucket per room at the top level, a room could even be called like the new bucket
+func moveTopLevelBucketsInto(name string) func(tx *bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		var names [][]byte
+		err := tx.ForEach(func(k []byte, _ *bolt.Bucket) error {
+			if string(k) != string(boltMetaBucket) {
+				names = append(names, append([]byte{}, k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		} //auto rollback
+
+		// moved twice, through a bucket no room can be called (see Idea)
+		movingB, err := tx.CreateBucket([]byte("\x00moving"))
+		if err != nil {
+			return err
+		} //auto rollback
+		for _, k := range names {
+			if err := copyBoltBucketInto(movingB, k, tx.Bucket(k)); err != nil {
+				return err
+			} //auto rollback
+			if err := tx.DeleteBucket(k); err != nil {
+				return err
+			} //auto rollback
+		}
+		targetB, err := tx.CreateBucket([]byte(name))
+		if err != nil {
+			return err
+		} //auto rollback
+		for _, k := range names {
+			if err := copyBoltBucketInto(targetB, k, movingB.Bucket(k)); err != nil {
+				return err
+			} //auto rollback
+		}
+		return tx.DeleteBucket([]byte("\x00moving"))
+	}
+}
+
+// Copies src (recursively) into a new sub bucket of parent with the given name
+//bucket must be in a Update context
+func copyBoltBucketInto(parent *bolt.Bucket, name []byte, src *bolt.Bucket) error {
+	dst, err := parent.CreateBucket(name)
+	if err != nil {
+		return err
+	} //auto rollback
+	return src.ForEach(func(k, v []byte) error {
+		if v == nil { //is bucket
+			return copyBoltBucketInto(dst, append([]byte{}, k...), src.Bucket(k))
+		}
+		return dst.Put(append([]byte{}, k...), append([]byte{}, v...))
+	})
+}
@@ -0,0 +1,173 @@
+package wsclientable
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"github.com/jokrey/utility-algorithms-golang/network/logging"
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+//IDEA:
+//  The history of rooms (see 'room_history.go') can be held in a database, so that it survives restarts
+//     like the rooms of the persisted controllers. It is kept in its own file, next to the database of the rooms.
+//  Retention is applied when a message is appended (the oldest messages are deleted) and when reading (expired messages are skipped).
+//
+//  Database Model ('-' denotes bolt buckets, '->' denotes key-value pair):
+//     - "histories": (MANY sub buckets)
+//        - <roomID>
+//            "last" -> <sequence number of the newest message, 8 bytes>
+//            - "messages"
+//               <seq-1, 8 bytes> -> <json encoded HistoryMessage>
+//               <seq-2, 8 bytes> -> ...
+//               etc...
+
+var roomHistoryBoltSchema = boltSchema{
+	layout: "room_histories",
+	migrations: []func(tx *bolt.Tx) error{
+		createBoltBuckets("histories"), // 0 -> 1
+	},
+}
+
+type BoltRoomHistoryStorage struct {
+	db        *bolt.DB
+	retention HistoryRetention
+	logger    *sharedLogger
+}
+
+// Opens (or creates and migrates) the database, see 'room_storage_bolt_schema.go'
+func NewRoomHistoryBoltStorage(dbPath string, retention HistoryRetention) (BoltRoomHistoryStorage, error) {
+	db, err := openBoltWithSchema(dbPath, roomHistoryBoltSchema)
+	if err != nil {
+		return BoltRoomHistoryStorage{}, err
+	}
+
+	return BoltRoomHistoryStorage{db: db, retention: retention, logger: &sharedLogger{logging.Nop()}}, nil
+}
+
+// Sets the logger used to report database errors (default: logging.Nop())
+func (b BoltRoomHistoryStorage) SetLogger(logger logging.Logger) {
+	b.logger.Logger = logging.OrNop(logger)
+}
+
+func (b BoltRoomHistoryStorage) Close() error {
+	return b.db.Close()
+}
+
+func (b BoltRoomHistoryStorage) Append(roomID string, message HistoryMessage) (uint64, error) {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		roomB, err := tx.Bucket([]byte("histories")).CreateBucketIfNotExists([]byte(roomID))
+		if err != nil {
+			return err
+		} //auto rollback
+		messagesB, err := roomB.CreateBucketIfNotExists([]byte("messages"))
+		if err != nil {
+			return err
+		} //auto rollback
+
+		message.Seq = 1
+		if raw := roomB.Get([]byte("last")); raw != nil {
+			message.Seq = binary.BigEndian.Uint64(raw) + 1
+		}
+		seqBytes := encodeHistorySeq(message.Seq)
+		if err := roomB.Put([]byte("last"), seqBytes); err != nil {
+			return err
+		} //auto rollback
+		encoded, err := json.Marshal(message)
+		if err != nil {
+			return err
+		} //auto rollback
+		if err := messagesB.Put(seqBytes, encoded); err != nil {
+			return err
+		} //auto rollback
+
+		// sequence numbers are contiguous and messages are only deleted from the front, so the count is last-first+1
+		now := time.Now()
+		c := messagesB.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.First() {
+			count := message.Seq - binary.BigEndian.Uint64(k) + 1
+			tooMany := b.retention.MaxMessages > 0 && count > uint64(b.retention.MaxMessages)
+			if !tooMany && !b.retention.expired(decodeHistoryMessageTime(v), now) {
+				break
+			}
+			if err := messagesB.Delete(k); err != nil {
+				return err
+			} //auto rollback
+		}
+		return nil
+	})
+	if err != nil {
+		b.logger.Error("database failed Append", "room", roomID, "err", err)
+		return 0, err
+	}
+	return message.Seq, nil
+}
+
+func (b BoltRoomHistoryStorage) Before(roomID string, beforeSeq uint64, limit int, visible func(HistoryMessage) bool) ([]HistoryMessage, bool, error) {
+	var page []HistoryMessage
+	more := false
+	err := b.db.View(func(tx *bolt.Tx) error {
+		roomB := tx.Bucket([]byte("histories")).Bucket([]byte(roomID))
+		if roomB == nil {
+			return nil
+		}
+		c := roomB.Bucket([]byte("messages")).Cursor()
+		var k, v []byte
+		if beforeSeq == 0 {
+			k, v = c.Last()
+		} else if k, _ = c.Seek(encodeHistorySeq(beforeSeq)); k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+
+		now := time.Now()
+		for ; k != nil; k, v = c.Prev() {
+			var message HistoryMessage
+			if err := json.Unmarshal(v, &message); err != nil {
+				return err
+			}
+			if b.retention.expired(message.AtUnixTime, now) {
+				break
+			}
+			if !visible(message) {
+				continue
+			}
+			if len(page) == limit {
+				more = true
+				break
+			}
+			page = append(page, message)
+		}
+		return nil
+	})
+	if err != nil {
+		b.logger.Error("database failed Before", "room", roomID, "err", err)
+		return nil, false, err
+	}
+	return reverseHistoryMessages(page), more, nil
+}
+
+func (b BoltRoomHistoryStorage) RemoveRoom(roomID string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket([]byte("histories")).DeleteBucket([]byte(roomID))
+		if err == bolt.ErrBucketNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
+func encodeHistorySeq(seq uint64) []byte {
+	bs := make([]byte, 8)
+	binary.BigEndian.PutUint64(bs, seq)
+	return bs
+}
+
+func decodeHistoryMessageTime(encoded []byte) int64 {
+	var message struct {
+		AtUnixTime int64 `json:"at"`
+	}
+	_ = json.Unmarshal(encoded, &message) // written by Append, cannot fail
+	return message.AtUnixTime
+}
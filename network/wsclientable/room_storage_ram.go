@@ -46,11 +46,29 @@ func (r RamRoomStorage) Get(roomID string) RoomI {
 	}
 	return v.(RoomI)
 }
+func (r RamRoomStorage) ForAll(f func(room RoomI)) error {
+	r.rooms.Range(func(_, v interface{}) bool {
+		f(v.(RoomI))
+		return true
+	})
+	return nil
+}
 
 func (r RamRoomStorage) Close() error {
 	return nil
 }
 
+func (r RamRoomStorage) SetValidUntil(roomID string, validUntilUnixTime int64) (TemporaryRoom, error) {
+	v, ok := r.rooms.Load(roomID)
+	if !ok {
+		return TemporaryRoom{}, RoomNotFoundError{RoomID: roomID}
+	}
+	room := v.(TemporaryRoom)
+	room.ValidUntilUnixTime = validUntilUnixTime
+	r.rooms.Store(roomID, room)
+	return room, nil
+}
+
 func (r RamRoomStorage) CleanExpired(removedCallback func(room *TemporaryRoom)) (*TemporaryRoom, error) {
 	var nextToExpire *TemporaryRoom
 	r.rooms.Range(func(key, value interface{}) bool {
@@ -2,28 +2,56 @@ package wsclientable
 
 import (
 	"fmt"
+	"github.com/jokrey/utility-algorithms-golang/network/logging"
 	bolt "go.etcd.io/bbolt"
-	"log"
-	"time"
 )
 
 //IDEA:
 //  RepeatingRooms can be held in a database.
 //  Upon query the appropriate room will be decoded from the database and checked for validity
 //
+//  Database Model ('-' denotes bolt buckets, '->' denotes key-value pair):
+//     - "rooms": (MANY sub buckets)
+//        - <roomID>
+//          <encoded room base and timing fields, see encodeRepeatingRoomIntoBucket>
+//  Before schema version 1 the room buckets were kept at the top level (see 'room_storage_bolt_schema.go')
+//
 // Only works for RepeatingRoom. When adding anything else, this code will panic.
 
+var repeatingRoomBoltSchema = boltSchema{
+	layout: "repeating_rooms",
+	migrations: []func(tx *bolt.Tx) error{
+		moveTopLevelBucketsInto("rooms"), // 0 -> 1
+	},
+}
+
 type BoltRepeatingRoomStorage struct {
-	db *bolt.DB
+	db     *bolt.DB
+	logger *sharedLogger
 }
 
+// Panics if the database cannot be opened, see OpenRepeatingRoomBoltStorage
 func NewRepeatingRoomBoltStorage(dbPath string) BoltRepeatingRoomStorage {
-	db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	storage, err := OpenRepeatingRoomBoltStorage(dbPath)
 	if err != nil {
 		panic(err)
 	}
+	return storage
+}
+
+// Opens (or creates and migrates) the database, fails with BoltSchemaTooNewError if a newer version of the server wrote it
+func OpenRepeatingRoomBoltStorage(dbPath string) (BoltRepeatingRoomStorage, error) {
+	db, err := openBoltWithSchema(dbPath, repeatingRoomBoltSchema)
+	if err != nil {
+		return BoltRepeatingRoomStorage{}, err
+	}
 
-	return BoltRepeatingRoomStorage{db: db}
+	return BoltRepeatingRoomStorage{db: db, logger: &sharedLogger{logging.Nop()}}, nil
+}
+
+// Sets the logger used to report database errors (default: logging.Nop())
+func (b BoltRepeatingRoomStorage) SetLogger(logger logging.Logger) {
+	b.logger.Logger = logging.OrNop(logger)
 }
 
 func (b BoltRepeatingRoomStorage) Close() error {
@@ -37,12 +65,12 @@ func (b BoltRepeatingRoomStorage) Put(roomI RoomI, allowOverride bool) error {
 		var err error
 		var roomB *bolt.Bucket
 		if allowOverride {
-			roomB, err = tx.CreateBucketIfNotExists(roomIDBytes)
+			roomB, err = tx.Bucket([]byte("rooms")).CreateBucketIfNotExists(roomIDBytes)
 			if err != nil {
 				return err
 			} //auto rollback
 		} else {
-			roomB, err = tx.CreateBucket(roomIDBytes)
+			roomB, err = tx.Bucket([]byte("rooms")).CreateBucket(roomIDBytes)
 			if err != nil {
 				if err == bolt.ErrBucketExists {
 					return fmt.Errorf("room already exists")
@@ -62,7 +90,7 @@ func (b BoltRepeatingRoomStorage) Put(roomI RoomI, allowOverride bool) error {
 func (b BoltRepeatingRoomStorage) Remove(roomID string) (bool, error) {
 	previouslyExisted := true
 	err := b.db.Update(func(tx *bolt.Tx) error {
-		err := tx.DeleteBucket([]byte(roomID))
+		err := tx.Bucket([]byte("rooms")).DeleteBucket([]byte(roomID))
 		if err == bolt.ErrBucketNotFound {
 			previouslyExisted = false
 			return nil
@@ -75,7 +103,7 @@ func (b BoltRepeatingRoomStorage) Remove(roomID string) (bool, error) {
 func (b BoltRepeatingRoomStorage) Get(roomID string) RoomI {
 	var decodedRoom RoomI
 	err := b.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(roomID))
+		b := tx.Bucket([]byte("rooms")).Bucket([]byte(roomID))
 		if b == nil {
 			decodedRoom = nil
 			return nil
@@ -86,24 +114,34 @@ func (b BoltRepeatingRoomStorage) Get(roomID string) RoomI {
 		return nil
 	})
 	if err != nil {
-		log.Printf("database failed Get, returning nil - err: %v", err)
+		b.logger.Error("database failed Get, returning nil", "room", roomID, "err", err)
 		return nil
 	}
 	return decodedRoom
 }
 
+func (b BoltRepeatingRoomStorage) ForAll(f func(room RoomI)) error {
+	var rooms []RoomI
+	err := b.db.View(func(tx *bolt.Tx) error {
+		roomsB := tx.Bucket([]byte("rooms"))
+		return roomsB.ForEach(func(k, v []byte) error {
+			rooms = append(rooms, decodeRepeatingRoomFromBucket(string(k), roomsB.Bucket(k)))
+			return nil
+		})
+	})
+	// outside of the transaction, f might use the storage
+	for _, room := range rooms {
+		f(room)
+	}
+	return err
+}
+
 //bucket must be in a Update context
 func encodeRepeatingRoomIntoBucket(room RepeatingRoom, roomB *bolt.Bucket) error {
-	allowedClientsB, err := roomB.CreateBucketIfNotExists([]byte("allowedClientIDs"))
+	err := encodeRoomBaseIntoBucket(room.Room, roomB)
 	if err != nil {
 		return err
 	} //auto rollback
-	for allowedClientName := range room.allowedClients {
-		err = allowedClientsB.Put([]byte(allowedClientName), []byte{})
-		if err != nil {
-			return err
-		} //auto rollback
-	}
 
 	err = roomB.Put([]byte("FirstTimeUnixTimestamp"), int64ToBytes(room.FirstTimeUnixTimestamp))
 	if err != nil {
@@ -117,31 +155,37 @@ func encodeRepeatingRoomIntoBucket(room RepeatingRoom, roomB *bolt.Bucket) error
 	if err != nil {
 		return err
 	} //auto rollback
+	err = roomB.Put([]byte("UntilUnixTimestamp"), int64ToBytes(room.UntilUnixTimestamp))
+	if err != nil {
+		return err
+	} //auto rollback
+	err = roomB.Put([]byte("MaxOccurrences"), int64ToBytes(room.MaxOccurrences))
+	if err != nil {
+		return err
+	} //auto rollback
 
 	return nil //success, no error
 }
 
 //bucket must be in at least a View context
 func decodeRepeatingRoomFromBucket(roomID string, roomB *bolt.Bucket) RepeatingRoom {
-	var allowedClientIds []string
-	allowedClientsB := roomB.Bucket([]byte("allowedClientIDs"))
-	c := allowedClientsB.Cursor()
-	for k, _ := c.First(); k != nil; k, _ = c.Next() {
-		allowedClientId := string(k)
-		allowedClientIds = append(allowedClientIds, allowedClientId)
-	}
-
 	firstTimeUnixTimestamp := int64FromBytes(roomB.Get([]byte("FirstTimeUnixTimestamp")))
 	repeatEverySeconds := int64FromBytes(roomB.Get([]byte("RepeatEverySeconds")))
 	durationInSeconds := int64FromBytes(roomB.Get([]byte("DurationInSeconds")))
+	var untilUnixTimestamp, maxOccurrences int64 // missing in databases written before they were added, decoded as unbounded
+	if raw := roomB.Get([]byte("UntilUnixTimestamp")); raw != nil {
+		untilUnixTimestamp = int64FromBytes(raw)
+	}
+	if raw := roomB.Get([]byte("MaxOccurrences")); raw != nil {
+		maxOccurrences = int64FromBytes(raw)
+	}
 
 	return RepeatingRoom{
-		Room: Room{
-			ID:             roomID,
-			allowedClients: CreateAllowedIdsMapFromSlice(allowedClientIds),
-		},
+		Room:                   decodeRoomBaseFromBucket(roomID, roomB),
 		FirstTimeUnixTimestamp: firstTimeUnixTimestamp,
 		RepeatEverySeconds:     repeatEverySeconds,
 		DurationInSeconds:      durationInSeconds,
+		UntilUnixTimestamp:     untilUnixTimestamp,
+		MaxOccurrences:         maxOccurrences,
 	}
 }
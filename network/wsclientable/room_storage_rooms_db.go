@@ -0,0 +1,621 @@
+package wsclientable
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/jokrey/utility-algorithms-golang/network/logging"
+	bolt "go.etcd.io/bbolt"
+	"gopkg.in/ini.v1"
+	"math"
+	"sync"
+	"time"
+)
+
+//IDEA:
+//  One database file holds the rooms of every type, so a server needs only a single file (see [rooms_db] in the config).
+//  Each room is stored with a type tag, its fields are written by the codec registered for that tag:
+//     permanent, temporary, repeating and scheduled rooms are built in, custom room types bring their own BoltRoomCodecI
+//  Rooms of time-bound kinds (see ExpiringRoomI) are additionally indexed by the unix time at which they expire,
+//     so expired rooms are found without decoding all rooms (see CleanExpiredRooms)
+//
+//  Database Model ('-' denotes bolt buckets, '->' denotes key-value pair):
+//     - "rooms": (MANY sub buckets)
+//        - <roomID>
+//          "Type" -> <type tag>
+//          "ExpiryUnixTime" -> <ExpiryUnixTime> (missing if the room never expires)
+//          ... (written by the codec of the type, for the built in types the same as in their own storages)
+//     - "expirations" (one sub bucket per type, so a view only walks the expirations of its types)
+//        - <type tag> (MANY sub buckets (SORTED, i.e. earliest first))
+//           - <ExpiryUnixTime> (SET, likely small)
+//             <roomID-1> -> ""
+//             <roomID-2> -> ""
+//             etc...
+//
+//  The controllers of this package cast the rooms of their storage to their type,
+//     so each of them gets a view of the storage that only sees (and only accepts) rooms of its type:
+//        rooms, err := NewRoomBoltStorage("rooms.db")
+//        NewTemporaryRoomController(rooms.OfTypes(RoomTypeTemporary))
+//        NewRepeatingRoomController(rooms.OfTypes(RoomTypeRepeating))
+//     room ids are unique across all types, a view cannot add a room whose id is taken by a room of another type
+//     views share the database, it is closed once the storage and all of its views are closed
+
+// Type tags of the built in room types
+const (
+	RoomTypePermanent = "permanent"
+	RoomTypeTemporary = "temporary"
+	RoomTypeRepeating = "repeating"
+	RoomTypeScheduled = "scheduled"
+)
+
+// Optionally implemented by rooms that become invalid at a known time
+//   TemporaryRoom and RepeatingRoom implement it
+type ExpiringRoomI interface {
+	RoomI
+	// The unix time after which the room is invalid, false if it never expires
+	GetExpiryUnixTime() (int64, bool)
+}
+
+// Returns the unix time after which the room is invalid, false if it never expires or does not tell
+func ExpiryOf(room RoomI) (int64, bool) {
+	if expiring, ok := room.(ExpiringRoomI); ok {
+		return expiring.GetExpiryUnixTime()
+	}
+	return 0, false
+}
+
+func (r TemporaryRoom) GetExpiryUnixTime() (int64, bool) {
+	return r.ValidUntilUnixTime, true
+}
+func (r RepeatingRoom) GetExpiryUnixTime() (int64, bool) {
+	last := r.LastValidUnixTime()
+	return last, last != math.MaxInt64
+}
+
+// Encodes the rooms of one type into their bucket of a BoltRoomStorage
+type BoltRoomCodecI interface {
+	// The type tag stored with the rooms, unique within a storage
+	RoomType() string
+	// Whether the given room is of the type of this codec
+	Handles(room RoomI) bool
+	// Writes the room into its bucket, which only contains the "Type" and "ExpiryUnixTime" keys
+	//bucket is in a Update context
+	Encode(room RoomI, roomB *bolt.Bucket) error
+	//bucket is in at least a View context
+	Decode(roomID string, roomB *bolt.Bucket) (RoomI, error)
+}
+
+type builtinBoltRoomCodec struct {
+	roomType string
+	handles  func(room RoomI) bool
+	encode   func(room RoomI, roomB *bolt.Bucket) error
+	decode   func(roomID string, roomB *bolt.Bucket) (RoomI, error)
+}
+
+func (c builtinBoltRoomCodec) RoomType() string        { return c.roomType }
+func (c builtinBoltRoomCodec) Handles(room RoomI) bool { return c.handles(room) }
+func (c builtinBoltRoomCodec) Encode(room RoomI, roomB *bolt.Bucket) error {
+	return c.encode(room, roomB)
+}
+func (c builtinBoltRoomCodec) Decode(roomID string, roomB *bolt.Bucket) (RoomI, error) {
+	return c.decode(roomID, roomB)
+}
+
+var builtinBoltRoomCodecs = []BoltRoomCodecI{
+	builtinBoltRoomCodec{
+		roomType: RoomTypePermanent,
+		handles:  func(room RoomI) bool { _, ok := room.(PermanentRoom); return ok },
+		encode: func(room RoomI, roomB *bolt.Bucket) error {
+			return encodeRoomBaseIntoBucket(room.(PermanentRoom).Room, roomB)
+		},
+		decode: func(roomID string, roomB *bolt.Bucket) (RoomI, error) {
+			return PermanentRoom{Room: decodeRoomBaseFromBucket(roomID, roomB)}, nil
+		},
+	},
+	builtinBoltRoomCodec{
+		roomType: RoomTypeTemporary,
+		handles:  func(room RoomI) bool { _, ok := room.(TemporaryRoom); return ok },
+		encode: func(room RoomI, roomB *bolt.Bucket) error {
+			return encodeTemporaryRoomIntoBucket(room.(TemporaryRoom), roomB)
+		},
+		decode: func(roomID string, roomB *bolt.Bucket) (RoomI, error) {
+			return decodeTemporaryRoomFromBucket(roomID, roomB), nil
+		},
+	},
+	builtinBoltRoomCodec{
+		roomType: RoomTypeRepeating,
+		handles:  func(room RoomI) bool { _, ok := room.(RepeatingRoom); return ok },
+		encode: func(room RoomI, roomB *bolt.Bucket) error {
+			return encodeRepeatingRoomIntoBucket(room.(RepeatingRoom), roomB)
+		},
+		decode: func(roomID string, roomB *bolt.Bucket) (RoomI, error) {
+			return decodeRepeatingRoomFromBucket(roomID, roomB), nil
+		},
+	},
+	builtinBoltRoomCodec{
+		roomType: RoomTypeScheduled,
+		handles:  func(room RoomI) bool { _, ok := room.(ScheduledRoom); return ok },
+		encode: func(room RoomI, roomB *bolt.Bucket) error {
+			return encodeScheduledRoomIntoBucket(room.(ScheduledRoom), roomB)
+		},
+		decode: func(roomID string, roomB *bolt.Bucket) (RoomI, error) {
+			return decodeScheduledRoomFromBucket(roomID, roomB)
+		},
+	},
+}
+
+var roomsBoltSchema = boltSchema{
+	layout: "rooms",
+	migrations: []func(tx *bolt.Tx) error{
+		createBoltBuckets("rooms", "expirations"), // 0 -> 1
+		indexExpirationsByType,                    // 1 -> 2
+	},
+}
+
+// Migration from one expirations bucket for all types to one per type (see Idea)
+func indexExpirationsByType(tx *bolt.Tx) error {
+	roomsB := tx.Bucket([]byte("rooms"))
+	type indexed struct{ expiryKey, roomIDBytes []byte }
+	var entries []indexed
+	err := tx.Bucket([]byte("expirations")).ForEach(func(k, _ []byte) error {
+		for _, roomIDBytes := range decodeExpiredRoomIDsBytes(tx.Bucket([]byte("expirations")).Bucket(k)) {
+			entries = append(entries, indexed{append([]byte{}, k...), append([]byte{}, roomIDBytes...)})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	} //auto rollback
+	if err := tx.DeleteBucket([]byte("expirations")); err != nil {
+		return err
+	} //auto rollback
+	expirationsB, err := tx.CreateBucket([]byte("expirations"))
+	if err != nil {
+		return err
+	} //auto rollback
+	for _, entry := range entries {
+		roomB := roomsB.Bucket(entry.roomIDBytes)
+		if roomB == nil { // stale index entry
+			continue
+		}
+		if err := addToExpiration(entry.roomIDBytes, string(roomB.Get([]byte("Type"))), entry.expiryKey, expirationsB); err != nil {
+			return err
+		} //auto rollback
+	}
+	return nil
+}
+
+type BoltRoomStorage struct {
+	db       *bolt.DB
+	closer   *sharedCloser // shared with the views
+	released *sync.Once    // of this storage or view, so closing it twice releases the database once
+	logger   *sharedLogger
+	codecs []BoltRoomCodecI // custom codecs first, built in codecs last
+	types  map[string]bool  // the types seen by this view, nil if all are seen (see OfTypes)
+}
+
+// Opens (or creates and migrates) the database, rooms of custom types can be stored with the given codecs
+//   a custom codec for a built in type tag replaces the built in codec
+//   fails with BoltSchemaTooNewError if it was written by a newer version, or if it holds another layout
+func NewRoomBoltStorage(dbPath string, customCodecs ...BoltRoomCodecI) (BoltRoomStorage, error) {
+	codecs := append([]BoltRoomCodecI{}, customCodecs...)
+	for _, builtin := range builtinBoltRoomCodecs {
+		if codecOfType(customCodecs, builtin.RoomType()) == nil {
+			codecs = append(codecs, builtin)
+		}
+	}
+
+	db, err := openBoltWithSchema(dbPath, roomsBoltSchema)
+	if err != nil {
+		return BoltRoomStorage{}, err
+	}
+
+	return BoltRoomStorage{
+		db: db, closer: newSharedCloser(db.Close), released: &sync.Once{},
+		logger: &sharedLogger{logging.Nop()}, codecs: codecs,
+	}, nil
+}
+
+// Returns a view of the storage that only sees and accepts rooms of the given types (see Idea)
+//   the view has to be closed like the storage, the database is closed once all of them are
+func (b BoltRoomStorage) OfTypes(roomTypes ...string) BoltRoomStorage {
+	view, _ := b.acquireView(roomTypes...)
+	return view
+}
+
+// Same as OfTypes, false if the database was closed already (closing the view then does nothing)
+func (b BoltRoomStorage) acquireView(roomTypes ...string) (BoltRoomStorage, bool) {
+	view := b.view(roomTypes...)
+	view.released = &sync.Once{}
+	acquired := view.closer.acquire()
+	if !acquired {
+		view.released.Do(func() {})
+	}
+	return view, acquired
+}
+
+// Returns a view that shares the reference of this storage, for use within the storage
+func (b BoltRoomStorage) view(roomTypes ...string) BoltRoomStorage {
+	b.types = make(map[string]bool, len(roomTypes))
+	for _, roomType := range roomTypes {
+		b.types[roomType] = true
+	}
+	return b
+}
+
+// Returns the type tag under which the room would be stored, false if no codec handles it
+func (b BoltRoomStorage) RoomTypeOf(room RoomI) (string, bool) {
+	for _, codec := range b.codecs {
+		if codec.Handles(room) {
+			return codec.RoomType(), true
+		}
+	}
+	return "", false
+}
+
+func (b BoltRoomStorage) sees(roomType string) bool {
+	return b.types == nil || b.types[roomType]
+}
+
+func codecOfType(codecs []BoltRoomCodecI, roomType string) BoltRoomCodecI {
+	for _, codec := range codecs {
+		if codec.RoomType() == roomType {
+			return codec
+		}
+	}
+	return nil
+}
+
+// Sets the logger used to report database errors (default: logging.Nop())
+func (b BoltRoomStorage) SetLogger(logger logging.Logger) {
+	b.logger.Logger = logging.OrNop(logger)
+}
+
+// Releases this storage (or view), the database is closed once the storage and all of its views are released
+func (b BoltRoomStorage) Close() error {
+	var err error
+	b.released.Do(func() {
+		err = b.closer.release()
+	})
+	return err
+}
+
+func (b BoltRoomStorage) Put(room RoomI, allowOverride bool) error {
+	roomType, ok := b.RoomTypeOf(room)
+	if !ok {
+		return fmt.Errorf("no codec for rooms of type %T", room)
+	}
+	if !b.sees(roomType) {
+		return fmt.Errorf("rooms of type %v are not stored in this view", roomType)
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		roomIDBytes := []byte(room.GetID())
+		roomsB := tx.Bucket([]byte("rooms"))
+		expirationsB := tx.Bucket([]byte("expirations"))
+
+		if existingB := roomsB.Bucket(roomIDBytes); existingB != nil {
+			if !allowOverride {
+				return fmt.Errorf("room already exists")
+			}
+			if existingType := string(existingB.Get([]byte("Type"))); !b.sees(existingType) {
+				return fmt.Errorf("room already exists with type %v", existingType)
+			}
+			_, err := removeRoomWithExpiration(roomIDBytes, roomsB, expirationsB)
+			if err != nil {
+				return err
+			} //auto rollback
+		}
+		return b.putRoom(roomIDBytes, roomType, room, roomsB, expirationsB)
+	})
+}
+
+//must be in UPDATE context, the room must not exist
+func (b BoltRoomStorage) putRoom(roomIDBytes []byte, roomType string, room RoomI, roomsB, expirationsB *bolt.Bucket) error {
+	roomB, err := roomsB.CreateBucket(roomIDBytes)
+	if err != nil {
+		return err
+	} //auto rollback
+	err = roomB.Put([]byte("Type"), []byte(roomType))
+	if err != nil {
+		return err
+	} //auto rollback
+
+	if expiry, expires := ExpiryOf(room); expires {
+		err = roomB.Put([]byte("ExpiryUnixTime"), int64ToBytes(expiry))
+		if err != nil {
+			return err
+		} //auto rollback
+		err = addToExpiration(roomIDBytes, roomType, int64ToBytes(expiry), expirationsB)
+		if err != nil {
+			return err
+		} //auto rollback
+	}
+
+	return codecOfType(b.codecs, roomType).Encode(room, roomB)
+}
+
+//must be in UPDATE context
+func addToExpiration(roomIDBytes []byte, roomType string, expiryKey []byte, expirationsB *bolt.Bucket) error {
+	typeB, err := expirationsB.CreateBucketIfNotExists([]byte(roomType))
+	if err != nil {
+		return err
+	} //auto rollback
+	expirationB, err := typeB.CreateBucketIfNotExists(expiryKey)
+	if err != nil {
+		return err
+	} //auto rollback
+	return expirationB.Put(roomIDBytes, []byte{})
+}
+
+//must be in UPDATE context
+func removeRoomWithExpiration(roomIDBytes []byte, roomsB, expirationsB *bolt.Bucket) (bool, error) {
+	roomB := roomsB.Bucket(roomIDBytes)
+	if roomB == nil { //does not exist
+		return false, nil
+	}
+	rawExpiry := roomB.Get([]byte("ExpiryUnixTime"))
+	if typeB := expirationsB.Bucket(roomB.Get([]byte("Type"))); rawExpiry != nil && typeB != nil {
+		err := removeFromExpiration(roomIDBytes, int64ToBytes(int64FromBytes(rawExpiry)), typeB)
+		if err != nil {
+			return true, err
+		} //auto rollback
+	}
+	return true, roomsB.DeleteBucket(roomIDBytes)
+}
+
+//must be in UPDATE context, removes the expiration bucket once it is empty
+func removeFromExpiration(roomIDBytes, expiryKey []byte, expirationsOfTypeB *bolt.Bucket) error {
+	expirationB := expirationsOfTypeB.Bucket(expiryKey)
+	if expirationB == nil {
+		return nil
+	}
+	err := expirationB.Delete(roomIDBytes)
+	if err != nil {
+		return err
+	} //auto rollback
+	if k, _ := expirationB.Cursor().First(); k == nil {
+		return expirationsOfTypeB.DeleteBucket(expiryKey)
+	}
+	return nil
+}
+
+func (b BoltRoomStorage) Remove(roomID string) (bool, error) {
+	previouslyExisted := false
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		roomIDBytes := []byte(roomID)
+		roomsB := tx.Bucket([]byte("rooms"))
+		if roomB := roomsB.Bucket(roomIDBytes); roomB == nil || !b.sees(string(roomB.Get([]byte("Type")))) {
+			return nil
+		}
+		existed, err := removeRoomWithExpiration(roomIDBytes, roomsB, tx.Bucket([]byte("expirations")))
+		previouslyExisted = existed
+		return err
+	})
+	return previouslyExisted, err
+}
+
+func (b BoltRoomStorage) Get(roomID string) RoomI {
+	var decodedRoom RoomI
+	err := b.db.View(func(tx *bolt.Tx) error {
+		room, err := b.decodeRoom(roomID, tx.Bucket([]byte("rooms")).Bucket([]byte(roomID)))
+		decodedRoom = room
+		return err
+	})
+	if err != nil {
+		b.logger.Error("database failed Get, returning nil", "room", roomID, "err", err)
+		return nil
+	}
+	return decodedRoom
+}
+
+//bucket must be in at least a View context, returns nil if the room does not exist or is not seen by this view
+func (b BoltRoomStorage) decodeRoom(roomID string, roomB *bolt.Bucket) (RoomI, error) {
+	if roomB == nil {
+		return nil, nil
+	}
+	roomType := string(roomB.Get([]byte("Type")))
+	if !b.sees(roomType) {
+		return nil, nil
+	}
+	codec := codecOfType(b.codecs, roomType)
+	if codec == nil {
+		return nil, fmt.Errorf("no codec for rooms of type %v", roomType)
+	}
+	return codec.Decode(roomID, roomB)
+}
+
+// Calls the given function with all rooms seen by this view, in the order of their ids
+//   rooms that cannot be decoded are skipped and reported to the logger
+func (b BoltRoomStorage) ForAll(f func(room RoomI)) error {
+	var rooms []RoomI
+	err := b.db.View(func(tx *bolt.Tx) error {
+		roomsB := tx.Bucket([]byte("rooms"))
+		return roomsB.ForEach(func(k, v []byte) error {
+			room, err := b.decodeRoom(string(k), roomsB.Bucket(k))
+			if err != nil {
+				b.logger.Error("database failed to decode room, skipping it", "room", string(k), "err", err)
+			} else if room != nil {
+				rooms = append(rooms, room)
+			}
+			return nil
+		})
+	})
+	// outside of the transaction, f might use the storage
+	for _, room := range rooms {
+		f(room)
+	}
+	return err
+}
+
+func (b BoltRoomStorage) SetValidUntil(roomID string, validUntilUnixTime int64) (TemporaryRoom, error) {
+	var changedRoom TemporaryRoom
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		roomIDBytes := []byte(roomID)
+		roomsB := tx.Bucket([]byte("rooms"))
+		expirationsB := tx.Bucket([]byte("expirations"))
+		roomI, err := b.decodeRoom(roomID, roomsB.Bucket(roomIDBytes))
+		if err != nil {
+			return err
+		} //auto rollback
+		room, ok := roomI.(TemporaryRoom)
+		if !ok {
+			return RoomNotFoundError{RoomID: roomID}
+		}
+
+		room.ValidUntilUnixTime = validUntilUnixTime
+		_, err = removeRoomWithExpiration(roomIDBytes, roomsB, expirationsB)
+		if err != nil {
+			return err
+		} //auto rollback
+		changedRoom = room
+		return b.putRoom(roomIDBytes, RoomTypeTemporary, room, roomsB, expirationsB)
+	})
+	return changedRoom, err
+}
+
+// Removes all expired rooms seen by this view and returns the next to expire (or nil)
+//   only the expirations of the types seen by this view are walked
+//   the callback is called within the transaction and must not use the storage
+func (b BoltRoomStorage) CleanExpiredRooms(removedCallback func(room RoomI)) (RoomI, error) {
+	var nextToExpire RoomI
+	var nextExpiryKey []byte
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		roomsB := tx.Bucket([]byte("rooms"))
+		expirationsB := tx.Bucket([]byte("expirations"))
+		for _, roomType := range b.expiringTypes(expirationsB) {
+			next, expiryKey, err := b.cleanExpiredOfType(roomType, roomsB, expirationsB, removedCallback)
+			if err != nil {
+				return err
+			} //auto rollback
+			if next != nil && (nextToExpire == nil || bytes.Compare(expiryKey, nextExpiryKey) < 0) {
+				nextToExpire, nextExpiryKey = next, expiryKey
+			}
+		}
+		return nil
+	})
+	return nextToExpire, err
+}
+
+// The types seen by this view that have expirations
+//bucket must be in at least a View context
+func (b BoltRoomStorage) expiringTypes(expirationsB *bolt.Bucket) []string {
+	var roomTypes []string
+	_ = expirationsB.ForEach(func(k, _ []byte) error {
+		if b.sees(string(k)) {
+			roomTypes = append(roomTypes, string(k))
+		}
+		return nil
+	})
+	return roomTypes
+}
+
+// Removes the expired rooms of the given type, returns the next of them to expire and its expiry key
+//must be in UPDATE context
+func (b BoltRoomStorage) cleanExpiredOfType(
+	roomType string, roomsB, expirationsB *bolt.Bucket, removedCallback func(room RoomI),
+) (RoomI, []byte, error) {
+	typeB := expirationsB.Bucket([]byte(roomType))
+
+	// collected (and copied) first, the expirations bucket is changed while removing
+	nowBytes := int64ToBytes(time.Now().Unix())
+	expired := map[string][][]byte{} // expiry key -> room ids
+	var expiryKeys [][]byte
+	c := typeB.Cursor()
+	for k, v := c.First(); k != nil && bytes.Compare(k, nowBytes) <= 0; k, v = c.Next() {
+		if v != nil {
+			return nil, nil, fmt.Errorf("corrupted expirations bucket, expected only sub buckets (v == nil)")
+		}
+		expiryKey := append([]byte{}, k...)
+		expiryKeys = append(expiryKeys, expiryKey)
+		for _, roomIDBytes := range decodeExpiredRoomIDsBytes(typeB.Bucket(k)) {
+			expired[string(expiryKey)] = append(expired[string(expiryKey)], append([]byte{}, roomIDBytes...))
+		}
+	}
+
+	for _, expiryKey := range expiryKeys {
+		for _, roomIDBytes := range expired[string(expiryKey)] {
+			room, err := b.decodeRoom(string(roomIDBytes), roomsB.Bucket(roomIDBytes))
+			if err != nil {
+				return nil, nil, err
+			} //auto rollback
+			if room == nil { // stale index entry
+				err = removeFromExpiration(roomIDBytes, expiryKey, typeB)
+			} else {
+				_, err = removeRoomWithExpiration(roomIDBytes, roomsB, expirationsB)
+			}
+			if err != nil {
+				return nil, nil, err
+			} //auto rollback
+			if room != nil {
+				removedCallback(room)
+			}
+		}
+	}
+
+	c = typeB.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		for _, roomIDBytes := range decodeExpiredRoomIDsBytes(typeB.Bucket(k)) {
+			next, err := b.decodeRoom(string(roomIDBytes), roomsB.Bucket(roomIDBytes))
+			if next != nil || err != nil {
+				return next, append([]byte{}, k...), err
+			}
+		}
+	}
+	return nil, nil, nil
+}
+
+// Removes all expired temporary rooms seen by this view and returns the next temporary room to expire (or nil)
+//   see TemporaryRoomStorageI, expired rooms of other types are left for CleanExpiredRooms
+func (b BoltRoomStorage) CleanExpired(removedCallback func(*TemporaryRoom)) (*TemporaryRoom, error) {
+	temporaries := b.view()
+	if b.sees(RoomTypeTemporary) {
+		temporaries = b.view(RoomTypeTemporary)
+	}
+	next, err := temporaries.CleanExpiredRooms(func(room RoomI) {
+		removed := room.(TemporaryRoom)
+		removedCallback(&removed)
+	})
+	if nextRoom, ok := next.(TemporaryRoom); ok {
+		return &nextRoom, err
+	}
+	return nil, err
+}
+
+// The storages opened from the config, by db path - bolt allows a file to be opened only once
+//   the registry holds no reference, the database is closed once all views handed out for it are closed
+var sharedRoomBoltStorages = struct {
+	mut    sync.Mutex
+	byPath map[string]BoltRoomStorage
+}{byPath: map[string]BoltRoomStorage{}}
+
+// Returns a view of the rooms of the given type in the database of [rooms_db] (db_path)
+//   the database is opened once and shared by all editors created from the same config,
+//   it is closed once all of them are closed (reopened if another editor is created afterwards)
+//   if the config has a [rooms_sql] section instead, the rooms are kept in that sql database (see NewRoomSQLStorageFromCFG)
+//   if it has neither, the rooms are held in memory (NewMutableRamRoomStorage)
+func RoomStorageFromCFG(cfg *ini.File, roomType string) RoomStorageI {
+	if _, err := cfg.GetSection("rooms_db"); err != nil {
+		if _, err := cfg.GetSection("rooms_sql"); err == nil {
+			return NewRoomSQLStorageFromCFG(cfg, roomType)
+		}
+		return NewMutableRamRoomStorage()
+	}
+	dbPath := cfg.Section("rooms_db").Key("db_path").String()
+	if len(dbPath) == 0 {
+		panic("Could not load [rooms_db], db_path missing")
+	}
+
+	sharedRoomBoltStorages.mut.Lock()
+	defer sharedRoomBoltStorages.mut.Unlock()
+	if storage, ok := sharedRoomBoltStorages.byPath[dbPath]; ok {
+		if view, acquired := storage.acquireView(roomType); acquired {
+			return view
+		}
+	}
+	storage, err := NewRoomBoltStorage(dbPath)
+	if err != nil {
+		panic("Could not load [rooms_db]: " + err.Error())
+	}
+	storage.SetLogger(logging.NewFromCFG(cfg))
+	sharedRoomBoltStorages.byPath[dbPath] = storage
+	return storage.view(roomType) // holds the reference of the opened storage
+}
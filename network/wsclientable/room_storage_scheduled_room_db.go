@@ -0,0 +1,175 @@
+package wsclientable
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jokrey/utility-algorithms-golang/network/logging"
+	bolt "go.etcd.io/bbolt"
+)
+
+//IDEA:
+//  ScheduledRooms can be held in a database, one bucket per room.
+//  The schedule is stored as given (timezone, rules, excluded dates) and parsed again when the room is decoded
+//
+//  Database Model ('-' denotes bolt buckets, '->' denotes key-value pair):
+//     - "rooms": (MANY sub buckets)
+//        - <roomID>
+//          <encoded room base and schedule, see encodeScheduledRoomIntoBucket>
+//  Before schema version 1 the room buckets were kept at the top level (see 'room_storage_bolt_schema.go')
+//
+// Only works for ScheduledRoom. When adding anything else, this code will panic.
+
+var scheduledRoomBoltSchema = boltSchema{
+	layout: "scheduled_rooms",
+	migrations: []func(tx *bolt.Tx) error{
+		moveTopLevelBucketsInto("rooms"), // 0 -> 1
+	},
+}
+
+type BoltScheduledRoomStorage struct {
+	db     *bolt.DB
+	logger *sharedLogger
+}
+
+// Panics if the database cannot be opened, see OpenScheduledRoomBoltStorage
+func NewScheduledRoomBoltStorage(dbPath string) BoltScheduledRoomStorage {
+	storage, err := OpenScheduledRoomBoltStorage(dbPath)
+	if err != nil {
+		panic(err)
+	}
+	return storage
+}
+
+// Opens (or creates and migrates) the database, fails with BoltSchemaTooNewError if a newer version of the server wrote it
+func OpenScheduledRoomBoltStorage(dbPath string) (BoltScheduledRoomStorage, error) {
+	db, err := openBoltWithSchema(dbPath, scheduledRoomBoltSchema)
+	if err != nil {
+		return BoltScheduledRoomStorage{}, err
+	}
+
+	return BoltScheduledRoomStorage{db: db, logger: &sharedLogger{logging.Nop()}}, nil
+}
+
+// Sets the logger used to report database errors (default: logging.Nop())
+func (b BoltScheduledRoomStorage) SetLogger(logger logging.Logger) {
+	b.logger.Logger = logging.OrNop(logger)
+}
+
+func (b BoltScheduledRoomStorage) Close() error {
+	return b.db.Close()
+}
+func (b BoltScheduledRoomStorage) Put(roomI RoomI, allowOverride bool) error {
+	room := roomI.(ScheduledRoom)
+	return b.db.Update(func(tx *bolt.Tx) error {
+		roomIDBytes := []byte(room.GetID())
+
+		var err error
+		var roomB *bolt.Bucket
+		if allowOverride {
+			roomB, err = tx.Bucket([]byte("rooms")).CreateBucketIfNotExists(roomIDBytes)
+			if err != nil {
+				return err
+			} //auto rollback
+		} else {
+			roomB, err = tx.Bucket([]byte("rooms")).CreateBucket(roomIDBytes)
+			if err != nil {
+				if err == bolt.ErrBucketExists {
+					return fmt.Errorf("room already exists")
+				}
+				return err
+			} //auto rollback
+		}
+		err = encodeScheduledRoomIntoBucket(room, roomB)
+		if err != nil {
+			return err
+		} //auto rollback
+
+		return nil
+	})
+}
+
+func (b BoltScheduledRoomStorage) Remove(roomID string) (bool, error) {
+	previouslyExisted := true
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket([]byte("rooms")).DeleteBucket([]byte(roomID))
+		if err == bolt.ErrBucketNotFound {
+			previouslyExisted = false
+			return nil
+		}
+		return err
+	})
+	return previouslyExisted, err
+}
+
+func (b BoltScheduledRoomStorage) Get(roomID string) RoomI {
+	var decodedRoom RoomI
+	err := b.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("rooms")).Bucket([]byte(roomID))
+		if b == nil {
+			decodedRoom = nil
+			return nil
+		}
+
+		room, err := decodeScheduledRoomFromBucket(roomID, b)
+		if err != nil {
+			return err
+		}
+		decodedRoom = room
+
+		return nil
+	})
+	if err != nil {
+		b.logger.Error("database failed Get, returning nil", "room", roomID, "err", err)
+		return nil
+	}
+	return decodedRoom
+}
+
+func (b BoltScheduledRoomStorage) ForAll(f func(room RoomI)) error {
+	var rooms []RoomI
+	err := b.db.View(func(tx *bolt.Tx) error {
+		roomsB := tx.Bucket([]byte("rooms"))
+		return roomsB.ForEach(func(k, v []byte) error {
+			room, err := decodeScheduledRoomFromBucket(string(k), roomsB.Bucket(k))
+			if err != nil {
+				b.logger.Error("database failed to decode room, skipping it", "room", string(k), "err", err)
+				return nil
+			}
+			rooms = append(rooms, room)
+			return nil
+		})
+	})
+	// outside of the transaction, f might use the storage
+	for _, room := range rooms {
+		f(room)
+	}
+	return err
+}
+
+//bucket must be in a Update context
+func encodeScheduledRoomIntoBucket(room ScheduledRoom, roomB *bolt.Bucket) error {
+	err := encodeRoomBaseIntoBucket(room.Room, roomB)
+	if err != nil {
+		return err
+	} //auto rollback
+
+	encodedSchedule, err := json.Marshal(room.Schedule)
+	if err != nil {
+		return err
+	} //auto rollback
+	return roomB.Put([]byte("Schedule"), encodedSchedule)
+}
+
+//bucket must be in at least a View context
+//  fails if the stored schedule cannot be parsed anymore (for example if the timezone is unknown on this host)
+func decodeScheduledRoomFromBucket(roomID string, roomB *bolt.Bucket) (ScheduledRoom, error) {
+	var schedule Schedule
+	if err := json.Unmarshal(roomB.Get([]byte("Schedule")), &schedule); err != nil {
+		return ScheduledRoom{}, fmt.Errorf("could not decode schedule of room(%v): %w", roomID, err)
+	}
+
+	return ScheduledRoom{
+		Room:     decodeRoomBaseFromBucket(roomID, roomB),
+		Schedule: schedule,
+	}, nil
+}
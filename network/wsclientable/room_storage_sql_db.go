@@ -0,0 +1,477 @@
+package wsclientable
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/jokrey/utility-algorithms-golang/network/logging"
+	"gopkg.in/ini.v1"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//IDEA:
+//  Rooms can be held in a relational database (anything with a database/sql driver), so several services can manage them.
+//  Each room is one row, its fields are a json document (see 'room_json.go'):
+//     wsclientable_rooms:
+//        id          VARCHAR(256) PRIMARY KEY
+//        type        VARCHAR(64)  (type tag, see RoomCodecI)
+//        valid_until BIGINT       (unix time after which the room is invalid, NULL if it never expires, see ExpiringRoomI)
+//        data        TEXT         (json document of the room)
+//     with an index on valid_until, so CleanExpired only reads the expired rooms and the next one to expire
+//  The schema is created and migrated when the storage is opened:
+//     wsclientable_rooms_schema holds the version of the schema, sqlRoomSchemaMigrations upgrade it step by step.
+//     Opening a database with a newer schema than this code knows fails.
+//  Like BoltRoomStorage, controllers get views of the storage that only see rooms of their type (see OfTypes).
+//  The driver has to be registered by the program, for example: import _ "modernc.org/sqlite"
+//     this module does not depend on a driver, the tests run against sqlite in their own module (network/wsclientable_sqltest)
+//  Storages opened from the config share one database per driver and dsn, so the schema is migrated once (see NewRoomSQLStorageFromCFG)
+
+// The differences between sql databases that matter to SQLRoomStorage
+type SQLDialect struct {
+	// Whether placeholders are numbered ($1, $2, ...), otherwise they are ?
+	NumberedPlaceholders bool
+}
+
+var (
+	SQLiteDialect   = SQLDialect{}
+	MySQLDialect    = SQLDialect{}
+	PostgresDialect = SQLDialect{NumberedPlaceholders: true}
+)
+
+// Returns the dialect of the given driver name (sqlite, sqlite3, mysql, postgres, pgx), SQLiteDialect if unknown
+func SQLDialectOf(driverName string) SQLDialect {
+	switch driverName {
+	case "postgres", "pgx":
+		return PostgresDialect
+	case "mysql":
+		return MySQLDialect
+	}
+	return SQLiteDialect
+}
+
+// Replaces the ? placeholders of the query with the placeholders of the dialect
+func (d SQLDialect) rebind(query string) string {
+	if !d.NumberedPlaceholders {
+		return query
+	}
+	var rebound strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			rebound.WriteString("$" + strconv.Itoa(n))
+		} else {
+			rebound.WriteRune(c)
+		}
+	}
+	return rebound.String()
+}
+
+// The migrations of the schema, the statements at index i upgrade version i to i+1
+//   applied migrations must never change, new ones are appended
+var sqlRoomSchemaMigrations = [][]string{
+	{
+		"CREATE TABLE wsclientable_rooms (" +
+			"id VARCHAR(256) NOT NULL PRIMARY KEY, " +
+			"type VARCHAR(64) NOT NULL, " +
+			"valid_until BIGINT NULL, " +
+			"data TEXT NOT NULL)",
+		"CREATE INDEX wsclientable_rooms_valid_until ON wsclientable_rooms (valid_until)",
+	},
+}
+
+// Returned when the schema of the database is newer than the code opening it
+type SQLSchemaTooNewError struct {
+	Version, SupportedVersion int
+}
+
+func (e SQLSchemaTooNewError) Error() string {
+	return fmt.Sprintf("room database schema version %v is newer than the supported version %v, update the server", e.Version, e.SupportedVersion)
+}
+
+type SQLRoomStorage struct {
+	db       *sql.DB
+	closer   *sharedCloser // shared with the views
+	released *sync.Once    // of this storage or view, so closing it twice releases the database once
+	dialect  SQLDialect
+	logger   *sharedLogger
+	codecs   []RoomCodecI
+	types    []string // the types seen by this view, nil if all are seen (see OfTypes)
+}
+
+// Creates or migrates the schema in the given database, rooms of custom types can be stored with the given codecs
+//   a custom codec for a built in type tag replaces the built in codec
+//   the storage owns the database, it is closed once the storage and all of its views are closed
+//   if the schema cannot be migrated (for example SQLSchemaTooNewError) the error is returned and the database is left open
+func NewRoomSQLStorage(db *sql.DB, dialect SQLDialect, customCodecs ...RoomCodecI) (SQLRoomStorage, error) {
+	if err := migrateSQLRoomSchema(db, dialect); err != nil {
+		return SQLRoomStorage{}, err
+	}
+	return SQLRoomStorage{
+		db: db, closer: newSharedCloser(db.Close), released: &sync.Once{},
+		dialect: dialect, logger: &sharedLogger{logging.Nop()}, codecs: withBuiltinRoomCodecs(customCodecs),
+	}, nil
+}
+
+// The storages opened from the config, by driver and dsn - so the schema is migrated once and the connection pool is shared
+//   the registry holds no reference, the database is closed once all views handed out for it are closed
+var sharedRoomSQLStorages = struct {
+	mut   sync.Mutex
+	byDSN map[[2]string]SQLRoomStorage
+}{byDSN: map[[2]string]SQLRoomStorage{}}
+
+// Returns a view of the rooms of the given type in the database of [rooms_sql] (driver, dsn)
+//   the driver has to be registered by the program
+//   the database is opened once and shared by all editors created from the same config,
+//   it is closed once all of them are closed (reopened if another editor is created afterwards)
+func NewRoomSQLStorageFromCFG(cfg *ini.File, roomType string) SQLRoomStorage {
+	driverName := cfg.Section("rooms_sql").Key("driver").String()
+	dsn := cfg.Section("rooms_sql").Key("dsn").String()
+	key := [2]string{driverName, dsn}
+
+	sharedRoomSQLStorages.mut.Lock()
+	defer sharedRoomSQLStorages.mut.Unlock()
+	if storage, ok := sharedRoomSQLStorages.byDSN[key]; ok {
+		if view, acquired := storage.acquireView(roomType); acquired {
+			return view
+		}
+	}
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		panic("Could not load [rooms_sql]: " + err.Error())
+	}
+	storage, err := NewRoomSQLStorage(db, SQLDialectOf(driverName))
+	if err != nil {
+		_ = db.Close()
+		panic("Could not load [rooms_sql]: " + err.Error())
+	}
+	storage.SetLogger(logging.NewFromCFG(cfg))
+	sharedRoomSQLStorages.byDSN[key] = storage
+	return storage.view(roomType) // holds the reference of the opened storage
+}
+
+// Returns the version of the schema in the database, 0 if it was not created yet
+func SQLRoomSchemaVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow("SELECT version FROM wsclientable_rooms_schema").Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return version, err
+}
+
+func migrateSQLRoomSchema(db *sql.DB, dialect SQLDialect) error {
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS wsclientable_rooms_schema (version INTEGER NOT NULL)"); err != nil {
+		return err
+	}
+	version, err := SQLRoomSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if version > len(sqlRoomSchemaMigrations) {
+		return SQLSchemaTooNewError{Version: version, SupportedVersion: len(sqlRoomSchemaMigrations)}
+	}
+	for ; version < len(sqlRoomSchemaMigrations); version++ {
+		err := inSQLTx(db, func(tx *sql.Tx) error {
+			for _, statement := range sqlRoomSchemaMigrations[version] {
+				if _, err := tx.Exec(statement); err != nil {
+					return fmt.Errorf("could not migrate room database to version %v: %w", version+1, err)
+				}
+			}
+			if version == 0 {
+				_, err := tx.Exec(dialect.rebind("INSERT INTO wsclientable_rooms_schema (version) VALUES (?)"), version+1)
+				return err
+			}
+			// only one service migrates, the others fail and open the storage again
+			result, err := tx.Exec(dialect.rebind("UPDATE wsclientable_rooms_schema SET version = ? WHERE version = ?"), version+1, version)
+			if err != nil {
+				return err
+			}
+			if updated, err := result.RowsAffected(); err == nil && updated != 1 {
+				return fmt.Errorf("room database was migrated concurrently")
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func inSQLTx(db *sql.DB, f func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Returns a view of the storage that only sees and accepts rooms of the given types
+//   the view has to be closed like the storage, the database is closed once all of them are
+func (s SQLRoomStorage) OfTypes(roomTypes ...string) SQLRoomStorage {
+	view, _ := s.acquireView(roomTypes...)
+	return view
+}
+
+// Same as OfTypes, false if the database was closed already (closing the view then does nothing)
+func (s SQLRoomStorage) acquireView(roomTypes ...string) (SQLRoomStorage, bool) {
+	view := s.view(roomTypes...)
+	view.released = &sync.Once{}
+	acquired := view.closer.acquire()
+	if !acquired {
+		view.released.Do(func() {})
+	}
+	return view, acquired
+}
+
+// Returns a view that shares the reference of this storage, for use within the storage
+func (s SQLRoomStorage) view(roomTypes ...string) SQLRoomStorage {
+	s.types = append([]string{}, roomTypes...)
+	return s
+}
+
+func (s SQLRoomStorage) sees(roomType string) bool {
+	if s.types == nil {
+		return true
+	}
+	for _, seen := range s.types {
+		if seen == roomType {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the condition (and its arguments) that restricts a query to the types of this view
+func (s SQLRoomStorage) typeCondition() (string, []interface{}) {
+	if s.types == nil {
+		return "1 = 1", nil
+	}
+	if len(s.types) == 0 {
+		return "1 = 0", nil
+	}
+	args := make([]interface{}, len(s.types))
+	for i, roomType := range s.types {
+		args[i] = roomType
+	}
+	return "type IN (?" + strings.Repeat(", ?", len(s.types)-1) + ")", args
+}
+
+// Sets the logger used to report database errors (default: logging.Nop())
+func (s SQLRoomStorage) SetLogger(logger logging.Logger) {
+	s.logger.Logger = logging.OrNop(logger)
+}
+
+// Releases this storage (or view), the database is closed once the storage and all of its views are released
+func (s SQLRoomStorage) Close() error {
+	var err error
+	s.released.Do(func() {
+		err = s.closer.release()
+	})
+	return err
+}
+
+// Returns the type tag under which the room would be stored, false if no codec handles it
+func (s SQLRoomStorage) RoomTypeOf(room RoomI) (string, bool) {
+	if codec := roomCodecOf(s.codecs, room); codec != nil {
+		return codec.RoomType(), true
+	}
+	return "", false
+}
+
+// Returns the values of the type, valid_until and data columns of the room
+func (s SQLRoomStorage) encodeRoom(room RoomI) (string, sql.NullInt64, string, error) {
+	codec := roomCodecOf(s.codecs, room)
+	if codec == nil {
+		return "", sql.NullInt64{}, "", fmt.Errorf("no codec for rooms of type %T", room)
+	}
+	if !s.sees(codec.RoomType()) {
+		return "", sql.NullInt64{}, "", fmt.Errorf("rooms of type %v are not stored in this view", codec.RoomType())
+	}
+	data, err := codec.Encode(room)
+	if err != nil {
+		return "", sql.NullInt64{}, "", err
+	}
+	expiry, expires := ExpiryOf(room)
+	return codec.RoomType(), sql.NullInt64{Int64: expiry, Valid: expires}, string(data), nil
+}
+
+func (s SQLRoomStorage) decodeRoom(roomID, roomType, data string) (RoomI, error) {
+	codec := roomCodecOfType(s.codecs, roomType)
+	if codec == nil {
+		return nil, fmt.Errorf("no codec for rooms of type %v", roomType)
+	}
+	return codec.Decode(roomID, json.RawMessage(data))
+}
+
+func (s SQLRoomStorage) Put(room RoomI, allowOverride bool) error {
+	roomType, validUntil, data, err := s.encodeRoom(room)
+	if err != nil {
+		return err
+	}
+	return inSQLTx(s.db, func(tx *sql.Tx) error {
+		var existingType string
+		err := tx.QueryRow(s.dialect.rebind("SELECT type FROM wsclientable_rooms WHERE id = ?"), room.GetID()).Scan(&existingType)
+		if err == sql.ErrNoRows {
+			_, err = tx.Exec(s.dialect.rebind("INSERT INTO wsclientable_rooms (id, type, valid_until, data) VALUES (?, ?, ?, ?)"),
+				room.GetID(), roomType, validUntil, data)
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if !allowOverride {
+			return fmt.Errorf("room already exists")
+		}
+		if !s.sees(existingType) {
+			return fmt.Errorf("room already exists with type %v", existingType)
+		}
+		_, err = tx.Exec(s.dialect.rebind("UPDATE wsclientable_rooms SET type = ?, valid_until = ?, data = ? WHERE id = ?"),
+			roomType, validUntil, data, room.GetID())
+		return err
+	})
+}
+
+func (s SQLRoomStorage) Remove(roomID string) (bool, error) {
+	condition, args := s.typeCondition()
+	result, err := s.db.Exec(s.dialect.rebind("DELETE FROM wsclientable_rooms WHERE id = ? AND "+condition), append([]interface{}{roomID}, args...)...)
+	if err != nil {
+		return false, err
+	}
+	removed, err := result.RowsAffected()
+	return removed > 0, err
+}
+
+func (s SQLRoomStorage) Get(roomID string) RoomI {
+	condition, args := s.typeCondition()
+	var roomType, data string
+	err := s.db.QueryRow(s.dialect.rebind("SELECT type, data FROM wsclientable_rooms WHERE id = ? AND "+condition),
+		append([]interface{}{roomID}, args...)...).Scan(&roomType, &data)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	var room RoomI
+	if err == nil {
+		room, err = s.decodeRoom(roomID, roomType, data)
+	}
+	if err != nil {
+		s.logger.Error("database failed Get, returning nil", "room", roomID, "err", err)
+		return nil
+	}
+	return room
+}
+
+// Calls the given function with all rooms seen by this view, in the order of their ids
+//   rooms that cannot be decoded are skipped and reported to the logger
+func (s SQLRoomStorage) ForAll(f func(room RoomI)) error {
+	condition, args := s.typeCondition()
+	rooms, err := s.queryRooms("SELECT id, type, data FROM wsclientable_rooms WHERE "+condition+" ORDER BY id", args...)
+	for _, room := range rooms {
+		f(room)
+	}
+	return err
+}
+
+// Returns the decoded rooms of a query for id, type and data - rooms that cannot be decoded are skipped
+func (s SQLRoomStorage) queryRooms(query string, args ...interface{}) ([]RoomI, error) {
+	rows, err := s.db.Query(s.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	var rooms []RoomI
+	for rows.Next() {
+		var roomID, roomType, data string
+		if err := rows.Scan(&roomID, &roomType, &data); err != nil {
+			return rooms, err
+		}
+		room, err := s.decodeRoom(roomID, roomType, data)
+		if err != nil {
+			s.logger.Error("database failed to decode room, skipping it", "room", roomID, "err", err)
+			continue
+		}
+		rooms = append(rooms, room)
+	}
+	return rooms, rows.Err()
+}
+
+func (s SQLRoomStorage) SetValidUntil(roomID string, validUntilUnixTime int64) (TemporaryRoom, error) {
+	var changedRoom TemporaryRoom
+	err := inSQLTx(s.db, func(tx *sql.Tx) error {
+		var roomType, data string
+		err := tx.QueryRow(s.dialect.rebind("SELECT type, data FROM wsclientable_rooms WHERE id = ?"), roomID).Scan(&roomType, &data)
+		if err == sql.ErrNoRows || (err == nil && (roomType != RoomTypeTemporary || !s.sees(roomType))) {
+			return RoomNotFoundError{RoomID: roomID}
+		}
+		if err != nil {
+			return err
+		}
+		room, err := s.decodeRoom(roomID, roomType, data)
+		if err != nil {
+			return err
+		}
+		changedRoom = room.(TemporaryRoom)
+		changedRoom.ValidUntilUnixTime = validUntilUnixTime
+		_, validUntil, changedData, err := s.encodeRoom(changedRoom)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(s.dialect.rebind("UPDATE wsclientable_rooms SET valid_until = ?, data = ? WHERE id = ?"), validUntil, changedData, roomID)
+		return err
+	})
+	return changedRoom, err
+}
+
+// Removes all expired rooms seen by this view and returns the next to expire (or nil)
+//   a room is only removed (and reported) if it was not changed in the meantime, for example by another service
+func (s SQLRoomStorage) CleanExpiredRooms(removedCallback func(room RoomI)) (RoomI, error) {
+	condition, args := s.typeCondition()
+	now := time.Now().Unix()
+	expired, err := s.queryRooms("SELECT id, type, data FROM wsclientable_rooms WHERE valid_until <= ? AND "+condition+" ORDER BY valid_until",
+		append([]interface{}{now}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	for _, room := range expired {
+		expiry, _ := ExpiryOf(room)
+		result, err := s.db.Exec(s.dialect.rebind("DELETE FROM wsclientable_rooms WHERE id = ? AND valid_until = ?"), room.GetID(), expiry)
+		if err != nil {
+			return nil, err
+		}
+		if removed, err := result.RowsAffected(); err == nil && removed > 0 {
+			removedCallback(room)
+		}
+	}
+
+	next, err := s.queryRooms("SELECT id, type, data FROM wsclientable_rooms WHERE valid_until > ? AND "+condition+" ORDER BY valid_until LIMIT 1",
+		append([]interface{}{now}, args...)...)
+	if err != nil || len(next) == 0 {
+		return nil, err
+	}
+	return next[0], nil
+}
+
+// Removes all expired temporary rooms seen by this view and returns the next temporary room to expire (or nil)
+//   see TemporaryRoomStorageI, expired rooms of other types are left for CleanExpiredRooms
+func (s SQLRoomStorage) CleanExpired(removedCallback func(*TemporaryRoom)) (*TemporaryRoom, error) {
+	temporaries := s.view()
+	if s.sees(RoomTypeTemporary) {
+		temporaries = s.view(RoomTypeTemporary)
+	}
+	next, err := temporaries.CleanExpiredRooms(func(room RoomI) {
+		removed := room.(TemporaryRoom)
+		removedCallback(&removed)
+	})
+	if nextRoom, ok := next.(TemporaryRoom); ok {
+		return &nextRoom, err
+	}
+	return nil, err
+}
@@ -0,0 +1,166 @@
+package wsclientable
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"github.com/jokrey/utility-algorithms-golang/network/logging"
+	bolt "go.etcd.io/bbolt"
+)
+
+//IDEA:
+//  The shared state of rooms (see 'room_state.go') can be held in a database, so that it survives restarts
+//     like the rooms of the persisted controllers. It is kept in its own file, next to the database of the rooms.
+//
+//  Database Model ('-' denotes bolt buckets, '->' denotes key-value pair):
+//     - "states": (MANY sub buckets)
+//        - <roomID>
+//            "version" -> <version of the room, 8 bytes>
+//            - "entries"
+//               <key-1> -> <version of the key, 8 bytes><json value>
+//               <key-2> -> ...
+//               etc...
+
+var roomStateBoltSchema = boltSchema{
+	layout: "room_states",
+	migrations: []func(tx *bolt.Tx) error{
+		createBoltBuckets("states"), // 0 -> 1
+	},
+}
+
+type BoltRoomStateStorage struct {
+	db     *bolt.DB
+	logger *sharedLogger
+}
+
+// Opens (or creates and migrates) the database, see 'room_storage_bolt_schema.go'
+func NewRoomStateBoltStorage(dbPath string) (BoltRoomStateStorage, error) {
+	db, err := openBoltWithSchema(dbPath, roomStateBoltSchema)
+	if err != nil {
+		return BoltRoomStateStorage{}, err
+	}
+
+	return BoltRoomStateStorage{db: db, logger: &sharedLogger{logging.Nop()}}, nil
+}
+
+// Sets the logger used to report database errors (default: logging.Nop())
+func (b BoltRoomStateStorage) SetLogger(logger logging.Logger) {
+	b.logger.Logger = logging.OrNop(logger)
+}
+
+func (b BoltRoomStateStorage) Close() error {
+	return b.db.Close()
+}
+
+func (b BoltRoomStateStorage) Snapshot(roomID string) (RoomStateSnapshot, error) {
+	snapshot := RoomStateSnapshot{Entries: make(map[string]RoomStateEntry)}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		roomB := tx.Bucket([]byte("states")).Bucket([]byte(roomID))
+		if roomB == nil {
+			return nil
+		}
+		snapshot.Version = binary.BigEndian.Uint64(roomB.Get([]byte("version")))
+		return roomB.Bucket([]byte("entries")).ForEach(func(k, v []byte) error {
+			snapshot.Entries[string(k)] = decodeRoomStateEntry(v)
+			return nil
+		})
+	})
+	if err != nil {
+		b.logger.Error("database failed Snapshot", "room", roomID, "err", err)
+	}
+	return snapshot, err
+}
+
+func (b BoltRoomStateStorage) Set(roomID, key string, value json.RawMessage, expectedVersion *uint64) (uint64, error) {
+	var version uint64
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		roomB, entriesB, err := roomStateBuckets(tx, roomID)
+		if err != nil {
+			return err
+		} //auto rollback
+		var current uint64
+		if raw := entriesB.Get([]byte(key)); raw != nil {
+			current = decodeRoomStateEntry(raw).Version
+		}
+		if err := checkRoomStateVersion(roomID, key, current, expectedVersion); err != nil {
+			return err
+		} //auto rollback
+		version, err = incrementRoomStateVersion(roomB)
+		if err != nil {
+			return err
+		} //auto rollback
+		return entriesB.Put([]byte(key), encodeRoomStateEntry(RoomStateEntry{Value: value, Version: version}))
+	})
+	return version, err
+}
+
+func (b BoltRoomStateStorage) Delete(roomID, key string, expectedVersion *uint64) (uint64, bool, error) {
+	var version uint64
+	existed := false
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		roomB := tx.Bucket([]byte("states")).Bucket([]byte(roomID))
+		if roomB == nil {
+			return checkRoomStateVersion(roomID, key, 0, expectedVersion)
+		}
+		version = binary.BigEndian.Uint64(roomB.Get([]byte("version")))
+		entriesB := roomB.Bucket([]byte("entries"))
+		raw := entriesB.Get([]byte(key))
+		var current uint64
+		if raw != nil {
+			current = decodeRoomStateEntry(raw).Version
+		}
+		if err := checkRoomStateVersion(roomID, key, current, expectedVersion); err != nil || raw == nil {
+			return err
+		}
+		err := entriesB.Delete([]byte(key))
+		if err != nil {
+			return err
+		} //auto rollback
+		existed = true
+		version, err = incrementRoomStateVersion(roomB)
+		return err
+	})
+	return version, existed, err
+}
+
+func (b BoltRoomStateStorage) RemoveRoom(roomID string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket([]byte("states")).DeleteBucket([]byte(roomID))
+		if err == bolt.ErrBucketNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
+// Returns the bucket of the room and its entries, both are created if the room has no state yet
+func roomStateBuckets(tx *bolt.Tx, roomID string) (*bolt.Bucket, *bolt.Bucket, error) {
+	roomB, err := tx.Bucket([]byte("states")).CreateBucketIfNotExists([]byte(roomID))
+	if err != nil {
+		return nil, nil, err
+	}
+	if roomB.Get([]byte("version")) == nil {
+		if err := roomB.Put([]byte("version"), make([]byte, 8)); err != nil {
+			return nil, nil, err
+		}
+	}
+	entriesB, err := roomB.CreateBucketIfNotExists([]byte("entries"))
+	return roomB, entriesB, err
+}
+
+func incrementRoomStateVersion(roomB *bolt.Bucket) (uint64, error) {
+	version := binary.BigEndian.Uint64(roomB.Get([]byte("version"))) + 1
+	bs := make([]byte, 8)
+	binary.BigEndian.PutUint64(bs, version)
+	return version, roomB.Put([]byte("version"), bs)
+}
+
+func encodeRoomStateEntry(entry RoomStateEntry) []byte {
+	bs := make([]byte, 8+len(entry.Value))
+	binary.BigEndian.PutUint64(bs, entry.Version)
+	copy(bs[8:], entry.Value)
+	return bs
+}
+func decodeRoomStateEntry(bs []byte) RoomStateEntry {
+	// values returned by bolt are only valid within the transaction
+	return RoomStateEntry{Version: binary.BigEndian.Uint64(bs), Value: append(json.RawMessage{}, bs[8:]...)}
+}
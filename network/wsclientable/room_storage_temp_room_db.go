@@ -3,9 +3,10 @@ package wsclientable
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"github.com/jokrey/utility-algorithms-golang/network/logging"
 	bolt "go.etcd.io/bbolt"
-	"log"
 	"time"
 )
 
@@ -20,6 +21,7 @@ import (
 //            <ClientID-1> -> ""
 //            <ClientID-2> -> ""
 //			  etc...
+//          "MaxParticipants" -> <MaxParticipants>
 //          "ValidFromUnixTime" -> <ValidFromUnixTime>
 //          "ValidUntilUnixTime" -> <ValidUntilUnixTime>
 //     - "expirations" (MANY sub buckets (SORTED, i.e. earliest first))
@@ -33,6 +35,13 @@ import (
 //
 // Only works for TemporaryRooms. When adding anything else, this code will panic.
 
+var temporaryRoomBoltSchema = boltSchema{
+	layout: "temporary_rooms",
+	migrations: []func(tx *bolt.Tx) error{
+		createBoltBuckets("rooms", "expirations"), // 0 -> 1, files of older versions already have both
+	},
+}
+
 type TemporaryRoomStorageI interface {
 	RoomStorageI
 
@@ -40,31 +49,39 @@ type TemporaryRoomStorageI interface {
 	// (based on RoomI.IsValid OR some specific logic in case the room storage is specific to a room-type)
 	// Returns the next room that will expire or nil and an error
 	CleanExpired(removedCallback func(*TemporaryRoom)) (*TemporaryRoom, error)
+
+	// Changes only the end of the time frame of the room (and its expiration), all other properties are kept
+	// Returns the changed room, or RoomNotFoundError if the room does not exist
+	SetValidUntil(roomID string, validUntilUnixTime int64) (TemporaryRoom, error)
 }
 
 type BoltTemporaryRoomStorage struct {
-	db *bolt.DB
+	db     *bolt.DB
+	logger *sharedLogger
 }
 
+// Panics if the database cannot be opened, see OpenTemporaryRoomBoltStorage
 func NewTemporaryRoomBoltStorage(dbPath string) BoltTemporaryRoomStorage {
-	db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	storage, err := OpenTemporaryRoomBoltStorage(dbPath)
 	if err != nil {
 		panic(err)
 	}
+	return storage
+}
 
-	err = db.Update(func(tx *bolt.Tx) error {
-		_, err = tx.CreateBucketIfNotExists([]byte("rooms"))       //stores the raw room data (map roomID -> roomData)
-		_, err = tx.CreateBucketIfNotExists([]byte("expirations")) //stores the expirationDate mapped to roomID
-		if err != nil {
-			return err
-		} //auto rollback
-		return nil
-	})
+// Opens (or creates and migrates) the database, fails with BoltSchemaTooNewError if a newer version of the server wrote it
+func OpenTemporaryRoomBoltStorage(dbPath string) (BoltTemporaryRoomStorage, error) {
+	db, err := openBoltWithSchema(dbPath, temporaryRoomBoltSchema)
 	if err != nil {
-		panic(err)
+		return BoltTemporaryRoomStorage{}, err
 	}
 
-	return BoltTemporaryRoomStorage{db: db}
+	return BoltTemporaryRoomStorage{db: db, logger: &sharedLogger{logging.Nop()}}, nil
+}
+
+// Sets the logger used to report database errors (default: logging.Nop())
+func (b BoltTemporaryRoomStorage) SetLogger(logger logging.Logger) {
+	b.logger.Logger = logging.OrNop(logger)
 }
 
 func (b BoltTemporaryRoomStorage) Close() error {
@@ -139,6 +156,46 @@ func removeTemporaryRoomWithExpiration(roomIDBytes []byte, roomsB, expirationsB
 	return true, nil
 }
 
+func (b BoltTemporaryRoomStorage) SetValidUntil(roomID string, validUntilUnixTime int64) (TemporaryRoom, error) {
+	var changedRoom TemporaryRoom
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		roomIDBytes := []byte(roomID)
+		expirationsB := tx.Bucket([]byte("expirations"))
+		roomB := tx.Bucket([]byte("rooms")).Bucket(roomIDBytes)
+		if roomB == nil {
+			return RoomNotFoundError{RoomID: roomID}
+		}
+		changedRoom = decodeTemporaryRoomFromBucket(roomID, roomB)
+
+		oldExpirationKey := int64ToBytes(changedRoom.ValidUntilUnixTime)
+		if oldExpirationB := expirationsB.Bucket(oldExpirationKey); oldExpirationB != nil {
+			err := oldExpirationB.Delete(roomIDBytes)
+			if err != nil {
+				return err
+			} //auto rollback
+			if k, _ := oldExpirationB.Cursor().First(); k == nil {
+				err = expirationsB.DeleteBucket(oldExpirationKey)
+				if err != nil {
+					return err
+				} //auto rollback
+			}
+		}
+
+		changedRoom.ValidUntilUnixTime = validUntilUnixTime
+		err := roomB.Put([]byte("ValidUntilUnixTime"), int64ToBytes(validUntilUnixTime))
+		if err != nil {
+			return err
+		} //auto rollback
+
+		expirationB, err := expirationsB.CreateBucketIfNotExists(int64ToBytes(validUntilUnixTime))
+		if err != nil {
+			return err
+		} //auto rollback
+		return encodeIntoExpiration(changedRoom, expirationB)
+	})
+	return changedRoom, err
+}
+
 func (b BoltTemporaryRoomStorage) Get(roomID string) RoomI {
 	var decodedRoom RoomI
 	err := b.db.View(func(tx *bolt.Tx) error {
@@ -154,12 +211,28 @@ func (b BoltTemporaryRoomStorage) Get(roomID string) RoomI {
 		return nil
 	})
 	if err != nil {
-		log.Printf("database failed Get, returning nil - err: %v", err)
+		b.logger.Error("database failed Get, returning nil", "room", roomID, "err", err)
 		return nil
 	}
 	return decodedRoom
 }
 
+func (b BoltTemporaryRoomStorage) ForAll(f func(room RoomI)) error {
+	var rooms []RoomI
+	err := b.db.View(func(tx *bolt.Tx) error {
+		roomsB := tx.Bucket([]byte("rooms"))
+		return roomsB.ForEach(func(k, v []byte) error {
+			rooms = append(rooms, decodeTemporaryRoomFromBucket(string(k), roomsB.Bucket(k)))
+			return nil
+		})
+	})
+	// outside of the transaction, f might use the storage
+	for _, room := range rooms {
+		f(room)
+	}
+	return err
+}
+
 //func (b BoltTemporaryRoomStorage) GetNextExpiration() *TemporaryRoom {
 //	var decodedRoom *TemporaryRoom
 //	err := b.db.Update(func(tx *bolt.Tx) error {
@@ -179,7 +252,7 @@ func (b BoltTemporaryRoomStorage) Get(roomID string) RoomI {
 //		return nil
 //	})
 //	if err != nil {
-//		log.Printf("database failed Get, returning nil - err: %v", err)
+//		b.logger.Error("database failed Get, returning nil", "room", roomID, "err", err)
 //		return nil
 //	}
 //	return decodedRoom
@@ -198,7 +271,7 @@ func (b BoltTemporaryRoomStorage) CleanExpired(removedCallback func(*TemporaryRo
 		var k, v []byte
 		for k, v = expirationsC.First(); k != nil && bytes.Compare(k, nowBytes) <= 0; k, v = expirationsC.Next() {
 			if v != nil {
-				log.Fatal("if v == nil, value at k is a bucket... This is what we expect here")
+				return fmt.Errorf("corrupted expirations bucket, expected only sub buckets (v == nil)")
 			}
 
 			expirationTime := int64FromBytes(k)
@@ -240,24 +313,24 @@ func (b BoltTemporaryRoomStorage) CleanExpired(removedCallback func(*TemporaryRo
 
 func (b BoltTemporaryRoomStorage) logContents() error {
 	return b.db.View(func(tx *bolt.Tx) error {
-		prettyPrint(tx)
+		prettyPrint(b.logger, tx)
 
 		return nil
 	})
 }
 
-func prettyPrint(tx *bolt.Tx) {
+func prettyPrint(logger logging.Logger, tx *bolt.Tx) {
 	c := tx.Cursor()
 	for k, v := c.First(); k != nil; k, v = c.Next() {
 		if v == nil { //is bucket
-			log.Println("- ", string(k))
-			prettyPrintInner(1, tx.Bucket(k))
+			logger.Debug("- " + string(k))
+			prettyPrintInner(logger, 1, tx.Bucket(k))
 		} else {
-			log.Println(string(k), " -> ", string(v))
+			logger.Debug(string(k) + " -> " + string(v))
 		}
 	}
 }
-func prettyPrintInner(indent int, b *bolt.Bucket) {
+func prettyPrintInner(logger logging.Logger, indent int, b *bolt.Bucket) {
 	indentStr := ""
 	for i := 0; i < indent; i++ {
 		indentStr += " "
@@ -265,10 +338,10 @@ func prettyPrintInner(indent int, b *bolt.Bucket) {
 	c := b.Cursor()
 	for k, v := c.First(); k != nil; k, v = c.Next() {
 		if v == nil { //is bucket
-			log.Println(indentStr, "- ", string(k))
-			prettyPrintInner(indent+1, b.Bucket(k))
+			logger.Debug(indentStr + "- " + string(k))
+			prettyPrintInner(logger, indent+1, b.Bucket(k))
 		} else {
-			log.Println(indentStr, string(k), " -> ", string(v))
+			logger.Debug(indentStr + string(k) + " -> " + string(v))
 		}
 	}
 }
@@ -297,7 +370,50 @@ func encodeIntoExpiration(room TemporaryRoom, expirationB *bolt.Bucket) error {
 
 //bucket must be in a Update context
 func encodeTemporaryRoomIntoBucket(room TemporaryRoom, roomB *bolt.Bucket) error {
-	allowedClientsB, err := roomB.CreateBucketIfNotExists([]byte("allowedClientIDs"))
+	err := encodeRoomBaseIntoBucket(room.Room, roomB)
+	if err != nil {
+		return err
+	} //auto rollback
+
+	err = roomB.Put([]byte("ValidFromUnixTime"), int64ToBytes(room.ValidFromUnixTime))
+	if err != nil {
+		return err
+	} //auto rollback
+	err = roomB.Put([]byte("ValidUntilUnixTime"), int64ToBytes(room.ValidUntilUnixTime))
+	if err != nil {
+		return err
+	} //auto rollback
+
+	return nil //success, no error
+}
+
+//bucket must be in at least a View context
+func decodeTemporaryRoomFromBucket(roomID string, roomB *bolt.Bucket) TemporaryRoom {
+	validFromUnixTime := int64FromBytes(roomB.Get([]byte("ValidFromUnixTime")))
+	validUntilUnixTime := int64FromBytes(roomB.Get([]byte("ValidUntilUnixTime")))
+
+	return TemporaryRoom{
+		Room:               decodeRoomBaseFromBucket(roomID, roomB),
+		ValidFromUnixTime:  validFromUnixTime,
+		ValidUntilUnixTime: validUntilUnixTime,
+	}
+}
+
+// Encodes the properties shared by all rooms, used by all bolt storages
+//   Model (within the bucket of the room):
+//     - "allowedClientIDs" (SET)
+//        <ClientID> -> ""
+//     - "roles" (MAP, only owners and moderators, missing in databases written before it was added)
+//        <ClientID> -> <Role>
+//     "MaxParticipants" -> <MaxParticipants> (missing in databases written before it was added, decoded as 0)
+//     "ForwardingPolicy" -> <json encoded ForwardingPolicy> (missing if the room does not restrict forwarding)
+//     "Lobby" -> 1 (missing if the room has no lobby)
+//     - "userWindows" (MAP, missing in databases written before it was added)
+//        <ClientID> -> <FromUnixTime><UntilUnixTime> (8 bytes each)
+//  Sub buckets are replaced, so that overriding a room does not keep stale entries
+//bucket must be in a Update context
+func encodeRoomBaseIntoBucket(room Room, roomB *bolt.Bucket) error {
+	allowedClientsB, err := recreateBucket(roomB, []byte("allowedClientIDs"))
 	if err != nil {
 		return err
 	} //auto rollback
@@ -308,20 +424,76 @@ func encodeTemporaryRoomIntoBucket(room TemporaryRoom, roomB *bolt.Bucket) error
 		} //auto rollback
 	}
 
-	err = roomB.Put([]byte("ValidFromUnixTime"), int64ToBytes(room.ValidFromUnixTime))
+	rolesB, err := recreateBucket(roomB, []byte("roles"))
 	if err != nil {
 		return err
 	} //auto rollback
-	err = roomB.Put([]byte("ValidUntilUnixTime"), int64ToBytes(room.ValidUntilUnixTime))
+	for userID, role := range room.roles {
+		err = rolesB.Put([]byte(userID), []byte(role))
+		if err != nil {
+			return err
+		} //auto rollback
+	}
+
+	userWindowsB, err := recreateBucket(roomB, []byte("userWindows"))
 	if err != nil {
 		return err
 	} //auto rollback
+	for userID, window := range room.userWindows {
+		err = userWindowsB.Put([]byte(userID), append(int64ToBytes(window.FromUnixTime), int64ToBytes(window.UntilUnixTime)...))
+		if err != nil {
+			return err
+		} //auto rollback
+	}
 
-	return nil //success, no error
+	err = roomB.Put([]byte("MaxParticipants"), int64ToBytes(int64(room.MaxParticipants)))
+	if err != nil {
+		return err
+	} //auto rollback
+
+	if room.Lobby {
+		err = roomB.Put([]byte("Lobby"), []byte{1})
+	} else {
+		err = roomB.Delete([]byte("Lobby"))
+	}
+	if err != nil {
+		return err
+	} //auto rollback
+
+	if room.forwarding.IsZero() {
+		err = roomB.Delete([]byte("ForwardingPolicy"))
+	} else {
+		encodedPolicy, e := json.Marshal(room.forwarding)
+		if e != nil {
+			return e
+		} //auto rollback
+		err = roomB.Put([]byte("ForwardingPolicy"), encodedPolicy)
+	}
+	if err != nil {
+		return err
+	} //auto rollback
+
+	if room.history.IsZero() {
+		return roomB.Delete([]byte("HistoryPolicy"))
+	}
+	encodedHistory, err := json.Marshal(room.history)
+	if err != nil {
+		return err
+	} //auto rollback
+	return roomB.Put([]byte("HistoryPolicy"), encodedHistory)
+}
+
+//bucket must be in a Update context
+func recreateBucket(parent *bolt.Bucket, name []byte) (*bolt.Bucket, error) {
+	err := parent.DeleteBucket(name)
+	if err != nil && err != bolt.ErrBucketNotFound {
+		return nil, err
+	}
+	return parent.CreateBucket(name)
 }
 
 //bucket must be in at least a View context
-func decodeTemporaryRoomFromBucket(roomID string, roomB *bolt.Bucket) TemporaryRoom {
+func decodeRoomBaseFromBucket(roomID string, roomB *bolt.Bucket) Room {
 	var allowedClientIds []string
 	allowedClientsB := roomB.Bucket([]byte("allowedClientIDs"))
 	c := allowedClientsB.Cursor()
@@ -330,16 +502,45 @@ func decodeTemporaryRoomFromBucket(roomID string, roomB *bolt.Bucket) TemporaryR
 		allowedClientIds = append(allowedClientIds, allowedClientId)
 	}
 
-	validFromUnixTime := int64FromBytes(roomB.Get([]byte("ValidFromUnixTime")))
-	validUntilUnixTime := int64FromBytes(roomB.Get([]byte("ValidUntilUnixTime")))
+	maxParticipants := 0
+	if raw := roomB.Get([]byte("MaxParticipants")); raw != nil {
+		maxParticipants = int(int64FromBytes(raw))
+	}
 
-	return TemporaryRoom{
-		Room: Room{
-			ID:             roomID,
-			allowedClients: CreateAllowedIdsMapFromSlice(allowedClientIds),
-		},
-		ValidFromUnixTime:  validFromUnixTime,
-		ValidUntilUnixTime: validUntilUnixTime,
+	roles := make(map[string]Role)
+	if rolesB := roomB.Bucket([]byte("roles")); rolesB != nil {
+		c := rolesB.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			roles[string(k)] = Role(v)
+		}
+	}
+
+	userWindows := make(map[string]UserWindow)
+	if userWindowsB := roomB.Bucket([]byte("userWindows")); userWindowsB != nil {
+		c := userWindowsB.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			userWindows[string(k)] = UserWindow{FromUnixTime: int64FromBytes(v[:8]), UntilUnixTime: int64FromBytes(v[8:])}
+		}
+	}
+
+	var forwarding ForwardingPolicy
+	if raw := roomB.Get([]byte("ForwardingPolicy")); raw != nil {
+		_ = json.Unmarshal(raw, &forwarding) // written by encodeRoomBaseIntoBucket, cannot fail
+	}
+	var history HistoryPolicy
+	if raw := roomB.Get([]byte("HistoryPolicy")); raw != nil {
+		_ = json.Unmarshal(raw, &history) // written by encodeRoomBaseIntoBucket, cannot fail
+	}
+
+	return Room{
+		ID:              roomID,
+		allowedClients:  CreateAllowedIdsMapFromSlice(allowedClientIds),
+		MaxParticipants: maxParticipants,
+		roles:           roles,
+		forwarding:      forwarding,
+		Lobby:           roomB.Get([]byte("Lobby")) != nil,
+		userWindows:     userWindows,
+		history:         history,
 	}
 }
 
@@ -0,0 +1,132 @@
+package wsclientable
+
+import (
+	"github.com/jokrey/utility-algorithms-golang/network/logging"
+	bolt "go.etcd.io/bbolt"
+)
+
+//IDEA:
+//  User groups (see 'room_allow_list.go') can be held in a database, so that they survive restarts.
+//
+//  Database Model ('-' denotes bolt buckets, '->' denotes key-value pair):
+//     - "groups": (MANY sub buckets)
+//        - <groupName> (SET)
+//            <ClientID-1> -> ""
+//            <ClientID-2> -> ""
+//            etc...
+
+var userGroupsBoltSchema = boltSchema{
+	layout: "user_groups",
+	migrations: []func(tx *bolt.Tx) error{
+		createBoltBuckets("groups"), // 0 -> 1
+	},
+}
+
+type BoltUserGroupsStorage struct {
+	db     *bolt.DB
+	logger *sharedLogger
+}
+
+// Opens (or creates and migrates) the database, see 'room_storage_bolt_schema.go'
+func NewUserGroupsBoltStorage(dbPath string) (BoltUserGroupsStorage, error) {
+	db, err := openBoltWithSchema(dbPath, userGroupsBoltSchema)
+	if err != nil {
+		return BoltUserGroupsStorage{}, err
+	}
+
+	return BoltUserGroupsStorage{db: db, logger: &sharedLogger{logging.Nop()}}, nil
+}
+
+// Sets the logger used to report database errors (default: logging.Nop())
+func (b BoltUserGroupsStorage) SetLogger(logger logging.Logger) {
+	b.logger.Logger = logging.OrNop(logger)
+}
+
+func (b BoltUserGroupsStorage) Close() error {
+	return b.db.Close()
+}
+
+func (b BoltUserGroupsStorage) IsMember(group, userID string) bool {
+	isMember := false
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if groupB := tx.Bucket([]byte("groups")).Bucket([]byte(group)); groupB != nil {
+			isMember = groupB.Get([]byte(userID)) != nil
+		}
+		return nil
+	})
+	if err != nil {
+		b.logger.Error("database failed IsMember, returning false", "group", group, "user", userID, "err", err)
+		return false
+	}
+	return isMember
+}
+
+func (b BoltUserGroupsStorage) Members(group string) []string {
+	members := []string{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if groupB := tx.Bucket([]byte("groups")).Bucket([]byte(group)); groupB != nil {
+			c := groupB.Cursor()
+			for k, _ := c.First(); k != nil; k, _ = c.Next() {
+				members = append(members, string(k))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.logger.Error("database failed Members, returning none", "group", group, "err", err)
+		return []string{}
+	}
+	return members
+}
+
+func (b BoltUserGroupsStorage) SetGroup(group string, members []string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		groupsB := tx.Bucket([]byte("groups"))
+		err := groupsB.DeleteBucket([]byte(group))
+		if err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		} //auto rollback
+		if len(members) == 0 {
+			return nil
+		}
+		groupB, err := groupsB.CreateBucket([]byte(group))
+		if err != nil {
+			return err
+		} //auto rollback
+		for _, userID := range members {
+			err = groupB.Put([]byte(userID), []byte{})
+			if err != nil {
+				return err
+			} //auto rollback
+		}
+		return nil
+	})
+}
+
+func (b BoltUserGroupsStorage) AddMember(group, userID string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		groupB, err := tx.Bucket([]byte("groups")).CreateBucketIfNotExists([]byte(group))
+		if err != nil {
+			return err
+		} //auto rollback
+		return groupB.Put([]byte(userID), []byte{})
+	})
+}
+
+func (b BoltUserGroupsStorage) RemoveMember(group, userID string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		groupsB := tx.Bucket([]byte("groups"))
+		groupB := groupsB.Bucket([]byte(group))
+		if groupB == nil {
+			return nil
+		}
+		err := groupB.Delete([]byte(userID))
+		if err != nil {
+			return err
+		} //auto rollback
+		if k, _ := groupB.Cursor().First(); k == nil {
+			return groupsB.DeleteBucket([]byte(group))
+		}
+		return nil
+	})
+}
@@ -0,0 +1,89 @@
+package wsclientable
+
+import (
+	"encoding/json"
+	"github.com/jokrey/utility-algorithms-golang/network/logging"
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+//IDEA:
+//  Webhook events (see 'room_webhooks.go') are written to the outbox before they are sent.
+//     Persisted, events that were not delivered yet survive restarts and are sent once the server runs again.
+//     It is kept in its own file, next to the database of the rooms.
+//  The outbox is expected to be small (only undelivered events), so due deliveries are found by a full scan.
+//
+//  Database Model ('-' denotes bolt buckets, '->' denotes key-value pair):
+//     - "outbox":
+//        <deliveryID-1> -> <json encoded WebhookDelivery>
+//        <deliveryID-2> -> ...
+//        etc...
+
+var webhookOutboxBoltSchema = boltSchema{
+	layout: "webhook_outbox",
+	migrations: []func(tx *bolt.Tx) error{
+		createBoltBuckets("outbox"), // 0 -> 1
+	},
+}
+
+type BoltWebhookOutbox struct {
+	db     *bolt.DB
+	logger *sharedLogger
+}
+
+// Opens (or creates and migrates) the database, see 'room_storage_bolt_schema.go'
+func NewWebhookOutboxBoltStorage(dbPath string) (BoltWebhookOutbox, error) {
+	db, err := openBoltWithSchema(dbPath, webhookOutboxBoltSchema)
+	if err != nil {
+		return BoltWebhookOutbox{}, err
+	}
+
+	return BoltWebhookOutbox{db: db, logger: &sharedLogger{logging.Nop()}}, nil
+}
+
+// Sets the logger used to report database errors (default: logging.Nop())
+func (b BoltWebhookOutbox) SetLogger(logger logging.Logger) {
+	b.logger.Logger = logging.OrNop(logger)
+}
+
+func (b BoltWebhookOutbox) Close() error {
+	return b.db.Close()
+}
+
+func (b BoltWebhookOutbox) Put(delivery WebhookDelivery) error {
+	encoded, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("outbox")).Put([]byte(delivery.ID), encoded)
+	})
+}
+
+func (b BoltWebhookOutbox) Due(now time.Time) ([]WebhookDelivery, error) {
+	var due []WebhookDelivery
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("outbox")).ForEach(func(k, v []byte) error {
+			var delivery WebhookDelivery
+			if err := json.Unmarshal(v, &delivery); err != nil {
+				return err
+			}
+			if delivery.NextAttemptUnixNano <= now.UnixNano() {
+				due = append(due, delivery)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		b.logger.Error("database failed Due", "err", err)
+		return nil, err
+	}
+	sortWebhookDeliveries(due)
+	return due, nil
+}
+
+func (b BoltWebhookOutbox) Remove(deliveryID string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("outbox")).Delete([]byte(deliveryID))
+	})
+}
@@ -0,0 +1,156 @@
+package wsclientable
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jokrey/utility-algorithms-golang/network/logging"
+	"github.com/jokrey/utility-algorithms-golang/network/synchronization"
+	"sync"
+	"time"
+)
+
+//Idea:
+//  All allowed clients of a room share the time window of the room.
+//  Some users should only be allowed for a part of it, e.g. a guest for 30 minutes or a substitute teacher for one session.
+//  Rooms can therefore carry a personal window per user (WithUserWindows):
+//     a user with a window is allowed in the room (even if it is not in the allowed clients, i.e. a guest pass),
+//     but only while both the room and the personal window are open
+//  Controllers disconnect a user at the end of its personal window (CloseCodeAccessEnded),
+//     the room_info message of such a user additionally contains "accessUntil":<unix seconds>
+//  The http editors accept the url param
+//     user_windows={"<userID>": {"from_in_seconds_from_now": 0, "until_in_seconds_from_now": 1800}}
+//     missing bounds mean the personal window is not bounded on that side
+
+// Personal access window of a user within a room, in unix seconds. 0 means unbounded
+type UserWindow struct {
+	FromUnixTime  int64 `json:"from,omitempty"`
+	UntilUnixTime int64 `json:"until,omitempty"`
+}
+
+// Whether the window is open at the given unix time
+func (w UserWindow) Contains(unixTime int64) bool {
+	return (w.FromUnixTime == 0 || unixTime >= w.FromUnixTime) && (w.UntilUnixTime == 0 || unixTime <= w.UntilUnixTime)
+}
+
+// Optionally implemented by rooms that restrict users to personal windows
+//   All rooms embedding Room implement it
+type UserWindowedRoomI interface {
+	RoomI
+	// The personal window of the user, false if the user has none
+	GetUserWindow(userID string) (UserWindow, bool)
+}
+
+// Returns the personal window of the user in the given room, false if it has none or the room does not support them
+func UserWindowOf(room RoomI, userID string) (UserWindow, bool) {
+	if windowed, ok := room.(UserWindowedRoomI); ok {
+		return windowed.GetUserWindow(userID)
+	}
+	return UserWindow{}, false
+}
+
+func createUserWindowsMap(windows map[string]UserWindow) map[string]UserWindow {
+	copied := make(map[string]UserWindow, len(windows))
+	for userID, window := range windows {
+		copied[userID] = window
+	}
+	return copied
+}
+
+// Parses the user_windows url param described above, relative to the given unix time. Empty if raw is empty
+func ParseUserWindows(raw string, nowUnixTime int64) (map[string]UserWindow, error) {
+	windows := make(map[string]UserWindow)
+	if len(raw) == 0 {
+		return windows, nil
+	}
+	var relative map[string]struct {
+		From  *int64 `json:"from_in_seconds_from_now"`
+		Until *int64 `json:"until_in_seconds_from_now"`
+	}
+	if err := json.Unmarshal([]byte(raw), &relative); err != nil {
+		return nil, fmt.Errorf("user windows not a json object of windows: %w", err)
+	}
+	for userID, r := range relative {
+		if err := ValidateID("user", userID); err != nil {
+			return nil, err
+		}
+		var window UserWindow
+		if r.From != nil {
+			window.FromUnixTime = nowUnixTime + *r.From
+		}
+		if r.Until != nil {
+			window.UntilUnixTime = nowUnixTime + *r.Until
+		}
+		if window.UntilUnixTime != 0 && window.UntilUnixTime < window.FromUnixTime {
+			return nil, fmt.Errorf("window of user(%v) ends before it starts", userID)
+		}
+		windows[userID] = window
+	}
+	return windows, nil
+}
+
+// Disconnects connected users at the end of their personal window, using a single TimedCallback for all users
+type userWindowExpirations struct {
+	mut      *sync.Mutex
+	due      map[userInRoom]time.Time
+	callback synchronization.TimedCallback
+	expire   func(roomID, userID string)
+	logger   logging.Logger
+}
+
+type userInRoom struct {
+	roomID, userID string
+}
+
+func newUserWindowExpirations(expire func(roomID, userID string)) *userWindowExpirations {
+	return &userWindowExpirations{
+		mut:      &sync.Mutex{},
+		due:      make(map[userInRoom]time.Time),
+		callback: synchronization.NewTimedCallback(),
+		expire:   expire,
+		logger:   logging.Nop(),
+	}
+}
+
+// Tracks the end of the personal window of the user, untracks it if the user has no bounded window
+func (e *userWindowExpirations) track(roomID, userID string, room RoomI) {
+	window, ok := UserWindowOf(room, userID)
+	key := userInRoom{roomID: roomID, userID: userID}
+
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	if !ok || window.UntilUnixTime == 0 {
+		delete(e.due, key)
+		return
+	}
+	at := time.Unix(window.UntilUnixTime, 0).Add(time.Second) //a little after, so IsAllowed definitely fails
+	e.due[key] = at
+	e.callback.CallMeBackIfEarlierThanCurrent(at, e.expireDue)
+}
+
+func (e *userWindowExpirations) stop() {
+	e.callback.Stop()
+}
+
+func (e *userWindowExpirations) expireDue() {
+	now := time.Now()
+	var expired []userInRoom
+	var next time.Time
+	e.mut.Lock()
+	for key, at := range e.due {
+		if !at.After(now) {
+			expired = append(expired, key)
+			delete(e.due, key)
+		} else if next.IsZero() || at.Before(next) {
+			next = at
+		}
+	}
+	if !next.IsZero() {
+		e.callback.CallMeBackIfEarlierThanCurrent(next, e.expireDue)
+	}
+	e.mut.Unlock()
+
+	for _, key := range expired {
+		e.logger.Debug("personal window of user ended", "room", key.roomID, "user", key.userID)
+		e.expire(key.roomID, key.userID)
+	}
+}
@@ -0,0 +1,447 @@
+package wsclientable
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/jokrey/utility-algorithms-golang/network/logging"
+	"gopkg.in/ini.v1"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+//Idea:
+//  A backend (billing, attendance) needs to know who was in which room and when rooms ended, without polling.
+//  Webhooks POST json events to configured urls:
+//     {"id":"<event id>", "type":"user_joined", "room":"<roomID>", "user":"<userID>", "at":<unix>}
+//     user_joined:  a connection was added to a room (directly or when admitted from the lobby)
+//     user_left:    a connection that had joined a room closed, "code" carries the close code received from the client
+//                   (1008 if the connection failed or was closed by the server)
+//     room_expired: a temporary room expired or a repeating room ended after its last occurrence (see RoomExpiryNotifierI)
+//  Every webhook has its own secret, the body is signed with it:
+//     X-Webhook-Signature: sha256=<hex hmac-sha256(secret, body)>
+//     X-Webhook-Event: <type>, X-Webhook-Delivery: <delivery id>, X-Webhook-Attempt: <n, starting at 1>
+//  Events are written to an outbox before they are sent and removed once the receiver answered with 2xx.
+//     failed deliveries are retried with exponential backoff, up to a maximum number of attempts (see WebhookRetryPolicy)
+//     with a persisted outbox (see 'room_storage_webhook_outbox_db.go') pending events survive restarts
+//     receivers should deduplicate by event id, an event can be delivered more than once
+//  Config:
+//     [webhooks]
+//     outbox_db_path=webhooks.db   (optional, events are kept in memory without)
+//     [webhooks.billing]
+//     url=https://backend.example/hooks/rooms
+//     secret=<secret>
+//     events=["user_joined", "user_left"]   (optional, all events without)
+
+const (
+	WebhookEventUserJoined  = "user_joined"
+	WebhookEventUserLeft    = "user_left"
+	WebhookEventRoomExpired = "room_expired"
+)
+
+type WebhookEvent struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	RoomID     string `json:"room"`
+	UserID     string `json:"user,omitempty"`
+	AtUnixTime int64  `json:"at"`
+	// Close code received from the client, only for user_left
+	CloseCode int `json:"code,omitempty"`
+}
+
+type Webhook struct {
+	URL    string
+	Secret []byte
+	// Event types sent to this webhook, all if empty
+	Events []string
+}
+
+// Whether events of the given type are sent to the webhook
+func (w Webhook) Accepts(eventType string) bool {
+	return len(w.Events) == 0 || containsString(w.Events, eventType)
+}
+
+// Returns the value of the X-Webhook-Signature header for the given body
+func (w Webhook) Sign(body []byte) string {
+	mac := hmac.New(sha256.New, w.Secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Whether the given X-Webhook-Signature header matches the body, for receivers
+func (w Webhook) Verify(body []byte, signature string) bool {
+	return hmac.Equal([]byte(w.Sign(body)), []byte(signature))
+}
+
+func (w Webhook) validate() error {
+	parsed, err := url.Parse(w.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || len(parsed.Host) == 0 {
+		return fmt.Errorf("webhook url %v is not an absolute http(s) url", w.URL)
+	}
+	if len(w.Secret) == 0 {
+		return fmt.Errorf("webhook %v has no secret", w.URL)
+	}
+	return nil
+}
+
+type WebhookRetryPolicy struct {
+	// Wait before the second attempt, doubled with every further attempt
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Deliveries are dropped after this many failed attempts (0 = retried forever)
+	MaxAttempts int
+}
+
+var DefaultWebhookRetryPolicy = WebhookRetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Minute, MaxAttempts: 10}
+
+// Returns the wait after the given number of failed attempts
+func (r WebhookRetryPolicy) backoff(failedAttempts int) time.Duration {
+	wait := r.InitialBackoff
+	for i := 1; i < failedAttempts && wait < r.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > r.MaxBackoff {
+		return r.MaxBackoff
+	}
+	return wait
+}
+
+// A single event for a single webhook, as it is kept in the outbox
+type WebhookDelivery struct {
+	ID       string          `json:"id"`
+	URL      string          `json:"url"`
+	Event    string          `json:"event"`
+	Body     json.RawMessage `json:"body"`
+	Attempts int             `json:"attempts"`
+	// The delivery is not attempted before this time
+	NextAttemptUnixNano int64 `json:"next"`
+}
+
+// Stores deliveries until they succeeded or were dropped
+type WebhookOutboxI interface {
+	// Adds the delivery or replaces the one with the same id
+	Put(delivery WebhookDelivery) error
+	// Returns the deliveries due at the given time
+	Due(now time.Time) ([]WebhookDelivery, error)
+	Remove(deliveryID string) error
+
+	// Closes underlying resources
+	Close() error
+}
+
+// Sends events to webhooks, create with NewWebhooks or NewWebhooksFromCFG
+type Webhooks struct {
+	hooks     []Webhook
+	outbox    WebhookOutboxI
+	retry     WebhookRetryPolicy
+	client    *http.Client
+	logMut    *sync.RWMutex
+	logger    logging.Logger
+	wake      chan struct{}
+	stop      chan struct{}
+	stopped   chan struct{}
+	closeOnce *sync.Once
+}
+
+// Starts delivering events (also those already in the outbox) to the given webhooks until Close is called
+func NewWebhooks(outbox WebhookOutboxI, retry WebhookRetryPolicy, hooks ...Webhook) (*Webhooks, error) {
+	if retry.InitialBackoff <= 0 || retry.MaxBackoff < retry.InitialBackoff || retry.MaxAttempts < 0 {
+		return nil, errors.New("invalid webhook retry policy")
+	}
+	for _, hook := range hooks {
+		if err := hook.validate(); err != nil {
+			return nil, err
+		}
+	}
+	w := &Webhooks{
+		hooks: hooks, outbox: outbox, retry: retry,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		logMut:    &sync.RWMutex{},
+		logger:    logging.Nop(),
+		wake:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+		closeOnce: &sync.Once{},
+	}
+	go w.run()
+	return w, nil
+}
+
+// Creates the webhooks described above from the [webhooks] section and its child sections
+//   returns nil if the config has no [webhooks] section, panics if it is invalid
+func NewWebhooksFromCFG(cfg *ini.File) *Webhooks {
+	section, err := cfg.GetSection("webhooks")
+	if err != nil {
+		return nil
+	}
+	var hooks []Webhook
+	for _, hookSection := range section.ChildSections() {
+		hook := Webhook{URL: hookSection.Key("url").String(), Secret: []byte(hookSection.Key("secret").String())}
+		if raw := hookSection.Key("events").String(); len(raw) > 0 {
+			if err := json.Unmarshal([]byte(raw), &hook.Events); err != nil {
+				panic("Could not load webhook (" + hookSection.Name() + "), events not a json list: " + raw)
+			}
+		}
+		hooks = append(hooks, hook)
+	}
+	logger := logging.NewFromCFG(cfg)
+	var outbox WebhookOutboxI = NewRamWebhookOutbox()
+	if dbPath := section.Key("outbox_db_path").String(); len(dbPath) > 0 {
+		boltOutbox, err := NewWebhookOutboxBoltStorage(dbPath)
+		if err != nil {
+			panic("Could not load webhook outbox: " + err.Error())
+		}
+		boltOutbox.SetLogger(logger)
+		outbox = boltOutbox
+	}
+	webhooks, err := NewWebhooks(outbox, DefaultWebhookRetryPolicy, hooks...)
+	if err != nil {
+		panic("Could not load webhooks: " + err.Error())
+	}
+	webhooks.SetLogger(logger)
+	return webhooks
+}
+
+// Sets the logger used to report failed deliveries (default: logging.Nop())
+func (w *Webhooks) SetLogger(logger logging.Logger) {
+	w.logMut.Lock()
+	defer w.logMut.Unlock()
+	w.logger = logging.OrNop(logger)
+}
+
+// the logger can be set while deliveries are running
+func (w *Webhooks) log() logging.Logger {
+	w.logMut.RLock()
+	defer w.logMut.RUnlock()
+	return w.logger
+}
+
+// Writes the event to the outbox for every webhook accepting its type, they are sent asynchronously
+//   the id and time of the event are set if empty
+func (w *Webhooks) Emit(event WebhookEvent) {
+	if len(event.ID) == 0 {
+		event.ID = newWebhookID()
+	}
+	if event.AtUnixTime == 0 {
+		event.AtUnixTime = time.Now().Unix()
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		w.log().Error("could not encode webhook event", "event", event.Type, "err", err)
+		return
+	}
+	now := time.Now().UnixNano()
+	for _, hook := range w.hooks {
+		if !hook.Accepts(event.Type) {
+			continue
+		}
+		delivery := WebhookDelivery{ID: newWebhookID(), URL: hook.URL, Event: event.Type, Body: body, NextAttemptUnixNano: now}
+		if err := w.outbox.Put(delivery); err != nil {
+			w.log().Error("could not write webhook event to outbox", "event", event.Type, "url", hook.URL, "err", err)
+		}
+	}
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Stops delivering and closes the outbox, pending deliveries stay in a persisted outbox
+func (w *Webhooks) Close() error {
+	var err error
+	w.closeOnce.Do(func() {
+		close(w.stop)
+		<-w.stopped
+		err = w.outbox.Close()
+	})
+	return err
+}
+
+func (w *Webhooks) run() {
+	defer close(w.stopped)
+	poll := w.retry.InitialBackoff
+	if poll > time.Second {
+		poll = time.Second
+	}
+	for {
+		w.deliverDue()
+		select {
+		case <-w.stop:
+			return
+		case <-w.wake:
+		case <-time.After(poll):
+		}
+	}
+}
+
+func (w *Webhooks) deliverDue() {
+	due, err := w.outbox.Due(time.Now())
+	if err != nil {
+		w.log().Error("could not read webhook outbox", "err", err)
+		return
+	}
+	for _, delivery := range due {
+		select {
+		case <-w.stop:
+			return
+		default:
+		}
+		hook, ok := w.hookFor(delivery.URL)
+		if !ok {
+			w.log().Warn("dropped webhook event of removed webhook", "event", delivery.Event, "url", delivery.URL)
+			_ = w.outbox.Remove(delivery.ID)
+			continue
+		}
+
+		delivery.Attempts++
+		err := w.send(hook, delivery)
+		if err == nil {
+			err = w.outbox.Remove(delivery.ID)
+		} else if w.retry.MaxAttempts > 0 && delivery.Attempts >= w.retry.MaxAttempts {
+			w.log().Warn("dropped webhook event after failed attempts", "event", delivery.Event, "url", delivery.URL, "attempts", delivery.Attempts, "err", err)
+			err = w.outbox.Remove(delivery.ID)
+		} else {
+			w.log().Debug("webhook delivery failed, retrying", "event", delivery.Event, "url", delivery.URL, "attempts", delivery.Attempts, "err", err)
+			delivery.NextAttemptUnixNano = time.Now().Add(w.retry.backoff(delivery.Attempts)).UnixNano()
+			err = w.outbox.Put(delivery)
+		}
+		if err != nil {
+			w.log().Error("could not update webhook outbox", "event", delivery.Event, "url", delivery.URL, "err", err)
+		}
+	}
+}
+
+func (w *Webhooks) hookFor(hookURL string) (Webhook, bool) {
+	for _, hook := range w.hooks {
+		if hook.URL == hookURL {
+			return hook, true
+		}
+	}
+	return Webhook{}, false
+}
+
+func (w *Webhooks) send(hook Webhook, delivery WebhookDelivery) error {
+	request, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(delivery.Body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Webhook-Signature", hook.Sign(delivery.Body))
+	request.Header.Set("X-Webhook-Event", delivery.Event)
+	request.Header.Set("X-Webhook-Delivery", delivery.ID)
+	request.Header.Set("X-Webhook-Attempt", fmt.Sprint(delivery.Attempts))
+	response, err := w.client.Do(request)
+	if err != nil {
+		return err
+	}
+	_ = response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook answered with status %v", response.StatusCode)
+	}
+	return nil
+}
+
+func newWebhookID() string {
+	bs := make([]byte, 16)
+	_, _ = rand.Read(bs)
+	return hex.EncodeToString(bs)
+}
+
+// Sends the webhook events described above for the rooms of the given controllers
+//   roomControllers have to be the same controllers given to AddRoomForwardingFunctionality (which initializes and closes them)
+//   the webhooks are closed with the server
+func (s *Server) AddRoomWebhooksFunctionality(roomControllers RoomControllers, webhooks *Webhooks) {
+	rooms := &roomControllers
+	// connections that joined a room, only they leave it (rejected connections and those waiting in the lobby never joined)
+	mut := &sync.Mutex{}
+	//   keyed by the websocket, the id of a connection is its user id, which is only unique within a room
+	joined := make(map[*websocket.Conn]bool)
+
+	rooms.AddRoomJoinedHandler(func(roomID string, connection ClientConnection) {
+		mut.Lock()
+		joined[connection.raw] = true
+		mut.Unlock()
+		webhooks.Emit(WebhookEvent{Type: WebhookEventUserJoined, RoomID: roomID, UserID: connection.Identity.UserID})
+	})
+	s.AddConnClosedHandlerWithConnection(func(connection ClientConnection, closeCode int, closeReason string) {
+		mut.Lock()
+		wasJoined := joined[connection.raw]
+		delete(joined, connection.raw)
+		mut.Unlock()
+		if wasJoined {
+			webhooks.Emit(WebhookEvent{
+				Type: WebhookEventUserLeft, RoomID: connection.Identity.RoomID, UserID: connection.Identity.UserID, CloseCode: closeCode,
+			})
+		}
+	})
+	rooms.AddRoomExpiredHandler(func(roomID string) {
+		webhooks.Emit(WebhookEvent{Type: WebhookEventRoomExpired, RoomID: roomID})
+	})
+	s.AddServerClosedHandler(func() {
+		_ = webhooks.Close()
+	})
+}
+
+// Adds the webhooks configured in the [webhooks] section (see NewWebhooksFromCFG), does nothing if there is none
+func (s *Server) AddRoomWebhooksFunctionalityFromCFG(roomControllers RoomControllers, cfg *ini.File) {
+	if webhooks := NewWebhooksFromCFG(cfg); webhooks != nil {
+		s.AddRoomWebhooksFunctionality(roomControllers, webhooks)
+	}
+}
+
+// WebhookOutboxI held in memory, pending events are lost when the program exits
+type RamWebhookOutbox struct {
+	mut        *sync.Mutex
+	deliveries map[string]WebhookDelivery
+}
+
+func NewRamWebhookOutbox() RamWebhookOutbox {
+	return RamWebhookOutbox{mut: &sync.Mutex{}, deliveries: make(map[string]WebhookDelivery)}
+}
+
+func (r RamWebhookOutbox) Put(delivery WebhookDelivery) error {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.deliveries[delivery.ID] = delivery
+	return nil
+}
+
+func (r RamWebhookOutbox) Due(now time.Time) ([]WebhookDelivery, error) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	var due []WebhookDelivery
+	for _, delivery := range r.deliveries {
+		if delivery.NextAttemptUnixNano <= now.UnixNano() {
+			due = append(due, delivery)
+		}
+	}
+	sortWebhookDeliveries(due)
+	return due, nil
+}
+
+func (r RamWebhookOutbox) Remove(deliveryID string) error {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	delete(r.deliveries, deliveryID)
+	return nil
+}
+
+func (r RamWebhookOutbox) Close() error {
+	return nil
+}
+
+// Oldest due first, so that events of a webhook are sent roughly in order
+func sortWebhookDeliveries(deliveries []WebhookDelivery) {
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].NextAttemptUnixNano < deliveries[j].NextAttemptUnixNano
+	})
+}
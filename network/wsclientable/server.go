@@ -1,10 +1,12 @@
 package wsclientable
 
 import (
+	"context"
 	"crypto/tls"
 	"github.com/gorilla/websocket"
+	"fmt"
+	"github.com/jokrey/utility-algorithms-golang/network/logging"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -21,17 +23,26 @@ import (
 //      The concrete server implementation will handle those messages according to the type.
 
 type MessageHandlers map[string]func(mType string, client ClientConnection, message map[string]interface{})
+type RequestAuthenticator func(request *http.Request) (string, error)
+
+// Same as RequestAuthenticator, but returns the full Identity (room, user and attributes) of the connection
+//   and a context derived from request.Context() that carries request-scoped values (for example a looked up account).
+//   The context of the resulting ClientConnection is derived from it, so handlers can read those values.
+type ContextAuthenticator func(request *http.Request) (context.Context, Identity, error)
 type ConnOpenedHandlers []func(ClientConnection)
 type ConnClosedHandlers []func(connectionID string, closeCode int, closeReason string)
+type ConnClosedWithConnectionHandlers []func(connection ClientConnection, closeCode int, closeReason string)
 type ServerClosedHandlers []func()
 
 type Server struct {
 	raw *http.Server
 
-	authenticate         func(url.Values) (string, error)
+	authenticate         ContextAuthenticator
 	connOpenedHandlers   ConnOpenedHandlers
-	connClosedHandlers   ConnClosedHandlers
+	connClosedHandlers   ConnClosedWithConnectionHandlers
 	serverClosedHandlers ServerClosedHandlers
+	logger               logging.Logger
+	subprotocols         []string
 	// any here registered message handlers will be called upon a message of specified type
 	//   remaining json map will contain the parsed data field
 	//   the first argument will be the type again, in case we use the same func
@@ -41,12 +52,13 @@ type Server struct {
 
 func NewWSHandlingServer() Server {
 	return Server{
-		authenticate: func(url.Values) (string, error) {
-			return "", AuthenticationError{Reason: "No authenticator set."}
+		authenticate: func(request *http.Request) (context.Context, Identity, error) {
+			return request.Context(), Identity{}, AuthenticationError{Reason: "No authenticator set."}
 		},
 		connOpenedHandlers:   ConnOpenedHandlers{},
-		connClosedHandlers:   ConnClosedHandlers{},
+		connClosedHandlers:   ConnClosedWithConnectionHandlers{},
 		serverClosedHandlers: ServerClosedHandlers{},
+		logger:               logging.Nop(),
 		messageHandlers:      make(MessageHandlers),
 	}
 }
@@ -62,23 +74,58 @@ func (s *Server) AddMessageHandler(mType string, handler func(string, ClientConn
 	}
 	s.messageHandlers[mType] = handler
 }
+// Sets the subprotocols the server supports, in order of preference
+//   the first of them that the client also offers (see ConnectOptions.Subprotocols) is negotiated, none if it offers none of them
+func (s *Server) SetSubprotocols(subprotocols ...string) {
+	s.subprotocols = subprotocols
+}
+
 func (s *Server) AddConnOpenedHandler(handler func(ClientConnection)) {
 	s.connOpenedHandlers = append(s.connOpenedHandlers, handler)
 }
 
+// The handler receives the ID of the closed connection, which is the user id of the connection (see ClientConnection.ID)
 func (s *Server) AddConnClosedHandler(handler func(connectionID string, closeCode int, closeReason string)) {
+	s.AddConnClosedHandlerWithConnection(func(connection ClientConnection, closeCode int, closeReason string) {
+		handler(connection.ID, closeCode, closeReason)
+	})
+}
+
+// Same as AddConnClosedHandler, but the handler receives the entire closed connection (for example its Identity)
+func (s *Server) AddConnClosedHandlerWithConnection(handler func(connection ClientConnection, closeCode int, closeReason string)) {
 	s.connClosedHandlers = append(s.connClosedHandlers, handler)
 }
 func (s *Server) AddServerClosedHandler(handler func()) {
 	s.serverClosedHandlers = append(s.serverClosedHandlers, handler)
 }
 func (s *Server) SetAuthenticator(authenticator func(url.Values) (string, error)) {
+	s.SetRequestAuthenticator(RequestAuthenticatorFromURLParams(authenticator))
+}
+
+// Same as SetAuthenticator, but the authenticator gets to see the entire upgrade request
+//   (for example the tls connection state, which holds the verified client certificates)
+func (s *Server) SetRequestAuthenticator(authenticator RequestAuthenticator) {
+	s.authenticate = ContextAuthenticatorFrom(authenticator)
+}
+
+// Same as SetRequestAuthenticator, but the authenticator determines the entire Identity of the connection
+//   and the context returned by the authenticator becomes the parent of the connection context
+func (s *Server) SetContextAuthenticator(authenticator ContextAuthenticator) {
 	s.authenticate = authenticator
 }
+// Sets the logger used by the server, its connections and added functionality (default: logging.Nop())
+//   Should be called before functionality (like AddRoomForwardingFunctionality) is added
+func (s *Server) SetLogger(logger logging.Logger) {
+	s.logger = logging.OrNop(logger)
+}
+
 func (s *Server) Close() error {
 	for _, handler := range s.serverClosedHandlers {
 		handler()
 	}
+	if s.raw == nil { // not started, or served by a custom http server
+		return nil
+	}
 	return s.raw.Close()
 }
 
@@ -86,20 +133,26 @@ func (s *Server) Close() error {
 //   Connection will only be http. Some clients(browsers) have opted to disallow unencrypted http connections.
 // additionalRoutes will be added to server handler by handler.HandleFunc (must not contain conflicting patterns)
 func (s *Server) StartUnencrypted(bindAddress string, bindPort int, httpWsUpgradeRoute string, additionalRoutes ...HttpRouteFunc) error {
-	handler := http.NewServeMux()
-	for _, routeFunc := range additionalRoutes {
-		handler.HandleFunc(routeFunc.pattern, routeFunc.handler)
-	}
-	handler.HandleFunc(httpWsUpgradeRoute, s.upgradeAndHandleNewClient)
 	server := http.Server{
 		Addr:      bindAddress + ":" + strconv.Itoa(bindPort),
-		Handler:   handler,
+		Handler:   s.Handler(httpWsUpgradeRoute, additionalRoutes...),
 		TLSConfig: nil,
 	}
 	s.raw = &server
 	return server.ListenAndServe()
 }
 
+// Returns the handler StartUnencrypted would serve, to be used with a custom http server (for example in tests)
+//   Close should still be called once the custom server is shut down, to call the server closed handlers
+func (s *Server) Handler(httpWsUpgradeRoute string, additionalRoutes ...HttpRouteFunc) http.Handler {
+	handler := http.NewServeMux()
+	for _, routeFunc := range additionalRoutes {
+		handler.HandleFunc(routeFunc.pattern, routeFunc.handler)
+	}
+	handler.HandleFunc(httpWsUpgradeRoute, s.upgradeAndHandleNewClient)
+	return handler
+}
+
 type HttpRouteFunc struct {
 	pattern string
 	handler func(http.ResponseWriter, *http.Request)
@@ -136,50 +189,96 @@ type CertAndKeyPaths struct {
 //   For certificates to be accepted by the client they must be from a client-local-trusted ca.
 func (s *Server) StartWithTLSMultipleCerts(bindAddress string, bindPort int,
 	httpRoute string, tlsConfigs ...CertAndKeyPaths) error {
-	if len(tlsConfigs) < 1 {
-		log.Fatalf("Missing certificates. Require at least 1. " +
-			"Consider using http or adding a few. Can be generated with generate_cert.go")
+	handler := http.NewServeMux()
+	handler.HandleFunc(httpRoute, s.upgradeAndHandleNewClient)
+
+	cfg, err := tlsConfigWithCertificates(tlsConfigs...)
+	if err != nil {
+		return err
+	}
+	server := http.Server{ //nolint:exhaustivestruct
+		Addr:      bindAddress + ":" + strconv.Itoa(bindPort),
+		Handler:   handler,
+		TLSConfig: cfg,
+	}
+
+	s.raw = &server
+
+	return server.ListenAndServeTLS("", "")
+}
+
+// Returns the tls config StartWithMutualTLS would serve, to be used with a custom http server (for example in tests)
+func MutualTLSConfig(clientAuth ClientCertificateAuth, tlsConfigs ...CertAndKeyPaths) (*tls.Config, error) {
+	cfg, err := tlsConfigWithCertificates(tlsConfigs...)
+	if err != nil {
+		return nil, err
 	}
+	clientCAs, err := LoadCertPool(clientAuth.CABundlePath)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ClientCAs = clientCAs
+	cfg.ClientAuth = clientAuth.tlsClientAuthType()
+	return cfg, nil
+}
 
+// Same as StartWithTLSMultipleCerts, but additionally requests (or requires) a client certificate
+//   signed by one of the CAs in the given bundle during the tls handshake.
+//   Use AuthenticateUserByClientCertificate (or AddRoomForwardingFunctionalityWithUserAuthenticator) to
+//   derive the user id from the verified certificate.
+func (s *Server) StartWithMutualTLS(bindAddress string, bindPort int,
+	httpRoute string, clientAuth ClientCertificateAuth, tlsConfigs ...CertAndKeyPaths) error {
 	handler := http.NewServeMux()
 	handler.HandleFunc(httpRoute, s.upgradeAndHandleNewClient)
 
+	cfg, err := MutualTLSConfig(clientAuth, tlsConfigs...)
+	if err != nil {
+		return err
+	}
+
+	server := http.Server{ //nolint:exhaustivestruct
+		Addr:      bindAddress + ":" + strconv.Itoa(bindPort),
+		Handler:   handler,
+		TLSConfig: cfg,
+	}
+
+	s.raw = &server
+
+	return server.ListenAndServeTLS("", "")
+}
+
+func tlsConfigWithCertificates(tlsConfigs ...CertAndKeyPaths) (*tls.Config, error) {
+	if len(tlsConfigs) < 1 {
+		return nil, fmt.Errorf("missing certificates. Require at least 1. " +
+			"Consider using http or adding a few. Can be generated with generate_cert.go")
+	}
+
 	cfg := &tls.Config{MinVersion: tls.VersionTLS10}
 	for _, tlsConfig := range tlsConfigs {
 		certCont, err := ioutil.ReadFile(tlsConfig.CertificateFilePath)
 		if err != nil {
-			log.Fatalf("Reading cert from \"%v\", error: %v", tlsConfig.CertificateFilePath, err)
+			return nil, fmt.Errorf("reading cert from \"%v\", error: %w", tlsConfig.CertificateFilePath, err)
 		}
 		keyCont, err := ioutil.ReadFile(tlsConfig.KeyFilePath)
 		if err != nil {
-			log.Fatalf("Reading key from: \"%v\", error: %v", tlsConfig.KeyFilePath, err)
+			return nil, fmt.Errorf("reading key from: \"%v\", error: %w", tlsConfig.KeyFilePath, err)
 		}
 		cert, err := tls.X509KeyPair(certCont, keyCont)
 		if err != nil {
-			log.Fatalf("Decoding cert, error: %v", err)
+			return nil, fmt.Errorf("decoding cert, error: %w", err)
 		}
 		cfg.Certificates = append(cfg.Certificates, cert)
 	}
-
-	server := http.Server{ //nolint:exhaustivestruct
-		Addr:      bindAddress + ":" + strconv.Itoa(bindPort),
-		Handler:   handler,
-		TLSConfig: cfg,
-	}
-
-	s.raw = &server
-
-	return server.ListenAndServeTLS("", "")
+	return cfg, nil
 }
 
 func (s *Server) upgradeAndHandleNewClient(writer http.ResponseWriter, request *http.Request) {
-	initialParams, err := url.ParseQuery(request.URL.RawQuery)
-	if err != nil {
-		return
+	authContext, identity, err := s.authenticate(request)
+	if err == nil {
+		err = identity.Validate()
 	}
-	name, err := s.authenticate(initialParams)
 	if err != nil {
-		log.Printf("Auth Err: %v", err)
+		s.logger.Debug("authentication failed", "remote", request.RemoteAddr, "err", err)
 		writer.WriteHeader(http.StatusForbidden)
 		_, _ = writer.Write([]byte("Failed to authenticate - because: " + err.Error()))
 		return
@@ -190,16 +289,21 @@ func (s *Server) upgradeAndHandleNewClient(writer http.ResponseWriter, request *
 		CheckOrigin: func(r *http.Request) bool {
 			return true
 		},
+		Subprotocols: s.subprotocols,
 	}
 	conn, err := upgrader.Upgrade(writer, request, responseHeader)
 	if err != nil {
-		log.Println("failed to upgrade to websocket")
+		s.logger.Warn("failed to upgrade to websocket", "room", identity.RoomID, "user", identity.UserID, "err", err)
 		writer.WriteHeader(http.StatusUpgradeRequired)
 		_, _ = writer.Write([]byte("failed to upgrade to websocket"))
 		return
 	}
 
-	client := ClientConnection{ID: name, raw: conn, writeMut: new(sync.Mutex)}
+	ctx, cancel := context.WithCancel(authContext)
+	client := ClientConnection{
+		ID: identity.UserID, Identity: identity,
+		raw: conn, writeMut: new(sync.Mutex), logger: s.logger, ctx: ctx, cancel: cancel,
+	}
 
 	for _, connOpened := range s.connOpenedHandlers {
 		connOpened(client)
@@ -208,6 +312,6 @@ func (s *Server) upgradeAndHandleNewClient(writer http.ResponseWriter, request *
 	closeCode, closeReason := client.ListenLoop(s.messageHandlers)
 
 	for _, connClosed := range s.connClosedHandlers {
-		connClosed(client.ID, closeCode, closeReason)
+		connClosed(client, closeCode, closeReason)
 	}
 }
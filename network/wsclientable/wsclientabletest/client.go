@@ -0,0 +1,156 @@
+package wsclientabletest
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A received typed message
+type Message struct {
+	Type string
+	Data map[string]interface{}
+}
+
+// Returns the string field with the given key in the data of the message, or "" if it is not a string
+func (m Message) String(key string) string {
+	v, _ := m.Data[key].(string)
+	return v
+}
+
+// A connection to the harness server, that runs its listen loop in the background and buffers every received message
+type Client struct {
+	*wsclientable.ClientConnection
+	t testing.TB
+
+	mut      *sync.Mutex
+	received []Message
+	notify   chan struct{}
+
+	closed      chan struct{}
+	closeCode   int
+	closeReason string
+}
+
+func newClient(t testing.TB, raw *wsclientable.ClientConnection) *Client {
+	c := &Client{
+		ClientConnection: raw,
+		t:                t,
+		mut:              &sync.Mutex{},
+		notify:           make(chan struct{}, 1),
+		closed:           make(chan struct{}),
+	}
+	go func() {
+		code, reason := raw.ListenLoop(wsclientable.MessageHandlers{
+			wsclientable.AnyOtherMessageType: func(mType string, _ wsclientable.ClientConnection, data map[string]interface{}) {
+				c.mut.Lock()
+				c.received = append(c.received, Message{Type: mType, Data: data})
+				c.mut.Unlock()
+				select {
+				case c.notify <- struct{}{}:
+				default:
+				}
+			},
+		})
+		c.closeCode, c.closeReason = code, reason
+		close(c.closed)
+	}()
+	return c
+}
+
+// Marshals the given data and sends it with the given type, fails the test on error
+func (c *Client) Send(mType string, data map[string]interface{}) {
+	c.t.Helper()
+	if err := c.SendMapTyped(mType, data); err != nil {
+		c.t.Fatalf("could not send %v: %v", mType, err)
+	}
+}
+
+// Returns (and consumes) the oldest received message of the given type,
+//   waits at most timeout for it to arrive. Messages of other types remain buffered.
+func (c *Client) Await(mType string, timeout time.Duration) (Message, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		if m, ok := c.take(mType); ok {
+			return m, nil
+		}
+		select {
+		case <-c.notify:
+		case <-c.closed:
+			if m, ok := c.take(mType); ok {
+				return m, nil
+			}
+			return Message{}, fmt.Errorf("connection closed (c=%v, r=%v) before receiving %v", c.closeCode, c.closeReason, mType)
+		case <-deadline.C:
+			return Message{}, fmt.Errorf("no message of type %v within %v", mType, timeout)
+		}
+	}
+}
+
+func (c *Client) take(mType string) (Message, bool) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	for i, m := range c.received {
+		if m.Type == mType {
+			c.received = append(c.received[:i], c.received[i+1:]...)
+			return m, true
+		}
+	}
+	return Message{}, false
+}
+
+// Same as Await with the DefaultTimeout, but fails the test if no such message arrives
+func (c *Client) Expect(mType string) Message {
+	c.t.Helper()
+	m, err := c.Await(mType, DefaultTimeout)
+	if err != nil {
+		c.t.Fatalf("%v", err)
+	}
+	return m
+}
+
+// Expects a message of the given type whose data contains all the given fields (compared by their json encoding)
+func (c *Client) ExpectWith(mType string, fields map[string]interface{}) Message {
+	c.t.Helper()
+	m := c.Expect(mType)
+	for k, expected := range fields {
+		expectedJSON, _ := json.Marshal(expected)
+		actualJSON, _ := json.Marshal(m.Data[k])
+		if string(expectedJSON) != string(actualJSON) {
+			c.t.Fatalf("message %v: field %v is %v, expected %v", mType, k, string(actualJSON), string(expectedJSON))
+		}
+	}
+	return m
+}
+
+// Fails the test if a message of the given type arrives within the given duration
+func (c *Client) ExpectNone(mType string, within time.Duration) {
+	c.t.Helper()
+	if m, err := c.Await(mType, within); err == nil {
+		c.t.Fatalf("unexpected message of type %v: %v", mType, m.Data)
+	}
+}
+
+// Waits at most timeout for the connection to be closed, returns the close code and reason
+func (c *Client) AwaitClosed(timeout time.Duration) (int, string, error) {
+	select {
+	case <-c.closed:
+		return c.closeCode, c.closeReason, nil
+	case <-time.After(timeout):
+		return 0, "", fmt.Errorf("connection not closed within %v", timeout)
+	}
+}
+
+// Same as AwaitClosed with the DefaultTimeout, but fails the test if the connection stays open
+func (c *Client) ExpectClosed() (int, string) {
+	c.t.Helper()
+	code, reason, err := c.AwaitClosed(DefaultTimeout)
+	if err != nil {
+		c.t.Fatalf("%v", err)
+	}
+	return code, reason
+}
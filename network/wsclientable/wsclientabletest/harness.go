@@ -0,0 +1,195 @@
+// In-process test harness for wsclientable servers.
+// Starts a Server on an ephemeral localhost port, so tests neither collide on hard-coded ports nor sleep for startup.
+// Connected clients buffer every received message, tests then await specific message types with a timeout.
+//
+// Note: HTTP room editors start their own http servers when initialized (by StartRooms),
+//   to test their routes bind them to port 0 and send requests to their Addr.
+//   For rooms in tests prefer the plain controllers (for example NewTemporaryRoomController(NewMutableRamRoomStorage()))
+//   and add rooms through Harness.Rooms or the controller directly.
+package wsclientabletest
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+const route = "/wsclientabletest"
+
+// Default timeout of the Expect* helpers
+const DefaultTimeout = 2 * time.Second
+
+type Harness struct {
+	t      testing.TB
+	Server *wsclientable.Server
+	// Only set when started with StartRooms
+	Rooms *wsclientable.RoomControllers
+	// Base url of the upgrade route, for example http://127.0.0.1:41234/wsclientabletest (https if started with StartTLS)
+	URL string
+
+	raw       *http.Server
+	mut       *sync.Mutex
+	clients   []*Client
+	serveDone chan struct{}
+	closed    bool
+	// closes handled by the server (by connection id) that were not awaited yet, see ExpectClosedOnServer
+	closedOnServer        map[string]int
+	closedOnServerChanged chan struct{}
+}
+
+// Starts the given (fully configured) server on an ephemeral port, the harness is closed on test cleanup
+//   the harness adds a conn closed handler of its own, after those of the server (see ExpectClosedOnServer)
+func Start(t testing.TB, server *wsclientable.Server) *Harness {
+	t.Helper()
+	return start(t, server, nil)
+}
+
+// Same as Start, but serves https with the given config (for example from wsclientable.MutualTLSConfig)
+//   the certificate of the server has to be valid for 127.0.0.1
+func StartTLS(t testing.TB, server *wsclientable.Server, tlsConfig *tls.Config) *Harness {
+	t.Helper()
+	return start(t, server, tlsConfig)
+}
+
+func start(t testing.TB, server *wsclientable.Server, tlsConfig *tls.Config) *Harness {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen on ephemeral port: %v", err)
+	}
+	scheme := "http://"
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+		scheme = "https://"
+	}
+
+	h := &Harness{
+		t:         t,
+		Server:    server,
+		URL:       scheme + listener.Addr().String() + route,
+		raw:       &http.Server{Handler: server.Handler(route)},
+		mut:       &sync.Mutex{},
+		serveDone: make(chan struct{}),
+
+		closedOnServer:        map[string]int{},
+		closedOnServerChanged: make(chan struct{}),
+	}
+	server.AddConnClosedHandler(func(connectionID string, _ int, _ string) {
+		h.mut.Lock()
+		defer h.mut.Unlock()
+		h.closedOnServer[connectionID]++
+		close(h.closedOnServerChanged)
+		h.closedOnServerChanged = make(chan struct{})
+	})
+	go func() {
+		defer close(h.serveDone)
+		_ = h.raw.Serve(listener)
+	}()
+	t.Cleanup(h.Close)
+	return h
+}
+
+// Starts a new server with room forwarding on the given message types, see Server.AddRoomForwardingFunctionality
+func StartRooms(t testing.TB, controllers wsclientable.RoomControllers, messageTypes ...string) *Harness {
+	t.Helper()
+	server := wsclientable.NewWSHandlingServer()
+	server.AddRoomForwardingFunctionality(controllers, messageTypes...)
+	h := Start(t, &server)
+	h.Rooms = &controllers
+	return h
+}
+
+// Connects with the url params user=<userID>, fails the test if the connection is rejected
+func (h *Harness) Connect(userID string) *Client {
+	h.t.Helper()
+	c, err := h.TryConnect(url.Values{"user": []string{userID}})
+	if err != nil {
+		h.t.Fatalf("could not connect as %v: %v", userID, err)
+	}
+	return c
+}
+
+// Connects with the url params room=<roomID>&user=<userID>, fails the test if the connection is rejected
+func (h *Harness) ConnectToRoom(roomID, userID string) *Client {
+	h.t.Helper()
+	c, err := h.TryConnectToRoom(roomID, userID)
+	if err != nil {
+		h.t.Fatalf("could not connect as %v to room %v: %v", userID, roomID, err)
+	}
+	return c
+}
+
+// Same as ConnectToRoom, but returns the error (to assert on rejected connections)
+func (h *Harness) TryConnectToRoom(roomID, userID string) (*Client, error) {
+	return h.TryConnect(url.Values{"room": []string{roomID}, "user": []string{userID}})
+}
+
+// Connects with the given url params and connect options, returns the error if the connection is rejected
+func (h *Harness) TryConnect(params url.Values, options ...wsclientable.ConnectOptions) (*Client, error) {
+	raw, err := wsclientable.Connect(h.URL+"?"+params.Encode(), options...)
+	if err != nil {
+		return nil, err
+	}
+	c := newClient(h.t, raw)
+
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	if h.closed {
+		_ = raw.Close()
+		return nil, fmt.Errorf("harness already closed")
+	}
+	h.clients = append(h.clients, c)
+	return c, nil
+}
+
+// Waits until the server handled the close of a connection with the given id (all of its conn closed handlers ran)
+//   fails the test after DefaultTimeout, each handled close is awaited once
+func (h *Harness) ExpectClosedOnServer(connectionID string) {
+	h.t.Helper()
+	timeout := time.After(DefaultTimeout)
+	for {
+		h.mut.Lock()
+		if h.closedOnServer[connectionID] > 0 {
+			h.closedOnServer[connectionID]--
+			h.mut.Unlock()
+			return
+		}
+		changed := h.closedOnServerChanged
+		h.mut.Unlock()
+
+		select {
+		case <-changed:
+		case <-timeout:
+			h.t.Fatalf("server did not handle the close of %v within %v", connectionID, DefaultTimeout)
+		}
+	}
+}
+
+// Closes all clients and the server (which closes the room controllers). Safe to call multiple times.
+func (h *Harness) Close() {
+	h.mut.Lock()
+	if h.closed {
+		h.mut.Unlock()
+		return
+	}
+	h.closed = true
+	clients := h.clients
+	h.mut.Unlock()
+
+	for _, c := range clients {
+		_ = c.Close()
+	}
+	_ = h.Server.Close()
+	_ = h.raw.Close()
+	select {
+	case <-h.serveDone:
+	case <-time.After(DefaultTimeout):
+		h.t.Errorf("server did not stop within %v", DefaultTimeout)
+	}
+}
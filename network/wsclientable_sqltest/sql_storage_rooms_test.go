@@ -0,0 +1,178 @@
+package wsclientable_test
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable"
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable/wsclientabletest"
+	"gopkg.in/ini.v1"
+	_ "modernc.org/sqlite"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openSQLite(t *testing.T, dbPath string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("could not open sqlite: %v", err)
+	}
+	return db
+}
+
+func openSQLiteStorage(t *testing.T, dbPath string) wsclientable.SQLRoomStorage {
+	t.Helper()
+	store, err := wsclientable.NewRoomSQLStorage(openSQLite(t, dbPath), wsclientable.SQLiteDialect)
+	if err != nil {
+		t.Fatalf("could not open storage: %v", err)
+	}
+	return store
+}
+
+func TestSQLRoomStorageStoresAllRoomTypes(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "rooms.sqlite")
+	now := time.Now().Unix()
+	schedule, err := wsclientable.NewSchedule("UTC", []string{"MON-FRI 09:00-17:00"}, nil)
+	if err != nil {
+		t.Fatalf("could not create schedule: %v", err)
+	}
+	history, _ := wsclientable.ParseHistoryPolicy(`{"message_types":["chat"], "replay":5}`)
+	rooms := []wsclientable.RoomI{
+		wsclientable.NewPermanentRoom("p", []string{"a", "!glob:b*"}).WithMaxParticipants(3).
+			WithRoles(map[string]wsclientable.Role{"a": wsclientable.RoleOwner}).WithHistoryPolicy(history),
+		wsclientable.NewTemporaryRoom("t", []string{"b"}, now-10, now+3600).WithLobby(true).
+			WithUserWindows(map[string]wsclientable.UserWindow{"c": {FromUnixTime: now, UntilUnixTime: now + 60}}),
+		wsclientable.NewRepeatingRoom("r", []string{}, now, 60, 10).WithMaxOccurrences(5),
+		wsclientable.NewScheduledRoom("s", []string{"c"}, schedule),
+	}
+
+	store := openSQLiteStorage(t, dbPath)
+	for _, room := range rooms {
+		if err := store.Put(room, false); err != nil {
+			t.Fatalf("could not put %v: %v", room.GetID(), err)
+		}
+	}
+	if err := store.Put(rooms[1], false); err == nil {
+		t.Fatalf("existing room put without override")
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("could not close: %v", err)
+	}
+
+	// opening again does not migrate again
+	store = openSQLiteStorage(t, dbPath)
+	defer func() { _ = store.Close() }()
+	if p := store.Get("p").(wsclientable.PermanentRoom); !p.AllowsClient("a") || p.AllowsClient("bob") || p.MaxParticipants != 3 ||
+		p.GetRole("a") != wsclientable.RoleOwner || p.GetHistoryPolicy().Replay != 5 {
+		t.Fatalf("permanent room not restored: %v", p)
+	}
+	if tr := store.Get("t").(wsclientable.TemporaryRoom); tr.ValidUntilUnixTime != now+3600 || !tr.HasLobby() || !tr.AllowsClient("c") {
+		t.Fatalf("temporary room not restored: %v", tr)
+	}
+	if r := store.Get("r").(wsclientable.RepeatingRoom); r.RepeatEverySeconds != 60 || r.DurationInSeconds != 10 || r.MaxOccurrences != 5 {
+		t.Fatalf("repeating room not restored: %v", r)
+	}
+	if s := store.Get("s").(wsclientable.ScheduledRoom); s.Schedule.Timezone != "UTC" || len(s.Schedule.Rules) != 1 {
+		t.Fatalf("scheduled room not restored: %v", s)
+	}
+
+	temporaries := store.OfTypes(wsclientable.RoomTypeTemporary)
+	if temporaries.Get("p") != nil {
+		t.Fatalf("view saw a room of another type")
+	}
+	if err := temporaries.Put(wsclientable.NewTemporaryRoom("p", nil, now, now+10), true); err == nil {
+		t.Fatalf("view replaced a room of another type")
+	}
+	if existed, err := temporaries.Remove("p"); existed || err != nil {
+		t.Fatalf("view removed a room of another type")
+	}
+	var ids []string
+	if err := store.ForAll(func(room wsclientable.RoomI) { ids = append(ids, room.GetID()) }); err != nil || len(ids) != 4 {
+		t.Fatalf("expected 4 rooms, got %v (%v)", ids, err)
+	}
+}
+
+func TestSQLRoomStorageExpiration(t *testing.T) {
+	store := openSQLiteStorage(t, filepath.Join(t.TempDir(), "rooms.sqlite"))
+	controller := wsclientable.NewTemporaryRoomController(store.OfTypes(wsclientable.RoomTypeTemporary))
+	controllers := wsclientable.BundleControllers(controller)
+	server := wsclientable.NewWSHandlingServer()
+	server.AddRoomForwardingFunctionality(controllers)
+	h := wsclientabletest.Start(t, &server)
+
+	now := time.Now().Unix()
+	if err := controller.AddRoom("short", wsclientable.NewTemporaryRoom("short", nil, now-10, now+60), false); err != nil {
+		t.Fatalf("could not add room: %v", err)
+	}
+	if _, err := controller.SetRoomValidUntil("short", now+1); err != nil {
+		t.Fatalf("could not shorten room: %v", err)
+	}
+	if err := controller.AddRoom("long", wsclientable.NewTemporaryRoom("long", nil, now-10, now+60), false); err != nil {
+		t.Fatalf("could not add room: %v", err)
+	}
+	c := h.ConnectToRoom("short", "a")
+
+	if code, reason := c.ExpectClosed(); code != wsclientable.CloseCodeRoomClosed {
+		t.Fatalf("unexpected close: %v %v", code, reason)
+	}
+	if controller.GetRoom("short") != nil || controller.GetRoom("long") == nil {
+		t.Fatalf("expected only the expired room to be removed")
+	}
+	next, err := store.CleanExpired(func(room *wsclientable.TemporaryRoom) { t.Fatalf("removed %v twice", room.GetID()) })
+	if err != nil || next == nil || next.GetID() != "long" {
+		t.Fatalf("expected long to expire next, got %v (%v)", next, err)
+	}
+}
+
+func TestSQLRoomStorageRejectsNewerSchema(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "rooms.sqlite")
+	_ = openSQLiteStorage(t, dbPath).Close()
+
+	db := openSQLite(t, dbPath)
+	defer func() { _ = db.Close() }()
+	version, err := wsclientable.SQLRoomSchemaVersion(db)
+	if err != nil || version < 1 {
+		t.Fatalf("expected a schema version, got %v (%v)", version, err)
+	}
+	if _, err := db.Exec("UPDATE wsclientable_rooms_schema SET version = version + 1"); err != nil {
+		t.Fatalf("could not change version: %v", err)
+	}
+	_, err = wsclientable.NewRoomSQLStorage(db, wsclientable.SQLiteDialect)
+	var tooNew wsclientable.SQLSchemaTooNewError
+	if !errors.As(err, &tooNew) || tooNew.Version != version+1 {
+		t.Fatalf("expected a schema too new error, got %v", err)
+	}
+}
+
+func TestRoomStorageFromCFGWithSQL(t *testing.T) {
+	cfg := ini.Empty()
+	cfg.Section("rooms_sql").Key("driver").SetValue("sqlite")
+	cfg.Section("rooms_sql").Key("dsn").SetValue(filepath.Join(t.TempDir(), "rooms.sqlite"))
+
+	permanents := wsclientable.RoomStorageFromCFG(cfg, wsclientable.RoomTypePermanent)
+	defer func() { _ = permanents.Close() }()
+	temporaries := wsclientable.RoomStorageFromCFG(cfg, wsclientable.RoomTypeTemporary)
+	defer func() { _ = temporaries.Close() }()
+	if err := permanents.Put(wsclientable.NewPermanentRoom("p", nil), false); err != nil {
+		t.Fatalf("could not put: %v", err)
+	}
+	if temporaries.Get("p") != nil || permanents.Get("p") == nil {
+		t.Fatalf("expected the room only in the permanent view")
+	}
+
+	// the editors share one database, closing one of them does not close it for the others
+	_ = permanents.Close()
+	_ = permanents.Close()
+	if err := temporaries.Put(wsclientable.NewTemporaryRoom("t", nil, 1, 4102444800), false); err != nil {
+		t.Fatalf("database closed with the other view: %v", err)
+	}
+	_ = temporaries.Close()
+
+	// once all are closed, the next editor opens it again
+	reopened := wsclientable.RoomStorageFromCFG(cfg, wsclientable.RoomTypePermanent)
+	defer func() { _ = reopened.Close() }()
+	if reopened.Get("p") == nil {
+		t.Fatalf("database not reopened")
+	}
+}
@@ -0,0 +1,208 @@
+package wsclientable_test
+
+import (
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable"
+	bolt "go.etcd.io/bbolt"
+	"gopkg.in/ini.v1"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// A room type unknown to the package, open on even unix seconds
+type evenSecondsRoom struct {
+	ID   string
+	Note string
+}
+
+func (r evenSecondsRoom) GetID() string                { return r.ID }
+func (r evenSecondsRoom) IsAllowed(userID string) bool { return time.Now().Unix()%2 == 0 }
+func (r evenSecondsRoom) IsValid() bool                { return true }
+
+type evenSecondsRoomCodec struct{}
+
+func (evenSecondsRoomCodec) RoomType() string { return "even_seconds" }
+func (evenSecondsRoomCodec) Handles(room wsclientable.RoomI) bool {
+	_, ok := room.(evenSecondsRoom)
+	return ok
+}
+func (evenSecondsRoomCodec) Encode(room wsclientable.RoomI, roomB *bolt.Bucket) error {
+	return roomB.Put([]byte("Note"), []byte(room.(evenSecondsRoom).Note))
+}
+func (evenSecondsRoomCodec) Decode(roomID string, roomB *bolt.Bucket) (wsclientable.RoomI, error) {
+	return evenSecondsRoom{ID: roomID, Note: string(roomB.Get([]byte("Note")))}, nil
+}
+
+func TestBoltRoomStorageStoresAllRoomTypes(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "rooms.db")
+	now := time.Now().Unix()
+	schedule, err := wsclientable.NewSchedule("UTC", []string{"MON-FRI 09:00-17:00"}, nil)
+	if err != nil {
+		t.Fatalf("could not create schedule: %v", err)
+	}
+	rooms := []wsclientable.RoomI{
+		wsclientable.NewPermanentRoom("p", []string{"a", "group:staff"}).WithMaxParticipants(3).WithRoles(map[string]wsclientable.Role{"a": wsclientable.RoleOwner}),
+		wsclientable.NewTemporaryRoom("t", []string{"b"}, now-10, now+3600).WithLobby(true),
+		wsclientable.NewRepeatingRoom("r", []string{}, now, 60, 10).WithMaxOccurrences(5),
+		wsclientable.NewScheduledRoom("s", []string{"c"}, schedule),
+		evenSecondsRoom{ID: "e", Note: "custom"},
+	}
+
+	store, err := wsclientable.NewRoomBoltStorage(dbPath, evenSecondsRoomCodec{})
+	if err != nil {
+		t.Fatalf("could not open: %v", err)
+	}
+	for _, room := range rooms {
+		if err := store.Put(room, false); err != nil {
+			t.Fatalf("could not put %v: %v", room.GetID(), err)
+		}
+	}
+	if err := store.Put(rooms[1], false); err == nil {
+		t.Fatalf("existing room put without override")
+	}
+	if err := store.Put(wsclientable.NewPermanentRoom("t", nil), true); err != nil {
+		t.Fatalf("could not override room with a room of another type: %v", err)
+	}
+	if err := store.Put(rooms[1], true); err != nil {
+		t.Fatalf("could not override room: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("could not close: %v", err)
+	}
+
+	store, err = wsclientable.NewRoomBoltStorage(dbPath, evenSecondsRoomCodec{})
+	if err != nil {
+		t.Fatalf("could not open: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+	if p := store.Get("p").(wsclientable.PermanentRoom); !p.AllowsClient("a") || p.AllowsClient("b") || p.MaxParticipants != 3 || p.GetRole("a") != wsclientable.RoleOwner {
+		t.Fatalf("permanent room not restored: %v", p)
+	}
+	if tr := store.Get("t").(wsclientable.TemporaryRoom); tr.ValidUntilUnixTime != now+3600 || !tr.HasLobby() || !tr.AllowsClient("b") {
+		t.Fatalf("temporary room not restored: %v", tr)
+	}
+	if r := store.Get("r").(wsclientable.RepeatingRoom); r.RepeatEverySeconds != 60 || r.MaxOccurrences != 5 {
+		t.Fatalf("repeating room not restored: %v", r)
+	}
+	if s := store.Get("s").(wsclientable.ScheduledRoom); s.Schedule.Timezone != "UTC" || len(s.Schedule.Rules) != 1 {
+		t.Fatalf("scheduled room not restored: %v", s)
+	}
+	if e := store.Get("e").(evenSecondsRoom); e.Note != "custom" {
+		t.Fatalf("custom room not restored: %v", e)
+	}
+	var ids []string
+	if err := store.ForAll(func(room wsclientable.RoomI) { ids = append(ids, room.GetID()) }); err != nil || len(ids) != 5 {
+		t.Fatalf("expected 5 rooms, got %v (%v)", ids, err)
+	}
+
+	type unknownRoom struct{ evenSecondsRoom }
+	if err := store.Put(unknownRoom{}, false); err == nil {
+		t.Fatalf("room without codec stored")
+	}
+}
+
+func TestBoltRoomStorageViews(t *testing.T) {
+	store, err := wsclientable.NewRoomBoltStorage(filepath.Join(t.TempDir(), "rooms.db"))
+	if err != nil {
+		t.Fatalf("could not open: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+	temporaries := store.OfTypes(wsclientable.RoomTypeTemporary)
+	permanents := store.OfTypes(wsclientable.RoomTypePermanent)
+
+	now := time.Now().Unix()
+	if err := temporaries.Put(wsclientable.NewTemporaryRoom("t", nil, now, now+60), false); err != nil {
+		t.Fatalf("could not put: %v", err)
+	}
+	if err := temporaries.Put(wsclientable.NewPermanentRoom("p", nil), false); err == nil {
+		t.Fatalf("view accepted a room of another type")
+	}
+	if err := permanents.Put(wsclientable.NewPermanentRoom("t", nil), true); err == nil {
+		t.Fatalf("view replaced a room of another type")
+	}
+	if permanents.Get("t") != nil || store.Get("t") == nil {
+		t.Fatalf("view saw a room of another type")
+	}
+	if existed, err := permanents.Remove("t"); existed || err != nil {
+		t.Fatalf("view removed a room of another type")
+	}
+	if existed, err := temporaries.Remove("t"); !existed || err != nil {
+		t.Fatalf("could not remove: %v", err)
+	}
+
+	_ = temporaries.Close()
+	_ = temporaries.Close()
+	if err := permanents.Put(wsclientable.NewPermanentRoom("p", nil), false); err != nil || store.Get("p") == nil {
+		t.Fatalf("closing a view closed the database for the others (%v)", err)
+	}
+	_ = permanents.Close()
+}
+
+func TestBoltRoomStorageExpiration(t *testing.T) {
+	store, err := wsclientable.NewRoomBoltStorage(filepath.Join(t.TempDir(), "rooms.db"))
+	if err != nil {
+		t.Fatalf("could not open: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+	controller := wsclientable.NewTemporaryRoomController(store.OfTypes(wsclientable.RoomTypeTemporary))
+	defer func() { _ = controller.Close() }()
+
+	now := time.Now().Unix()
+	if err := controller.AddRoom("short", wsclientable.NewTemporaryRoom("short", nil, now-10, now+1), false); err != nil {
+		t.Fatalf("could not add room: %v", err)
+	}
+	if err := controller.AddRoom("long", wsclientable.NewTemporaryRoom("long", nil, now-10, now+60), false); err != nil {
+		t.Fatalf("could not add room: %v", err)
+	}
+	if _, err := controller.SetRoomValidUntil("long", now+1); err != nil {
+		t.Fatalf("could not shorten room: %v", err)
+	}
+	// expired, but not seen by the controller - removed by the views that see it
+	repeating := wsclientable.NewRepeatingRoom("repeating", nil, now-100, 10, 5).WithUntil(now - 1)
+	if err := store.Put(repeating, false); err != nil {
+		t.Fatalf("could not put: %v", err)
+	}
+
+	time.Sleep(3 * time.Second)
+	if controller.GetRoom("short") != nil || controller.GetRoom("long") != nil {
+		t.Fatalf("expired temporary rooms not removed")
+	}
+	if store.Get("repeating") == nil {
+		t.Fatalf("room of another type removed by the temporary controller")
+	}
+	var removed []string
+	next, err := store.CleanExpiredRooms(func(room wsclientable.RoomI) { removed = append(removed, room.GetID()) })
+	if err != nil || next != nil || len(removed) != 1 || removed[0] != "repeating" {
+		t.Fatalf("expected the repeating room to be removed, got %v, next %v (%v)", removed, next, err)
+	}
+}
+
+func TestRoomStorageFromCFGSharesTheDatabase(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "rooms.db")
+	cfg := ini.Empty()
+	cfg.Section("rooms_db").Key("db_path").SetValue(dbPath)
+
+	permanents := wsclientable.RoomStorageFromCFG(cfg, wsclientable.RoomTypePermanent)
+	temporaries := wsclientable.RoomStorageFromCFG(cfg, wsclientable.RoomTypeTemporary)
+	if err := permanents.Put(wsclientable.NewPermanentRoom("p", nil), false); err != nil {
+		t.Fatalf("could not put: %v", err)
+	}
+	if err := temporaries.Put(wsclientable.NewPermanentRoom("p", nil), true); err == nil {
+		t.Fatalf("temporary view accepted a permanent room")
+	}
+	_ = permanents.Close()
+	if err := temporaries.Put(wsclientable.NewTemporaryRoom("t", nil, 0, time.Now().Unix()+60), false); err != nil {
+		t.Fatalf("closing one editor's storage closed the database for the others: %v", err)
+	}
+	_ = temporaries.Close()
+
+	reopened := wsclientable.RoomStorageFromCFG(cfg, wsclientable.RoomTypePermanent)
+	defer func() { _ = reopened.Close() }()
+	if reopened.Get("p") == nil {
+		t.Fatalf("room not persisted")
+	}
+
+	if _, ok := wsclientable.RoomStorageFromCFG(ini.Empty(), wsclientable.RoomTypePermanent).(wsclientable.RamRoomStorage); !ok {
+		t.Fatalf("expected rooms in memory without [rooms_db]")
+	}
+}
@@ -0,0 +1,187 @@
+package wsclientable_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable"
+	bolt "go.etcd.io/bbolt"
+	"path/filepath"
+	"testing"
+)
+
+func bigEndian(i int64) []byte {
+	bs := make([]byte, 8)
+	binary.BigEndian.PutUint64(bs, uint64(i))
+	return bs
+}
+
+func updateRawBolt(t *testing.T, dbPath string, update func(tx *bolt.Tx) error) {
+	t.Helper()
+	db, err := bolt.Open(dbPath, 0600, nil)
+	if err != nil {
+		t.Fatalf("could not open: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+	if err := db.Update(update); err != nil {
+		t.Fatalf("could not update: %v", err)
+	}
+}
+
+func TestBoltRepeatingRoomStorageMigratesTopLevelRooms(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "repeat.db")
+	// the layout before schema versions, one bucket per room at the top level - a room may even be called "rooms"
+	updateRawBolt(t, dbPath, func(tx *bolt.Tx) error {
+		for _, roomID := range []string{"r", "rooms"} {
+			roomB, err := tx.CreateBucket([]byte(roomID))
+			if err != nil {
+				return err
+			}
+			allowedB, err := roomB.CreateBucket([]byte("allowedClientIDs"))
+			if err != nil {
+				return err
+			}
+			if err := allowedB.Put([]byte("a"), []byte{}); err != nil {
+				return err
+			}
+			_ = roomB.Put([]byte("FirstTimeUnixTimestamp"), bigEndian(1000))
+			_ = roomB.Put([]byte("RepeatEverySeconds"), bigEndian(60))
+			_ = roomB.Put([]byte("DurationInSeconds"), bigEndian(10))
+		}
+		return nil
+	})
+
+	store := wsclientable.NewRepeatingRoomBoltStorage(dbPath)
+	for _, roomID := range []string{"r", "rooms"} {
+		room, ok := store.Get(roomID).(wsclientable.RepeatingRoom)
+		if !ok || room.RepeatEverySeconds != 60 || room.DurationInSeconds != 10 || !room.AllowsClient("a") || room.AllowsClient("b") {
+			t.Fatalf("room %v not migrated: %v", roomID, room)
+		}
+	}
+	if existed, err := store.Remove("rooms"); !existed || err != nil {
+		t.Fatalf("could not remove migrated room: %v", err)
+	}
+	_ = store.Close()
+
+	// opening again does not migrate again
+	store = wsclientable.NewRepeatingRoomBoltStorage(dbPath)
+	defer func() { _ = store.Close() }()
+	if store.Get("r") == nil || store.Get("rooms") != nil {
+		t.Fatalf("migrated rooms not persisted")
+	}
+}
+
+func TestBoltRoomStorageRejectsNewerSchema(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "rooms.db")
+	store, err := wsclientable.NewRoomBoltStorage(dbPath)
+	if err != nil {
+		t.Fatalf("could not open: %v", err)
+	}
+	_ = store.Close()
+	var version int64
+	updateRawBolt(t, dbPath, func(tx *bolt.Tx) error {
+		metaB := tx.Bucket([]byte("\x00meta"))
+		version = int64(binary.BigEndian.Uint64(metaB.Get([]byte("version"))))
+		return metaB.Put([]byte("version"), bigEndian(version+1))
+	})
+
+	_, err = wsclientable.NewRoomBoltStorage(dbPath)
+	var tooNew wsclientable.BoltSchemaTooNewError
+	if !errors.As(err, &tooNew) || int64(tooNew.Version) != version+1 || int64(tooNew.SupportedVersion) != version {
+		t.Fatalf("expected a schema too new error, got %v", err)
+	}
+}
+
+func TestBoltRoomStoragesRejectOtherLayouts(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "temp.db")
+	_ = wsclientable.NewTemporaryRoomBoltStorage(dbPath).Close()
+
+	if _, err := wsclientable.OpenRepeatingRoomBoltStorage(dbPath); err == nil {
+		t.Fatalf("repeating rooms storage opened a temporary rooms database")
+	}
+	if _, err := wsclientable.NewRoomStateBoltStorage(dbPath); err == nil {
+		t.Fatalf("room state storage opened a temporary rooms database")
+	}
+	if _, err := wsclientable.NewUserGroupsBoltStorage(dbPath); err == nil {
+		t.Fatalf("user groups storage opened a temporary rooms database")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("repeating rooms storage opened a temporary rooms database")
+		}
+	}()
+	wsclientable.NewRepeatingRoomBoltStorage(dbPath)
+}
+
+func TestBoltRoomHistoryStorageMigratesUnversionedFiles(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "history.db")
+	// the layout before schema versions, only the histories bucket
+	updateRawBolt(t, dbPath, func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("histories"))
+		return err
+	})
+
+	storage, err := wsclientable.NewRoomHistoryBoltStorage(dbPath, wsclientable.HistoryRetention{MaxMessages: 10})
+	if err != nil {
+		t.Fatalf("could not open an unversioned history database: %v", err)
+	}
+	_ = storage.Close()
+	if _, err := wsclientable.NewWebhookOutboxBoltStorage(dbPath); err == nil {
+		t.Fatalf("webhook outbox opened a history database")
+	}
+}
+
+func TestBoltRoomStorageMigratesExpirationsIntoTypes(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "rooms.db")
+	// version 1 kept the expirations of all types in one bucket
+	updateRawBolt(t, dbPath, func(tx *bolt.Tx) error {
+		metaB, err := tx.CreateBucket([]byte("\x00meta"))
+		if err != nil {
+			return err
+		}
+		_ = metaB.Put([]byte("layout"), []byte("rooms"))
+		_ = metaB.Put([]byte("version"), bigEndian(1))
+		roomsB, err := tx.CreateBucket([]byte("rooms"))
+		if err != nil {
+			return err
+		}
+		roomB, err := roomsB.CreateBucket([]byte("t"))
+		if err != nil {
+			return err
+		}
+		_ = roomB.Put([]byte("Type"), []byte(wsclientable.RoomTypeTemporary))
+		_ = roomB.Put([]byte("ExpiryUnixTime"), bigEndian(100))
+		_ = roomB.Put([]byte("ValidFromUnixTime"), bigEndian(1))
+		_ = roomB.Put([]byte("ValidUntilUnixTime"), bigEndian(100))
+		if _, err := roomB.CreateBucket([]byte("allowedClientIDs")); err != nil {
+			return err
+		}
+		expirationsB, err := tx.CreateBucket([]byte("expirations"))
+		if err != nil {
+			return err
+		}
+		expirationB, err := expirationsB.CreateBucket(bigEndian(100))
+		if err != nil {
+			return err
+		}
+		_ = expirationB.Put([]byte("t"), []byte{})
+		_ = expirationB.Put([]byte("stale"), []byte{})
+		return nil
+	})
+
+	store, err := wsclientable.NewRoomBoltStorage(dbPath)
+	if err != nil {
+		t.Fatalf("could not open: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+	permanents := store.OfTypes(wsclientable.RoomTypePermanent)
+	defer func() { _ = permanents.Close() }()
+	var removed []string
+	_, err = permanents.CleanExpiredRooms(func(room wsclientable.RoomI) { removed = append(removed, room.GetID()) })
+	if err != nil || len(removed) != 0 {
+		t.Fatalf("view removed rooms of another type: %v (%v)", removed, err)
+	}
+	_, err = store.CleanExpiredRooms(func(room wsclientable.RoomI) { removed = append(removed, room.GetID()) })
+	if err != nil || len(removed) != 1 || removed[0] != "t" || store.Get("t") != nil {
+		t.Fatalf("migrated expiration not found: %v (%v)", removed, err)
+	}
+}
@@ -0,0 +1,81 @@
+package wsclientable_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable"
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable/wsclientabletest"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestConnectWithHeadersAndSubprotocols(t *testing.T) {
+	server := wsclientable.NewWSHandlingServer()
+	server.SetRequestAuthenticator(func(request *http.Request) (string, error) {
+		if request.Header.Get("Authorization") != "Bearer secret" {
+			return "", wsclientable.AuthenticationError{Reason: "missing bearer token"}
+		}
+		return "u1", nil
+	})
+	server.SetSubprotocols("signaling.v2", "signaling.v1")
+	negotiated := make(chan string, 1)
+	server.AddConnOpenedHandler(func(connection wsclientable.ClientConnection) {
+		negotiated <- connection.Subprotocol()
+	})
+	h := wsclientabletest.Start(t, &server)
+	params := url.Values{"user": []string{"u1"}}
+
+	if _, err := h.TryConnect(params); err == nil {
+		t.Fatalf("connected without authorization header")
+	}
+
+	client, err := h.TryConnect(params, wsclientable.ConnectOptions{
+		Header:           http.Header{"Authorization": []string{"Bearer secret"}},
+		HandshakeTimeout: time.Second,
+		Proxy:            wsclientable.NoProxy,
+		Subprotocols:     []string{"signaling.v1", "signaling.v2"},
+	})
+	if err != nil {
+		t.Fatalf("could not connect with authorization header: %v", err)
+	}
+	if client.Subprotocol() != "signaling.v2" {
+		t.Fatalf("client negotiated %q, expected the preference of the server", client.Subprotocol())
+	}
+	select {
+	case serverSide := <-negotiated:
+		if serverSide != "signaling.v2" {
+			t.Fatalf("server negotiated %q", serverSide)
+		}
+	case <-time.After(wsclientabletest.DefaultTimeout):
+		t.Fatalf("connection not opened on the server")
+	}
+
+	if _, err := h.TryConnect(params, wsclientable.ConnectOptions{}, wsclientable.ConnectOptions{}); err == nil {
+		t.Fatalf("accepted more than one ConnectOptions")
+	}
+	if _, err := h.TryConnect(params, wsclientable.ConnectOptions{CAPem: []byte("not a certificate")}); err == nil {
+		t.Fatalf("accepted invalid CAPem")
+	}
+}
+
+func TestConnectWithCAPemKeepsTheRootCAsOfTheCaller(t *testing.T) {
+	server := wsclientable.NewWSHandlingServer()
+	server.SetAuthenticator(wsclientable.AuthenticateUserPermitAll())
+	h := wsclientabletest.Start(t, &server)
+
+	caCert, _ := generateTestCert(t, nil, nil, "test-ca", true)
+	pool := x509.NewCertPool()
+	options := wsclientable.ConnectOptions{
+		TLSConfig: &tls.Config{RootCAs: pool},
+		CAPem:     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw}),
+	}
+	if _, err := h.TryConnect(url.Values{"user": []string{"u1"}}, options); err != nil {
+		t.Fatalf("could not connect: %v", err)
+	}
+	if !pool.Equal(x509.NewCertPool()) {
+		t.Fatalf("CAPem was added to the RootCAs of the given TLSConfig")
+	}
+}
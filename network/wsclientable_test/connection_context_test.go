@@ -0,0 +1,52 @@
+package wsclientable_test
+
+import (
+	"context"
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable"
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable/wsclientabletest"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type accountKey struct{}
+
+func TestConnectionContextCancelledOnDisconnect(t *testing.T) {
+	started := make(chan struct{})
+	observed := make(chan interface{}, 1)
+
+	server := wsclientable.NewWSHandlingServer()
+	server.SetContextAuthenticator(func(request *http.Request) (context.Context, wsclientable.Identity, error) {
+		userID := request.URL.Query().Get("user")
+		ctx := context.WithValue(request.Context(), accountKey{}, "account-of-"+userID)
+		return ctx, wsclientable.Identity{UserID: userID}, nil
+	})
+	server.AddMessageHandler("slow", func(_ string, connection wsclientable.ClientConnection, _ map[string]interface{}) {
+		close(started)
+		select {
+		case <-connection.Context().Done():
+			observed <- connection.Context().Value(accountKey{})
+		case <-time.After(wsclientabletest.DefaultTimeout):
+			observed <- nil
+		}
+	})
+	h := wsclientabletest.Start(t, &server)
+
+	c := h.Connect("u1")
+	c.Send("slow", map[string]interface{}{})
+	select {
+	case <-started:
+	case <-time.After(wsclientabletest.DefaultTimeout):
+		t.Fatalf("handler not called")
+	}
+	_ = c.Close()
+
+	select {
+	case v := <-observed:
+		if v != "account-of-u1" {
+			t.Fatalf("context not cancelled or value missing, got: %v", v)
+		}
+	case <-time.After(2 * wsclientabletest.DefaultTimeout):
+		t.Fatalf("handler did not return")
+	}
+}
@@ -0,0 +1,39 @@
+package wsclientable_test
+
+import (
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable"
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable/wsclientabletest"
+	"testing"
+	"time"
+)
+
+func TestHarnessRoomForwarding(t *testing.T) {
+	t.Parallel()
+	temporaryRooms := wsclientable.NewTemporaryRoomController(wsclientable.NewMutableRamRoomStorage())
+	h := wsclientabletest.StartRooms(t, wsclientable.BundleControllers(
+		wsclientable.NewPermanentRoomController(wsclientable.NewPermanentRoom("testRoom", []string{"u1", "u2"})),
+		temporaryRooms,
+	), "roomForward")
+
+	u1 := h.ConnectToRoom("testRoom", "u1")
+	u2 := h.ConnectToRoom("testRoom", "u2")
+	if _, err := h.TryConnectToRoom("testRoom", "u3"); err == nil {
+		t.Fatalf("u3 is not allowed in testRoom")
+	}
+
+	u1.Send("roomForward", map[string]interface{}{"to": "u2", "text": "hello"})
+	u2.ExpectWith("roomForward", map[string]interface{}{"from": "u1", "text": "hello"})
+	u1.Send("roomForward", map[string]interface{}{"to": "u3"})
+	u1.Expect("error")
+
+	now := time.Now().Unix()
+	err := temporaryRooms.AddRoom("tempRoom", wsclientable.NewTemporaryRoom("tempRoom", []string{}, now, now+1), false)
+	if err != nil {
+		t.Fatalf("could not add temporary room: %v", err)
+	}
+	t1 := h.ConnectToRoom("tempRoom", "t1")
+	if _, _, err := t1.AwaitClosed(5 * time.Second); err != nil {
+		t.Fatalf("client in expired temporary room not disconnected: %v", err)
+	}
+	u2.ExpectNone("roomForward", 100*time.Millisecond)
+}
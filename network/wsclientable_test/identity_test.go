@@ -0,0 +1,105 @@
+package wsclientable_test
+
+import (
+	"context"
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable"
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable/wsclientabletest"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRoomConnectionIdentity(t *testing.T) {
+	controller := wsclientable.NewTemporaryRoomController(wsclientable.NewMutableRamRoomStorage())
+	server := wsclientable.NewWSHandlingServer()
+	server.AddRoomForwardingFunctionalityWithContextAuthenticator(
+		wsclientable.BundleControllers(controller),
+		func(request *http.Request) (context.Context, wsclientable.Identity, error) {
+			userID := request.URL.Query().Get("user")
+			identity := wsclientable.Identity{UserID: userID}.WithAttribute("displayName", "Name of "+userID)
+			return request.Context(), identity, nil
+		},
+		"roomForward",
+	)
+	server.AddMessageHandler("whoami", func(_ string, connection wsclientable.ClientConnection, _ map[string]interface{}) {
+		_ = connection.SendMapTyped("whoami", map[string]interface{}{
+			"room": connection.Identity.RoomID,
+			"user": connection.Identity.UserID,
+			"name": connection.Identity.StringAttribute("displayName"),
+		})
+	})
+	h := wsclientabletest.Start(t, &server)
+
+	quoted := `u1", "r":"otherRoom`
+	if err := controller.AddRoom("r1", wsclientable.NewTemporaryRoom("r1", []string{quoted, "u2"}, 0, time.Now().Add(time.Hour).Unix()), false); err != nil {
+		t.Fatalf("could not add room: %v", err)
+	}
+
+	c1 := h.ConnectToRoom("r1", quoted)
+	c2 := h.ConnectToRoom("r1", "u2")
+
+	c1.Send("whoami", map[string]interface{}{})
+	c1.ExpectWith("whoami", map[string]interface{}{"room": "r1", "user": quoted, "name": "Name of " + quoted})
+
+	c2.Send("roomForward", map[string]interface{}{"to": quoted, "m": "hi"})
+	c1.ExpectWith("roomForward", map[string]interface{}{"from": "u2", "m": "hi"})
+
+	if _, err := h.TryConnectToRoom("r1", strings.Repeat("u", wsclientable.MaxIDLength+1)); err == nil {
+		t.Fatalf("connected with a too long user id")
+	}
+	if _, err := h.TryConnect(url.Values{"room": []string{"r1"}, "user": []string{"u\n3"}}); err == nil {
+		t.Fatalf("connected with a control character in the user id")
+	}
+}
+
+func TestConnClosedHandlersReceiveTheRoomAndUserConnectionID(t *testing.T) {
+	controller := wsclientable.NewTemporaryRoomController(wsclientable.NewMutableRamRoomStorage())
+	for _, roomID := range []string{"r1", "r\"2"} {
+		if err := controller.AddRoom(roomID, wsclientable.NewTemporaryRoom(roomID, []string{"u1"}, 0, time.Now().Add(time.Hour).Unix()), false); err != nil {
+			t.Fatalf("could not add room: %v", err)
+		}
+	}
+	server := wsclientable.NewWSHandlingServer()
+	server.SetAuthenticator(wsclientable.AuthenticateRoomUserPermitAllowed(controller))
+	closedIDs := make(chan string, 1)
+	closedRooms := make(chan string, 1)
+	server.AddConnClosedHandler(func(connectionID string, _ int, _ string) { closedIDs <- connectionID })
+	server.AddConnClosedHandlerWithConnection(func(connection wsclientable.ClientConnection, _ int, _ string) {
+		closedRooms <- connection.Identity.RoomID
+	})
+	h := wsclientabletest.Start(t, &server)
+
+	if _, err := h.TryConnectToRoom("r1", "u2"); err == nil {
+		t.Fatalf("connected a user that is not allowed in the room")
+	}
+	c := h.ConnectToRoom("r1", "u1")
+	_ = c.Close()
+	for expected, closed := range map[string]chan string{`{"r":"r1", "u":"u1"}`: closedIDs, "": closedRooms} {
+		select {
+		case got := <-closed:
+			if got != expected {
+				t.Fatalf("closed handler received %q, expected %q", got, expected)
+			}
+		case <-time.After(wsclientabletest.DefaultTimeout):
+			t.Fatalf("closed handler not called")
+		}
+	}
+
+	// same user in another room, the id is escaped so that it can be parsed back
+	c, err := h.TryConnect(url.Values{"room": []string{"r\"2"}, "user": []string{"u1"}})
+	if err != nil {
+		t.Fatalf("could not connect: %v", err)
+	}
+	_ = c.Close()
+	select {
+	case got := <-closedIDs:
+		roomID, userID, err := wsclientable.ConnectionIDStringToRoomIDAndUserID(got)
+		if err != nil || roomID != "r\"2" || userID != "u1" {
+			t.Fatalf("could not parse connection id %q: %q, %q, %v", got, roomID, userID, err)
+		}
+	case <-time.After(wsclientabletest.DefaultTimeout):
+		t.Fatalf("closed handler not called")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/jokrey/utility-algorithms-golang/network/wsclientable"
 	"log"
 	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -25,8 +26,8 @@ func TestRepeatingRoomsStress(t *testing.T) {
 	}()
 
 	numSendClients := 1000
-	totalClientsDone := 0
-	successReceiveCounter := 0
+	var totalClientsDone int32
+	var successReceiveCounter int32
 
 	time.Sleep(1 * time.Second)
 	for i := 0; i < numSendClients; i++ {
@@ -34,7 +35,7 @@ func TestRepeatingRoomsStress(t *testing.T) {
 		go func() {
 			client, err := wsclientable.Connect("http://localhost:15202/room/test?room=t&user=receiving-user" + strconv.Itoa(i))
 			if err != nil {
-				totalClientsDone++
+				atomic.AddInt32(&totalClientsDone, 1)
 				t.Fatalf("could not start client, error: %v", err)
 			}
 
@@ -45,12 +46,12 @@ func TestRepeatingRoomsStress(t *testing.T) {
 				},
 			}
 			client.ListenLoop(msgHandlers)
-			totalClientsDone++
+			atomic.AddInt32(&totalClientsDone, 1)
 
 			if len(receivedMessagesSenderName) != 1 {
 				t.Fatalf(strconv.Itoa(i)+" received wrong number of messages, senders: %v", receivedMessagesSenderName)
 			}
-			successReceiveCounter++
+			atomic.AddInt32(&successReceiveCounter, 1)
 		}()
 	}
 
@@ -60,12 +61,12 @@ func TestRepeatingRoomsStress(t *testing.T) {
 		go func() {
 			client, err := wsclientable.Connect("http://localhost:15202/room/test?room=t&user=sending-user" + strconv.Itoa(i))
 			if err != nil {
-				totalClientsDone++
+				atomic.AddInt32(&totalClientsDone, 1)
 				t.Fatalf("could not start client("+strconv.Itoa(i)+"), error: %v", err)
 			}
 
 			err = client.SendTyped("roomForward", "{\"to\":\"receiving-user"+strconv.Itoa(i)+"\", \"data\":\"hallo\"}")
-			totalClientsDone++
+			atomic.AddInt32(&totalClientsDone, 1)
 			if err != nil {
 				t.Fatalf("could not send on client("+strconv.Itoa(i)+"), error: %v", err)
 			}
@@ -73,7 +74,7 @@ func TestRepeatingRoomsStress(t *testing.T) {
 	}
 
 	stoppedAt := time.Now()
-	for totalClientsDone < numSendClients*2 {
+	for atomic.LoadInt32(&totalClientsDone) < int32(numSendClients*2) {
 		time.Sleep(1 * time.Second)
 	}
 
@@ -83,7 +84,7 @@ func TestRepeatingRoomsStress(t *testing.T) {
 	if timeTaken >= 40*time.Second {
 		t.Fatal("Took to long") //in this case the clients did not receive, but where kicked by repeating room closure
 	}
-	for successReceiveCounter < numSendClients {
+	for atomic.LoadInt32(&successReceiveCounter) < int32(numSendClients) {
 		t.Fatal("Not all receivers received")
 	}
 }
@@ -0,0 +1,177 @@
+package wsclientable_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable"
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable/wsclientabletest"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMutualTLSRoomAuthentication(t *testing.T) {
+	m := startMutualTLSRooms(t, false, wsclientable.IdentityFromCommonName, "u1")
+	withCert := m.clientOptions(t, "u1")
+	withoutCert := wsclientable.ConnectOptions{CAPem: m.caPem}
+
+	client, err := m.h.TryConnect(url.Values{"room": []string{"testRoom"}}, withCert)
+	if err != nil {
+		t.Fatalf("could not connect with valid client certificate: %v", err)
+	}
+	_ = client.Close()
+	m.h.ExpectClosedOnServer("u1")
+
+	// the user param is ignored, the certificate determines the user id
+	if _, err := m.h.TryConnect(url.Values{"room": []string{"testRoom"}, "user": []string{"u1"}}, withoutCert); err == nil {
+		t.Fatalf("connected without client certificate")
+	}
+	if _, err := m.h.TryConnect(url.Values{"room": []string{"otherRoom"}}, withCert); err == nil {
+		t.Fatalf("connected to room that does not exist")
+	}
+}
+
+func TestMutualTLSRequiredCertificate(t *testing.T) {
+	m := startMutualTLSRooms(t, true, wsclientable.IdentityFromCommonName, "u1")
+
+	// rejected in the tls handshake already
+	if _, err := m.h.TryConnect(url.Values{"room": []string{"testRoom"}}, wsclientable.ConnectOptions{CAPem: m.caPem}); err == nil {
+		t.Fatalf("connected without the required client certificate")
+	}
+	otherCA, otherCAKey := generateTestCert(t, nil, nil, "other-ca", true)
+	otherCert, otherKey := generateTestCert(t, otherCA, otherCAKey, "u1", false)
+	signedByOtherCA := wsclientable.ConnectOptions{CAPem: m.caPem, TLSConfig: &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{otherCert.Raw}, PrivateKey: otherKey}},
+	}}
+	if _, err := m.h.TryConnect(url.Values{"room": []string{"testRoom"}}, signedByOtherCA); err == nil {
+		t.Fatalf("connected with a client certificate of an unknown ca")
+	}
+
+	client, err := m.h.TryConnect(url.Values{"room": []string{"testRoom"}}, m.clientOptions(t, "u1"))
+	if err != nil {
+		t.Fatalf("could not connect with valid client certificate: %v", err)
+	}
+	_ = client.Close()
+	m.h.ExpectClosedOnServer("u1")
+}
+
+func TestMutualTLSIdentityFromSAN(t *testing.T) {
+	m := startMutualTLSRooms(t, true, wsclientable.IdentityFromSANEmail, "u2@example.org")
+
+	// the common name is ignored, the first email SAN is the user id
+	client, err := m.h.TryConnect(url.Values{"room": []string{"testRoom"}}, m.clientOptions(t, "u2", "u2@example.org", "other@example.org"))
+	if err != nil {
+		t.Fatalf("could not connect with the email SAN: %v", err)
+	}
+	_ = client.Close()
+	m.h.ExpectClosedOnServer("u2@example.org")
+
+	if _, err := m.h.TryConnect(url.Values{"room": []string{"testRoom"}}, m.clientOptions(t, "u2@example.org")); err == nil {
+		t.Fatalf("connected with a client certificate without an email SAN")
+	}
+}
+
+// Harness with a single room (testRoom) that allows the given users, authenticated by client certificates
+type mutualTLSRooms struct {
+	h     *wsclientabletest.Harness
+	ca    *x509.Certificate
+	caKey *ecdsa.PrivateKey
+	caPem []byte
+}
+
+func startMutualTLSRooms(t *testing.T, required bool, identityFrom wsclientable.CertificateIdentitySource, allowed ...string) mutualTLSRooms {
+	dir := t.TempDir()
+	caCert, caKey := generateTestCert(t, nil, nil, "test-ca", true)
+	writeTestPem(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", caCert.Raw)
+	serverCert, serverKey := generateTestCert(t, caCert, caKey, "localhost", false)
+	writeTestPem(t, filepath.Join(dir, "server_cert.pem"), "CERTIFICATE", serverCert.Raw)
+	writeTestKeyPem(t, filepath.Join(dir, "server_key.pem"), serverKey)
+
+	base := wsclientable.NewWSHandlingServer()
+	base.AddRoomForwardingFunctionalityWithUserAuthenticator(
+		wsclientable.BundleControllers(
+			wsclientable.NewPermanentRoomController(wsclientable.NewPermanentRoom("testRoom", allowed)),
+		),
+		wsclientable.AuthenticateUserByClientCertificate(identityFrom),
+		"roomForward",
+	)
+	tlsConfig, err := wsclientable.MutualTLSConfig(
+		wsclientable.ClientCertificateAuth{CABundlePath: filepath.Join(dir, "ca.pem"), Required: required},
+		wsclientable.CertAndKeyPaths{
+			CertificateFilePath: filepath.Join(dir, "server_cert.pem"),
+			KeyFilePath:         filepath.Join(dir, "server_key.pem"),
+		},
+	)
+	if err != nil {
+		t.Fatalf("could not load the tls config: %v", err)
+	}
+	return mutualTLSRooms{
+		h:     wsclientabletest.StartTLS(t, &base, tlsConfig),
+		ca:    caCert,
+		caKey: caKey,
+		caPem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw}),
+	}
+}
+
+// Options to connect with a client certificate signed by the ca of the harness
+func (m mutualTLSRooms) clientOptions(t *testing.T, commonName string, emails ...string) wsclientable.ConnectOptions {
+	clientCert, clientKey := generateTestCert(t, m.ca, m.caKey, commonName, false, emails...)
+	return wsclientable.ConnectOptions{CAPem: m.caPem, TLSConfig: &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{clientCert.Raw}, PrivateKey: clientKey}},
+	}}
+}
+
+func generateTestCert(t *testing.T, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, commonName string, isCA bool, emails ...string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		DNSNames:              []string{commonName},
+		EmailAddresses:        emails,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("could not create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		t.Fatalf("could not parse certificate: %v", err)
+	}
+	return cert, key
+}
+
+func writeTestPem(t *testing.T, path, blockType string, raw []byte) {
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: raw}), 0600); err != nil {
+		t.Fatalf("could not write %v: %v", path, err)
+	}
+}
+
+func writeTestKeyPem(t *testing.T, path string, key *ecdsa.PrivateKey) {
+	raw, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("could not marshal key: %v", err)
+	}
+	writeTestPem(t, path, "EC PRIVATE KEY", raw)
+}
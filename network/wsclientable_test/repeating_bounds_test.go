@@ -0,0 +1,93 @@
+package wsclientable_test
+
+import (
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable"
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable/wsclientabletest"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRepeatingRoomBounds(t *testing.T) {
+	now := time.Now().Unix()
+	unbounded := wsclientable.NewRepeatingRoom("r", []string{}, now-100, 60, 10)
+	if unbounded.LastValidUnixTime() != math.MaxInt64 || !unbounded.IsValid() {
+		t.Fatalf("unbounded repeating room should be valid forever")
+	}
+
+	byOccurrences := unbounded.WithMaxOccurrences(3)
+	if last := byOccurrences.LastValidUnixTime(); last != now-100+2*60+10 {
+		t.Fatalf("expected last valid time at the end of the third occurrence, got %v", last-now)
+	}
+	if !byOccurrences.IsValid() {
+		t.Fatalf("room invalid before its last occurrence ended")
+	}
+	if byOccurrences.WithMaxOccurrences(2).IsValid() || byOccurrences.WithMaxOccurrences(2).IsAllowed("a") {
+		t.Fatalf("room still valid after its last occurrence ended")
+	}
+
+	byDate := unbounded.WithUntil(now - 1)
+	if byDate.IsValid() || byDate.IsAllowed("a") {
+		t.Fatalf("room still valid after its end date")
+	}
+	if both := byOccurrences.WithUntil(now + 5); both.LastValidUnixTime() != now+5 {
+		t.Fatalf("expected the earlier bound to apply, got %v", both.LastValidUnixTime()-now)
+	}
+}
+
+func TestRepeatingRoomControllerRemovesRoomAfterLastOccurrence(t *testing.T) {
+	now := time.Now().Unix()
+	controller := wsclientable.NewRepeatingRoomController(wsclientable.NewMutableRamRoomStorage())
+	room := wsclientable.NewRepeatingRoom("course", []string{}, now, 10, 2).WithMaxOccurrences(1)
+	if err := controller.AddRoom("course", room, false); err != nil {
+		t.Fatalf("could not add room: %v", err)
+	}
+	h := wsclientabletest.StartRooms(t, wsclientable.BundleControllers(controller), "roomForward")
+
+	c := h.ConnectToRoom("course", "student")
+	if _, _, err := c.AwaitClosed(5 * time.Second); err != nil {
+		t.Fatalf("connection not closed after the last occurrence: %v", err)
+	}
+	if controller.GetRoom("course") != nil {
+		t.Fatalf("room not removed after its last occurrence")
+	}
+}
+
+func TestRepeatingRoomControllerRemovesExpiredRoomOnLookup(t *testing.T) {
+	now := time.Now().Unix()
+	storage := wsclientable.NewMutableRamRoomStorage()
+	controller := wsclientable.NewRepeatingRoomController(storage)
+	defer func() { _ = controller.Close() }()
+	if err := controller.AddRoom("old", wsclientable.NewRepeatingRoom("old", []string{}, now-100, 10, 2).WithUntil(now-50), false); err != nil {
+		t.Fatalf("could not add room: %v", err)
+	}
+
+	if controller.GetRoom("old") != nil {
+		t.Fatalf("expired room returned")
+	}
+	if storage.Get("old") != nil {
+		t.Fatalf("expired room not removed from storage")
+	}
+}
+
+func TestRepeatingRoomBoundsPersistedInBolt(t *testing.T) {
+	storage := wsclientable.NewRepeatingRoomBoltStorage(filepath.Join(t.TempDir(), "repeat_bounds.db"))
+	defer func() { _ = storage.Close() }()
+
+	now := time.Now().Unix()
+	if err := storage.Put(wsclientable.NewRepeatingRoom("b", []string{"a"}, now, 60, 30).WithUntil(now+600).WithMaxOccurrences(4), false); err != nil {
+		t.Fatalf("could not put repeating room: %v", err)
+	}
+	if err := storage.Put(wsclientable.NewRepeatingRoom("u", []string{"a"}, now, 60, 30), false); err != nil {
+		t.Fatalf("could not put repeating room: %v", err)
+	}
+
+	bounded := storage.Get("b").(wsclientable.RepeatingRoom)
+	if bounded.UntilUnixTimestamp != now+600 || bounded.MaxOccurrences != 4 {
+		t.Fatalf("bounds not persisted, got until=%v occurrences=%v", bounded.UntilUnixTimestamp, bounded.MaxOccurrences)
+	}
+	if unbounded := storage.Get("u").(wsclientable.RepeatingRoom); unbounded.LastValidUnixTime() != math.MaxInt64 {
+		t.Fatalf("unbounded room decoded as bounded: %+v", unbounded)
+	}
+}
@@ -0,0 +1,125 @@
+package wsclientable_test
+
+import (
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable"
+	"gopkg.in/ini.v1"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestAllowListGroupsPatternsAndDenyEntries(t *testing.T) {
+	groups := wsclientable.NewRamUserGroups()
+	if err := groups.SetGroup("students", []string{"s1", "s2"}); err != nil {
+		t.Fatalf("could not set group: %v", err)
+	}
+
+	unresolved := wsclientable.NewPermanentRoom("class", []string{"group:students", "glob:teacher-*", "regex:sub[0-9]+", "!s2", "!glob:teacher-x*"})
+	controller := wsclientable.NewPermanentRoomController(unresolved)
+	controller.SetUserGroups(groups)
+	room := controller.GetRoom("class")
+	for userID, expected := range map[string]bool{
+		"s1": true, "s2": false, "s3": false,
+		"teacher-anna": true, "teacher-xaver": false, "teacher": false,
+		"sub12": true, "sub": false, "asub1": false,
+	} {
+		if room.IsAllowed(userID) != expected {
+			t.Fatalf("expected IsAllowed(%v) = %v", userID, expected)
+		}
+	}
+
+	if err := groups.AddMember("students", "s3"); err != nil {
+		t.Fatalf("could not add member: %v", err)
+	}
+	if !room.IsAllowed("s3") {
+		t.Fatalf("new group member not allowed without editing the room")
+	}
+	if unresolved.IsAllowed("s1") || !unresolved.IsAllowed("teacher-anna") {
+		t.Fatalf("group entries of a room without groups should match nobody")
+	}
+	otherGroups := wsclientable.NewRamUserGroups()
+	_ = otherGroups.SetGroup("students", []string{"o1"})
+	other := wsclientable.NewPermanentRoomController(unresolved)
+	other.SetUserGroups(otherGroups)
+	if other.GetRoom("class").IsAllowed("s1") || !other.GetRoom("class").IsAllowed("o1") || !controller.GetRoom("class").IsAllowed("s1") {
+		t.Fatalf("groups of one controller applied to another")
+	}
+
+	onlyDeny := wsclientable.NewTemporaryRoom("t", []string{"!banned"}, time.Now().Unix()-10, time.Now().Unix()+60)
+	if !onlyDeny.IsAllowed("anyone") || onlyDeny.IsAllowed("banned") {
+		t.Fatalf("room with only deny entries should allow everyone else")
+	}
+	withGuest := wsclientable.NewPermanentRoom("g", []string{"!guest"}).
+		WithUserWindows(map[string]wsclientable.UserWindow{"guest": {}})
+	if withGuest.IsAllowed("guest") {
+		t.Fatalf("personal window overrode a deny entry")
+	}
+}
+
+func TestValidateAllowList(t *testing.T) {
+	if err := wsclientable.ValidateAllowList([]string{"a", "group:g", "glob:x*", "regex:^a+$", "!b"}); err != nil {
+		t.Fatalf("valid allow list rejected: %v", err)
+	}
+	for _, invalid := range [][]string{{"regex:("}, {"group:"}, {"!"}, {""}} {
+		if err := wsclientable.ValidateAllowList(invalid); err == nil {
+			t.Fatalf("invalid allow list %v accepted", invalid)
+		}
+	}
+}
+
+func TestUserGroupsBoltStorage(t *testing.T) {
+	storage, err := wsclientable.NewUserGroupsBoltStorage(filepath.Join(t.TempDir(), "groups.db"))
+	if err != nil {
+		t.Fatalf("could not open: %v", err)
+	}
+	defer func() { _ = storage.Close() }()
+
+	if err := storage.SetGroup("g", []string{"a", "b"}); err != nil {
+		t.Fatalf("could not set group: %v", err)
+	}
+	if err := storage.AddMember("g", "c"); err != nil {
+		t.Fatalf("could not add member: %v", err)
+	}
+	if err := storage.RemoveMember("g", "a"); err != nil {
+		t.Fatalf("could not remove member: %v", err)
+	}
+	members := storage.Members("g")
+	sort.Strings(members)
+	if len(members) != 2 || members[0] != "b" || members[1] != "c" || storage.IsMember("g", "a") {
+		t.Fatalf("unexpected members %v", members)
+	}
+	if err := storage.SetGroup("g", nil); err != nil {
+		t.Fatalf("could not remove group: %v", err)
+	}
+	if storage.IsMember("g", "b") || len(storage.Members("g")) != 0 {
+		t.Fatalf("group not removed")
+	}
+
+	_ = storage.AddMember("persisted", "p")
+	controller := wsclientable.NewTemporaryRoomController(wsclientable.NewTemporaryRoomBoltStorage(filepath.Join(t.TempDir(), "group_rooms.db")))
+	defer func() { _ = controller.Close() }()
+	controller.SetUserGroups(storage)
+	now := time.Now().Unix()
+	if err := controller.AddRoom("r", wsclientable.NewTemporaryRoom("r", []string{"group:persisted", "!x"}, now-10, now+60), false); err != nil {
+		t.Fatalf("could not add room: %v", err)
+	}
+	if room := controller.GetRoom("r"); !room.IsAllowed("p") || room.IsAllowed("x") || room.IsAllowed("q") {
+		t.Fatalf("allow list entries not persisted")
+	}
+}
+
+func TestUserGroupsFromCFG(t *testing.T) {
+	cfg, err := ini.Load([]byte("[user_groups]\nstudents=[\"s1\", \"s2\"]\nteachers=[\"t\"]\n"))
+	if err != nil {
+		t.Fatalf("could not load config: %v", err)
+	}
+	controllers := wsclientable.BundleControllers(wsclientable.NewPermanentRoomController(
+		wsclientable.NewPermanentRoom("class", []string{"group:students"}),
+	))
+	defer func() { _ = controllers.Close() }()
+	controllers.SetUserGroupsFromCFG(cfg)
+	if room := controllers.GetRoom("class"); !room.IsAllowed("s2") || room.IsAllowed("t") {
+		t.Fatalf("groups not loaded from config")
+	}
+}
@@ -0,0 +1,243 @@
+package wsclientable_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable"
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable/wsclientabletest"
+	"gopkg.in/ini.v1"
+	"io"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRoomExportRoundTripsAllRoomTypes(t *testing.T) {
+	now := time.Now().Unix()
+	schedule, err := wsclientable.NewSchedule("Europe/Berlin", []string{"MON-FRI 09:00-17:00"}, []string{"2030-12-24"})
+	if err != nil {
+		t.Fatalf("could not create schedule: %v", err)
+	}
+	store, err := wsclientable.NewRoomBoltStorage(filepath.Join(t.TempDir(), "rooms.db"))
+	if err != nil {
+		t.Fatalf("could not open: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+	rooms := []wsclientable.RoomI{
+		wsclientable.NewPermanentRoom("p", []string{"a", "group:staff"}).WithMaxParticipants(3).
+			WithRoles(map[string]wsclientable.Role{"a": wsclientable.RoleOwner}),
+		wsclientable.NewTemporaryRoom("t", []string{"b"}, now-10, now+3600).WithLobby(true),
+		wsclientable.NewRepeatingRoom("r", []string{}, now, 60, 10).WithMaxOccurrences(5),
+		wsclientable.NewScheduledRoom("s", []string{"!c"}, schedule),
+	}
+	for _, room := range rooms {
+		if err := store.Put(room, false); err != nil {
+			t.Fatalf("could not put %v: %v", room.GetID(), err)
+		}
+	}
+
+	export, err := wsclientable.ExportRooms(store)
+	if err != nil || len(export.Rooms) != 4 || export.Rooms[0].ID() != "p" || export.Rooms[0].Type != wsclientable.RoomTypePermanent {
+		t.Fatalf("unexpected export %+v (%v)", export, err)
+	}
+	raw, _ := json.Marshal(export)
+	fromJSON, err := wsclientable.ParseRoomExport(raw)
+	if err != nil {
+		t.Fatalf("could not parse json export: %v", err)
+	}
+	cfg, err := export.ToINI()
+	if err != nil {
+		t.Fatalf("could not write ini: %v", err)
+	}
+	var written bytes.Buffer
+	_, _ = cfg.WriteTo(&written)
+	loaded, err := ini.Load(written.Bytes())
+	if err != nil {
+		t.Fatalf("could not load written ini: %v", err)
+	}
+	fromINI, err := wsclientable.ParseRoomExportINI(loaded)
+	if err != nil {
+		t.Fatalf("could not parse ini export: %v", err)
+	}
+
+	for name, parsed := range map[string]wsclientable.RoomExport{"json": fromJSON, "ini": fromINI} {
+		controller := wsclientable.NewEditableRoomControllerInRam()
+		result, err := wsclientable.ImportRooms(&controller, parsed, wsclientable.RoomImportMerge, false)
+		if err != nil || len(result.Added) != 4 {
+			t.Fatalf("%v: could not import: %+v (%v)", name, result, err)
+		}
+		reexported, err := controller.ExportRooms()
+		if err != nil || len(reexported.Rooms) != len(export.Rooms) {
+			t.Fatalf("%v: could not export the imported rooms: %+v (%v)", name, reexported, err)
+		}
+		for i, room := range reexported.Rooms {
+			if room.Type != export.Rooms[i].Type || string(room.Room) != string(export.Rooms[i].Room) {
+				t.Fatalf("%v: room not restored:\n%s\n%s", name, room.Room, export.Rooms[i].Room)
+			}
+		}
+	}
+
+	// exported permanent rooms can be loaded from the config
+	permanents := wsclientable.NewPermanentRoomControllerFromCFG(loaded)
+	if p := permanents.GetRoom("p").(wsclientable.PermanentRoom); !p.AllowsClient("a") || p.MaxParticipants != 3 || p.GetRole("a") != wsclientable.RoleOwner {
+		t.Fatalf("permanent room not loaded from the ini export: %+v", p)
+	}
+}
+
+func TestImportRoomsModesRevalidateConnectedClients(t *testing.T) {
+	controller := wsclientable.NewEditableRoomControllerInRam()
+	if err := controller.AddRoom("a", wsclientable.NewPermanentRoom("a", []string{"x"}), false); err != nil {
+		t.Fatalf("could not add room: %v", err)
+	}
+	h := wsclientabletest.StartRooms(t, wsclientable.BundleControllers(&controller))
+	c := h.ConnectToRoom("a", "x")
+
+	source := wsclientable.NewMutableRamRoomStorage()
+	_ = source.Put(wsclientable.NewPermanentRoom("a", []string{"y"}), false)
+	_ = source.Put(wsclientable.NewPermanentRoom("b", nil), false)
+	export, err := wsclientable.ExportRooms(source)
+	if err != nil {
+		t.Fatalf("could not export: %v", err)
+	}
+
+	result, err := wsclientable.ImportRooms(&controller, export, wsclientable.RoomImportOverride, true)
+	if err != nil || !reflect.DeepEqual(result.Added, []string{"b"}) || !reflect.DeepEqual(result.Replaced, []string{"a"}) || !result.DryRun {
+		t.Fatalf("unexpected dry run result %+v (%v)", result, err)
+	}
+	if controller.GetRoom("b") != nil || !controller.IsConnected("a", "x") {
+		t.Fatalf("dry run changed the rooms")
+	}
+
+	result, err = wsclientable.ImportRooms(&controller, export, wsclientable.RoomImportMerge, false)
+	if err != nil || !reflect.DeepEqual(result.Added, []string{"b"}) || !reflect.DeepEqual(result.Kept, []string{"a"}) {
+		t.Fatalf("unexpected merge result %+v (%v)", result, err)
+	}
+	if controller.GetRoom("a").IsAllowed("y") || !controller.IsConnected("a", "x") {
+		t.Fatalf("merge replaced an existing room")
+	}
+
+	broken := wsclientable.RoomExport{Version: wsclientable.RoomExportVersion, Rooms: append([]wsclientable.ExportedRoom{},
+		wsclientable.ExportedRoom{Type: wsclientable.RoomTypePermanent, Room: json.RawMessage(`{"id":"c","allowed_clients":[]}`)},
+		wsclientable.ExportedRoom{Type: wsclientable.RoomTypePermanent, Room: json.RawMessage(`{"id":"d","allowed_clients":["regex:("]}`)},
+	)}
+	if _, err := wsclientable.ImportRooms(&controller, broken, wsclientable.RoomImportOverride, false); err == nil || controller.GetRoom("c") != nil {
+		t.Fatalf("an export with an invalid room changed the rooms (%v)", err)
+	}
+
+	result, err = wsclientable.ImportRooms(&controller, export, wsclientable.RoomImportOverride, false)
+	if err != nil || !reflect.DeepEqual(result.Replaced, []string{"a", "b"}) {
+		t.Fatalf("unexpected override result %+v (%v)", result, err)
+	}
+	c.ExpectClosed()
+	if !controller.GetRoom("a").IsAllowed("y") {
+		t.Fatalf("override did not replace the room")
+	}
+}
+
+func TestImportRoomsRoutesRoomsByType(t *testing.T) {
+	now := time.Now().Unix()
+	temporaries := wsclientable.NewTemporaryRoomController(wsclientable.NewMutableRamRoomStorage())
+	repeating := wsclientable.NewRepeatingRoomController(wsclientable.NewMutableRamRoomStorage())
+	controllers := wsclientable.BundleControllers(temporaries, repeating)
+	defer func() { _ = controllers.Close() }()
+
+	source := wsclientable.NewMutableRamRoomStorage()
+	_ = source.Put(wsclientable.NewTemporaryRoom("t", nil, now-10, now+3600), false)
+	_ = source.Put(wsclientable.NewRepeatingRoom("r", nil, now, 60, 10), false)
+	_ = source.Put(wsclientable.NewPermanentRoom("p", nil), false)
+	export, err := wsclientable.ExportRooms(source)
+	if err != nil {
+		t.Fatalf("could not export: %v", err)
+	}
+
+	result, err := wsclientable.ImportRooms(temporaries, export, wsclientable.RoomImportMerge, false)
+	if err != nil || !reflect.DeepEqual(result.Added, []string{"t"}) || !reflect.DeepEqual(result.Ignored, []string{"p", "r"}) {
+		t.Fatalf("unexpected import into one controller %+v (%v)", result, err)
+	}
+	if _, err := temporaries.CloseAndRemoveRoom("t"); err != nil {
+		t.Fatalf("could not remove room: %v", err)
+	}
+
+	result, err = wsclientable.ImportRooms(&controllers, export, wsclientable.RoomImportMerge, true)
+	if err != nil || !reflect.DeepEqual(result.Added, []string{"r", "t"}) || !reflect.DeepEqual(result.Ignored, []string{"p"}) {
+		t.Fatalf("unexpected dry run %+v (%v)", result, err)
+	}
+	result, err = wsclientable.ImportRooms(&controllers, export, wsclientable.RoomImportMerge, false)
+	if err != nil || !reflect.DeepEqual(result.Added, []string{"r", "t"}) {
+		t.Fatalf("unexpected import %+v (%v)", result, err)
+	}
+	if temporaries.GetRoom("t") == nil || repeating.GetRoom("r") == nil || temporaries.GetRoom("r") != nil {
+		t.Fatalf("rooms not imported into the controllers of their types")
+	}
+
+	// a temporary room cannot replace the repeating room with its id
+	clash := wsclientable.NewMutableRamRoomStorage()
+	_ = clash.Put(wsclientable.NewTemporaryRoom("r", nil, now-10, now+3600), false)
+	clashing, _ := wsclientable.ExportRooms(clash)
+	for _, dryRun := range []bool{true, false} {
+		if _, err := wsclientable.ImportRooms(&controllers, clashing, wsclientable.RoomImportOverride, dryRun); err == nil {
+			t.Fatalf("replaced a room of another type (dry run: %v)", dryRun)
+		}
+	}
+	if _, ok := repeating.GetRoom("r").(wsclientable.RepeatingRoom); !ok {
+		t.Fatalf("room of another type replaced")
+	}
+	if err := temporaries.AddRoom("x", wsclientable.NewPermanentRoom("x", nil), false); err == nil {
+		t.Fatalf("temporary room controller added a permanent room")
+	}
+}
+
+func TestHTTPRoomEditorBackupRoutes(t *testing.T) {
+	now := time.Now().Unix()
+	editor := wsclientable.NewHTTPTemporaryRoomEditorInRam("127.0.0.1", 0, "/add", "/edit", "/remove")
+	editor.SetBackupRoutes("/export", "/import")
+	if err := editor.AddRoom("t", wsclientable.NewTemporaryRoom("t", []string{"a"}, now-10, now+60), false); err != nil {
+		t.Fatalf("could not add room: %v", err)
+	}
+	wsclientabletest.StartRooms(t, wsclientable.BundleControllers(editor))
+
+	request := func(method, route, body string) (int, string) {
+		r, _ := http.NewRequest(method, "http://"+editor.Addr()+route, strings.NewReader(body))
+		response, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("editor not reachable: %v", err)
+		}
+		defer func() { _ = response.Body.Close() }()
+		responseBody, _ := io.ReadAll(response.Body)
+		return response.StatusCode, string(responseBody)
+	}
+
+	status, exported := request(http.MethodGet, "/export?format=ini", "")
+	if status != http.StatusOK || !strings.Contains(exported, "[temporary_rooms.room1]") || !strings.Contains(exported, "valid_until") {
+		t.Fatalf("unexpected ini export: %v %v", status, exported)
+	}
+
+	body := `{"version":1,"rooms":[` +
+		`{"type":"temporary","room":{"id":"t","allowed_clients":["b"],"valid_from":1,"valid_until":` + strconv.FormatInt(now+60, 10) + `}},` +
+		`{"type":"temporary","room":{"id":"u","allowed_clients":[],"valid_from":1,"valid_until":4102444800}},` +
+		`{"type":"permanent","room":{"id":"p","allowed_clients":[]}}]}`
+	status, response := request(http.MethodPost, "/import?mode=merge&dry_run=true", body)
+	var result wsclientable.RoomImportResult
+	if err := json.Unmarshal([]byte(response), &result); status != http.StatusOK || err != nil ||
+		!reflect.DeepEqual(result.Added, []string{"u"}) || !reflect.DeepEqual(result.Kept, []string{"t"}) || !reflect.DeepEqual(result.Ignored, []string{"p"}) {
+		t.Fatalf("unexpected dry run: %v %v", status, response)
+	}
+	if editor.GetRoom("u") != nil {
+		t.Fatalf("dry run added a room")
+	}
+
+	status, response = request(http.MethodPost, "/import?mode=merge&format=ini", exported)
+	if status != http.StatusOK || !strings.Contains(response, `"kept":["t"]`) {
+		t.Fatalf("unexpected ini import: %v %v", status, response)
+	}
+	if status, _ := request(http.MethodPost, "/import?mode=replace", body); status != http.StatusBadRequest {
+		t.Fatalf("expected bad request for an unknown mode, got %v", status)
+	}
+	if status, _ := request(http.MethodPost, "/import", `{"version":2,"rooms":[]}`); status != http.StatusBadRequest {
+		t.Fatalf("expected bad request for a newer export version, got %v", status)
+	}
+}
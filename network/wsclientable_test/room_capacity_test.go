@@ -0,0 +1,79 @@
+package wsclientable_test
+
+import (
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable"
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable/wsclientabletest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRoomMaxParticipants(t *testing.T) {
+	h := wsclientabletest.StartRooms(t, wsclientable.BundleControllers(
+		wsclientable.NewPermanentRoomController(wsclientable.NewPermissiblePermanentRoom("pair").WithMaxParticipants(2)),
+	), "roomForward")
+
+	c1 := h.ConnectToRoom("pair", "u1")
+	h.ConnectToRoom("pair", "u2")
+
+	_, err := h.TryConnectToRoom("pair", "u3")
+	if err == nil {
+		t.Fatalf("third participant connected to room with max 2 participants")
+	}
+
+	_ = c1.Close()
+	h.ExpectClosedOnServer("u1")
+	h.ConnectToRoom("pair", "u3")
+}
+
+func TestRoomMaxParticipantsEnforcedAtomically(t *testing.T) {
+	rooms := wsclientable.NewRoomConnectionsMap()
+
+	var wg sync.WaitGroup
+	var mut sync.Mutex
+	accepted, rejected := 0, 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			connection := wsclientable.ClientConnection{ID: "u" + string(rune('a'+i))}
+			err := rooms.AddConnectionInRoomWithinCapacity("r", connection, 3)
+			mut.Lock()
+			defer mut.Unlock()
+			if err == nil {
+				accepted++
+			} else if strings.Contains(err.Error(), "full") {
+				rejected++
+			}
+		}(i)
+	}
+	wg.Wait()
+	if accepted != 3 || rejected != 17 {
+		t.Fatalf("expected 3 accepted and 17 rejected, got %v and %v", accepted, rejected)
+	}
+}
+
+func TestRoomMaxParticipantsPersistedInBolt(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now().Unix()
+
+	temporary := wsclientable.NewTemporaryRoomBoltStorage(filepath.Join(dir, "temp.db"))
+	defer func() { _ = temporary.Close() }()
+	if err := temporary.Put(wsclientable.NewTemporaryRoom("t", []string{"a"}, now, now+60).WithMaxParticipants(3), false); err != nil {
+		t.Fatalf("could not put temporary room: %v", err)
+	}
+	if max := wsclientable.MaxParticipantsOf(temporary.Get("t")); max != 3 {
+		t.Fatalf("temporary room max participants not persisted, got %v", max)
+	}
+
+	repeating := wsclientable.NewRepeatingRoomBoltStorage(filepath.Join(dir, "repeat.db"))
+	defer func() { _ = repeating.Close() }()
+	if err := repeating.Put(wsclientable.NewRepeatingRoom("r", []string{"a"}, now, 60, 30).WithMaxParticipants(2), false); err != nil {
+		t.Fatalf("could not put repeating room: %v", err)
+	}
+	if max := wsclientable.MaxParticipantsOf(repeating.Get("r")); max != 2 {
+		t.Fatalf("repeating room max participants not persisted, got %v", max)
+	}
+}
@@ -0,0 +1,74 @@
+package wsclientable_test
+
+import (
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable"
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable/wsclientabletest"
+	"testing"
+	"time"
+)
+
+func TestRoomInfoOnJoin(t *testing.T) {
+	now := time.Now().Unix()
+	temporary := wsclientable.NewTemporaryRoomController(wsclientable.NewMutableRamRoomStorage())
+	if err := temporary.AddRoom("t", wsclientable.NewTemporaryRoom("t", []string{"a"}, now-10, now+60), false); err != nil {
+		t.Fatalf("could not add room: %v", err)
+	}
+	permanent := wsclientable.NewPermanentRoomController(wsclientable.NewPermissiblePermanentRoom("p"))
+	h := wsclientabletest.StartRooms(t, wsclientable.BundleControllers(temporary, permanent), "roomForward")
+
+	info := h.ConnectToRoom("t", "a").ExpectWith(wsclientable.MessageTypeRoomInfo, map[string]interface{}{"room": "t"})
+	if closesAt, _ := info.Data["closesAt"].(float64); int64(closesAt) != now+60 {
+		t.Fatalf("expected closesAt %v, got %v", now+60, info.Data["closesAt"])
+	}
+	if opensAt, _ := info.Data["opensAt"].(float64); int64(opensAt) != now-10 {
+		t.Fatalf("expected opensAt %v, got %v", now-10, info.Data["opensAt"])
+	}
+
+	info = h.ConnectToRoom("p", "b").ExpectWith(wsclientable.MessageTypeRoomInfo, map[string]interface{}{"room": "p"})
+	if _, ok := info.Data["closesAt"]; ok {
+		t.Fatalf("permanent room should not close, got %v", info.Data)
+	}
+	if _, ok := info.Data["serverTime"].(float64); !ok {
+		t.Fatalf("missing serverTime in %v", info.Data)
+	}
+}
+
+func TestRoomClosingWarningAndCloseCode(t *testing.T) {
+	now := time.Now().Unix()
+	controller := wsclientable.NewTemporaryRoomController(wsclientable.NewMutableRamRoomStorage())
+	if err := controller.AddRoom("ending", wsclientable.NewTemporaryRoom("ending", []string{"a"}, now-10, now+3), false); err != nil {
+		t.Fatalf("could not add room: %v", err)
+	}
+	if err := controller.AddRoom("removed", wsclientable.NewTemporaryRoom("removed", []string{"a"}, now-10, now+60), false); err != nil {
+		t.Fatalf("could not add room: %v", err)
+	}
+	rooms := wsclientable.BundleControllers(controller)
+	rooms.SetClosingWarnings(2 * time.Second)
+	h := wsclientabletest.StartRooms(t, rooms, "roomForward")
+
+	ending := h.ConnectToRoom("ending", "a")
+	removed := h.ConnectToRoom("removed", "a")
+
+	warning, err := ending.Await(wsclientable.MessageTypeRoomClosing, 5*time.Second)
+	if err != nil {
+		t.Fatalf("no closing warning: %v", err)
+	}
+	if closesAt, _ := warning.Data["closesAt"].(float64); int64(closesAt) != now+3 {
+		t.Fatalf("expected closesAt %v, got %v", now+3, warning.Data["closesAt"])
+	}
+	if left, _ := warning.Data["secondsLeft"].(float64); left < 0 || left > 3 {
+		t.Fatalf("unexpected secondsLeft %v", warning.Data["secondsLeft"])
+	}
+	code, _, err := ending.AwaitClosed(5 * time.Second)
+	if err != nil || code != wsclientable.CloseCodeRoomClosed {
+		t.Fatalf("expected close code %v on expiry, got %v (%v)", wsclientable.CloseCodeRoomClosed, code, err)
+	}
+
+	removed.ExpectNone(wsclientable.MessageTypeRoomClosing, 100*time.Millisecond)
+	if _, err := controller.CloseAndRemoveRoom("removed"); err != nil {
+		t.Fatalf("could not remove room: %v", err)
+	}
+	if code, reason := removed.ExpectClosed(); code != wsclientable.CloseCodeRoomClosed || reason != "room removed" {
+		t.Fatalf("expected close code %v on removal, got %v %q", wsclientable.CloseCodeRoomClosed, code, reason)
+	}
+}
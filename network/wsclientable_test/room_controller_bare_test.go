@@ -0,0 +1,37 @@
+package wsclientable_test
+
+import (
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable"
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable/wsclientabletest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Only implements RoomControllerI, none of the optional interfaces
+type bareRoomController struct {
+	wsclientable.RoomControllerI
+}
+
+func TestBareRoomController(t *testing.T) {
+	t.Parallel()
+	h := wsclientabletest.StartRooms(t, wsclientable.BundleControllers(bareRoomController{wsclientable.NewPermanentRoomController(
+		wsclientable.NewPermissiblePermanentRoom("bare").WithMaxParticipants(2),
+	)}), "chat")
+
+	a := h.ConnectToRoom("bare", "a")
+	b := h.ConnectToRoom("bare", "b")
+	a.Send("chat", map[string]interface{}{"to": "b", "text": "private"})
+	b.ExpectWith("chat", map[string]interface{}{"from": "a", "text": "private"})
+
+	// the connections of the controller cannot be iterated, so messages to everyone reach no one
+	a.Send("chat", map[string]interface{}{"to": wsclientable.RelayToEveryone, "text": "all"})
+	b.ExpectNone("chat", 100*time.Millisecond)
+
+	// nor counted before the upgrade, the controller rejects the third participant without giving a reason
+	c := h.ConnectToRoom("bare", "c")
+	code, reason := c.ExpectClosed()
+	if code != wsclientable.CloseCodeRoomRejected || !strings.Contains(reason, "rejected by room") {
+		t.Fatalf("unexpected close: %d %q", code, reason)
+	}
+}
@@ -0,0 +1,115 @@
+package wsclientable_test
+
+import (
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable"
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable/wsclientabletest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Waits for the next event, which must have the type of expected, and returns it
+func awaitRoomEvent(t *testing.T, events <-chan wsclientable.RoomEvent, expected wsclientable.RoomEvent) wsclientable.RoomEvent {
+	t.Helper()
+	select {
+	case event := <-events:
+		if reflect.TypeOf(event) != reflect.TypeOf(expected) {
+			t.Fatalf("expected %T, got %T: %v", expected, event, event)
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no event received, expected %T", expected)
+		return nil
+	}
+}
+
+func TestRoomEventsOfTemporaryRoom(t *testing.T) {
+	controller := wsclientable.NewTemporaryRoomController(wsclientable.NewMutableRamRoomStorage())
+	controllers := wsclientable.BundleControllers(controller)
+	server := wsclientable.NewWSHandlingServer()
+	server.AddRoomForwardingFunctionality(controllers)
+	h := wsclientabletest.Start(t, &server)
+	events, unsubscribe := wsclientable.SubscribeRoomEventsChannel(&controllers, 100)
+	defer unsubscribe()
+
+	now := time.Now().Unix()
+	if err := controller.AddRoom("e", wsclientable.NewTemporaryRoom("e", []string{"a"}, now-10, now+60), false); err != nil {
+		t.Fatalf("could not add room: %v", err)
+	}
+	awaitRoomEvent(t, events, wsclientable.RoomAddedEvent{})
+	if err := controller.AddRoom("e", wsclientable.NewTemporaryRoom("e", []string{"a", "b"}, now-10, now+2), true); err != nil {
+		t.Fatalf("could not edit room: %v", err)
+	}
+	edited := awaitRoomEvent(t, events, wsclientable.RoomEditedEvent{}).(wsclientable.RoomEditedEvent)
+	if edited.Previous.(wsclientable.TemporaryRoom).ValidUntilUnixTime != now+60 || edited.Room.(wsclientable.TemporaryRoom).ValidUntilUnixTime != now+2 {
+		t.Fatalf("unexpected edit: %v", edited)
+	}
+
+	if _, err := h.TryConnectToRoom("e", "intruder"); err == nil {
+		t.Fatalf("intruder joined")
+	}
+	if rejected := awaitRoomEvent(t, events, wsclientable.UserRejectedEvent{}).(wsclientable.UserRejectedEvent); rejected.UserID != "intruder" || rejected.Err == nil {
+		t.Fatalf("unexpected rejection: %v", rejected)
+	}
+	a := h.ConnectToRoom("e", "a")
+	if joined := awaitRoomEvent(t, events, wsclientable.UserJoinedEvent{}).(wsclientable.UserJoinedEvent); joined.UserID != "a" || joined.RoomID != "e" {
+		t.Fatalf("unexpected join: %v", joined)
+	}
+	_ = a.Close()
+	if left := awaitRoomEvent(t, events, wsclientable.UserLeftEvent{}).(wsclientable.UserLeftEvent); left.UserID != "a" {
+		t.Fatalf("unexpected leave: %v", left)
+	}
+
+	h.ConnectToRoom("e", "b")
+	awaitRoomEvent(t, events, wsclientable.UserJoinedEvent{})
+	// the room expires
+	if closed := awaitRoomEvent(t, events, wsclientable.RoomClosedEvent{}).(wsclientable.RoomClosedEvent); closed.Reason != "room expired" {
+		t.Fatalf("unexpected close: %v", closed)
+	}
+	if left := awaitRoomEvent(t, events, wsclientable.UserLeftEvent{}).(wsclientable.UserLeftEvent); left.UserID != "b" {
+		t.Fatalf("unexpected leave: %v", left)
+	}
+	awaitRoomEvent(t, events, wsclientable.RoomRemovedEvent{})
+	awaitRoomEvent(t, events, wsclientable.RoomExpiredEvent{})
+}
+
+func TestRoomEventsOfLobbyAndUnsubscribe(t *testing.T) {
+	controller := wsclientable.NewTemporaryRoomController(wsclientable.NewMutableRamRoomStorage())
+	controllers := wsclientable.BundleControllers(controller)
+	server := wsclientable.NewWSHandlingServer()
+	server.AddRoomForwardingFunctionality(controllers)
+	h := wsclientabletest.Start(t, &server)
+
+	var controllerRejections []wsclientable.UserRejectedEvent
+	unsubscribeController := controller.SubscribeRoomEvents(func(event wsclientable.RoomEvent) {
+		if rejected, ok := event.(wsclientable.UserRejectedEvent); ok {
+			controllerRejections = append(controllerRejections, rejected)
+		}
+	})
+	now := time.Now().Unix()
+	room := wsclientable.NewTemporaryRoom("l", []string{}, now+1, now+60).WithLobby(true)
+	if err := controller.AddRoom("l", room, false); err != nil {
+		t.Fatalf("could not add room: %v", err)
+	}
+	events, unsubscribe := wsclientable.SubscribeRoomEventsChannel(&controllers, 100)
+
+	// waiting in the lobby is no rejection of the bundle, but of the controller
+	c := h.ConnectToRoom("l", "early")
+	c.Expect(wsclientable.MessageTypeRoomOpensAt)
+	awaitRoomEvent(t, events, wsclientable.RoomOpenedEvent{})
+	if joined := awaitRoomEvent(t, events, wsclientable.UserJoinedEvent{}).(wsclientable.UserJoinedEvent); joined.UserID != "early" {
+		t.Fatalf("unexpected join: %v", joined)
+	}
+	unsubscribeController()
+	if len(controllerRejections) != 1 || controllerRejections[0].UserID != "early" {
+		t.Fatalf("expected the controller to reject the early user once, got %v", controllerRejections)
+	}
+
+	unsubscribe()
+	if _, err := controller.CloseAndRemoveRoom("l"); err != nil {
+		t.Fatalf("could not remove room: %v", err)
+	}
+	for event := range events {
+		t.Fatalf("event after unsubscribe: %v", event)
+	}
+}
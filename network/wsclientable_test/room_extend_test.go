@@ -0,0 +1,169 @@
+package wsclientable_test
+
+import (
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable"
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable/wsclientabletest"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExtendAndShortenTemporaryRoomKeepsConnections(t *testing.T) {
+	now := time.Now().Unix()
+	controller := wsclientable.NewTemporaryRoomController(
+		wsclientable.NewTemporaryRoomBoltStorage(filepath.Join(t.TempDir(), "extend.db")),
+	)
+	if err := controller.AddRoom("meeting", wsclientable.NewTemporaryRoom("meeting", []string{"a", "b"}, now-10, now+2), false); err != nil {
+		t.Fatalf("could not add room: %v", err)
+	}
+	expired := make(chan string, 1)
+	controller.AddRoomExpiredHandler(func(roomID string) { expired <- roomID })
+	h := wsclientabletest.StartRooms(t, wsclientable.BundleControllers(controller), "roomForward")
+
+	a := h.ConnectToRoom("meeting", "a")
+	b := h.ConnectToRoom("meeting", "b")
+	a.ExpectWith(wsclientable.MessageTypeRoomInfo, map[string]interface{}{"closesAt": now + 2})
+
+	extended, err := controller.ExtendRoom("meeting", 60)
+	if err != nil {
+		t.Fatalf("could not extend room: %v", err)
+	}
+	if extended.ValidUntilUnixTime != now+62 || extended.ValidFromUnixTime != now-10 || !extended.IsAllowed("a") {
+		t.Fatalf("unexpected extended room: %+v", extended)
+	}
+	a.ExpectWith(wsclientable.MessageTypeRoomInfo, map[string]interface{}{"closesAt": now + 62})
+
+	if _, _, err := a.AwaitClosed(4 * time.Second); err == nil {
+		t.Fatalf("connection closed at the original end of the extended room")
+	}
+	a.Send("roomForward", map[string]interface{}{"to": "b", "text": "still here"})
+	b.ExpectWith("roomForward", map[string]interface{}{"from": "a", "text": "still here"})
+
+	endInOneSecond := extended.ValidUntilUnixTime - (time.Now().Unix() + 1)
+	shortened, err := controller.ShortenRoom("meeting", endInOneSecond)
+	if err != nil {
+		t.Fatalf("could not shorten room: %v", err)
+	}
+	if shortened.ValidUntilUnixTime > time.Now().Unix()+2 {
+		t.Fatalf("room not shortened: %+v", shortened)
+	}
+	code, _, err := a.AwaitClosed(5 * time.Second)
+	if err != nil || code != wsclientable.CloseCodeRoomClosed {
+		t.Fatalf("expected close at the new end (%v), got %v (%v)", wsclientable.CloseCodeRoomClosed, code, err)
+	}
+	select { // the connections are closed before the removal is committed
+	case <-expired:
+	case <-time.After(wsclientabletest.DefaultTimeout):
+		t.Fatalf("shortened room did not expire")
+	}
+	if controller.GetRoom("meeting") != nil {
+		t.Fatalf("shortened room not removed after its new end")
+	}
+}
+
+func TestMoveEndOfTemporaryRoomErrors(t *testing.T) {
+	now := time.Now().Unix()
+	controller := wsclientable.NewTemporaryRoomController(wsclientable.NewMutableRamRoomStorage())
+	defer func() { _ = controller.Close() }()
+	if err := controller.AddRoom("r", wsclientable.NewTemporaryRoom("r", nil, now+10, now+60), false); err != nil {
+		t.Fatalf("could not add room: %v", err)
+	}
+
+	if _, err := controller.ExtendRoom("missing", 10); err == nil {
+		t.Fatalf("extended a room that does not exist")
+	} else if _, ok := err.(wsclientable.RoomNotFoundError); !ok {
+		t.Fatalf("expected RoomNotFoundError, got %v", err)
+	}
+	if _, err := controller.ShortenRoom("r", 51); err == nil {
+		t.Fatalf("shortened the room to end before it starts")
+	}
+	if _, err := controller.ExtendRoom("r", -1); err == nil {
+		t.Fatalf("extended the room by a negative number of seconds")
+	}
+	if room := controller.GetRoom("r").(wsclientable.TemporaryRoom); room.ValidUntilUnixTime != now+60 {
+		t.Fatalf("failed operations changed the room: %+v", room)
+	}
+}
+
+func TestMoveEndOfRoomThatIsNotTemporary(t *testing.T) {
+	now := time.Now().Unix()
+	store := wsclientable.NewRepeatingRoomBoltStorage(filepath.Join(t.TempDir(), "not_temporary.db"))
+	if err := store.Put(wsclientable.NewRepeatingRoom("r", nil, now, 60, 30), false); err != nil {
+		t.Fatalf("could not put room: %v", err)
+	}
+	controller := wsclientable.NewTemporaryRoomController(store)
+	defer func() { _ = controller.Close() }()
+
+	if _, err := controller.ExtendRoom("r", 10); err == nil || !strings.Contains(err.Error(), "not a temporary room") {
+		t.Fatalf("extended a room that is not temporary: %v", err)
+	}
+	if _, err := controller.SetRoomValidUntil("r", now+10); err == nil || !strings.Contains(err.Error(), "not a temporary room") {
+		t.Fatalf("moved the end of a room that is not temporary: %v", err)
+	}
+	if removed, err := controller.CloseAndRemoveRoom("r"); !removed || err != nil {
+		t.Fatalf("could not remove room: %v, %v", removed, err)
+	}
+}
+
+func TestBoltTemporaryRoomSetValidUntilMovesExpiration(t *testing.T) {
+	storage := wsclientable.NewTemporaryRoomBoltStorage(filepath.Join(t.TempDir(), "valid_until.db"))
+	defer func() { _ = storage.Close() }()
+
+	now := time.Now().Unix()
+	if err := storage.Put(wsclientable.NewTemporaryRoom("r", []string{"a"}, now-10, now-1).WithMaxParticipants(3), false); err != nil {
+		t.Fatalf("could not put room: %v", err)
+	}
+	changed, err := storage.SetValidUntil("r", now+60)
+	if err != nil {
+		t.Fatalf("could not set valid until: %v", err)
+	}
+	if changed.ValidUntilUnixTime != now+60 || changed.MaxParticipants != 3 {
+		t.Fatalf("unexpected changed room: %+v", changed)
+	}
+
+	next, err := storage.CleanExpired(func(room *wsclientable.TemporaryRoom) {
+		t.Fatalf("extended room removed at its old expiration")
+	})
+	if err != nil || next.GetID() != "r" {
+		t.Fatalf("expected the extended room to expire next, got %+v (%v)", next, err)
+	}
+	if _, err := storage.SetValidUntil("missing", now); err == nil {
+		t.Fatalf("set valid until of a room that does not exist")
+	}
+}
+
+func TestHTTPTemporaryRoomEditorExtendRoute(t *testing.T) {
+	now := time.Now().Unix()
+	editor := wsclientable.NewHTTPTemporaryRoomEditorInRam("127.0.0.1", 0, "/add", "/edit", "/remove")
+	editor.SetExtendAndShortenRoutes("/extend", "/shorten")
+	if err := editor.AddRoom("r", wsclientable.NewTemporaryRoom("r", nil, now-10, now+60), false); err != nil {
+		t.Fatalf("could not add room: %v", err)
+	}
+	wsclientabletest.StartRooms(t, wsclientable.BundleControllers(editor), "roomForward")
+
+	get := func(route string) (int, string) {
+		response, err := http.Get("http://" + editor.Addr() + route)
+		if err != nil {
+			t.Fatalf("editor not reachable: %v", err)
+		}
+		defer func() { _ = response.Body.Close() }()
+		body, _ := io.ReadAll(response.Body)
+		return response.StatusCode, string(body)
+	}
+
+	if status, body := get("/extend?id=r&seconds=30"); status != http.StatusOK || !strings.HasPrefix(body, "extended") {
+		t.Fatalf("could not extend room: %v %v", status, body)
+	}
+	if room := editor.GetRoom("r").(wsclientable.TemporaryRoom); room.ValidUntilUnixTime != now+90 {
+		t.Fatalf("room not extended: %+v", room)
+	}
+	if status, _ := get("/shorten?id=missing&seconds=30"); status != http.StatusNotFound {
+		t.Fatalf("expected not found, got %v", status)
+	}
+	if status, _ := get("/shorten?id=r&seconds=-5"); status != http.StatusBadRequest {
+		t.Fatalf("expected bad request for negative seconds, got %v", status)
+	}
+}
@@ -0,0 +1,61 @@
+package wsclientable_test
+
+import (
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable"
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable/wsclientabletest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRoomForwardingPolicy(t *testing.T) {
+	signaling := wsclientable.ForwardingPolicy{
+		MessageTypes: []string{"offer", "answer"},
+		SendRoles:    map[string][]wsclientable.Role{"offer": {"host"}},
+	}
+	h := wsclientabletest.StartRooms(t, wsclientable.BundleControllers(wsclientable.NewPermanentRoomController(
+		wsclientable.NewPermissiblePermanentRoom("call").
+			WithRoles(map[string]wsclientable.Role{"h": "host"}).WithForwardingPolicy(signaling),
+		wsclientable.NewPermissiblePermanentRoom("chat").
+			WithForwardingPolicy(wsclientable.ForwardingPolicy{MessageTypes: []string{"chat"}}),
+	)), "offer", "answer", "chat")
+
+	host := h.ConnectToRoom("call", "h")
+	guest := h.ConnectToRoom("call", "g")
+
+	guest.Send("offer", map[string]interface{}{"to": "h"})
+	guest.ExpectWith("error", map[string]interface{}{"requestType": "offer", "code": wsclientable.ErrorCodeMessageTypeDenied})
+	guest.Send("chat", map[string]interface{}{"to": "h"})
+	guest.ExpectWith("error", map[string]interface{}{"requestType": "chat", "code": wsclientable.ErrorCodeMessageTypeDenied})
+	host.ExpectNone("offer", 100*time.Millisecond)
+
+	host.Send("offer", map[string]interface{}{"to": "g"})
+	guest.ExpectWith("offer", map[string]interface{}{"from": "h"})
+	guest.Send("answer", map[string]interface{}{"to": "h"})
+	host.ExpectWith("answer", map[string]interface{}{"from": "g"})
+
+	a := h.ConnectToRoom("chat", "a")
+	b := h.ConnectToRoom("chat", "b")
+	a.Send("chat", map[string]interface{}{"to": "b", "text": "hi"})
+	b.ExpectWith("chat", map[string]interface{}{"from": "a", "text": "hi"})
+	a.Send("offer", map[string]interface{}{"to": "b"})
+	a.ExpectWith("error", map[string]interface{}{"requestType": "offer", "code": wsclientable.ErrorCodeMessageTypeDenied})
+}
+
+func TestRoomForwardingPolicyPersistedInBolt(t *testing.T) {
+	store := wsclientable.NewTemporaryRoomBoltStorage(filepath.Join(t.TempDir(), "policy.db"))
+	defer func() { _ = store.Close() }()
+
+	policy, err := wsclientable.ParseForwardingPolicy(`["offer", "answer"]`, `{"offer":["host"]}`)
+	if err != nil {
+		t.Fatalf("could not parse policy: %v", err)
+	}
+	now := time.Now().Unix()
+	if err := store.Put(wsclientable.NewTemporaryRoom("t", nil, now, now+60).WithForwardingPolicy(policy), false); err != nil {
+		t.Fatalf("could not put room: %v", err)
+	}
+	if stored := wsclientable.ForwardingPolicyOf(store.Get("t")); !reflect.DeepEqual(stored, policy) {
+		t.Fatalf("policy not persisted, got %v, expected %v", stored, policy)
+	}
+}
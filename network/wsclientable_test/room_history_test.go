@@ -0,0 +1,158 @@
+package wsclientable_test
+
+import (
+	"encoding/json"
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable"
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable/wsclientabletest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func historyPageOf(t *testing.T, m wsclientabletest.Message) []wsclientable.HistoryMessage {
+	t.Helper()
+	encoded, _ := json.Marshal(m.Data["messages"])
+	var page []wsclientable.HistoryMessage
+	if err := json.Unmarshal(encoded, &page); err != nil {
+		t.Fatalf("messages of history not decodable: %v", err)
+	}
+	return page
+}
+
+func TestRoomHistoryReplayAndPaging(t *testing.T) {
+	storage := wsclientable.NewRamRoomHistoryStorage(wsclientable.HistoryRetention{})
+	controller := wsclientable.NewTemporaryRoomController(wsclientable.NewMutableRamRoomStorage())
+	controllers := wsclientable.BundleControllers(controller)
+	server := wsclientable.NewWSHandlingServer()
+	server.AddRoomForwardingFunctionality(controllers, "chat", "typing")
+	server.AddRoomHistoryFunctionality(controllers, storage)
+	h := wsclientabletest.Start(t, &server)
+
+	now := time.Now().Unix()
+	room := wsclientable.NewTemporaryRoom("h", []string{}, now-10, now+60).
+		WithHistoryPolicy(wsclientable.HistoryPolicy{MessageTypes: []string{"chat"}, Replay: 2})
+	if err := controller.AddRoom("h", room, false); err != nil {
+		t.Fatalf("could not add room: %v", err)
+	}
+
+	a := h.ConnectToRoom("h", "a")
+	a.ExpectWith(wsclientable.MessageTypeHistory, map[string]interface{}{"room": "h", "messages": []interface{}{}, "more": false})
+	b := h.ConnectToRoom("h", "b")
+	b.Expect(wsclientable.MessageTypeHistory)
+
+	for _, text := range []string{"one", "two", "three"} {
+		a.Send("chat", map[string]interface{}{"to": wsclientable.RelayToEveryone, "text": text})
+		b.ExpectWith("chat", map[string]interface{}{"from": "a", "text": text})
+	}
+	a.Send("typing", map[string]interface{}{"to": wsclientable.RelayToEveryone})
+	b.Expect("typing")
+	b.Send("chat", map[string]interface{}{"to": "a", "text": "private"})
+	a.ExpectWith("chat", map[string]interface{}{"from": "b", "text": "private"})
+
+	// late joiners see the last messages sent to everyone, not the private one or other types
+	c := h.ConnectToRoom("h", "c")
+	page := historyPageOf(t, c.ExpectWith(wsclientable.MessageTypeHistory, map[string]interface{}{"more": true}))
+	if len(page) != 2 || page[0].Seq != 2 || page[1].Seq != 3 || page[1].From != "a" || page[1].To != wsclientable.RelayToEveryone ||
+		string(page[1].Data) != `{"from":"a","text":"three","to":"*"}` {
+		t.Fatalf("unexpected replay: %v", page)
+	}
+	c.Send(wsclientable.MessageTypeHistory, map[string]interface{}{"before": 2})
+	page = historyPageOf(t, c.ExpectWith(wsclientable.MessageTypeHistory, map[string]interface{}{"more": false}))
+	if len(page) != 1 || page[0].Seq != 1 {
+		t.Fatalf("unexpected page: %v", page)
+	}
+
+	// the recipient sees the private message
+	a.Send(wsclientable.MessageTypeHistory, map[string]interface{}{"limit": 1})
+	page = historyPageOf(t, a.ExpectWith(wsclientable.MessageTypeHistory, map[string]interface{}{"more": true}))
+	if len(page) != 1 || page[0].Seq != 4 || page[0].From != "b" || page[0].To != "a" {
+		t.Fatalf("unexpected page: %v", page)
+	}
+	a.Send(wsclientable.MessageTypeHistory, map[string]interface{}{"limit": 0})
+	a.ExpectWith("error", map[string]interface{}{"requestType": wsclientable.MessageTypeHistory})
+
+	// removing the room removes its history
+	if _, err := controller.CloseAndRemoveRoom("h"); err != nil {
+		t.Fatalf("could not remove room: %v", err)
+	}
+	if messages, _, _ := storage.Before("h", 0, 10, func(wsclientable.HistoryMessage) bool { return true }); len(messages) != 0 {
+		t.Fatalf("history of removed room still stored: %v", messages)
+	}
+}
+
+func TestRoomHistoryRejectedWithoutPolicy(t *testing.T) {
+	controller := wsclientable.NewTemporaryRoomController(wsclientable.NewMutableRamRoomStorage())
+	controllers := wsclientable.BundleControllers(controller)
+	server := wsclientable.NewWSHandlingServer()
+	server.AddRoomForwardingFunctionality(controllers, "chat")
+	server.AddRoomHistoryFunctionality(controllers, wsclientable.NewRamRoomHistoryStorage(wsclientable.HistoryRetention{}))
+	h := wsclientabletest.Start(t, &server)
+
+	now := time.Now().Unix()
+	if err := controller.AddRoom("n", wsclientable.NewTemporaryRoom("n", []string{}, now-10, now+60), false); err != nil {
+		t.Fatalf("could not add room: %v", err)
+	}
+	a := h.ConnectToRoom("n", "a")
+	a.Send(wsclientable.MessageTypeHistory, map[string]interface{}{})
+	a.ExpectWith("error", map[string]interface{}{"requestType": wsclientable.MessageTypeHistory})
+}
+
+func TestParseHistoryPolicy(t *testing.T) {
+	policy, err := wsclientable.ParseHistoryPolicy(`{"message_types":["chat"], "replay":20}`)
+	if err != nil || !policy.Records("chat") || policy.Records("offer") || policy.Replay != 20 {
+		t.Fatalf("unexpected policy %v (err: %v)", policy, err)
+	}
+	if policy, err := wsclientable.ParseHistoryPolicy(""); err != nil || !policy.IsZero() {
+		t.Fatalf("expected zero policy, got %v (err: %v)", policy, err)
+	}
+	for _, invalid := range []string{`["chat"]`, `{"replay":-1}`, `{"replay":100000}`} {
+		if _, err := wsclientable.ParseHistoryPolicy(invalid); err == nil {
+			t.Fatalf("invalid policy accepted: %v", invalid)
+		}
+	}
+}
+
+func TestRoomHistoryBoltStorageRetention(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "history.db")
+	retention := wsclientable.HistoryRetention{MaxMessages: 3, MaxAge: time.Hour}
+	storage, err := wsclientable.NewRoomHistoryBoltStorage(dbPath, retention)
+	if err != nil {
+		t.Fatalf("could not open: %v", err)
+	}
+	all := func(wsclientable.HistoryMessage) bool { return true }
+
+	now := time.Now().Unix()
+	if _, err := storage.Append("r", wsclientable.HistoryMessage{Type: "chat", To: "*", Data: json.RawMessage(`"old"`), AtUnixTime: now - 7200}); err != nil {
+		t.Fatalf("could not append: %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		seq, err := storage.Append("r", wsclientable.HistoryMessage{Type: "chat", To: "*", Data: json.RawMessage(`"new"`), AtUnixTime: now})
+		if err != nil || seq != uint64(i+2) {
+			t.Fatalf("expected seq %v, got %v (err: %v)", i+2, seq, err)
+		}
+	}
+	if err := storage.Close(); err != nil {
+		t.Fatalf("could not close: %v", err)
+	}
+
+	storage, err = wsclientable.NewRoomHistoryBoltStorage(dbPath, retention)
+	if err != nil {
+		t.Fatalf("could not open: %v", err)
+	}
+	defer func() { _ = storage.Close() }()
+	messages, more, err := storage.Before("r", 0, 10, all)
+	if err != nil || more || len(messages) != 3 || messages[0].Seq != 3 || messages[2].Seq != 5 {
+		t.Fatalf("expected the newest 3 messages, got %v more=%v (err: %v)", messages, more, err)
+	}
+	messages, more, _ = storage.Before("r", 5, 1, all)
+	if len(messages) != 1 || messages[0].Seq != 4 || !more {
+		t.Fatalf("expected message 4 and more, got %v more=%v", messages, more)
+	}
+
+	if err := storage.RemoveRoom("r"); err != nil {
+		t.Fatalf("could not remove room: %v", err)
+	}
+	if messages, _, _ := storage.Before("r", 0, 10, all); len(messages) != 0 {
+		t.Fatalf("history of removed room still stored: %v", messages)
+	}
+}
@@ -0,0 +1,116 @@
+package wsclientable_test
+
+import (
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable"
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable/wsclientabletest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestInviteTokens(t *testing.T, secret string) wsclientable.InviteTokens {
+	t.Helper()
+	invites, err := wsclientable.NewInviteTokens([]byte(secret))
+	if err != nil {
+		t.Fatalf("could not create invite tokens: %v", err)
+	}
+	return invites
+}
+
+func TestInviteTokensMintAndVerify(t *testing.T) {
+	if _, err := wsclientable.NewInviteTokens([]byte("short")); err == nil {
+		t.Fatalf("short secret accepted")
+	}
+	invites := newTestInviteTokens(t, strings.Repeat("s", wsclientable.MinInviteSecretLength))
+	now := time.Now()
+	claims := wsclientable.InviteClaims{
+		RoomID: "r", UserID: "guest", Role: wsclientable.RoleModerator,
+		NotBeforeUnixTime: now.Unix() - 10, ExpiresUnixTime: now.Unix() + 60,
+	}
+	token, err := invites.Mint(claims)
+	if err != nil {
+		t.Fatalf("could not mint: %v", err)
+	}
+	verified, err := invites.Verify(token, now)
+	if err != nil || verified != claims {
+		t.Fatalf("expected %v, got %v (err: %v)", claims, verified, err)
+	}
+
+	if _, err := invites.Verify(token, now.Add(2*time.Minute)); err == nil {
+		t.Fatalf("expired token verified")
+	}
+	if _, err := invites.Verify(token, now.Add(-time.Minute)); err == nil {
+		t.Fatalf("token verified before it became valid")
+	}
+	other := newTestInviteTokens(t, strings.Repeat("o", wsclientable.MinInviteSecretLength))
+	if _, err := other.Verify(token, now); err == nil {
+		t.Fatalf("token verified with another secret")
+	}
+	otherToken, _ := invites.Mint(wsclientable.InviteClaims{RoomID: "r", UserID: "admin", ExpiresUnixTime: now.Unix() + 60})
+	forged := token[:strings.IndexByte(token, '.')] + otherToken[strings.IndexByte(otherToken, '.'):]
+	if _, err := invites.Verify(forged, now); err == nil {
+		t.Fatalf("token with swapped signature verified")
+	}
+	if _, err := invites.Mint(wsclientable.InviteClaims{RoomID: "r", UserID: "", ExpiresUnixTime: now.Unix()}); err == nil {
+		t.Fatalf("minted token without user")
+	}
+}
+
+func TestJoinRoomWithInviteToken(t *testing.T) {
+	invites := newTestInviteTokens(t, strings.Repeat("k", wsclientable.MinInviteSecretLength))
+	controllers := wsclientable.BundleControllers(wsclientable.NewPermanentRoomController(
+		wsclientable.NewPermanentRoom("r", []string{"alice", "!banned"}),
+		wsclientable.NewPermanentRoom("other", []string{"alice"}),
+	))
+	server := wsclientable.NewWSHandlingServer()
+	server.AddRoomForwardingFunctionalityWithContextAuthenticator(controllers,
+		wsclientable.AuthenticateUserByInviteToken(invites, wsclientable.ContextAuthenticatorFrom(
+			wsclientable.RequestAuthenticatorFromURLParams(wsclientable.AuthenticateUserPermitAll()),
+		)), "roomForward")
+	server.AddRoomModerationFunctionality(controllers)
+	h := wsclientabletest.Start(t, &server)
+
+	mint := func(roomID, userID string, role wsclientable.Role, expiresIn time.Duration) string {
+		t.Helper()
+		token, err := invites.Mint(wsclientable.InviteClaims{
+			RoomID: roomID, UserID: userID, Role: role, ExpiresUnixTime: time.Now().Add(expiresIn).Unix(),
+		})
+		if err != nil {
+			t.Fatalf("could not mint: %v", err)
+		}
+		return token
+	}
+	join := func(roomID, token string) (*wsclientabletest.Client, error) {
+		return h.TryConnect(url.Values{"room": []string{roomID}, "token": []string{token}})
+	}
+
+	if _, err := h.TryConnectToRoom("r", "guest"); err == nil {
+		t.Fatalf("guest joined without invite")
+	}
+	guest, err := join("r", mint("r", "guest", wsclientable.RoleModerator, time.Minute))
+	if err != nil {
+		t.Fatalf("invited guest rejected: %v", err)
+	}
+	guest.ExpectWith(wsclientable.MessageTypeRoomInfo, map[string]interface{}{"room": "r"})
+	alice := h.ConnectToRoom("r", "alice")
+
+	// the user id is taken from the token, the role of the invite applies
+	guest.Send("roomForward", map[string]interface{}{"to": "alice"})
+	alice.ExpectWith("roomForward", map[string]interface{}{"from": "guest"})
+	guest.Send(wsclientable.MessageTypeLock, map[string]interface{}{})
+	alice.ExpectWith(wsclientable.MessageTypeModeration, map[string]interface{}{"action": "lock", "by": "guest"})
+
+	if _, err := join("other", mint("r", "second", "", time.Minute)); err == nil {
+		t.Fatalf("invite used for another room")
+	}
+	if _, err := join("r", mint("r", "banned", "", time.Minute)); err == nil {
+		t.Fatalf("invite overrode a deny entry")
+	}
+	if _, err := join("r", mint("r", "late", "", -time.Minute)); err == nil {
+		t.Fatalf("expired invite accepted")
+	}
+	if _, err := join("r", mint("r", "guest2", "", time.Minute)[1:]); err == nil {
+		t.Fatalf("tampered invite accepted")
+	}
+}
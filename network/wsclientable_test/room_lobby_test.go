@@ -0,0 +1,117 @@
+package wsclientable_test
+
+import (
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable"
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable/wsclientabletest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func startTemporaryRoomsWithLobby(t *testing.T, rooms ...wsclientable.TemporaryRoom) *wsclientabletest.Harness {
+	controller := wsclientable.NewTemporaryRoomController(wsclientable.NewMutableRamRoomStorage())
+	for _, room := range rooms {
+		if err := controller.AddRoom(room.ID, room, false); err != nil {
+			t.Fatalf("could not add room: %v", err)
+		}
+	}
+	return wsclientabletest.StartRooms(t, wsclientable.BundleControllers(controller), "roomForward")
+}
+
+func TestLobbyAdmitsClientsWhenRoomOpens(t *testing.T) {
+	now := time.Now().Unix()
+	h := startTemporaryRoomsWithLobby(t,
+		wsclientable.NewTemporaryRoom("later", []string{"a", "b"}, now+2, now+60).WithLobby(true),
+	)
+
+	a := h.ConnectToRoom("later", "a")
+	b := h.ConnectToRoom("later", "b")
+	waiting := a.ExpectWith(wsclientable.MessageTypeRoomOpensAt, map[string]interface{}{"room": "later"})
+	if opensAt, _ := waiting.Data["opensAt"].(float64); int64(opensAt) != now+2 {
+		t.Fatalf("expected opensAt %v, got %v", now+2, waiting.Data["opensAt"])
+	}
+	if _, ok := waiting.Data["serverTime"].(float64); !ok {
+		t.Fatalf("missing serverTime in %v", waiting.Data)
+	}
+	b.Expect(wsclientable.MessageTypeRoomOpensAt)
+
+	a.Send("roomForward", map[string]interface{}{"to": "b", "text": "too early"})
+	a.ExpectWith("error", map[string]interface{}{"requestType": "roomForward"})
+	if h.Rooms.CountConnectionsInRoom("later") != 0 {
+		t.Fatalf("waiting clients counted as participants")
+	}
+
+	for _, c := range []*wsclientabletest.Client{a, b} {
+		if _, err := c.Await(wsclientable.MessageTypeRoomOpened, 5*time.Second); err != nil {
+			t.Fatalf("client not admitted when the room opened: %v", err)
+		}
+	}
+	a.Send("roomForward", map[string]interface{}{"to": "b", "text": "hello"})
+	b.ExpectWith("roomForward", map[string]interface{}{"from": "a", "text": "hello"})
+}
+
+func TestLobbyOnlyForAllowedClientsOfRoomsWithLobby(t *testing.T) {
+	now := time.Now().Unix()
+	h := startTemporaryRoomsWithLobby(t,
+		wsclientable.NewTemporaryRoom("lobby", []string{"a"}, now+60, now+120).WithLobby(true),
+		wsclientable.NewTemporaryRoom("noLobby", []string{"a"}, now+60, now+120),
+	)
+
+	if _, err := h.TryConnectToRoom("lobby", "stranger"); err == nil {
+		t.Fatalf("client that is not allowed in the room was let into the lobby")
+	}
+	if _, err := h.TryConnectToRoom("noLobby", "a"); err == nil {
+		t.Fatalf("client accepted before a room without lobby opened")
+	}
+
+	a := h.ConnectToRoom("lobby", "a")
+	a.Expect(wsclientable.MessageTypeRoomOpensAt)
+	if _, err := h.TryConnectToRoom("lobby", "a"); err == nil {
+		t.Fatalf("same user waited in the lobby twice")
+	}
+
+	_ = a.Close()
+	h.ExpectClosedOnServer("a")
+	if h.Rooms.IsWaitingInLobby("lobby", "a") {
+		t.Fatalf("closed connection still waiting in lobby")
+	}
+}
+
+func TestRoomsNextOpening(t *testing.T) {
+	now := time.Now()
+	nowUnix := now.Unix()
+
+	if _, ok := wsclientable.NextOpeningOf(wsclientable.NewPermissiblePermanentRoom("p"), now); ok {
+		t.Fatalf("permanent room has no opening")
+	}
+	if at, ok := wsclientable.NextOpeningOf(wsclientable.NewTemporaryRoom("t", nil, nowUnix+10, nowUnix+20), now); !ok || at.Unix() != nowUnix+10 {
+		t.Fatalf("temporary room should open at its valid from time, got %v %v", at, ok)
+	}
+
+	repeating := wsclientable.NewRepeatingRoom("r", nil, nowUnix-25, 10, 2)
+	if at, ok := wsclientable.NextOpeningOf(repeating, now); !ok || at.Unix() != nowUnix+5 {
+		t.Fatalf("repeating room should open at its next occurrence, got %v (%v)", at.Unix()-nowUnix, ok)
+	}
+	if _, ok := wsclientable.NextOpeningOf(repeating.WithMaxOccurrences(3), now); ok {
+		t.Fatalf("repeating room opened after its last occurrence")
+	}
+}
+
+func TestLobbyPersistedInBolt(t *testing.T) {
+	storage := wsclientable.NewTemporaryRoomBoltStorage(filepath.Join(t.TempDir(), "lobby.db"))
+	defer func() { _ = storage.Close() }()
+
+	now := time.Now().Unix()
+	if err := storage.Put(wsclientable.NewTemporaryRoom("l", nil, now+10, now+60).WithLobby(true), false); err != nil {
+		t.Fatalf("could not put room: %v", err)
+	}
+	if !storage.Get("l").(wsclientable.TemporaryRoom).HasLobby() {
+		t.Fatalf("lobby not persisted")
+	}
+	if err := storage.Put(wsclientable.NewTemporaryRoom("l", nil, now+10, now+60), true); err != nil {
+		t.Fatalf("could not override room: %v", err)
+	}
+	if storage.Get("l").(wsclientable.TemporaryRoom).HasLobby() {
+		t.Fatalf("lobby kept after overriding the room without it")
+	}
+}
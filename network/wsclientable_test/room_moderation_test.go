@@ -0,0 +1,133 @@
+package wsclientable_test
+
+import (
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable"
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable/wsclientabletest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRoomModeration(t *testing.T) {
+	controllers := wsclientable.BundleControllers(wsclientable.NewPermanentRoomController(
+		wsclientable.NewPermissiblePermanentRoom("mod").WithRoles(map[string]wsclientable.Role{
+			"o": wsclientable.RoleOwner, "m": wsclientable.RoleModerator,
+		}),
+	))
+	server := wsclientable.NewWSHandlingServer()
+	server.AddRoomForwardingFunctionality(controllers, "roomForward")
+	server.AddRoomModerationFunctionality(controllers)
+	h := wsclientabletest.Start(t, &server)
+
+	o := h.ConnectToRoom("mod", "o")
+	m := h.ConnectToRoom("mod", "m")
+	p := h.ConnectToRoom("mod", "p")
+
+	// participants cannot moderate, moderators cannot moderate owners
+	p.Send(wsclientable.MessageTypeKick, map[string]interface{}{"user": "m"})
+	p.ExpectWith("error", map[string]interface{}{"requestType": wsclientable.MessageTypeKick})
+	m.Send(wsclientable.MessageTypeMute, map[string]interface{}{"user": "o"})
+	m.ExpectWith("error", map[string]interface{}{"requestType": wsclientable.MessageTypeMute})
+
+	all := []*wsclientabletest.Client{o, m, p}
+	expectAnnounced := func(fields map[string]interface{}) {
+		t.Helper()
+		for _, c := range all {
+			c.ExpectWith(wsclientable.MessageTypeModeration, fields)
+		}
+	}
+
+	m.Send(wsclientable.MessageTypeMute, map[string]interface{}{"user": "p"})
+	expectAnnounced(map[string]interface{}{"action": "mute", "by": "m", "user": "p"})
+	p.Send("roomForward", map[string]interface{}{"to": "o"})
+	p.ExpectWith("error", map[string]interface{}{"requestType": "roomForward"})
+	o.ExpectNone("roomForward", 100*time.Millisecond)
+
+	m.Send(wsclientable.MessageTypeUnmute, map[string]interface{}{"user": "p"})
+	expectAnnounced(map[string]interface{}{"action": "unmute"})
+	p.Send("roomForward", map[string]interface{}{"to": "o"})
+	o.ExpectWith("roomForward", map[string]interface{}{"from": "p"})
+
+	m.Send(wsclientable.MessageTypeLock, map[string]interface{}{})
+	expectAnnounced(map[string]interface{}{"action": "lock"})
+	if _, err := h.TryConnectToRoom("mod", "q"); err == nil {
+		t.Fatalf("participant connected to locked room")
+	}
+	m.Send(wsclientable.MessageTypeUnlock, map[string]interface{}{})
+	expectAnnounced(map[string]interface{}{"action": "unlock"})
+	q := h.ConnectToRoom("mod", "q")
+
+	m.Send(wsclientable.MessageTypeKick, map[string]interface{}{"user": "q"})
+	expectAnnounced(map[string]interface{}{"action": "kick", "user": "q"})
+	if code, _ := q.ExpectClosed(); code != wsclientable.CloseCodeKicked {
+		t.Fatalf("kicked with close code %v, expected %v", code, wsclientable.CloseCodeKicked)
+	}
+
+	o.Send(wsclientable.MessageTypeEndRoom, map[string]interface{}{})
+	expectAnnounced(map[string]interface{}{"action": "end_room", "by": "o"})
+	for _, c := range all {
+		c.ExpectClosed()
+	}
+	if _, err := h.TryConnectToRoom("mod", "p"); err == nil {
+		t.Fatalf("connected to ended room")
+	}
+}
+
+// Only implements RoomControllerI and RoomConnectionsIterableI, none of the other optional interfaces
+type minimalRoomController struct {
+	wsclientable.RoomControllerI
+}
+
+func (p minimalRoomController) ForAllIn(roomID string, f func(connection *wsclientable.ClientConnection)) {
+	wsclientable.ForAllInRoom(p.RoomControllerI, roomID, f)
+}
+
+func TestRoomModerationOfCustomController(t *testing.T) {
+	controllers := wsclientable.BundleControllers(minimalRoomController{wsclientable.NewPermanentRoomController(
+		wsclientable.NewPermissiblePermanentRoom("mod").WithMaxParticipants(2).
+			WithRoles(map[string]wsclientable.Role{"m": wsclientable.RoleModerator}),
+	)})
+	server := wsclientable.NewWSHandlingServer()
+	server.AddRoomForwardingFunctionality(controllers, "roomForward")
+	server.AddRoomModerationFunctionality(controllers)
+	h := wsclientabletest.Start(t, &server)
+
+	m := h.ConnectToRoom("mod", "m")
+	p := h.ConnectToRoom("mod", "p")
+	if _, err := h.TryConnectToRoom("mod", "q"); err == nil {
+		t.Fatalf("third participant connected to room with max 2 participants")
+	}
+	if count := wsclientable.CountConnectionsInRoom(minimalRoomController{&controllers}, "mod"); count != 2 {
+		t.Fatalf("counted %v connections, expected 2", count)
+	}
+
+	m.Send(wsclientable.MessageTypeLock, map[string]interface{}{})
+	m.ExpectWith("error", map[string]interface{}{"requestType": wsclientable.MessageTypeLock})
+	m.Send(wsclientable.MessageTypeMute, map[string]interface{}{"user": "p"})
+	m.ExpectWith("error", map[string]interface{}{"requestType": wsclientable.MessageTypeMute})
+	m.Send(wsclientable.MessageTypeKick, map[string]interface{}{"user": "p"})
+	m.ExpectWith(wsclientable.MessageTypeModeration, map[string]interface{}{"action": "kick", "user": "p"})
+	p.ExpectClosed()
+}
+
+func TestRoomRolesPersistedInBolt(t *testing.T) {
+	store := wsclientable.NewRepeatingRoomBoltStorage(filepath.Join(t.TempDir(), "roles.db"))
+	defer func() { _ = store.Close() }()
+
+	now := time.Now().Unix()
+	room := wsclientable.NewRepeatingRoom("r", []string{"o", "m", "p"}, now, 60, 30)
+	if err := store.Put(room.WithRoles(map[string]wsclientable.Role{"o": wsclientable.RoleOwner}), false); err != nil {
+		t.Fatalf("could not put room: %v", err)
+	}
+	if err := store.Put(room.WithRoles(map[string]wsclientable.Role{"m": wsclientable.RoleModerator}), true); err != nil {
+		t.Fatalf("could not override room: %v", err)
+	}
+
+	stored := store.Get("r")
+	if role := wsclientable.RoleOf(stored, "m"); role != wsclientable.RoleModerator {
+		t.Fatalf("role of m not persisted, got %v", role)
+	}
+	if role := wsclientable.RoleOf(stored, "o"); role != wsclientable.RoleParticipant {
+		t.Fatalf("role of o not replaced on override, got %v", role)
+	}
+}
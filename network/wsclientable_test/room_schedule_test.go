@@ -0,0 +1,197 @@
+package wsclientable_test
+
+import (
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable"
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable/wsclientabletest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustSchedule(t *testing.T, timezone string, rules []string, excludedDates ...string) wsclientable.Schedule {
+	t.Helper()
+	schedule, err := wsclientable.NewSchedule(timezone, rules, excludedDates)
+	if err != nil {
+		t.Fatalf("could not create schedule: %v", err)
+	}
+	return schedule
+}
+
+func utc(t *testing.T, raw string) time.Time {
+	t.Helper()
+	parsed, err := time.Parse("2006-01-02 15:04", raw)
+	if err != nil {
+		t.Fatalf("invalid test time %q: %v", raw, err)
+	}
+	return parsed
+}
+
+func TestScheduleWeeklyRuleFollowsDaylightSaving(t *testing.T) {
+	for _, rule := range []string{"Mon,Wed 17:00-18:30", "0 17 * * 1,3 90m"} {
+		schedule := mustSchedule(t, "Europe/Berlin", []string{rule})
+
+		// 2026-03-23 is a monday in winter time (UTC+1), 2026-03-30 a monday in summer time (UTC+2)
+		cases := map[string]bool{
+			"2026-03-23 15:59": false,
+			"2026-03-23 16:00": true,
+			"2026-03-23 17:29": true,
+			"2026-03-23 17:30": false,
+			"2026-03-24 16:30": false, // tuesday
+			"2026-03-25 16:30": true,  // wednesday
+			"2026-03-30 14:59": false,
+			"2026-03-30 15:00": true,
+			"2026-03-30 16:29": true,
+			"2026-03-30 16:30": false,
+		}
+		for at, expected := range cases {
+			if open := schedule.IsOpenAt(utc(t, at)); open != expected {
+				t.Errorf("rule %q: expected open=%v at %v UTC, got %v", rule, expected, at, open)
+			}
+		}
+
+		until, open := schedule.OpenUntil(utc(t, "2026-03-30 15:10"))
+		if !open || !until.Equal(utc(t, "2026-03-30 16:30")) {
+			t.Errorf("rule %q: expected open until 16:30 UTC, got %v (open=%v)", rule, until, open)
+		}
+	}
+}
+
+func TestScheduleExcludedDatesAndNextWindow(t *testing.T) {
+	schedule := mustSchedule(t, "Europe/Berlin", []string{"Mon,Wed 17:00-18:30"}, "2026-03-25")
+
+	if schedule.IsOpenAt(utc(t, "2026-03-25 16:30")) {
+		t.Fatalf("schedule open on excluded date")
+	}
+	next, ok := schedule.NextWindowAfter(utc(t, "2026-03-23 17:30"))
+	if !ok {
+		t.Fatalf("no next window found")
+	}
+	if !next.Start.Equal(utc(t, "2026-03-30 15:00")) || !next.End.Equal(utc(t, "2026-03-30 16:30")) {
+		t.Fatalf("expected next window on monday after the excluded wednesday, got %v - %v", next.Start, next.End)
+	}
+}
+
+func TestScheduleOvernightAndJoinedWindows(t *testing.T) {
+	overnight := mustSchedule(t, "UTC", []string{"Fri 22:00-02:00"})
+	// 2026-03-27 is a friday
+	if !overnight.IsOpenAt(utc(t, "2026-03-28 01:00")) {
+		t.Fatalf("overnight window not open after midnight")
+	}
+	if overnight.IsOpenAt(utc(t, "2026-03-28 02:00")) {
+		t.Fatalf("overnight window open after its end")
+	}
+
+	joined := mustSchedule(t, "UTC", []string{"Mon 09:00-12:00", "Mon 12:00-13:00"})
+	until, open := joined.OpenUntil(utc(t, "2026-03-23 10:00"))
+	if !open || !until.Equal(utc(t, "2026-03-23 13:00")) {
+		t.Fatalf("expected adjacent windows to be joined until 13:00, got %v (open=%v)", until, open)
+	}
+}
+
+func TestScheduleRareCronDays(t *testing.T) {
+	leapDay := mustSchedule(t, "UTC", []string{"0 0 29 2 * 1h"})
+	next, ok := leapDay.NextWindowAfter(utc(t, "2026-03-01 00:00"))
+	if !ok || !next.Start.Equal(utc(t, "2028-02-29 00:00")) {
+		t.Fatalf("expected the next leap day, got %v (found=%v)", next.Start, ok)
+	}
+
+	// restricted day of week, either has to match
+	mondays := mustSchedule(t, "UTC", []string{"0 0 31 2 1 1h"})
+	next, ok = mondays.NextWindowAfter(utc(t, "2026-03-01 00:00"))
+	if !ok || !next.Start.Equal(utc(t, "2027-02-01 00:00")) {
+		t.Fatalf("expected the first monday in february, got %v (found=%v)", next.Start, ok)
+	}
+}
+
+func TestScheduleRejectsInvalidInput(t *testing.T) {
+	invalid := []struct {
+		timezone string
+		rules    []string
+		excluded []string
+	}{
+		{"Mars/Olympus", []string{"Mon 17:00-18:00"}, nil},
+		{"UTC", nil, nil},
+		{"UTC", []string{"Someday 17:00-18:00"}, nil},
+		{"UTC", []string{"Mon 25:00-26:00"}, nil},
+		{"UTC", []string{"0 17 * * 1"}, nil},
+		{"UTC", []string{"60 17 * * 1 1h"}, nil},
+		{"UTC", []string{"0 17 * * 1 -1h"}, nil},
+		{"UTC", []string{"0 0 31 2 * 1h"}, nil},
+		{"UTC", []string{"0 0 30 2 * 1h"}, nil},
+		{"UTC", []string{"0 0 31 4,6,9,11 * 1h"}, nil},
+		{"UTC", []string{"Mon 17:00-18:00"}, []string{"25.12.2026"}},
+	}
+	for _, c := range invalid {
+		if _, err := wsclientable.NewSchedule(c.timezone, c.rules, c.excluded); err == nil {
+			t.Errorf("expected error for timezone=%q rules=%q excluded=%q", c.timezone, c.rules, c.excluded)
+		}
+	}
+}
+
+func TestScheduledRoomControllerClosesConnectionsWhenWindowEnds(t *testing.T) {
+	// a window that started at the current minute and ends in about 2 seconds
+	now := time.Now().UTC()
+	minuteStart := now.Truncate(time.Minute)
+	duration := now.Sub(minuteStart).Truncate(time.Second) + 2*time.Second
+	rule := strconv.Itoa(minuteStart.Minute()) + " " + strconv.Itoa(minuteStart.Hour()) + " * * * " + duration.String()
+
+	room := wsclientable.NewScheduledRoom("class", []string{}, mustSchedule(t, "UTC", []string{rule}))
+	controller := wsclientable.NewScheduledRoomController(wsclientable.NewMutableRamRoomStorage())
+	if err := controller.AddRoom("class", room, false); err != nil {
+		t.Fatalf("could not add room: %v", err)
+	}
+	h := wsclientabletest.StartRooms(t, wsclientable.BundleControllers(controller), "roomForward")
+
+	c := h.ConnectToRoom("class", "student")
+	if _, _, err := c.AwaitClosed(4 * time.Second); err != nil {
+		t.Fatalf("connection not closed at the end of the window: %v", err)
+	}
+	if _, err := h.TryConnectToRoom("class", "student"); err == nil {
+		t.Fatalf("connected to scheduled room outside of its window")
+	}
+}
+
+func TestScheduledRoomControllerRejectsOtherRooms(t *testing.T) {
+	store := wsclientable.NewMutableRamRoomStorage()
+	if err := store.Put(wsclientable.NewPermissiblePermanentRoom("other"), false); err != nil {
+		t.Fatalf("could not put room: %v", err)
+	}
+	h := wsclientabletest.StartRooms(t, wsclientable.BundleControllers(wsclientable.NewScheduledRoomController(store)), "roomForward")
+
+	c := h.ConnectToRoom("other", "student")
+	code, reason := c.ExpectClosed()
+	if code != wsclientable.CloseCodeRoomRejected || !strings.Contains(reason, "not a scheduled room") {
+		t.Fatalf("unexpected close: %d %q", code, reason)
+	}
+}
+
+func TestScheduledRoomPersistedInBolt(t *testing.T) {
+	storage := wsclientable.NewScheduledRoomBoltStorage(filepath.Join(t.TempDir(), "scheduled.db"))
+	defer func() { _ = storage.Close() }()
+
+	schedule := mustSchedule(t, "Europe/Berlin", []string{"Mon,Wed 17:00-18:30", "0 9 1 * * 1h"}, "2026-12-23")
+	room := wsclientable.NewScheduledRoom("s", []string{"a", "b"}, schedule).
+		WithMaxParticipants(2).WithRoles(map[string]wsclientable.Role{"a": wsclientable.RoleOwner})
+	if err := storage.Put(room, false); err != nil {
+		t.Fatalf("could not put scheduled room: %v", err)
+	}
+	if err := storage.Put(room, false); err == nil {
+		t.Fatalf("put without override replaced existing room")
+	}
+
+	decoded, ok := storage.Get("s").(wsclientable.ScheduledRoom)
+	if !ok {
+		t.Fatalf("scheduled room not decoded, got %v", storage.Get("s"))
+	}
+	if decoded.Schedule.Timezone != "Europe/Berlin" || len(decoded.Schedule.Rules) != 2 || len(decoded.Schedule.ExcludedDates) != 1 {
+		t.Fatalf("schedule not persisted, got %+v", decoded.Schedule)
+	}
+	if !decoded.Schedule.IsOpenAt(utc(t, "2026-03-30 15:00")) || decoded.Schedule.IsOpenAt(utc(t, "2026-12-23 16:30")) {
+		t.Fatalf("decoded schedule does not compute the same windows")
+	}
+	if decoded.GetMaxParticipants() != 2 || decoded.GetRole("a") != wsclientable.RoleOwner {
+		t.Fatalf("room base not persisted, got %+v", decoded.Room)
+	}
+}
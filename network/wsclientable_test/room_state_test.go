@@ -0,0 +1,139 @@
+package wsclientable_test
+
+import (
+	"encoding/json"
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable"
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable/wsclientabletest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRoomStateSnapshotDiffsAndVersions(t *testing.T) {
+	storage := wsclientable.NewRamRoomStateStorage()
+	controller := wsclientable.NewTemporaryRoomController(wsclientable.NewMutableRamRoomStorage())
+	controllers := wsclientable.BundleControllers(controller)
+	server := wsclientable.NewWSHandlingServer()
+	server.AddRoomForwardingFunctionality(controllers)
+	server.AddRoomStateFunctionality(controllers, storage)
+	h := wsclientabletest.Start(t, &server)
+
+	now := time.Now().Unix()
+	if err := controller.AddRoom("s", wsclientable.NewTemporaryRoom("s", []string{}, now-10, now+60), false); err != nil {
+		t.Fatalf("could not add room: %v", err)
+	}
+
+	a := h.ConnectToRoom("s", "a")
+	a.ExpectWith(wsclientable.MessageTypeStateSnapshot, map[string]interface{}{"room": "s", "version": 0, "entries": map[string]interface{}{}})
+	a.Send(wsclientable.MessageTypeStateSet, map[string]interface{}{"key": "slide", "value": 3})
+	a.ExpectWith(wsclientable.MessageTypeStateChanged, map[string]interface{}{"key": "slide", "value": 3, "version": 1, "by": "a"})
+
+	b := h.ConnectToRoom("s", "b")
+	b.ExpectWith(wsclientable.MessageTypeStateSnapshot, map[string]interface{}{
+		"version": 1, "entries": map[string]interface{}{"slide": map[string]interface{}{"value": 3, "version": 1}},
+	})
+
+	// versioned changes are rejected if the key changed in the meantime
+	b.Send(wsclientable.MessageTypeStateSet, map[string]interface{}{"key": "slide", "value": 4, "version": 0})
+	b.ExpectWith("error", map[string]interface{}{"requestType": wsclientable.MessageTypeStateSet, "currentVersion": 1})
+	b.Send(wsclientable.MessageTypeStateSet, map[string]interface{}{"key": "slide", "value": 4, "version": 1})
+	for _, c := range []*wsclientabletest.Client{a, b} {
+		c.ExpectWith(wsclientable.MessageTypeStateChanged, map[string]interface{}{"key": "slide", "value": 4, "version": 2, "by": "b"})
+	}
+
+	a.Send(wsclientable.MessageTypeStateDelete, map[string]interface{}{"key": "slide"})
+	for _, c := range []*wsclientabletest.Client{a, b} {
+		c.ExpectWith(wsclientable.MessageTypeStateChanged, map[string]interface{}{"key": "slide", "deleted": true, "version": 3})
+	}
+	a.Send(wsclientable.MessageTypeStateDelete, map[string]interface{}{"key": "slide"})
+	a.ExpectWith("error", map[string]interface{}{"requestType": wsclientable.MessageTypeStateDelete})
+
+	// removing the room removes its state
+	a.Send(wsclientable.MessageTypeStateSet, map[string]interface{}{"key": "mode", "value": "draw"})
+	a.ExpectWith(wsclientable.MessageTypeStateChanged, map[string]interface{}{"key": "mode"})
+	if _, err := controller.CloseAndRemoveRoom("s"); err != nil {
+		t.Fatalf("could not remove room: %v", err)
+	}
+	if snapshot, _ := storage.Snapshot("s"); snapshot.Version != 0 || len(snapshot.Entries) != 0 {
+		t.Fatalf("state of removed room still stored: %v", snapshot)
+	}
+}
+
+func TestRoomStateRemovedWhenTemporaryRoomExpires(t *testing.T) {
+	storage := wsclientable.NewRamRoomStateStorage()
+	controller := wsclientable.NewTemporaryRoomController(wsclientable.NewMutableRamRoomStorage())
+	controllers := wsclientable.BundleControllers(controller)
+	server := wsclientable.NewWSHandlingServer()
+	server.AddRoomForwardingFunctionality(controllers)
+	server.AddRoomStateFunctionality(controllers, storage)
+	expired := make(chan string, 1)
+	controller.AddRoomExpiredHandler(func(roomID string) { expired <- roomID }) // after the state was removed
+	h := wsclientabletest.Start(t, &server)
+
+	now := time.Now().Unix()
+	if err := controller.AddRoom("e", wsclientable.NewTemporaryRoom("e", []string{}, now-10, now+1), false); err != nil {
+		t.Fatalf("could not add room: %v", err)
+	}
+	c := h.ConnectToRoom("e", "c")
+	c.Send(wsclientable.MessageTypeStateSet, map[string]interface{}{"key": "k", "value": true})
+	c.ExpectWith(wsclientable.MessageTypeStateChanged, map[string]interface{}{"key": "k", "version": 1})
+
+	if _, _, err := c.AwaitClosed(5 * time.Second); err != nil {
+		t.Fatalf("connection not closed when the room expired: %v", err)
+	}
+	select {
+	case <-expired:
+	case <-time.After(wsclientabletest.DefaultTimeout):
+		t.Fatalf("room did not expire")
+	}
+	if snapshot, _ := storage.Snapshot("e"); len(snapshot.Entries) != 0 {
+		t.Fatalf("state of expired room still stored: %v", snapshot)
+	}
+}
+
+func TestRoomStateBoltStorage(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "state.db")
+	storage, err := wsclientable.NewRoomStateBoltStorage(dbPath)
+	if err != nil {
+		t.Fatalf("could not open: %v", err)
+	}
+
+	version, err := storage.Set("r", "slide", json.RawMessage(`{"n":1}`), nil)
+	if err != nil || version != 1 {
+		t.Fatalf("expected version 1, got %v (err: %v)", version, err)
+	}
+	zero := uint64(0)
+	if _, err := storage.Set("r", "slide", json.RawMessage(`2`), &zero); err == nil {
+		t.Fatalf("set with outdated version applied")
+	} else if conflict, ok := err.(wsclientable.RoomStateVersionConflictError); !ok || conflict.CurrentVersion != 1 {
+		t.Fatalf("expected version conflict, got %v", err)
+	}
+	if _, err := storage.Set("r", "mode", json.RawMessage(`"draw"`), &zero); err != nil {
+		t.Fatalf("could not set new key: %v", err)
+	}
+	if version, existed, err := storage.Delete("r", "slide", nil); err != nil || !existed || version != 3 {
+		t.Fatalf("expected deletion with version 3, got %v %v (err: %v)", version, existed, err)
+	}
+	if _, existed, _ := storage.Delete("r", "slide", nil); existed {
+		t.Fatalf("deleted key twice")
+	}
+	if err := storage.Close(); err != nil {
+		t.Fatalf("could not close: %v", err)
+	}
+
+	storage, err = wsclientable.NewRoomStateBoltStorage(dbPath)
+	if err != nil {
+		t.Fatalf("could not open: %v", err)
+	}
+	defer func() { _ = storage.Close() }()
+	snapshot, err := storage.Snapshot("r")
+	if err != nil || snapshot.Version != 3 || len(snapshot.Entries) != 1 || string(snapshot.Entries["mode"].Value) != `"draw"` {
+		t.Fatalf("state not persisted: %v (err: %v)", snapshot, err)
+	}
+	if err := storage.RemoveRoom("r"); err != nil {
+		t.Fatalf("could not remove room: %v", err)
+	}
+	if snapshot, _ := storage.Snapshot("r"); snapshot.Version != 0 || len(snapshot.Entries) != 0 {
+		t.Fatalf("state of removed room still stored: %v", snapshot)
+	}
+}
@@ -0,0 +1,105 @@
+package wsclientable_test
+
+import (
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable"
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable/wsclientabletest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestUserWindowsRestrictAndGrantAccess(t *testing.T) {
+	now := time.Now().Unix()
+	room := wsclientable.NewPermanentRoom("r", []string{"member"}).WithUserWindows(map[string]wsclientable.UserWindow{
+		"guest":   {UntilUnixTime: now + 60},
+		"expired": {FromUnixTime: now - 60, UntilUnixTime: now - 1},
+		"later":   {FromUnixTime: now + 60},
+	})
+
+	if !room.IsAllowed("member") || room.IsAllowed("stranger") {
+		t.Fatalf("allowed clients without personal window changed")
+	}
+	if !room.IsAllowed("guest") || !room.AllowsClient("guest") {
+		t.Fatalf("guest with open personal window not allowed")
+	}
+	if room.IsAllowed("expired") || room.IsAllowed("later") {
+		t.Fatalf("user allowed outside of its personal window")
+	}
+
+	temporary := wsclientable.NewTemporaryRoom("t", nil, now+10, now+60).WithUserWindows(map[string]wsclientable.UserWindow{
+		"guest": {UntilUnixTime: now + 60},
+	})
+	if temporary.IsAllowed("guest") {
+		t.Fatalf("personal window allowed a user before the room opened")
+	}
+}
+
+func TestParseUserWindows(t *testing.T) {
+	windows, err := wsclientable.ParseUserWindows(`{"guest": {"until_in_seconds_from_now": 1800}, "sub": {"from_in_seconds_from_now": 0, "until_in_seconds_from_now": 60}}`, 1000)
+	if err != nil {
+		t.Fatalf("could not parse user windows: %v", err)
+	}
+	expected := map[string]wsclientable.UserWindow{
+		"guest": {UntilUnixTime: 2800},
+		"sub":   {FromUnixTime: 1000, UntilUnixTime: 1060},
+	}
+	if !reflect.DeepEqual(windows, expected) {
+		t.Fatalf("expected %v, got %v", expected, windows)
+	}
+
+	for _, invalid := range []string{`[]`, `{"a": {"from_in_seconds_from_now": 10, "until_in_seconds_from_now": 5}}`, `{"": {}}`} {
+		if _, err := wsclientable.ParseUserWindows(invalid, 1000); err == nil {
+			t.Fatalf("parsed invalid user windows %v", invalid)
+		}
+	}
+}
+
+func TestControllerDisconnectsUserAtEndOfPersonalWindow(t *testing.T) {
+	now := time.Now().Unix()
+	controller := wsclientable.NewTemporaryRoomController(wsclientable.NewMutableRamRoomStorage())
+	room := wsclientable.NewTemporaryRoom("class", []string{"teacher"}, now-10, now+60).
+		WithUserWindows(map[string]wsclientable.UserWindow{"substitute": {UntilUnixTime: now + 2}})
+	if err := controller.AddRoom("class", room, false); err != nil {
+		t.Fatalf("could not add room: %v", err)
+	}
+	h := wsclientabletest.StartRooms(t, wsclientable.BundleControllers(controller), "roomForward")
+
+	teacher := h.ConnectToRoom("class", "teacher")
+	substitute := h.ConnectToRoom("class", "substitute")
+	substitute.ExpectWith(wsclientable.MessageTypeRoomInfo, map[string]interface{}{"accessUntil": now + 2, "closesAt": now + 60})
+	if info := teacher.Expect(wsclientable.MessageTypeRoomInfo); info.Data["accessUntil"] != nil {
+		t.Fatalf("user without personal window received accessUntil: %v", info.Data)
+	}
+
+	code, _, err := substitute.AwaitClosed(5 * time.Second)
+	if err != nil || code != wsclientable.CloseCodeAccessEnded {
+		t.Fatalf("expected close code %v at the end of the personal window, got %v (%v)", wsclientable.CloseCodeAccessEnded, code, err)
+	}
+	if !h.Rooms.IsConnected("class", "teacher") {
+		t.Fatalf("user without personal window was disconnected")
+	}
+	if _, err := h.TryConnectToRoom("class", "substitute"); err == nil {
+		t.Fatalf("user reconnected after its personal window ended")
+	}
+}
+
+func TestUserWindowsPersistedInBolt(t *testing.T) {
+	storage := wsclientable.NewRepeatingRoomBoltStorage(filepath.Join(t.TempDir(), "user_windows.db"))
+	defer func() { _ = storage.Close() }()
+
+	now := time.Now().Unix()
+	windows := map[string]wsclientable.UserWindow{"guest": {FromUnixTime: now, UntilUnixTime: now + 1800}, "open": {}}
+	if err := storage.Put(wsclientable.NewRepeatingRoom("r", []string{"a"}, now, 60, 30).WithUserWindows(windows), false); err != nil {
+		t.Fatalf("could not put room: %v", err)
+	}
+	if decoded := storage.Get("r").(wsclientable.RepeatingRoom).GetUserWindows(); !reflect.DeepEqual(decoded, windows) {
+		t.Fatalf("expected %v, got %v", windows, decoded)
+	}
+	if err := storage.Put(wsclientable.NewRepeatingRoom("r", []string{"a"}, now, 60, 30), true); err != nil {
+		t.Fatalf("could not override room: %v", err)
+	}
+	if decoded := storage.Get("r").(wsclientable.RepeatingRoom).GetUserWindows(); len(decoded) != 0 {
+		t.Fatalf("user windows kept after overriding the room without them: %v", decoded)
+	}
+}
@@ -0,0 +1,189 @@
+package wsclientable_test
+
+import (
+	"encoding/json"
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable"
+	"github.com/jokrey/utility-algorithms-golang/network/wsclientable/wsclientabletest"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var fastWebhookRetries = wsclientable.WebhookRetryPolicy{InitialBackoff: 20 * time.Millisecond, MaxBackoff: 100 * time.Millisecond, MaxAttempts: 5}
+
+type receivedWebhook struct {
+	event    wsclientable.WebhookEvent
+	attempt  string
+	verified bool
+}
+
+// Receives webhook events, answers with the status returned by status (for the attempt header)
+func startWebhookReceiver(t *testing.T, secret string, status func(attempt string) int) (string, chan receivedWebhook) {
+	t.Helper()
+	received := make(chan receivedWebhook, 100)
+	hook := wsclientable.Webhook{Secret: []byte(secret)}
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		attempt := r.Header.Get("X-Webhook-Attempt")
+		code := status(attempt)
+		w.WriteHeader(code)
+		if code != http.StatusOK {
+			return
+		}
+		var event wsclientable.WebhookEvent
+		_ = json.Unmarshal(body, &event)
+		received <- receivedWebhook{event: event, attempt: attempt, verified: hook.Verify(body, r.Header.Get("X-Webhook-Signature"))}
+	}))
+	t.Cleanup(receiver.Close)
+	return receiver.URL, received
+}
+
+func awaitWebhook(t *testing.T, received chan receivedWebhook) receivedWebhook {
+	t.Helper()
+	select {
+	case r := <-received:
+		if !r.verified {
+			t.Fatalf("webhook signature not valid: %v", r.event)
+		}
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no webhook event received")
+		return receivedWebhook{}
+	}
+}
+
+func TestRoomWebhooksJoinLeaveAndExpiry(t *testing.T) {
+	receiverURL, received := startWebhookReceiver(t, "secret", func(string) int { return http.StatusOK })
+	webhooks, err := wsclientable.NewWebhooks(wsclientable.NewRamWebhookOutbox(), fastWebhookRetries,
+		wsclientable.Webhook{URL: receiverURL, Secret: []byte("secret")})
+	if err != nil {
+		t.Fatalf("could not create webhooks: %v", err)
+	}
+	controller := wsclientable.NewTemporaryRoomController(wsclientable.NewMutableRamRoomStorage())
+	controllers := wsclientable.BundleControllers(controller)
+	server := wsclientable.NewWSHandlingServer()
+	server.AddRoomForwardingFunctionality(controllers)
+	server.AddRoomWebhooksFunctionality(controllers, webhooks)
+	h := wsclientabletest.Start(t, &server)
+
+	now := time.Now().Unix()
+	if err := controller.AddRoom("w", wsclientable.NewTemporaryRoom("w", []string{"a", "b"}, now-10, now+1), false); err != nil {
+		t.Fatalf("could not add room: %v", err)
+	}
+	// rejected connections neither join nor leave
+	if _, err := h.TryConnectToRoom("w", "intruder"); err == nil {
+		t.Fatalf("intruder joined")
+	}
+
+	a := h.ConnectToRoom("w", "a")
+	if r := awaitWebhook(t, received); r.event.Type != wsclientable.WebhookEventUserJoined || r.event.RoomID != "w" || r.event.UserID != "a" || len(r.event.ID) == 0 {
+		t.Fatalf("expected a to join, got %v", r.event)
+	}
+	_ = a.Close()
+	if r := awaitWebhook(t, received); r.event.Type != wsclientable.WebhookEventUserLeft || r.event.UserID != "a" {
+		t.Fatalf("expected a to leave, got %v", r.event)
+	}
+
+	h.ConnectToRoom("w", "b")
+	awaitWebhook(t, received)
+	// the room expires, b is disconnected
+	events := map[string]wsclientable.WebhookEvent{}
+	for len(events) < 2 {
+		r := awaitWebhook(t, received)
+		events[r.event.Type] = r.event
+	}
+	if events[wsclientable.WebhookEventUserLeft].UserID != "b" {
+		t.Fatalf("expected b to leave, got %v", events[wsclientable.WebhookEventUserLeft])
+	}
+	if events[wsclientable.WebhookEventRoomExpired].RoomID != "w" {
+		t.Fatalf("expected the room to expire, got %v", events)
+	}
+}
+
+func TestWebhookRetriesAndEventFilter(t *testing.T) {
+	receiverURL, received := startWebhookReceiver(t, "retried", func(attempt string) int {
+		if attempt != "3" {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	})
+	otherURL, other := startWebhookReceiver(t, "filtered", func(string) int { return http.StatusOK })
+	webhooks, err := wsclientable.NewWebhooks(wsclientable.NewRamWebhookOutbox(), fastWebhookRetries,
+		wsclientable.Webhook{URL: receiverURL, Secret: []byte("retried")},
+		wsclientable.Webhook{URL: otherURL, Secret: []byte("filtered"), Events: []string{wsclientable.WebhookEventRoomExpired}},
+	)
+	if err != nil {
+		t.Fatalf("could not create webhooks: %v", err)
+	}
+	defer func() { _ = webhooks.Close() }()
+
+	webhooks.Emit(wsclientable.WebhookEvent{Type: wsclientable.WebhookEventUserJoined, RoomID: "r", UserID: "u"})
+	if r := awaitWebhook(t, received); r.attempt != "3" || r.event.UserID != "u" {
+		t.Fatalf("expected delivery on the third attempt, got %v", r)
+	}
+	webhooks.Emit(wsclientable.WebhookEvent{Type: wsclientable.WebhookEventRoomExpired, RoomID: "r"})
+	if r := awaitWebhook(t, other); r.event.Type != wsclientable.WebhookEventRoomExpired {
+		t.Fatalf("filtered webhook received %v", r.event)
+	}
+	select {
+	case r := <-other:
+		t.Fatalf("filtered webhook received %v", r.event)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if _, err := wsclientable.NewWebhooks(wsclientable.NewRamWebhookOutbox(), fastWebhookRetries,
+		wsclientable.Webhook{URL: "not a url", Secret: []byte("s")}); err == nil {
+		t.Fatalf("invalid webhook url accepted")
+	}
+}
+
+func TestWebhookOutboxSurvivesRestart(t *testing.T) {
+	var available int32
+	rejected := make(chan string, 100)
+	receiverURL, received := startWebhookReceiver(t, "persisted", func(attempt string) int {
+		if atomic.LoadInt32(&available) == 0 {
+			rejected <- attempt
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	})
+	hook := wsclientable.Webhook{URL: receiverURL, Secret: []byte("persisted")}
+	dbPath := filepath.Join(t.TempDir(), "outbox.db")
+	retries := wsclientable.WebhookRetryPolicy{InitialBackoff: 20 * time.Millisecond, MaxBackoff: 20 * time.Millisecond}
+
+	outbox, err := wsclientable.NewWebhookOutboxBoltStorage(dbPath)
+	if err != nil {
+		t.Fatalf("could not open: %v", err)
+	}
+	webhooks, err := wsclientable.NewWebhooks(outbox, retries, hook)
+	if err != nil {
+		t.Fatalf("could not create webhooks: %v", err)
+	}
+	webhooks.Emit(wsclientable.WebhookEvent{ID: "e1", Type: wsclientable.WebhookEventUserLeft, RoomID: "r", UserID: "u"})
+	select {
+	case <-rejected:
+	case <-time.After(wsclientabletest.DefaultTimeout):
+		t.Fatalf("event not attempted before the restart")
+	}
+	if err := webhooks.Close(); err != nil {
+		t.Fatalf("could not close: %v", err)
+	}
+
+	atomic.StoreInt32(&available, 1)
+	outbox, err = wsclientable.NewWebhookOutboxBoltStorage(dbPath)
+	if err != nil {
+		t.Fatalf("could not open: %v", err)
+	}
+	webhooks, err = wsclientable.NewWebhooks(outbox, retries, hook)
+	if err != nil {
+		t.Fatalf("could not create webhooks: %v", err)
+	}
+	defer func() { _ = webhooks.Close() }()
+	if r := awaitWebhook(t, received); r.event.ID != "e1" {
+		t.Fatalf("expected pending event after restart, got %v", r.event)
+	}
+}